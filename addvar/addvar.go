@@ -0,0 +1,375 @@
+// Addvar adds variables computed from the existing variables of a data
+// set.  Each new variable is defined by an argument of the form
+// name=expression, or name:dtype=expression, for example
+//
+//	addvar -sourcedir=... "bmi=weight / pow(height, 2)" "old:uint8=age >= 65"
+//
+// See the expr package for the supported expressions.  The data type
+// defaults to float64.  Values stored with an integer data type must
+// be whole numbers in the range of the type, so a logical expression
+// can be stored as uint8.  Values stored as delta-uvarint must be
+// non-decreasing within each bucket.  Dates and times are used in
+// expressions as days or microseconds since 1970-01-01, and are stored
+// as such with the date32 and timestamp64 data types, for example
+// "due:date32=start + 90".
+//
+// The expressions only refer to variables already in the data set, not
+// to the other new variables.  An existing variable is only replaced if
+// -replace is given.  The new columns are written to temporary files
+// in every bucket, and moved into place with dtypes.json updated once
+// every bucket has been computed, so the data set is unchanged if any
+// value cannot be stored.
+
+package addvar
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+	"github.com/kshedden/gocols/expr"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The names, data types and definitions of the new variables
+	names, dtypes []string
+	defs          []*expr.Expr
+
+	// The variables referenced by the definitions
+	invars []string
+
+	// The position in invars of each variable of each definition
+	argpos [][]int
+
+	// If true, existing variables can be replaced
+	replace bool
+
+	// Configuration information for the data set
+	conf *config.Config
+
+	// The number of buckets processed in parallel
+	concurrency int
+
+	sem chan bool
+
+	// The first error from any bucket
+	firsterr error
+	errmut   sync.Mutex
+)
+
+// parsedef reads a definition of the form name[:dtype]=expression.
+func parsedef(s string) error {
+
+	i := strings.Index(s, "=")
+	if i < 1 {
+		return fmt.Errorf("invalid definition %q, expected name=expression", s)
+	}
+
+	name, dt := s[0:i], "float64"
+	if j := strings.Index(name, ":"); j >= 0 {
+		name, dt = name[0:j], name[j+1:]
+	}
+	if _, ok := config.DTsize[dt]; !ok && dt != "uvarint" && dt != "varint" && dt != "delta-uvarint" {
+		return fmt.Errorf("unsupported dtype %s for variable %s", dt, name)
+	}
+	for _, vn := range names {
+		if vn == name {
+			return fmt.Errorf("variable %s is defined more than once", name)
+		}
+	}
+
+	e, err := expr.Parse(s[i+1:])
+	if err != nil {
+		return err
+	}
+
+	var pos []int
+	for _, vn := range e.Vars {
+		j := 0
+		for ; j < len(invars); j++ {
+			if invars[j] == vn {
+				break
+			}
+		}
+		if j == len(invars) {
+			invars = append(invars, vn)
+		}
+		pos = append(pos, j)
+	}
+
+	names = append(names, name)
+	dtypes = append(dtypes, dt)
+	defs = append(defs, e)
+	argpos = append(argpos, pos)
+
+	return nil
+}
+
+// tobits returns a value in the representation of config.WriteBits
+// for a data type.
+func tobits(x float64, dt string) (uint64, error) {
+
+	switch {
+	case dt == "float32":
+		if !math.IsInf(x, 0) && math.Abs(x) > math.MaxFloat32 {
+			return 0, fmt.Errorf("value %g is out of the range of float32", x)
+		}
+		return math.Float64bits(x), nil
+	case dt == "float64":
+		return math.Float64bits(x), nil
+	case x != math.Trunc(x) || math.IsInf(x, 0):
+		return 0, fmt.Errorf("value %g is not a whole number", x)
+	case dt == "varint" || config.IsTime(dt):
+		if x < math.MinInt64 || x >= math.MaxInt64 {
+			return 0, fmt.Errorf("value %g is out of the range of %s", x, dt)
+		}
+		return uint64(int64(x)), nil
+	case x < 0 || x >= math.MaxUint64:
+		return 0, fmt.Errorf("value %g is out of the range of %s", x, dt)
+	}
+
+	return uint64(x), nil
+}
+
+// nrows returns the number of rows in a bucket.
+func nrows(bn int, bdtypes map[string]string) (int, error) {
+	for vn, dt := range bdtypes {
+		return config.CountRows(bn, sourcedir, vn, dt)
+	}
+	return 0, nil
+}
+
+// computebucket writes the new columns of one bucket to temporary
+// files.
+func computebucket(bn int) error {
+
+	bdtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return err
+	}
+
+	rdrs := make([]*config.ColumnReader, len(invars))
+	for j, vn := range invars {
+		dt, ok := bdtypes[vn]
+		if !ok {
+			return fmt.Errorf("variable %s not found in bucket %d", vn, bn)
+		}
+		rdrs[j], err = config.OpenReader(bn, sourcedir, vn, dt)
+		if err != nil {
+			return err
+		}
+		defer rdrs[j].Close()
+	}
+
+	// Constant expressions need the number of rows.
+	n := -1
+	if len(rdrs) == 0 {
+		n, err = nrows(bn, bdtypes)
+		if err != nil {
+			return err
+		}
+	}
+
+	wtrs := make([]io.WriteCloser, len(names))
+	fids := make([]io.Closer, len(names))
+	for j, vn := range names {
+		wtrs[j], fids[j], err = config.CreateColumn(bn, sourcedir, vn+".tmp")
+		if err != nil {
+			return err
+		}
+		defer fids[j].Close()
+	}
+
+	vals := make([]float64, len(invars))
+	args := make([][]float64, len(names))
+	for j := range names {
+		args[j] = make([]float64, len(argpos[j]))
+	}
+	last := make([]uint64, len(names))
+
+	for i := 0; i != n; i++ {
+		for j := range rdrs {
+			vals[j], err = rdrs[j].Float()
+			if err == io.EOF && j == 0 {
+				break
+			} else if err == io.EOF {
+				return fmt.Errorf("variable %s in bucket %d has fewer rows than %s", invars[j], bn, invars[0])
+			} else if err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+
+		for j, e := range defs {
+			for k, p := range argpos[j] {
+				args[j][k] = vals[p]
+			}
+			x, err := tobits(e.Eval(args[j]), dtypes[j])
+			if err == nil && dtypes[j] == "delta-uvarint" {
+				if x < last[j] {
+					err = fmt.Errorf("value %d is smaller than the preceding value", x)
+				} else {
+					err = config.WriteUint(wtrs[j], "uvarint", x-last[j])
+					last[j] = x
+				}
+			} else if err == nil {
+				err = config.WriteBits(wtrs[j], dtypes[j], x)
+			}
+			if err != nil {
+				return fmt.Errorf("variable %s, bucket %d, row %d: %v", names[j], bn, i, err)
+			}
+		}
+	}
+
+	for _, w := range wtrs {
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dobucket computes the new columns of one bucket, recording the first
+// error.
+func dobucket(bn int) {
+
+	defer func() { <-sem }()
+
+	if err := computebucket(bn); err != nil {
+		errmut.Lock()
+		if firsterr == nil {
+			firsterr = err
+		}
+		errmut.Unlock()
+	}
+}
+
+// replacebucket moves the new columns of one bucket into place and
+// records their data types, updating meta.json if it exists.
+func replacebucket(bn int) error {
+
+	bdtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return err
+	}
+
+	for j, vn := range names {
+		err := os.Rename(config.ColumnPath(bn, sourcedir, vn+".tmp"), config.ColumnPath(bn, sourcedir, vn))
+		if err != nil {
+			return err
+		}
+		bdtypes[vn] = dtypes[j]
+	}
+
+	if err := config.WriteDtypes(bn, sourcedir, bdtypes); err != nil {
+		return err
+	}
+
+	if meta, err := config.ReadMeta(bn, sourcedir); err == nil {
+		meta, err = config.ComputeMeta(bn, sourcedir, meta.IdVar)
+		if err != nil {
+			return err
+		}
+		return config.WriteMeta(bn, sourcedir, meta)
+	}
+
+	return nil
+}
+
+// cleanup removes the temporary files.
+func cleanup() {
+	for k := 0; k < conf.NumBuckets; k++ {
+		for _, vn := range names {
+			os.Remove(config.ColumnPath(k, sourcedir, vn+".tmp"))
+		}
+	}
+}
+
+// Run runs addvar with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("addvar", flag.ContinueOnError)
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.BoolVar(&replace, "replace", false, "replace existing variables")
+	fs.IntVar(&concurrency, "concurrency", runtime.NumCPU(), "number of buckets processed in parallel")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	names, dtypes, defs, invars, argpos = nil, nil, nil, nil, nil
+	firsterr = nil
+
+	if concurrency < 1 {
+		return errors.New("-concurrency must be positive")
+	}
+
+	if sourcedir == "" || fs.NArg() == 0 {
+		return cli.Usage("usage:\naddvar -sourcedir=... [-replace] name[:dtype]=expression...\n\n")
+	}
+
+	for _, s := range fs.Args() {
+		if err := parsedef(s); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	bdtypes, err := config.ReadDtypes(0, sourcedir)
+	if err != nil {
+		return err
+	}
+	for _, vn := range names {
+		if _, ok := bdtypes[vn]; ok && !replace {
+			return fmt.Errorf("Variable %s exists, use -replace=true to replace it", vn)
+		}
+		if config.HasFactorCodes(vn, conf) {
+			return fmt.Errorf("Variable %s is factor-coded, and cannot be replaced", vn)
+		}
+	}
+
+	sem = make(chan bool, concurrency)
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		sem <- true
+		go dobucket(k)
+	}
+
+	for k := 0; k < concurrency; k++ {
+		sem <- true
+	}
+
+	if firsterr != nil {
+		cleanup()
+		return firsterr
+	}
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		if err := replacebucket(k); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("added variables", "vars", strings.Join(names, ","), "buckets", conf.NumBuckets)
+
+	return nil
+}
@@ -0,0 +1,857 @@
+// Append adds records to an existing data set.  The new records are
+// read from another data set with the same variables (-sourcedir), or
+// from delimited text files with a header naming the variables (the
+// remaining arguments).  Each record is placed in the bucket given by
+// the routing of the data set (see buildrouting), and is appended to
+// the existing column files as a new compressed stream.
+//
+// Factor-coded variables are matched by label: labels that the data
+// set already has keep their codes, and new labels are given new codes,
+// which are saved in the Codes directory.  Likewise, if the routing
+// variable has an id map (see csv2cols -idmap), its values in text
+// files are string identifiers, and new identifiers are given new ids
+// and added to the map.  In text files, dates and times are given as in
+// csv2cols, and empty fields and fields equal to -na are missing values
+// of the variables other than the factor-coded variables and the
+// routing variable.  As in csv2cols, missing values are stored as zero
+// (NaN for floats) and marked in the validity bitmaps of the variables
+// (see config.NullSuffix).  The bitmaps of the buckets that receive
+// records are extended, or created when a variable has its first
+// missing value in a bucket, whether the records come from text files
+// or from a data set with bitmaps.  Values of delta-uvarint variables
+// must not be smaller than the values already in their bucket.  Data
+// sets with bool or text variables are not supported.  The meta.json
+// and manifest.json files of the buckets that receive records are
+// updated.
+//
+// The records are held in memory, grouped by bucket, until -buffer of
+// them are held, and are then written one bucket at a time, so that
+// only the files of one bucket are open at once.
+//
+// The data set is changed in place, and an error part way through
+// leaves the records appended so far, so keep a copy of data that
+// cannot be rebuilt.
+
+package append
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The data set receiving the records
+	targetdir string
+
+	// The data set holding the new records, if any
+	sourcedir string
+
+	// The field delimiter of text files
+	delim rune
+
+	// The value denoting a missing value in text files, besides an
+	// empty field
+	na string
+
+	// Configuration information for the target and source data
+	conf, sconf *config.Config
+
+	// The names and data types of the variables
+	vnames []string
+	dtypes map[string]string
+
+	// The position of the routing variable in vnames
+	idpos int
+
+	// The code group of each factor-coded variable, and the codes of
+	// each group
+	codefiles map[string]string
+	groups    map[string]map[string]int
+
+	// The code groups with new labels
+	changed map[string]bool
+
+	// The id maps of the routing variable in the target and the
+	// source, if it has one, and the number of identifiers in the
+	// target map before appending
+	idm, sidm *config.IdMap
+	nidm      int
+
+	// The maximum number of records held in memory before they are
+	// written to their buckets
+	buffer int
+
+	// The records waiting to be written, by bucket, and their number
+	pending  map[int][]record
+	npending int
+
+	// The most recent value of each delta-uvarint variable, for each
+	// bucket that receives records
+	last map[int][]uint64
+
+	// The number of rows of each bucket that receives records, before
+	// appending
+	nrows map[int]int
+
+	// The number of records added to each bucket
+	added map[int]int
+)
+
+// record is a record waiting to be written to its bucket, holding the
+// values returned by config.ColumnReader.Bits for each variable, except
+// that the values of delta-uvarint variables are the differences from
+// the preceding values.  If valid is not nil, the variables for which
+// it is false have missing values.
+type record struct {
+	row   []uint64
+	valid []bool
+}
+
+// kind returns "float", "varint", "date32", "timestamp64" or "uint"
+// for a data type.
+func kind(dt string) string {
+	switch dt {
+	case "float32", "float64":
+		return "float"
+	case "varint", "date32", "timestamp64":
+		return dt
+	}
+	return "uint"
+}
+
+// code returns the code of a label of a factor-coded variable, giving
+// new labels the next unused code.
+func code(vn, lab string) uint64 {
+
+	grp := codefiles[vn]
+	codes := groups[grp]
+	if c, ok := codes[lab]; ok {
+		return uint64(c)
+	}
+
+	c := 0
+	for _, d := range codes {
+		if d >= c {
+			c = d + 1
+		}
+	}
+	codes[lab] = c
+	changed[grp] = true
+
+	return uint64(c)
+}
+
+// lastvalue returns the last value of a delta-uvarint column.
+func lastvalue(bn int, vn string) (uint64, error) {
+
+	rdr, err := config.OpenReader(bn, targetdir, vn, "delta-uvarint")
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer rdr.Close()
+
+	var x uint64
+	for {
+		y, err := rdr.Uint()
+		if err == io.EOF {
+			return x, nil
+		} else if err != nil {
+			return 0, err
+		}
+		x = y
+	}
+}
+
+// checkbucket checks the data types of a bucket that receives
+// records, and reads its number of rows and the last values of its
+// delta-uvarint variables.
+func checkbucket(bn int) error {
+
+	bdt, err := config.ReadDtypes(bn, targetdir)
+	if err != nil {
+		return err
+	}
+	for _, vn := range vnames {
+		if bdt[vn] != dtypes[vn] {
+			return fmt.Errorf("variable %s has dtype %q in bucket %d, but %s in bucket 0", vn, bdt[vn], bn, dtypes[vn])
+		}
+	}
+
+	nrows[bn], err = config.NumRows(bn, targetdir)
+	if err != nil {
+		return err
+	}
+
+	lv := make([]uint64, len(vnames))
+	for j, vn := range vnames {
+		if dtypes[vn] == "delta-uvarint" {
+			lv[j], err = lastvalue(bn, vn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	last[bn] = lv
+
+	return nil
+}
+
+// oldnulls returns a writer for the temporary validity bitmap of a
+// variable in a bucket, holding the validity of the nrows rows that the
+// bucket already has.
+func oldnulls(bn int, vn string, nrows int) (*config.NullWriter, error) {
+
+	nw := config.CreateNulls(bn, targetdir, vn+".tmp")
+
+	nr, err := config.OpenNulls(bn, targetdir, vn)
+	if err != nil {
+		return nil, err
+	}
+	defer nr.Close()
+
+	for i := 0; i < nrows; i++ {
+		v, err := nr.Valid()
+		if err == io.EOF {
+			return nil, fmt.Errorf("the validity bitmap of %s in bucket %d has %d rows, expected %d", vn, bn, i, nrows)
+		} else if err != nil {
+			return nil, err
+		}
+		if err := nw.Put(v); err != nil {
+			return nil, err
+		}
+	}
+
+	return nw, nil
+}
+
+// putrow appends one record, given as the values returned by
+// config.ColumnReader.Bits for each variable.  If valid is not nil, the
+// variables for which it is false have missing values.  The record is
+// held until flush writes it to its bucket.
+func putrow(row []uint64, valid []bool) error {
+
+	bn, err := config.Route(conf, row[idpos])
+	if err != nil {
+		return err
+	}
+
+	if last[bn] == nil {
+		if err := checkbucket(bn); err != nil {
+			return err
+		}
+	}
+
+	rec := record{row: make([]uint64, len(vnames))}
+	copy(rec.row, row)
+	for j, vn := range vnames {
+		if valid != nil && !valid[j] && rec.valid == nil {
+			rec.valid = make([]bool, len(vnames))
+			copy(rec.valid, valid)
+		}
+		if dtypes[vn] != "delta-uvarint" {
+			continue
+		}
+		x := row[j]
+		if valid != nil && !valid[j] {
+			// The placeholder repeats the preceding value.
+			x = last[bn][j]
+		}
+		if x < last[bn][j] {
+			return fmt.Errorf("variable %s: value %d is smaller than the preceding value %d in bucket %d",
+				vn, x, last[bn][j], bn)
+		}
+		rec.row[j] = x - last[bn][j]
+		last[bn][j] = x
+	}
+
+	pending[bn] = append(pending[bn], rec)
+	npending++
+	if npending >= buffer {
+		return flush()
+	}
+
+	return nil
+}
+
+// flush writes the pending records, one bucket at a time.
+func flush() error {
+
+	var bns []int
+	for bn := range pending {
+		bns = append(bns, bn)
+	}
+	sort.Ints(bns)
+
+	for _, bn := range bns {
+		recs := pending[bn]
+		for j := range vnames {
+			if err := writecolumn(bn, j, recs); err != nil {
+				return err
+			}
+		}
+		added[bn] += len(recs)
+		delete(pending, bn)
+		npending -= len(recs)
+	}
+
+	return nil
+}
+
+// writecolumn appends the values of the variable at position j in
+// recs to its column file and validity bitmap in a bucket.  The bitmap
+// holds the existing rows followed by the new ones, and is written
+// under a temporary name and moved into place by putnulls.
+func writecolumn(bn, j int, recs []record) error {
+
+	vn := vnames[j]
+	w, fid, err := config.AppendColumn(bn, targetdir, vn)
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		if dtypes[vn] == "delta-uvarint" {
+			err = config.WriteUint(w, "uvarint", rec.row[j])
+		} else {
+			err = config.WriteBits(w, dtypes[vn], rec.row[j])
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err2 := w.Close(); err == nil {
+		err = err2
+	}
+	if err2 := fid.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return fmt.Errorf("variable %s in bucket %d: %v", vn, bn, err)
+	}
+
+	nw, err := oldnulls(bn, vn, nrows[bn]+added[bn])
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		if err := nw.Put(rec.valid == nil || rec.valid[j]); err != nil {
+			nw.Close()
+			return err
+		}
+	}
+
+	return putnulls(bn, vn, nw)
+}
+
+// appendbucket appends the records of a bucket of the source data set,
+// reading them one row at a time.
+func appendbucket(bn int) (int, error) {
+
+	sdt, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return 0, err
+	}
+	if len(sdt) != len(vnames) {
+		return 0, fmt.Errorf("bucket %d of %s has %d variables, expected %d", bn, sourcedir, len(sdt), len(vnames))
+	}
+
+	rdrs := make([]*config.ColumnReader, len(vnames))
+	nrs := make([]*config.NullReader, len(vnames))
+	defer func() {
+		for j := range vnames {
+			if rdrs[j] != nil {
+				rdrs[j].Close()
+			}
+			if nrs[j] != nil {
+				nrs[j].Close()
+			}
+		}
+	}()
+
+	// The labels of the source codes of each factor-coded variable,
+	// used to translate them to target codes
+	labels := make([]map[int]string, len(vnames))
+
+	for j, vn := range vnames {
+		dt, ok := sdt[vn]
+		if !ok {
+			return 0, fmt.Errorf("variable %s not found in bucket %d of %s", vn, bn, sourcedir)
+		}
+		if kind(dt) != kind(dtypes[vn]) {
+			return 0, fmt.Errorf("variable %s has dtype %s in %s, which cannot be stored as %s", vn, dt, sourcedir, dtypes[vn])
+		}
+
+		if _, ok := codefiles[vn]; ok {
+			codes, err := config.GetFactorCodes(vn, sconf)
+			if err != nil {
+				return 0, err
+			}
+			labels[j] = config.RevCodes(codes)
+		}
+
+		rdrs[j], err = config.OpenReader(bn, sourcedir, vn, dt)
+		if err != nil {
+			return 0, err
+		}
+		nrs[j], err = config.OpenNulls(bn, sourcedir, vn)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	row := make([]uint64, len(vnames))
+	valid := make([]bool, len(vnames))
+	for n := 0; ; n++ {
+		for j, vn := range vnames {
+			x, err := rdrs[j].Bits()
+			if err == io.EOF && j == 0 {
+				return n, lastrow(bn, n, rdrs, nrs)
+			} else if err == io.EOF {
+				err = fmt.Errorf("variable %s in bucket %d of %s has %d rows, expected more", vn, bn, sourcedir, n)
+			}
+			if err != nil {
+				return n, err
+			}
+
+			if labels[j] != nil {
+				lab, ok := labels[j][int(x)]
+				if !ok {
+					return n, fmt.Errorf("code %d of %s in bucket %d of %s has no label", x, vn, bn, sourcedir)
+				}
+				x = code(vn, lab)
+			} else if j == idpos && idm != nil {
+				lab, ok := sidm.Label(x)
+				if !ok {
+					return n, fmt.Errorf("id %d of %s in bucket %d of %s is not in its id map", x, vn, bn, sourcedir)
+				}
+				if x, err = idm.Add(lab); err != nil {
+					return n, err
+				}
+			}
+			row[j] = x
+
+			valid[j], err = nrs[j].Valid()
+			if err == io.EOF {
+				return n, fmt.Errorf("the validity bitmap of %s in bucket %d of %s has %d rows, expected more", vn, bn, sourcedir, n)
+			} else if err != nil {
+				return n, err
+			}
+		}
+
+		if err := putrow(row, valid); err != nil {
+			return n, fmt.Errorf("bucket %d of %s: %v", bn, sourcedir, err)
+		}
+	}
+}
+
+// lastrow checks that the columns and validity bitmaps of a bucket of
+// the source data set all end after n rows, once the first column has
+// ended.
+func lastrow(bn, n int, rdrs []*config.ColumnReader, nrs []*config.NullReader) error {
+
+	for j, vn := range vnames {
+		if j > 0 {
+			if _, err := rdrs[j].Bits(); err != io.EOF {
+				return fmt.Errorf("variable %s in bucket %d of %s has more than %d rows", vn, bn, sourcedir, n)
+			}
+		}
+		if nrs[j].HasNulls() {
+			if _, err := nrs[j].Valid(); err != io.EOF {
+				return fmt.Errorf("the validity bitmap of %s in bucket %d of %s has more than %d rows", vn, bn, sourcedir, n)
+			}
+		}
+	}
+
+	return nil
+}
+
+// appenddata appends the records of the source data set.
+func appenddata() (int, error) {
+
+	var err error
+	sconf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, vn := range vnames {
+		_, ok := codefiles[vn]
+		if config.HasFactorCodes(vn, sconf) != ok {
+			return 0, fmt.Errorf("variable %s is factor-coded in only one of %s and %s", vn, targetdir, sourcedir)
+		}
+	}
+
+	idvar := conf.Routing.IdVar
+	if config.HasIdMap(idvar, sconf) != (idm != nil) {
+		return 0, fmt.Errorf("variable %s has an id map in only one of %s and %s", idvar, targetdir, sourcedir)
+	}
+	if idm != nil {
+		sidm, err = config.ReadIdMap(idvar, sconf)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+	for k := 0; k < sconf.NumBuckets; k++ {
+		m, err := appendbucket(k)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// missing returns true if a text value is a missing value of a
+// variable, and the placeholder stored in its place.
+func missing(vn, x string) (bool, uint64) {
+
+	if x != "" && x != na {
+		return false, 0
+	}
+	if _, ok := codefiles[vn]; ok || vn == conf.Routing.IdVar {
+		return false, 0
+	}
+	if kind(dtypes[vn]) == "float" {
+		return true, math.Float64bits(math.NaN())
+	}
+	return true, 0
+}
+
+// parse converts a text value of a variable to a target value.
+func parse(vn, x string) (uint64, error) {
+
+	if _, ok := codefiles[vn]; ok {
+		return code(vn, x), nil
+	}
+	if idm != nil && vn == conf.Routing.IdVar {
+		return idm.Add(x)
+	}
+
+	switch kind(dtypes[vn]) {
+	case "float":
+		v, err := strconv.ParseFloat(x, 64)
+		return math.Float64bits(v), err
+	case "varint":
+		v, err := strconv.ParseInt(x, 10, 64)
+		return uint64(v), err
+	case "date32":
+		v, err := config.ParseDate(x)
+		return uint64(v), err
+	case "timestamp64":
+		v, err := config.ParseTimestamp(x)
+		return uint64(v), err
+	}
+
+	return strconv.ParseUint(x, 10, 64)
+}
+
+// appendtext appends the records of a delimited text file.
+func appendtext(fname string) (int, error) {
+
+	fid, err := os.Open(fname)
+	if err != nil {
+		return 0, err
+	}
+	defer fid.Close()
+
+	rdr := csv.NewReader(bufio.NewReader(fid))
+	rdr.Comma = delim
+	rdr.ReuseRecord = true
+
+	header, err := rdr.Read()
+	if err != nil {
+		return 0, err
+	}
+
+	// The position in the header of each variable
+	pos := make([]int, len(vnames))
+	hpos := make(map[string]int)
+	for i, h := range header {
+		hpos[h] = i
+	}
+	if len(hpos) != len(vnames) {
+		return 0, fmt.Errorf("%s has %d columns, expected %d", fname, len(hpos), len(vnames))
+	}
+	for j, vn := range vnames {
+		i, ok := hpos[vn]
+		if !ok {
+			return 0, fmt.Errorf("variable %s is not in the header of %s", vn, fname)
+		}
+		pos[j] = i
+	}
+
+	row := make([]uint64, len(vnames))
+	valid := make([]bool, len(vnames))
+	for n := 0; ; n++ {
+		rec, err := rdr.Read()
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+
+		for j, vn := range vnames {
+			x := rec[pos[j]]
+			var miss bool
+			if miss, row[j] = missing(vn, x); miss {
+				valid[j] = false
+				continue
+			}
+			valid[j] = true
+			row[j], err = parse(vn, x)
+			if err != nil {
+				return n, fmt.Errorf("%s line %d, variable %s: %v", fname, n+2, vn, err)
+			}
+		}
+		if err := putrow(row, valid); err != nil {
+			return n, fmt.Errorf("%s line %d: %v", fname, n+2, err)
+		}
+	}
+}
+
+// finish writes the pending records, and saves the new factor codes
+// and identifiers and the updated row counts.
+func finish() error {
+
+	if err := flush(); err != nil {
+		return err
+	}
+
+	for grp := range changed {
+		if err := config.WriteFactorCodes(grp, groups[grp], conf); err != nil {
+			return err
+		}
+	}
+	if idm != nil && idm.Len() > nidm {
+		if err := config.WriteIdMap(conf.Routing.IdVar, idm, conf); err != nil {
+			return err
+		}
+	}
+
+	for bn, n := range added {
+		if meta, err := config.ReadMeta(bn, targetdir); err == nil {
+			meta, err = config.ComputeMeta(bn, targetdir, meta.IdVar)
+			if err != nil {
+				return err
+			}
+			if err := config.WriteMeta(bn, targetdir, meta); err != nil {
+				return err
+			}
+		}
+		if err := updatemanifest(bn, n); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// putnulls finishes the temporary validity bitmap of a variable in a
+// bucket, and moves it over the bitmap of the variable, or removes the
+// bitmap of the variable if none of its values are missing.
+func putnulls(bn int, vn string, nw *config.NullWriter) error {
+
+	if err := nw.Close(); err != nil {
+		return err
+	}
+
+	tmp := config.ColumnPath(bn, targetdir, vn+".tmp"+config.NullSuffix)
+	pa := config.ColumnPath(bn, targetdir, vn+config.NullSuffix)
+	if _, err := config.StatFile(tmp); err == nil {
+		return config.RenameFile(tmp, pa)
+	}
+	if config.HasNulls(bn, targetdir, vn) {
+		return config.RemoveFile(pa)
+	}
+
+	return nil
+}
+
+// updatemanifest adds n to the number of rows recorded in the
+// manifest.json file of a bucket, if there is one.
+func updatemanifest(bn, n int) error {
+
+	fn := path.Join(config.BucketPath(bn, targetdir), "manifest.json")
+	b, err := ioutil.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var man map[string]interface{}
+	if err := json.Unmarshal(b, &man); err != nil {
+		return fmt.Errorf("cannot read %s: %v", fn, err)
+	}
+	nr, _ := man["NumRows"].(float64)
+	man["NumRows"] = int(nr) + n
+
+	b, err = json.Marshal(man)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(fn, append(b, '\n'), 0644)
+}
+
+// setup reads the variables, factor codes and id map of the target.
+func setup() error {
+
+	var err error
+	dtypes, err = config.ReadDtypes(0, targetdir)
+	if err != nil {
+		return err
+	}
+	for vn, dt := range dtypes {
+		// Bool columns end with a marker, and text columns have
+		// a second file, so neither can be appended to as a new
+		// stream.
+		if dt == "bool" || dt == "text" {
+			return fmt.Errorf("variable %s has dtype %s, which cannot be appended to", vn, dt)
+		}
+		vnames = append(vnames, vn)
+	}
+	sort.Strings(vnames)
+
+	idpos = -1
+	for j, vn := range vnames {
+		if vn == conf.Routing.IdVar {
+			idpos = j
+		}
+	}
+	if idpos == -1 || kind(dtypes[conf.Routing.IdVar]) != "uint" {
+		return fmt.Errorf("the routing variable %s must be in the data with an unsigned integer dtype", conf.Routing.IdVar)
+	}
+
+	cf, err := config.ReadCodeFiles(conf)
+	if err != nil {
+		return err
+	}
+	codefiles = make(map[string]string)
+	groups = make(map[string]map[string]int)
+	for _, vn := range vnames {
+		if !config.HasFactorCodes(vn, conf) {
+			continue
+		}
+		grp, ok := cf[vn]
+		if !ok {
+			grp = vn
+		}
+		codefiles[vn] = grp
+		if groups[grp] == nil {
+			groups[grp], err = config.GetFactorCodes(vn, conf)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	idm, sidm, nidm = nil, nil, 0
+	if config.HasIdMap(conf.Routing.IdVar, conf) {
+		idm, err = config.ReadIdMap(conf.Routing.IdVar, conf)
+		if err != nil {
+			return err
+		}
+		nidm = idm.Len()
+	}
+
+	changed = make(map[string]bool)
+	pending, npending = make(map[int][]record), 0
+	last = make(map[int][]uint64)
+	nrows = make(map[int]int)
+	added = make(map[int]int)
+
+	return nil
+}
+
+// Run runs append with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("append", flag.ContinueOnError)
+	var dl string
+	fs.StringVar(&targetdir, "targetdir", "", "data set receiving the records")
+	fs.StringVar(&sourcedir, "sourcedir", "", "data set holding the new records")
+	fs.StringVar(&dl, "delim", ",", "field delimiter of text files")
+	fs.StringVar(&na, "na", "NA", "value denoting a missing value in text files, besides an empty field")
+	fs.IntVar(&buffer, "buffer", 100000, "number of records held in memory before they are written to their buckets")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	vnames = nil
+
+	files := fs.Args()
+	if targetdir == "" || (sourcedir == "") == (len(files) == 0) || len([]rune(dl)) != 1 {
+		return cli.Usage("usage:\nappend -targetdir=... (-sourcedir=... | [-delim=...] [-na=...] file...)\n\n")
+	}
+	delim = []rune(dl)[0]
+
+	if buffer < 1 {
+		return errors.New("-buffer must be positive")
+	}
+
+	if sourcedir != "" {
+		if err := config.CheckTarget(sourcedir, targetdir); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	conf, err = config.GetConfig(targetdir)
+	if err != nil {
+		return err
+	}
+	if conf.Routing == nil {
+		return fmt.Errorf("%s has no routing information, run buildrouting first", targetdir)
+	}
+
+	if err := setup(); err != nil {
+		return err
+	}
+
+	var n int
+	if sourcedir != "" {
+		n, err = appenddata()
+	} else {
+		for _, fname := range files {
+			var m int
+			m, err = appendtext(fname)
+			n += m
+			if err != nil {
+				break
+			}
+		}
+	}
+
+	// Close the files even after an error, so that the records
+	// appended so far are readable.
+	if err2 := finish(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+
+	slog.Info("appended records", "records", n, "buckets", len(added))
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package append_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	appendcmd "github.com/kshedden/gocols/append"
+	"github.com/kshedden/gocols/coltest"
+	"github.com/kshedden/gocols/verify"
+)
+
+// records returns delimited text holding records lo to hi-1, where
+// every fourth value of x is missing.
+func records(lo, hi int) string {
+	var b strings.Builder
+	b.WriteString("id,x\n")
+	for i := lo; i < hi; i++ {
+		if i%4 == 0 {
+			fmt.Fprintf(&b, "%d,\n", i)
+		} else {
+			fmt.Fprintf(&b, "%d,%d\n", i, 10*i)
+		}
+	}
+	return b.String()
+}
+
+// buckets returns the sorted records of each bucket of a data set.
+func buckets(t *testing.T, dir string) [][]string {
+	var r [][]string
+	for k := 0; k < 3; k++ {
+		recs := coltest.Records(t, dir, fmt.Sprintf("-bucket=%d", k))
+		sort.Strings(recs)
+		r = append(r, recs)
+	}
+	return r
+}
+
+func TestAppend(t *testing.T) {
+
+	full := coltest.CSV(t, records(0, 30), "-buckets=3", "-idvar=id", "-routing=modulo")
+
+	// A small -buffer writes the records to their buckets in several
+	// rounds.
+	for _, buffer := range []string{"2", "100000"} {
+
+		text := coltest.CSV(t, records(0, 20), "-buckets=3", "-idvar=id", "-routing=modulo")
+		fn := filepath.Join(t.TempDir(), "more.csv")
+		if err := ioutil.WriteFile(fn, []byte(records(20, 30)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := appendcmd.Run([]string{"-targetdir=" + text, "-buffer=" + buffer, "-q", fn}); err != nil {
+			t.Fatal(err)
+		}
+
+		data := coltest.CSV(t, records(0, 20), "-buckets=3", "-idvar=id", "-routing=modulo")
+		more := coltest.CSV(t, records(20, 30), "-buckets=2", "-idvar=id", "-routing=modulo")
+		if err := appendcmd.Run([]string{"-targetdir=" + data, "-sourcedir=" + more, "-buffer=" + buffer, "-q"}); err != nil {
+			t.Fatal(err)
+		}
+
+		want := buckets(t, full)
+		for _, dir := range []string{text, data} {
+			if got := buckets(t, dir); !reflect.DeepEqual(got, want) {
+				t.Errorf("-buffer=%s: the appended data set has buckets %q, expected %q", buffer, got, want)
+			}
+			if _, err := coltest.Stdout(t, verify.Run, "-sourcedir="+dir); err != nil {
+				t.Errorf("-buffer=%s: verify: %v", buffer, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,416 @@
+// Package arrowcols converts the buckets of a data set to Apache Arrow
+// records, for export to Arrow and Parquet files and for streaming by
+// flightserver.
+//
+// Unsigned integer columns become Arrow unsigned integers (uvarint and
+// delta-uvarint become uint64), varint columns become int64, float
+// columns keep their width, bool columns become Arrow booleans, text
+// columns become Arrow strings, date32 columns become Arrow date32 and
+// timestamp64 columns become Arrow timestamps in microseconds, UTC.
+// Values marked as missing in the validity bitmap of a variable (see
+// config.NullSuffix) become Arrow nulls.  Factor-coded variables may be
+// decoded, in which case they become dictionary-encoded strings whose
+// dictionary holds the label of each code.  The data type of each
+// column is recorded in the field metadata under DtypeKey, and the
+// code group of each decoded variable under GroupKey.
+package arrowcols
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/kshedden/gocols/config"
+)
+
+const (
+	// DtypeKey is the field metadata key holding the data type of a
+	// column in the data set.
+	DtypeKey = "gocols.dtype"
+
+	// GroupKey is the field metadata key holding the code group of
+	// a factor-coded variable.
+	GroupKey = "gocols.codegroup"
+
+	// LabelsKey is the field metadata key holding the factor codes,
+	// as a JSON object mapping labels to codes, of a factor-coded
+	// variable that is not decoded.
+	LabelsKey = "gocols.labels"
+)
+
+// DictType is the Arrow type of the decoded factor-coded variables.
+var DictType = &arrow.DictionaryType{
+	IndexType: arrow.PrimitiveTypes.Int32,
+	ValueType: arrow.BinaryTypes.String,
+}
+
+// Converter reads the buckets of a data set as Arrow records.
+type Converter struct {
+
+	// The directory containing the data set
+	SourceDir string
+
+	// The variables that are converted
+	Vars []string
+
+	// The schema of the records
+	Schema *arrow.Schema
+
+	// The data types of the variables
+	dtypes []string
+
+	// The labels of the decoded variables, nil for variables that
+	// are not decoded
+	dicts []arrow.Array
+
+	mem memory.Allocator
+}
+
+// ArrowType returns the Arrow type used for a data type.
+func ArrowType(dtype string) (arrow.DataType, error) {
+
+	switch dtype {
+	case "uint8":
+		return arrow.PrimitiveTypes.Uint8, nil
+	case "uint16":
+		return arrow.PrimitiveTypes.Uint16, nil
+	case "uint32":
+		return arrow.PrimitiveTypes.Uint32, nil
+	case "uint64", "uvarint", "delta-uvarint":
+		return arrow.PrimitiveTypes.Uint64, nil
+	case "varint":
+		return arrow.PrimitiveTypes.Int64, nil
+	case "float32":
+		return arrow.PrimitiveTypes.Float32, nil
+	case "float64":
+		return arrow.PrimitiveTypes.Float64, nil
+	case "text":
+		return arrow.BinaryTypes.String, nil
+	case "bool":
+		return arrow.FixedWidthTypes.Boolean, nil
+	case "date32":
+		return arrow.FixedWidthTypes.Date32, nil
+	case "timestamp64":
+		return arrow.FixedWidthTypes.Timestamp_us, nil
+	}
+
+	return nil, fmt.Errorf("dtype %s has no Arrow equivalent", dtype)
+}
+
+// NewConverter returns a converter for the given variables of the
+// data set in sourcedir.  If decode is true, factor-coded variables
+// are converted to dictionary-encoded strings.  The data types are
+// taken from the first bucket, and every bucket must agree with them.
+func NewConverter(sourcedir string, vars []string, decode bool) (*Converter, error) {
+
+	conf, err := config.GetConfig(sourcedir)
+	if err != nil {
+		return nil, err
+	}
+
+	dtypes, err := config.ReadDtypes(0, sourcedir)
+	if err != nil {
+		return nil, err
+	}
+
+	cf, err := config.ReadCodeFiles(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Converter{
+		SourceDir: sourcedir,
+		Vars:      vars,
+		dicts:     make([]arrow.Array, len(vars)),
+		mem:       memory.NewGoAllocator(),
+	}
+
+	var fields []arrow.Field
+	for j, vn := range vars {
+		dt, ok := dtypes[vn]
+		if !ok {
+			return nil, fmt.Errorf("variable %s not found in %s", vn, sourcedir)
+		}
+		c.dtypes = append(c.dtypes, dt)
+
+		typ, err := ArrowType(dt)
+		if err != nil {
+			return nil, err
+		}
+		md := map[string]string{DtypeKey: dt}
+
+		if config.HasFactorCodes(vn, conf) {
+			codes, err := config.GetFactorCodes(vn, conf)
+			if err != nil {
+				return nil, err
+			}
+			if decode {
+				c.dicts[j] = c.dictionary(codes)
+				typ = DictType
+			} else {
+				b, err := json.Marshal(codes)
+				if err != nil {
+					return nil, err
+				}
+				md[LabelsKey] = string(b)
+			}
+			grp, ok := cf[vn]
+			if !ok {
+				grp = vn
+			}
+			md[GroupKey] = grp
+		}
+
+		// Every field is nullable, since any bucket may hold
+		// missing values.
+		fields = append(fields, arrow.Field{Name: vn, Type: typ, Nullable: true, Metadata: arrow.MetadataFrom(md)})
+	}
+	c.Schema = arrow.NewSchema(fields, nil)
+
+	return c, nil
+}
+
+// dictionary returns the labels of a factor-coded variable, indexed by
+// code.  Codes without a label are given empty labels.
+func (c *Converter) dictionary(codes map[string]int) arrow.Array {
+
+	rev := config.RevCodes(codes)
+	var n int
+	for x := range rev {
+		if x+1 > n {
+			n = x + 1
+		}
+	}
+
+	bld := array.NewStringBuilder(c.mem)
+	defer bld.Release()
+	for x := 0; x < n; x++ {
+		bld.Append(rev[x])
+	}
+
+	return bld.NewArray()
+}
+
+// Release frees the dictionaries held by the converter.
+func (c *Converter) Release() {
+	for _, d := range c.dicts {
+		if d != nil {
+			d.Release()
+		}
+	}
+}
+
+// Bucket returns the records of one bucket.  The caller must release
+// the record.
+func (c *Converter) Bucket(bn int) (arrow.Record, error) {
+	return c.Select(bn, nil)
+}
+
+// keep returns true if row i is selected by the mask ix, where a nil
+// mask selects every row.
+func keep(ix []bool, i int) bool {
+	return ix == nil || (i < len(ix) && ix[i])
+}
+
+// Select returns the records of the rows of a bucket selected by ix,
+// which holds one entry per row, or all records if ix is nil.  The
+// caller must release the record.
+func (c *Converter) Select(bn int, ix []bool) (arrow.Record, error) {
+
+	dtypes, err := config.ReadDtypes(bn, c.SourceDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var cols []arrow.Array
+	defer func() {
+		for _, a := range cols {
+			a.Release()
+		}
+	}()
+
+	nrows := -1
+	for j, vn := range c.Vars {
+		if dtypes[vn] != c.dtypes[j] {
+			return nil, fmt.Errorf("variable %s has dtype %s in bucket %d but %s in bucket 0",
+				vn, dtypes[vn], bn, c.dtypes[j])
+		}
+
+		a, err := c.column(bn, j, ix)
+		if err != nil {
+			return nil, err
+		}
+		cols = append(cols, a)
+
+		if nrows == -1 {
+			nrows = a.Len()
+		} else if a.Len() != nrows {
+			return nil, fmt.Errorf("variable %s in bucket %d has %d rows, expected %d", vn, bn, a.Len(), nrows)
+		}
+	}
+	if nrows == -1 {
+		nrows = 0
+	}
+
+	return array.NewRecord(c.Schema, cols, int64(nrows)), nil
+}
+
+// column reads the values of variable j in the rows of a bucket
+// selected by ix.
+func (c *Converter) column(bn, j int, ix []bool) (arrow.Array, error) {
+
+	vn, dt := c.Vars[j], c.dtypes[j]
+	rdr, err := config.OpenReader(bn, c.SourceDir, vn, dt)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+
+	nr, err := config.OpenNulls(bn, c.SourceDir, vn)
+	if err != nil {
+		return nil, err
+	}
+	defer nr.Close()
+
+	if c.dicts[j] != nil {
+		return c.dictcolumn(rdr, nr, j, ix)
+	}
+
+	typ, err := ArrowType(dt)
+	if err != nil {
+		return nil, err
+	}
+	bld := array.NewBuilder(c.mem, typ)
+	defer bld.Release()
+
+	for i := 0; ; i++ {
+		valid, err := nr.Valid()
+		if err == nil && valid && keep(ix, i) {
+			err = appendvalue(bld, rdr)
+		} else if err == nil {
+			// The placeholder value, or the value of a row that is
+			// not selected, is read and dropped.
+			if dt == "text" {
+				_, err = rdr.Text()
+			} else {
+				_, err = rdr.Bits()
+			}
+			if err == nil && keep(ix, i) {
+				bld.AppendNull()
+			}
+		}
+		if err == io.EOF {
+			return bld.NewArray(), nil
+		} else if err != nil {
+			return nil, fmt.Errorf("reading %s in bucket %d: %v", vn, bn, err)
+		}
+	}
+}
+
+// appendvalue reads the next value of a column and appends it to a
+// builder of the Arrow type of the column.
+func appendvalue(bld array.Builder, rdr *config.ColumnReader) error {
+
+	var err error
+	switch b := bld.(type) {
+	case *array.Uint8Builder:
+		var x uint64
+		if x, err = rdr.Uint(); err == nil {
+			b.Append(uint8(x))
+		}
+	case *array.Uint16Builder:
+		var x uint64
+		if x, err = rdr.Uint(); err == nil {
+			b.Append(uint16(x))
+		}
+	case *array.Uint32Builder:
+		var x uint64
+		if x, err = rdr.Uint(); err == nil {
+			b.Append(uint32(x))
+		}
+	case *array.Uint64Builder:
+		var x uint64
+		if x, err = rdr.Uint(); err == nil {
+			b.Append(x)
+		}
+	case *array.Int64Builder:
+		var x int64
+		if x, err = rdr.Int(); err == nil {
+			b.Append(x)
+		}
+	case *array.Float32Builder:
+		var x float64
+		if x, err = rdr.Float(); err == nil {
+			b.Append(float32(x))
+		}
+	case *array.Float64Builder:
+		var x float64
+		if x, err = rdr.Float(); err == nil {
+			b.Append(x)
+		}
+	case *array.BooleanBuilder:
+		var x bool
+		if x, err = rdr.Bool(); err == nil {
+			b.Append(x)
+		}
+	case *array.Date32Builder:
+		var x int64
+		if x, err = rdr.Int(); err == nil {
+			b.Append(arrow.Date32(x))
+		}
+	case *array.TimestampBuilder:
+		var x int64
+		if x, err = rdr.Int(); err == nil {
+			b.Append(arrow.Timestamp(x))
+		}
+	case *array.StringBuilder:
+		var x string
+		if x, err = rdr.Text(); err == nil {
+			b.Append(x)
+		}
+	}
+
+	return err
+}
+
+// dictcolumn reads the codes of a decoded variable in the rows selected
+// by ix as a dictionary array, with the missing values given by nr as
+// nulls.
+func (c *Converter) dictcolumn(rdr *config.ColumnReader, nr *config.NullReader, j int, ix []bool) (arrow.Array, error) {
+
+	bld := array.NewInt32Builder(c.mem)
+	defer bld.Release()
+
+	n := c.dicts[j].Len()
+	for i := 0; ; i++ {
+		x, err := rdr.Uint()
+		var valid bool
+		if err == nil {
+			valid, err = nr.Valid()
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if !keep(ix, i) {
+			continue
+		}
+		if !valid {
+			bld.AppendNull()
+			continue
+		}
+		if x >= uint64(n) {
+			return nil, fmt.Errorf("variable %s has code %d with no label", c.Vars[j], x)
+		}
+		bld.Append(int32(x))
+	}
+
+	idx := bld.NewArray()
+	defer idx.Release()
+
+	return array.NewDictionaryArray(DictType, idx, c.dicts[j]), nil
+}
@@ -0,0 +1,77 @@
+// Attrs shows or changes the attributes of a data set (see
+// Config.Attrs), such as the source system, the extraction date or the
+// cohort definition.  Attributes given as key=value arguments are set,
+// and the keys given by -delete are removed.  With no changes, the
+// attributes are printed.
+
+package attrs
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+// Run runs attrs with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("attrs", flag.ContinueOnError)
+	var sourcedir, dlist string
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&dlist, "delete", "", "comma-separated attributes to remove")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" {
+		return cli.Usage("usage:\nattrs -sourcedir=... [-delete=...] [key=value...]\n\n")
+	}
+
+	set := make(map[string]string)
+	for _, a := range fs.Args() {
+		kv := strings.SplitN(a, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("Attributes must be given as key=value, not %q", a)
+		}
+		set[kv[0]] = kv[1]
+	}
+
+	conf, err := config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	if len(set) == 0 && dlist == "" {
+		var keys []string
+		for k := range conf.Attrs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("%s=%s\n", k, conf.Attrs[k])
+		}
+		return nil
+	}
+
+	if conf.Attrs == nil {
+		conf.Attrs = make(map[string]string)
+	}
+	if dlist != "" {
+		for _, k := range strings.Split(dlist, ",") {
+			delete(conf.Attrs, k)
+		}
+	}
+	for k, v := range set {
+		conf.Attrs[k] = v
+	}
+
+	if err := config.WriteConfig(sourcedir, conf); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+// Bucketmanifest writes a small JSON descriptor for each bucket of a
+// data set, listing its columns, their data types and file paths, and
+// the number of rows.  This allows a scheduler to hand out buckets to
+// independent workers without inspecting the data.
+//
+// With -meta, a meta.json file is also written in each bucket
+// directory, recording the number of rows, the size of each column
+// file and, if -idvar is given, the range of the id variable.  The
+// ranges of other unsigned integer variables may be recorded as well
+// by listing them in -ranges.  Select uses the recorded ranges as zone
+// maps, skipping the buckets that cannot hold any of the requested ids.
+
+package bucketmanifest
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The directory where the manifests are written.  If empty,
+	// each manifest is written into its bucket directory.
+	outdir string
+
+	// If true, write meta.json in each bucket
+	writemeta bool
+
+	// The id variable whose range is recorded in meta.json
+	idvar string
+
+	// Other variables whose ranges are recorded in meta.json
+	ranges []string
+
+	// Configuration information for the data set
+	conf *config.Config
+)
+
+// Column describes one column file in a bucket.
+type Column struct {
+	Name  string
+	Dtype string
+	Path  string
+}
+
+// Manifest describes the contents of one bucket.
+type Manifest struct {
+	Bucket  int
+	NumRows int
+	Columns []Column
+}
+
+// getmanifest builds the manifest for one bucket.
+func getmanifest(bn int) (*Manifest, error) {
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for vn := range dtypes {
+		names = append(names, vn)
+	}
+	sort.Strings(names)
+
+	man := &Manifest{Bucket: bn, NumRows: -1}
+	for _, vn := range names {
+		dt := dtypes[vn]
+		n, err := config.CountRows(bn, sourcedir, vn, dt)
+		if err != nil {
+			return nil, err
+		}
+		if man.NumRows == -1 {
+			man.NumRows = n
+		} else if n != man.NumRows {
+			return nil, fmt.Errorf("Bucket %d: variable %s has %d rows, expected %d", bn, vn, n, man.NumRows)
+		}
+		col := Column{Name: vn, Dtype: dt, Path: config.ColumnPath(bn, sourcedir, vn)}
+		man.Columns = append(man.Columns, col)
+	}
+	if man.NumRows == -1 {
+		man.NumRows = 0
+	}
+
+	return man, nil
+}
+
+// writemanifest saves the manifest for one bucket.
+func writemanifest(man *Manifest) error {
+
+	fn := path.Join(config.BucketPath(man.Bucket, sourcedir), "manifest.json")
+	if outdir != "" {
+		fn = path.Join(outdir, fmt.Sprintf("%04d.json", man.Bucket))
+	}
+
+	fid, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(fid)
+	if err := enc.Encode(man); err != nil {
+		fid.Close()
+		return err
+	}
+	return fid.Close()
+}
+
+// Run runs bucketmanifest with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("bucketmanifest", flag.ContinueOnError)
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&outdir, "outdir", "", "directory for the manifests (default is each bucket directory)")
+	fs.BoolVar(&writemeta, "meta", false, "also write meta.json in each bucket")
+	fs.StringVar(&idvar, "idvar", "", "id variable whose range is recorded in meta.json")
+	rlist := fs.String("ranges", "", "comma-separated variables whose ranges are recorded in meta.json")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" {
+		return cli.Usage("usage:\nbucketmanifest -sourcedir=... [-outdir=...] [-meta [-idvar=...] [-ranges=...]]\n\n")
+	}
+
+	// Use absolute paths so that the manifests can be consumed
+	// from any working directory.
+	var err error
+	sourcedir, err = filepath.Abs(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	if outdir != "" {
+		err = os.MkdirAll(outdir, 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	ranges = nil
+	if *rlist != "" {
+		ranges = strings.Split(*rlist, ",")
+	}
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		man, err := getmanifest(k)
+		if err != nil {
+			return err
+		}
+		if err := writemanifest(man); err != nil {
+			return err
+		}
+		if writemeta {
+			meta, err := config.ComputeMeta(k, sourcedir, idvar)
+			if err != nil {
+				return err
+			}
+			// Ranges recorded earlier are kept unless -ranges
+			// is given.
+			if ranges != nil {
+				if err := config.AddRanges(k, sourcedir, meta, ranges); err != nil {
+					return err
+				}
+			}
+			if err := config.WriteMeta(k, sourcedir, meta); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
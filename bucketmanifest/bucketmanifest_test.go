@@ -0,0 +1,70 @@
+package bucketmanifest_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kshedden/gocols/bucketmanifest"
+	"github.com/kshedden/gocols/coltest"
+	"github.com/kshedden/gocols/config"
+)
+
+func TestManifest(t *testing.T) {
+
+	dir := coltest.CSV(t, "id,s,x\n10,a,1.5\n11,b,2.5\n12,a,3.5\n13,c,4.5\n14,b,5.5\n")
+	outdir := coltest.Dir(t, "manifests")
+
+	err := bucketmanifest.Run([]string{"-sourcedir=" + dir, "-outdir=" + outdir, "-meta", "-idvar=id", "-q"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		bucket       int
+		nrows        int
+		idmin, idmax uint64
+	}{
+		{0, 3, 10, 14},
+		{1, 2, 11, 13},
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(outdir, fmt.Sprintf("%04d.json", tc.bucket)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var man bucketmanifest.Manifest
+		if err := json.Unmarshal(b, &man); err != nil {
+			t.Fatal(err)
+		}
+
+		if man.Bucket != tc.bucket || man.NumRows != tc.nrows {
+			t.Errorf("bucket %d: manifest of bucket %d with %d rows, expected %d rows",
+				tc.bucket, man.Bucket, man.NumRows, tc.nrows)
+		}
+
+		dtypes := map[string]string{"id": "uvarint", "s": "uint32", "x": "float64"}
+		if len(man.Columns) != len(dtypes) {
+			t.Fatalf("bucket %d: %d columns, expected %d", tc.bucket, len(man.Columns), len(dtypes))
+		}
+		for _, c := range man.Columns {
+			if c.Dtype != dtypes[c.Name] {
+				t.Errorf("bucket %d: %s has dtype %s, expected %s", tc.bucket, c.Name, c.Dtype, dtypes[c.Name])
+			}
+			if _, err := os.Stat(c.Path); err != nil {
+				t.Errorf("bucket %d: %v", tc.bucket, err)
+			}
+		}
+
+		meta, err := config.ReadMeta(tc.bucket, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.NumRows != tc.nrows || meta.IdVar != "id" || meta.IdMin != tc.idmin || meta.IdMax != tc.idmax {
+			t.Errorf("bucket %d: meta has %d rows and %s in [%d, %d], expected %d rows and id in [%d, %d]",
+				tc.bucket, meta.NumRows, meta.IdVar, meta.IdMin, meta.IdMax, tc.nrows, tc.idmin, tc.idmax)
+		}
+	}
+}
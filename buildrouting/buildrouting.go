@@ -0,0 +1,186 @@
+// Buildrouting determines how the records of a data set have been
+// assigned to buckets based on an id variable, and records the
+// routing rule in the configuration file.  Commands that add records
+// to the data set use the rule to place each record in the same
+// bucket that a full import would have placed it in.
+//
+// The supported rules are modulo routing (id mod the number of
+// buckets), hash routing (see config.HashID) and range routing, where
+// each bucket holds a contiguous range of ids.  By default the first
+// rule that is consistent with every record is used.
+
+package buildrouting
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The variable that determines the bucket
+	idvar string
+
+	// The routing method to record, or "auto" to detect it
+	method string
+
+	// Configuration information for the data set
+	conf *config.Config
+)
+
+// bucketinfo summarizes the ids in one bucket.
+type bucketinfo struct {
+
+	// The number of ids in the bucket
+	n int
+
+	// The smallest and largest ids in the bucket
+	min, max uint64
+
+	// True if every id in the bucket is consistent with modulo or
+	// hash routing respectively
+	modulo, hash bool
+}
+
+// scanbucket reads the ids in one bucket.
+func scanbucket(bn int) (*bucketinfo, error) {
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return nil, err
+	}
+	dt, ok := dtypes[idvar]
+	if !ok {
+		return nil, fmt.Errorf("Variable %s not found in bucket %d", idvar, bn)
+	}
+
+	rdr, err := config.OpenReader(bn, sourcedir, idvar, dt)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+
+	nb := uint64(conf.NumBuckets)
+	bi := &bucketinfo{modulo: true, hash: true}
+	for {
+		x, err := rdr.Uint()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if bi.n == 0 || x < bi.min {
+			bi.min = x
+		}
+		if bi.n == 0 || x > bi.max {
+			bi.max = x
+		}
+		bi.n++
+
+		bi.modulo = bi.modulo && x%nb == uint64(bn)
+		bi.hash = bi.hash && config.HashID(x)%nb == uint64(bn)
+	}
+
+	return bi, nil
+}
+
+// rangebounds returns the bucket lower bounds for range routing, or
+// nil if the buckets do not hold disjoint increasing ranges of ids.
+func rangebounds(info []*bucketinfo) []uint64 {
+
+	bounds := make([]uint64, len(info))
+
+	// Work backward so that an empty bucket gets the lower bound of
+	// the next non-empty bucket.
+	var next uint64
+	havenext := false
+	for k := len(info) - 1; k >= 0; k-- {
+		bi := info[k]
+		if bi.n == 0 {
+			bounds[k] = next
+			continue
+		}
+		if havenext && bi.max >= next {
+			return nil
+		}
+		bounds[k] = bi.min
+		next = bi.min
+		havenext = true
+	}
+
+	// Ids below the smallest bound go to the first bucket.
+	bounds[0] = 0
+
+	return bounds
+}
+
+// Run runs buildrouting with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("buildrouting", flag.ContinueOnError)
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&idvar, "idvar", "", "variable that determines the bucket")
+	fs.StringVar(&method, "method", "auto", "routing method (auto, modulo, hash or range)")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" || idvar == "" {
+		return cli.Usage("usage:\nbuildrouting -sourcedir=... -idvar=... [-method=...]\n\n")
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	var info []*bucketinfo
+	modulo, hash := true, true
+	for k := 0; k < conf.NumBuckets; k++ {
+		bi, err := scanbucket(k)
+		if err != nil {
+			return err
+		}
+		info = append(info, bi)
+		modulo = modulo && bi.modulo
+		hash = hash && bi.hash
+	}
+	bounds := rangebounds(info)
+
+	ok := map[string]bool{"modulo": modulo, "hash": hash, "range": bounds != nil}
+	if method == "auto" {
+		for _, m := range []string{"modulo", "hash", "range"} {
+			if ok[m] {
+				method = m
+				break
+			}
+		}
+		if method == "auto" {
+			return fmt.Errorf("The buckets of %s are not consistent with any routing method", sourcedir)
+		}
+	} else if !ok[method] {
+		return fmt.Errorf("The buckets of %s are not consistent with %s routing", sourcedir, method)
+	}
+
+	r := &config.Routing{IdVar: idvar, Method: method}
+	if method == "range" {
+		r.Bounds = bounds
+	}
+	conf.Routing = r
+	if err := config.WriteConfig(sourcedir, conf); err != nil {
+		return err
+	}
+
+	slog.Info("recorded routing", "method", method, "idvar", idvar)
+
+	return nil
+}
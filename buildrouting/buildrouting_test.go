@@ -0,0 +1,81 @@
+package buildrouting_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	appendcmd "github.com/kshedden/gocols/append"
+	"github.com/kshedden/gocols/buildrouting"
+	"github.com/kshedden/gocols/coltest"
+	"github.com/kshedden/gocols/config"
+)
+
+// records returns delimited text holding records lo to hi-1, with
+// ids that are not consecutive.
+func records(lo, hi int) string {
+	var b strings.Builder
+	b.WriteString("id,x\n")
+	for i := lo; i < hi; i++ {
+		fmt.Fprintf(&b, "%d,%d\n", 7*i+3, i)
+	}
+	return b.String()
+}
+
+// buckets returns the sorted records of each bucket of a data set.
+func buckets(t *testing.T, dir string) [][]string {
+	var r [][]string
+	for k := 0; k < 3; k++ {
+		recs := coltest.Records(t, dir, fmt.Sprintf("-bucket=%d", k))
+		sort.Strings(recs)
+		r = append(r, recs)
+	}
+	return r
+}
+
+func TestAppendRouting(t *testing.T) {
+
+	for _, routing := range []string{"modulo", "hash"} {
+
+		full := coltest.CSV(t, records(0, 30), "-buckets=3", "-idvar=id", "-routing="+routing)
+
+		// Import part of the records, forget the routing and find
+		// it again with buildrouting, then append the others.
+		part := coltest.CSV(t, records(0, 20), "-buckets=3", "-idvar=id", "-routing="+routing)
+		conf, err := config.GetConfig(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conf.Routing = nil
+		if err := config.WriteConfig(part, conf); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := buildrouting.Run([]string{"-sourcedir=" + part, "-idvar=id", "-q"}); err != nil {
+			t.Fatal(err)
+		}
+		conf, err = config.GetConfig(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conf.Routing == nil || conf.Routing.Method != routing {
+			t.Errorf("%s: found routing %+v", routing, conf.Routing)
+		}
+
+		fn := filepath.Join(t.TempDir(), "more.csv")
+		if err := ioutil.WriteFile(fn, []byte(records(20, 30)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := appendcmd.Run([]string{"-targetdir=" + part, "-q", fn}); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := buckets(t, part), buckets(t, full); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: the appended data set has buckets %q, expected %q", routing, got, want)
+		}
+	}
+}
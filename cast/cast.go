@@ -0,0 +1,278 @@
+// Cast changes the data type used to store a variable, in every bucket
+// of a data set.  Any numeric data type can be converted to any other,
+// as long as every value can be represented in the new data type:
+// values must fit in the range of integer types, values converted to
+// unsigned types must be non-negative, float values converted to
+// integer types must be whole numbers, and values converted to
+// delta-uvarint must be non-decreasing within each bucket.  Converting
+// float64 to float32 rounds the values, but values beyond the range of
+// float32 are an error.  Factor-coded variables must keep an unsigned
+// integer type.
+//
+// Integer values converted to date32 or timestamp64 are taken to be
+// days or microseconds since 1970-01-01, so that dates stored as
+// integers can be given their data type, and dates and times converted
+// to other types become these integers.
+//
+// The converted columns are written to temporary files, which replace
+// the original columns only once every bucket has been converted, so
+// the data set is unchanged if any value cannot be converted.
+
+package cast
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The variable to convert
+	vname string
+
+	// The new data type
+	dtype string
+
+	// Configuration information for the data set
+	conf *config.Config
+)
+
+// isfloat returns true for the floating point data types.
+func isfloat(dt string) bool {
+	return dt == "float32" || dt == "float64"
+}
+
+// isunsigned returns true for the unsigned integer data types.
+func isunsigned(dt string) bool {
+	_, ok := config.DTsize[dt]
+	return (ok && !isfloat(dt) && !config.IsTime(dt)) || dt == "uvarint" || dt == "delta-uvarint"
+}
+
+// getdtype returns the data type of the variable in a bucket.
+func getdtype(bn int) (string, error) {
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return "", err
+	}
+	dt, ok := dtypes[vname]
+	if !ok {
+		return "", fmt.Errorf("variable %s not found in bucket %d", vname, bn)
+	}
+
+	return dt, nil
+}
+
+// convert reads the next value from a column and returns it in the
+// representation of config.WriteBits for the new data type.
+func convert(rdr *config.ColumnReader) (uint64, error) {
+
+	src := rdr.Dtype()
+
+	switch {
+	case isfloat(src):
+		x, err := rdr.Float()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case dtype == "float32":
+			if !math.IsInf(x, 0) && math.Abs(x) > math.MaxFloat32 {
+				return 0, fmt.Errorf("value %g is out of the range of float32", x)
+			}
+			return math.Float64bits(x), nil
+		case dtype == "float64":
+			return math.Float64bits(x), nil
+		case x != math.Trunc(x) || math.IsInf(x, 0):
+			return 0, fmt.Errorf("value %g is not a whole number", x)
+		case dtype == "varint" || config.IsTime(dtype):
+			if x < math.MinInt64 || x >= math.MaxInt64 {
+				return 0, fmt.Errorf("value %g is out of the range of %s", x, dtype)
+			}
+			return uint64(int64(x)), nil
+		case x < 0 || x >= math.MaxUint64:
+			return 0, fmt.Errorf("value %g is out of the range of %s", x, dtype)
+		}
+		return uint64(x), nil
+
+	case src == "varint" || config.IsTime(src):
+		x, err := rdr.Int()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case isfloat(dtype):
+			return math.Float64bits(float64(x)), nil
+		case dtype == "varint" || config.IsTime(dtype):
+			return uint64(x), nil
+		case x < 0:
+			return 0, fmt.Errorf("value %d is negative", x)
+		}
+		return uint64(x), nil
+	}
+
+	x, err := rdr.Uint()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case isfloat(dtype):
+		return math.Float64bits(float64(x)), nil
+	case (dtype == "varint" || config.IsTime(dtype)) && x > math.MaxInt64:
+		return 0, fmt.Errorf("value %d is out of the range of %s", x, dtype)
+	}
+	return x, nil
+}
+
+// castbucket writes the converted column of one bucket to a temporary
+// file.
+func castbucket(bn int) error {
+
+	dt, err := getdtype(bn)
+	if err != nil {
+		return err
+	}
+
+	rdr, err := config.OpenReader(bn, sourcedir, vname, dt)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+
+	wtr, fid, err := config.CreateColumn(bn, sourcedir, vname+".tmp")
+	if err != nil {
+		return err
+	}
+
+	var last uint64
+	for i := 0; ; i++ {
+		var x uint64
+		x, err = convert(rdr)
+		if err == io.EOF {
+			err = nil
+			break
+		} else if err != nil {
+			err = fmt.Errorf("row %d: %v", i, err)
+			break
+		}
+
+		if dtype == "delta-uvarint" {
+			if x < last {
+				err = fmt.Errorf("row %d: value %d is smaller than the preceding value", i, x)
+				break
+			}
+			err = config.WriteUint(wtr, "uvarint", x-last)
+			last = x
+		} else {
+			err = config.WriteBits(wtr, dtype, x)
+		}
+		if err != nil {
+			err = fmt.Errorf("row %d: %v", i, err)
+			break
+		}
+	}
+
+	err1 := wtr.Close()
+	err2 := fid.Close()
+	for _, e := range []error{err, err1, err2} {
+		if e != nil {
+			return fmt.Errorf("bucket %d: %v", bn, e)
+		}
+	}
+
+	return nil
+}
+
+// replacebucket moves the converted column of one bucket into place
+// and records its data type, updating meta.json if it exists.
+func replacebucket(bn int) error {
+
+	err := os.Rename(config.ColumnPath(bn, sourcedir, vname+".tmp"), config.ColumnPath(bn, sourcedir, vname))
+	if err != nil {
+		return err
+	}
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return err
+	}
+	dtypes[vname] = dtype
+	err = config.WriteDtypes(bn, sourcedir, dtypes)
+	if err != nil {
+		return err
+	}
+
+	// The column size has changed.
+	if meta, err := config.ReadMeta(bn, sourcedir); err == nil {
+		meta, err = config.ComputeMeta(bn, sourcedir, meta.IdVar)
+		if err != nil {
+			return err
+		}
+		return config.WriteMeta(bn, sourcedir, meta)
+	}
+
+	return nil
+}
+
+// cleanup removes the temporary files.
+func cleanup() {
+	for k := 0; k < conf.NumBuckets; k++ {
+		os.Remove(config.ColumnPath(k, sourcedir, vname+".tmp"))
+	}
+}
+
+// Run runs cast with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("cast", flag.ContinueOnError)
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&vname, "var", "", "variable to convert")
+	fs.StringVar(&dtype, "dtype", "", "new data type")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" || vname == "" || dtype == "" {
+		return cli.Usage("usage:\ncast -sourcedir=... -var=... -dtype=...\n\n")
+	}
+
+	if _, ok := config.DTsize[dtype]; !ok && dtype != "uvarint" && dtype != "varint" && dtype != "delta-uvarint" {
+		return fmt.Errorf("Unsupported dtype %s", dtype)
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	if config.HasFactorCodes(vname, conf) && !isunsigned(dtype) {
+		return fmt.Errorf("Variable %s is factor-coded, and must keep an unsigned integer dtype", vname)
+	}
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		if err := castbucket(k); err != nil {
+			cleanup()
+			return fmt.Errorf("Cannot convert %s to %s: %v", vname, dtype, err)
+		}
+	}
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		if err := replacebucket(k); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("converted variable", "var", vname, "dtype", dtype, "buckets", conf.NumBuckets)
+
+	return nil
+}
@@ -0,0 +1,232 @@
+// Changedrows compares two versions of a data set keyed by an id
+// variable, and lists the ids that were added, modified or removed in
+// the new version.  A row is modified if the values of any of the
+// compared variables differ between the versions, which is detected
+// by comparing hashes of the rows.  The output is CSV, with the
+// status and the id in the first two columns.  Optionally the values
+// of the compared variables in the new version are included for added
+// and modified rows.
+
+package changedrows
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directories containing the two versions of the data set
+	olddir, newdir string
+
+	// The variable that identifies the rows
+	idvar string
+
+	// The variables to compare, all variables other than idvar if
+	// empty
+	vars []string
+
+	// If true, write the values of the added and modified rows
+	rows bool
+
+	out *bufio.Writer
+)
+
+// visitor is called for each row of a data set, with the id and the
+// values of the compared variables.
+type visitor func(id uint64, vals []string)
+
+// scan calls f for every row of the data set in directory pa.
+func scan(pa string, f visitor) error {
+
+	conf, err := config.GetConfig(pa)
+	if err != nil {
+		return err
+	}
+
+	for bn := 0; bn < conf.NumBuckets; bn++ {
+
+		dtypes, err := config.ReadDtypes(bn, pa)
+		if err != nil {
+			return err
+		}
+		idt, ok := dtypes[idvar]
+		if !ok {
+			return fmt.Errorf("Variable %s not found in bucket %d of %s", idvar, bn, pa)
+		}
+
+		idr, err := config.OpenReader(bn, pa, idvar, idt)
+		if err != nil {
+			return err
+		}
+
+		// Variables that are missing from this version are
+		// represented by empty values.
+		rdrs := make([]*config.ColumnReader, len(vars))
+		for j, vn := range vars {
+			if dt, ok := dtypes[vn]; ok {
+				rdrs[j], err = config.OpenReader(bn, pa, vn, dt)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		vals := make([]string, len(vars))
+		for {
+			id, err := idr.Uint()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+
+			for j := range vars {
+				if rdrs[j] == nil {
+					continue
+				}
+				vals[j], err = rdrs[j].Text()
+				if err != nil {
+					return err
+				}
+			}
+
+			f(id, vals)
+		}
+
+		idr.Close()
+		for _, r := range rdrs {
+			if r != nil {
+				r.Close()
+			}
+		}
+	}
+
+	return nil
+}
+
+// rowhash returns a hash of the values in a row.
+func rowhash(vals []string) uint64 {
+	h := fnv.New64a()
+	for _, v := range vals {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
+// emit writes one line of output.
+func emit(status string, id uint64, vals []string) {
+	fmt.Fprintf(out, "%s,%d", status, id)
+	if rows && vals != nil {
+		fmt.Fprintf(out, ",%s", strings.Join(vals, ","))
+	}
+	fmt.Fprintf(out, "\n")
+}
+
+// getvars returns the names of all variables in the first bucket of
+// the new version, other than idvar.
+func getvars() ([]string, error) {
+
+	dtypes, err := config.ReadDtypes(0, newdir)
+	if err != nil {
+		return nil, err
+	}
+
+	var vn []string
+	for v := range dtypes {
+		if v != idvar {
+			vn = append(vn, v)
+		}
+	}
+	sort.Strings(vn)
+
+	return vn, nil
+}
+
+// Run runs changedrows with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("changedrows", flag.ContinueOnError)
+	var vlist string
+	fs.StringVar(&olddir, "olddir", "", "directory of the old version")
+	fs.StringVar(&newdir, "newdir", "", "directory of the new version")
+	fs.StringVar(&idvar, "idvar", "", "variable identifying the rows")
+	fs.StringVar(&vlist, "vars", "", "comma-separated variables to compare (default all)")
+	fs.BoolVar(&rows, "rows", false, "include the values of added and modified rows")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if olddir == "" || newdir == "" || idvar == "" {
+		return cli.Usage("usage:\nchangedrows -olddir=... -newdir=... -idvar=... [-vars=...] [-rows]\n\n")
+	}
+
+	if vlist != "" {
+		vars = strings.Split(vlist, ",")
+	} else {
+		var err error
+		vars, err = getvars()
+		if err != nil {
+			return err
+		}
+	}
+
+	out = bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	if rows {
+		fmt.Fprintf(out, "status,%s,%s\n", idvar, strings.Join(vars, ","))
+	} else {
+		fmt.Fprintf(out, "status,%s\n", idvar)
+	}
+
+	oldhash := make(map[uint64]uint64)
+	err := scan(olddir, func(id uint64, vals []string) {
+		oldhash[id] = rowhash(vals)
+	})
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[uint64]bool)
+	var nadd, nmod int
+	err = scan(newdir, func(id uint64, vals []string) {
+		seen[id] = true
+		h, ok := oldhash[id]
+		if !ok {
+			emit("added", id, vals)
+			nadd++
+		} else if h != rowhash(vals) {
+			emit("modified", id, vals)
+			nmod++
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	var removed []uint64
+	for id := range oldhash {
+		if !seen[id] {
+			removed = append(removed, id)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i] < removed[j] })
+	for _, id := range removed {
+		emit("removed", id, nil)
+	}
+
+	slog.Info("compared versions", "added", nadd, "modified", nmod, "removed", len(removed))
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package changedrows_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kshedden/gocols/changedrows"
+	"github.com/kshedden/gocols/coltest"
+)
+
+func TestChangedRows(t *testing.T) {
+
+	// Row 2 is modified, row 3 removed and row 5 added.
+	olddir := coltest.CSV(t, "id,x,y\n1,10,1.5\n2,20,2.5\n3,30,3.5\n4,40,4.5\n")
+	newdir := coltest.CSV(t, "id,x,y\n1,10,1.5\n2,20,2.75\n4,40,4.5\n5,50,5.5\n")
+
+	for _, tc := range []struct {
+		flags []string
+		want  []string
+	}{
+		{
+			want: []string{
+				"status,id",
+				"modified,2",
+				"added,5",
+				"removed,3",
+			},
+		},
+		{
+			flags: []string{"-rows"},
+			want: []string{
+				"status,id,x,y",
+				"modified,2,20,2.75",
+				"added,5,50,5.5",
+				"removed,3",
+			},
+		},
+		{
+			// Row 2 is only modified in y.
+			flags: []string{"-vars=x"},
+			want: []string{
+				"status,id",
+				"added,5",
+				"removed,3",
+			},
+		},
+	} {
+		args := append([]string{"-olddir=" + olddir, "-newdir=" + newdir, "-idvar=id"}, tc.flags...)
+		out, err := coltest.Stdout(t, changedrows.Run, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, tc.want) {
+			t.Errorf("%v: got %q, expected %q", tc.flags, out, tc.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Checkdtypes confirms that every variable has the same data type in
+// all buckets of a data set.  Variables declared with different data
+// types in different buckets are reported, along with the buckets
+// using each data type, and the program exits with a non-zero status.
+
+package checkdtypes
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// Configuration information for the data set
+	conf *config.Config
+)
+
+// Run runs checkdtypes with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("checkdtypes", flag.ContinueOnError)
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" {
+		return cli.Usage("usage:\ncheckdtypes -sourcedir=...\n\n")
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	// The buckets using each data type, for each variable
+	vdt := make(map[string]map[string][]int)
+	for k := 0; k < conf.NumBuckets; k++ {
+		dtypes, err := config.ReadDtypes(k, sourcedir)
+		if err != nil {
+			return err
+		}
+		for vn, dt := range dtypes {
+			if vdt[vn] == nil {
+				vdt[vn] = make(map[string][]int)
+			}
+			vdt[vn][dt] = append(vdt[vn][dt], k)
+		}
+	}
+
+	var bad []string
+	for vn, dts := range vdt {
+		if len(dts) > 1 {
+			bad = append(bad, vn)
+		}
+	}
+	sort.Strings(bad)
+
+	for _, vn := range bad {
+		fmt.Printf("%s has %d data types:\n", vn, len(vdt[vn]))
+		var dts []string
+		for dt := range vdt[vn] {
+			dts = append(dts, dt)
+		}
+		sort.Strings(dts)
+		for _, dt := range dts {
+			bl := vdt[vn][dt]
+			fmt.Printf("    %s in %d buckets: %v\n", dt, len(bl), bl)
+		}
+	}
+
+	if len(bad) > 0 {
+		return cli.Status(1)
+	}
+	fmt.Printf("All %d variables have consistent data types\n", len(vdt))
+
+	return nil
+}
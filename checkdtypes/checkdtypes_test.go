@@ -0,0 +1,61 @@
+package checkdtypes_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kshedden/gocols/checkdtypes"
+	"github.com/kshedden/gocols/coltest"
+	"github.com/kshedden/gocols/config"
+)
+
+func TestCheckDtypes(t *testing.T) {
+
+	for _, tc := range []struct {
+		// The data type declared for x in each bucket, replacing
+		// uvarint
+		change map[int]string
+		want   []string
+	}{
+		{
+			want: []string{"All 2 variables have consistent data types"},
+		},
+		{
+			change: map[int]string{1: "uint16"},
+			want: []string{
+				"x has 2 data types:",
+				"    uint16 in 1 buckets: [1]",
+				"    uvarint in 2 buckets: [0 2]",
+			},
+		},
+		{
+			change: map[int]string{0: "uint8", 2: "uint16"},
+			want: []string{
+				"x has 3 data types:",
+				"    uint16 in 1 buckets: [2]",
+				"    uint8 in 1 buckets: [0]",
+				"    uvarint in 1 buckets: [1]",
+			},
+		},
+	} {
+		dir := coltest.CSV(t, "x,y\n1,2\n3,4\n5,6\n", "-buckets=3")
+		for k, dt := range tc.change {
+			dtypes, err := config.ReadDtypes(k, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dtypes["x"] = dt
+			if err := config.WriteDtypes(k, dir, dtypes); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		out, err := coltest.Stdout(t, checkdtypes.Run, "-sourcedir="+dir)
+		if (err != nil) != (tc.change != nil) {
+			t.Errorf("%v: got error %v", tc.change, err)
+		}
+		if !reflect.DeepEqual(out, tc.want) {
+			t.Errorf("%v: got %q, expected %q", tc.change, out, tc.want)
+		}
+	}
+}
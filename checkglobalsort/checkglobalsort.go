@@ -0,0 +1,124 @@
+// Checkglobalsort confirms that a data set is sorted by a variable
+// across all of its buckets, taken in bucket order.  Each bucket must
+// be sorted, and the last value in each non-empty bucket must not
+// exceed the first value in the next non-empty bucket.  The first
+// violation found is reported and the program exits with a non-zero
+// status.
+
+package checkglobalsort
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The sort variable
+	vname string
+
+	// Configuration information for the data set
+	conf *config.Config
+)
+
+// key holds a value of the sort variable.  Only u is used for
+// unsigned integer types and only f is used for the other types.
+type key struct {
+	u uint64
+	f float64
+}
+
+func (a key) less(b key) bool {
+	return a.u < b.u || (a.u == b.u && a.f < b.f)
+}
+
+func (a key) String() string {
+	if a.f != 0 {
+		return fmt.Sprintf("%v", a.f)
+	}
+	return fmt.Sprintf("%d", a.u)
+}
+
+// readkey reads one value of the sort variable.
+func readkey(rdr *config.ColumnReader) (key, error) {
+
+	switch rdr.Dtype() {
+	case "float32", "float64", "varint", "date32", "timestamp64":
+		f, err := rdr.Float()
+		return key{f: f}, err
+	}
+
+	u, err := rdr.Uint()
+	return key{u: u}, err
+}
+
+// Run runs checkglobalsort with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("checkglobalsort", flag.ContinueOnError)
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&vname, "var", "", "sort variable")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" || vname == "" {
+		return cli.Usage("usage:\ncheckglobalsort -sourcedir=... -var=...\n\n")
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	// The last value in the most recent non-empty bucket
+	var last key
+	lastbucket := -1
+
+	for bn := 0; bn < conf.NumBuckets; bn++ {
+
+		dtypes, err := config.ReadDtypes(bn, sourcedir)
+		if err != nil {
+			return err
+		}
+		dt, ok := dtypes[vname]
+		if !ok {
+			return fmt.Errorf("Variable %s not found in bucket %d", vname, bn)
+		}
+
+		rdr, err := config.OpenReader(bn, sourcedir, vname, dt)
+		if err != nil {
+			return err
+		}
+		for i := 0; ; i++ {
+			x, err := readkey(rdr)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+
+			if lastbucket >= 0 && x.less(last) {
+				if i == 0 {
+					return fmt.Errorf("Boundary violation: last value %s of bucket %d exceeds first value %s of bucket %d",
+						last, lastbucket, x, bn)
+				}
+				return fmt.Errorf("Bucket %d is not sorted: row %d has value %s after %s", bn, i, x, last)
+			}
+			last = x
+			lastbucket = bn
+		}
+		rdr.Close()
+	}
+
+	fmt.Printf("%s is globally sorted by %s\n", sourcedir, vname)
+
+	return nil
+}
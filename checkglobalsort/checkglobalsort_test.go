@@ -0,0 +1,59 @@
+package checkglobalsort_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kshedden/gocols/checkglobalsort"
+	"github.com/kshedden/gocols/coltest"
+)
+
+func TestGlobalSort(t *testing.T) {
+
+	for _, tc := range []struct {
+		// The records are placed in the buckets in turn.
+		text    string
+		buckets string
+		err     string
+	}{
+		{
+			// Buckets 1, 2, 3 and 5, 6
+			text:    "x\n1\n5\n2\n6\n3\n",
+			buckets: "2",
+		},
+		{
+			// Buckets 1, 2 and 2, 3, with equal boundary values
+			text:    "x\n1\n2\n2\n3\n",
+			buckets: "2",
+		},
+		{
+			// Buckets 1 and 2, with the last bucket empty
+			text:    "x\n1\n2\n",
+			buckets: "3",
+		},
+		{
+			// Buckets 1, 3, 5 and 2, 4
+			text:    "x\n1\n2\n3\n4\n5\n",
+			buckets: "2",
+			err:     "last value 5 of bucket 0 exceeds first value 2 of bucket 1",
+		},
+		{
+			// Buckets 1, 4 and 5, 2
+			text:    "x\n1\n5\n4\n2\n",
+			buckets: "2",
+			err:     "Bucket 1 is not sorted: row 1 has value 2 after 5",
+		},
+	} {
+		dir := coltest.CSV(t, tc.text, "-buckets="+tc.buckets)
+		out, err := coltest.Stdout(t, checkglobalsort.Run, "-sourcedir="+dir, "-var=x")
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("%q: %v", tc.text, err)
+			} else if len(out) != 1 || !strings.HasSuffix(out[0], " is globally sorted by x") {
+				t.Errorf("%q: got %q", tc.text, out)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: got error %v, expected %q", tc.text, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// Checkintegrity confirms that every code stored in a factor-coded
+// variable has a label in the variable's codes group.  Codes without
+// a label ("orphans") are reported per variable, with their counts
+// and some example row positions.
+
+package checkintegrity
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The number of example positions to report for each orphan
+	// code
+	nexamples int
+
+	// Configuration information for the data set
+	conf *config.Config
+
+	// The reverse factor codes for each factor-coded variable,
+	// nil for variables that are not factor-coded
+	labels map[string]map[int]string
+)
+
+// orphan records where a code without a label was found.
+type orphan struct {
+	count    int
+	examples []string
+}
+
+// getlabels returns the reverse codes for a variable, or nil if the
+// variable is not factor-coded.
+func getlabels(vname string) (map[int]string, error) {
+
+	if lab, ok := labels[vname]; ok {
+		return lab, nil
+	}
+
+	var lab map[int]string
+	if config.HasFactorCodes(vname, conf) {
+		codes, err := config.GetFactorCodes(vname, conf)
+		if err != nil {
+			return nil, err
+		}
+		lab = config.RevCodes(codes)
+	}
+	labels[vname] = lab
+
+	return lab, nil
+}
+
+// checkbucket adds the orphan codes found in one bucket to the given
+// map, keyed by variable name and then by code.
+func checkbucket(bn int, orphans map[string]map[uint64]*orphan) error {
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return err
+	}
+
+	for vn, dt := range dtypes {
+
+		lab, err := getlabels(vn)
+		if err != nil {
+			return err
+		}
+		if lab == nil {
+			continue
+		}
+
+		rdr, err := config.OpenReader(bn, sourcedir, vn, dt)
+		if err != nil {
+			return err
+		}
+		for i := 0; ; i++ {
+			x, err := rdr.Uint()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+
+			if _, ok := lab[int(x)]; ok {
+				continue
+			}
+
+			if orphans[vn] == nil {
+				orphans[vn] = make(map[uint64]*orphan)
+			}
+			o := orphans[vn][x]
+			if o == nil {
+				o = new(orphan)
+				orphans[vn][x] = o
+			}
+			o.count++
+			if len(o.examples) < nexamples {
+				o.examples = append(o.examples, fmt.Sprintf("bucket %d row %d", bn, i))
+			}
+		}
+		rdr.Close()
+	}
+
+	return nil
+}
+
+// Run runs checkintegrity with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("checkintegrity", flag.ContinueOnError)
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.IntVar(&nexamples, "examples", 5, "number of example positions to report per orphan code")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" {
+		return cli.Usage("usage:\ncheckintegrity -sourcedir=... [-examples=...]\n\n")
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+	labels = make(map[string]map[int]string)
+
+	orphans := make(map[string]map[uint64]*orphan)
+	for k := 0; k < conf.NumBuckets; k++ {
+		if err := checkbucket(k, orphans); err != nil {
+			return err
+		}
+	}
+
+	var vnames []string
+	for vn := range orphans {
+		vnames = append(vnames, vn)
+	}
+	sort.Strings(vnames)
+
+	for _, vn := range vnames {
+		var codes []uint64
+		var n int
+		for x, o := range orphans[vn] {
+			codes = append(codes, x)
+			n += o.count
+		}
+		sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+
+		fmt.Printf("%s: %d distinct orphan codes in %d rows\n", vn, len(codes), n)
+		for _, x := range codes {
+			o := orphans[vn][x]
+			fmt.Printf("    code %d: %d rows", x, o.count)
+			for _, ex := range o.examples {
+				fmt.Printf("; %s", ex)
+			}
+			fmt.Printf("\n")
+		}
+	}
+
+	if len(vnames) > 0 {
+		return cli.Status(1)
+	}
+	fmt.Printf("All factor codes have labels\n")
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package checkintegrity_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kshedden/gocols/checkintegrity"
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/coltest"
+	"github.com/kshedden/gocols/config"
+)
+
+func TestOrphans(t *testing.T) {
+
+	for _, tc := range []struct {
+		// The label removed from the codes of s, if any
+		drop   string
+		flags  []string
+		want   []string
+		status int
+	}{
+		{
+			want: []string{"All factor codes have labels"},
+		},
+		{
+			// b has code 1, and is in rows 1 and 3, which are
+			// rows 0 and 1 of bucket 1.
+			drop: "b",
+			want: []string{
+				"s: 1 distinct orphan codes in 2 rows",
+				"    code 1: 2 rows; bucket 1 row 0; bucket 1 row 1",
+			},
+			status: 1,
+		},
+		{
+			drop:  "c",
+			flags: []string{"-examples=0"},
+			want: []string{
+				"s: 1 distinct orphan codes in 1 rows",
+				"    code 2: 1 rows",
+			},
+			status: 1,
+		},
+	} {
+		dir := coltest.CSV(t, "s,t\na,x\nb,y\nc,x\nb,y\na,x\n")
+		if tc.drop != "" {
+			conf, err := config.GetConfig(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			codes, err := config.GetFactorCodes("s", conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			delete(codes, tc.drop)
+			if err := config.WriteFactorCodes("s", codes, conf); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		out, err := coltest.Stdout(t, checkintegrity.Run, append([]string{"-sourcedir=" + dir}, tc.flags...)...)
+		if status := cli.Exit(err); status != tc.status {
+			t.Errorf("without %q: exit status %d, expected %d", tc.drop, status, tc.status)
+		}
+		if !reflect.DeepEqual(out, tc.want) {
+			t.Errorf("without %q: got %q, expected %q", tc.drop, out, tc.want)
+		}
+	}
+}
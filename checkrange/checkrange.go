@@ -0,0 +1,113 @@
+// Checkrange streams an unsigned integer column and reports every
+// value falling outside of a declared range, along with the bucket
+// and row position where it occurs.
+
+package checkrange
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The variable to check
+	vname string
+
+	// The inclusive bounds on the values of the variable
+	minval, maxval uint64
+
+	// If true, exit with a non-zero status when any value is out
+	// of range
+	strict bool
+
+	// Configuration information for the data set
+	conf *config.Config
+)
+
+// checkbucket reports the out of range values of the variable in one
+// bucket, and returns the number of values that were checked and the
+// number of values that are out of range.
+func checkbucket(bn int) (int, int, error) {
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return 0, 0, err
+	}
+	dt, ok := dtypes[vname]
+	if !ok {
+		return 0, 0, fmt.Errorf("Variable %s not found in bucket %d", vname, bn)
+	}
+
+	rdr, err := config.OpenReader(bn, sourcedir, vname, dt)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rdr.Close()
+
+	var n, nbad int
+	for {
+		x, err := rdr.Uint()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, 0, err
+		}
+
+		if x < minval || x > maxval {
+			fmt.Printf("bucket %d row %d: %d\n", bn, n, x)
+			nbad++
+		}
+		n++
+	}
+
+	return n, nbad, nil
+}
+
+// Run runs checkrange with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("checkrange", flag.ContinueOnError)
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&vname, "var", "", "variable to check")
+	fs.Uint64Var(&minval, "min", 0, "smallest allowed value")
+	fs.Uint64Var(&maxval, "max", ^uint64(0), "largest allowed value")
+	fs.BoolVar(&strict, "strict", false, "exit with an error if any value is out of range")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" || vname == "" {
+		return cli.Usage("usage:\ncheckrange -sourcedir=... -var=... [-min=...] [-max=...] [-strict]\n\n")
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	var n, nbad int
+	for k := 0; k < conf.NumBuckets; k++ {
+		m, mbad, err := checkbucket(k)
+		if err != nil {
+			return err
+		}
+		n += m
+		nbad += mbad
+	}
+
+	fmt.Printf("%d out of %d values of %s are outside [%d, %d]\n", nbad, n, vname, minval, maxval)
+
+	if strict && nbad > 0 {
+		return cli.Status(1)
+	}
+
+	return nil
+}
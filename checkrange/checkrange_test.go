@@ -0,0 +1,54 @@
+package checkrange_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kshedden/gocols/checkrange"
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/coltest"
+)
+
+func TestCheckrange(t *testing.T) {
+
+	// Rows 0, 2 and 4 are in bucket 0, rows 1 and 3 in bucket 1.
+	dir := coltest.CSV(t, "x\n5\n12\n7\n3\n10\n")
+
+	for _, tc := range []struct {
+		flags  []string
+		out    []string
+		status int
+	}{
+		{
+			flags: []string{"-min=3", "-max=12"},
+			out:   []string{"0 out of 5 values of x are outside [3, 12]"},
+		},
+		{
+			flags: []string{"-min=4", "-max=10"},
+			out: []string{
+				"bucket 1 row 0: 12",
+				"bucket 1 row 1: 3",
+				"2 out of 5 values of x are outside [4, 10]",
+			},
+		},
+		{
+			flags: []string{"-max=6", "-strict"},
+			out: []string{
+				"bucket 0 row 1: 7",
+				"bucket 0 row 2: 10",
+				"bucket 1 row 0: 12",
+				"3 out of 5 values of x are outside [0, 6]",
+			},
+			status: 1,
+		},
+	} {
+		args := append([]string{"-sourcedir=" + dir, "-var=x"}, tc.flags...)
+		out, err := coltest.Stdout(t, checkrange.Run, args...)
+		if status := cli.Exit(err); status != tc.status {
+			t.Errorf("%v: exit status %d, expected %d", tc.flags, status, tc.status)
+		}
+		if !reflect.DeepEqual(out, tc.out) {
+			t.Errorf("%v: got %q, expected %q", tc.flags, out, tc.out)
+		}
+	}
+}
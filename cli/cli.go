@@ -0,0 +1,103 @@
+// Package cli holds what the commands of gocols share.  Each command
+// is a package with a function
+//
+//	func Run(args []string) error
+//
+// which the gocols program calls with the arguments following the name
+// of the command.  A command defines its flags in its own flag set,
+// named after the command, parses them with Parse, logs its progress
+// with log/slog (see package logging), and returns its errors instead
+// of exiting, so that they are reported in the same way for every
+// command by Exit.
+
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/kshedden/gocols/jobconfig"
+	"github.com/kshedden/gocols/logging"
+)
+
+// Parse parses the arguments of a command with the flag set fs, which
+// is created with flag.ContinueOnError.  The flags that are not given
+// in args are taken from the environment or from a job file, as
+// described in package jobconfig.  An invalid flag is reported by fs,
+// and -h makes Parse return flag.ErrHelp after fs prints the flags.
+//
+// Parse adds the logging flags to fs, logging to stderr by default,
+// unless the command has registered them with its own default, and
+// sets up the default logger of log/slog.
+func Parse(fs *flag.FlagSet, args []string) error {
+
+	file := jobconfig.Flag(fs)
+	if fs.Lookup("log") == nil {
+		logging.Flags(fs, "-")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return Status(2)
+	}
+
+	if err := jobconfig.Apply(fs, *file); err != nil {
+		return err
+	}
+
+	_, err := logging.Setup(fs)
+	return err
+}
+
+// usageError is an error whose message is printed as is.
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// Usage returns an error reporting that a command was given invalid
+// arguments, whose message, usually the usage of the command, is
+// formatted as by fmt.Sprintf and printed as is.
+func Usage(format string, a ...interface{}) error {
+	return usageError(fmt.Sprintf(format, a...))
+}
+
+// statusError is an error that has already been reported.
+type statusError int
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+// Status returns an error that makes a command exit with the given
+// status without a message, for a command that has reported its result
+// itself, such as a check that found problems.
+func Status(code int) error {
+	return statusError(code)
+}
+
+// Exit reports the error returned by a command on stderr, and returns
+// the exit status of the command: 0 if err is nil or flag.ErrHelp, the
+// status given to Status, or else 1.
+func Exit(err error) int {
+
+	var ue usageError
+	var se statusError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.As(err, &se):
+		return int(se)
+	case errors.As(err, &ue):
+		os.Stderr.WriteString(string(ue))
+		return 1
+	}
+
+	os.Stderr.WriteString(err.Error() + "\n")
+	return 1
+}
@@ -0,0 +1,232 @@
+// Collapserare combines the rare levels of a factor-coded variable
+// into a single level labeled "Other".  Levels occurring in fewer than
+// a given number of rows are recoded to the code of the "Other" level,
+// the column is rewritten in every bucket, and a new codes file is
+// written for the variable.  The variable is given its own code group,
+// so that other variables sharing its original group are unaffected.
+
+package collapserare
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The factor-coded variable
+	vname string
+
+	// Levels with fewer than this many rows are collapsed
+	mincount int
+
+	// The label of the collapsed level
+	other string
+
+	// Configuration information for the data set
+	conf *config.Config
+)
+
+// getdtype returns the data type of the variable in a bucket.
+func getdtype(bn int) (string, error) {
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return "", err
+	}
+	dt, ok := dtypes[vname]
+	if !ok {
+		return "", fmt.Errorf("Variable %s not found in bucket %d", vname, bn)
+	}
+
+	return dt, nil
+}
+
+// countlevels returns the number of rows with each code.
+func countlevels() (map[uint64]int, error) {
+
+	counts := make(map[uint64]int)
+
+	for bn := 0; bn < conf.NumBuckets; bn++ {
+		dt, err := getdtype(bn)
+		if err != nil {
+			return nil, err
+		}
+		rdr, err := config.OpenReader(bn, sourcedir, vname, dt)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			x, err := rdr.Uint()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			counts[x]++
+		}
+		rdr.Close()
+	}
+
+	return counts, nil
+}
+
+// rewrite recodes the variable in one bucket using the given map from
+// old codes to new codes.
+func rewrite(bn int, recode map[uint64]uint64) error {
+
+	dt, err := getdtype(bn)
+	if err != nil {
+		return err
+	}
+
+	rdr, err := config.OpenReader(bn, sourcedir, vname, dt)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+
+	tmpname := vname + ".tmp"
+	wtr, fid2, err := config.CreateColumn(bn, sourcedir, tmpname)
+	if err != nil {
+		return err
+	}
+
+	for {
+		x, err := rdr.Uint()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		y, ok := recode[x]
+		if !ok {
+			y = x
+		}
+		err = config.WriteUint(wtr, dt, y)
+		if err != nil {
+			return err
+		}
+	}
+
+	wtr.Close()
+	fid2.Close()
+
+	err = os.Rename(config.ColumnPath(bn, sourcedir, tmpname), config.ColumnPath(bn, sourcedir, vname))
+	if err != nil {
+		return err
+	}
+	if err := config.UpdateMeta(bn, sourcedir); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Run runs collapserare with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("collapserare", flag.ContinueOnError)
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&vname, "var", "", "factor-coded variable")
+	fs.IntVar(&mincount, "min-count", 0, "collapse levels with fewer than this many rows")
+	fs.StringVar(&other, "other", "Other", "label for the collapsed level")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" || vname == "" || mincount < 1 {
+		return cli.Usage("usage:\ncollapserare -sourcedir=... -var=... -min-count=... [-other=...]\n\n")
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	if !config.HasFactorCodes(vname, conf) {
+		return fmt.Errorf("Variable %s is not factor-coded", vname)
+	}
+
+	codes, err := config.GetFactorCodes(vname, conf)
+	if err != nil {
+		return err
+	}
+	labels := config.RevCodes(codes)
+	counts, err := countlevels()
+	if err != nil {
+		return err
+	}
+
+	// Use the existing code for the collapsed level if there is
+	// one, otherwise the next unused code.
+	oc, ok := codes[other]
+	if !ok {
+		for _, c := range codes {
+			if c >= oc {
+				oc = c + 1
+			}
+		}
+	}
+
+	newcodes := map[string]int{other: oc}
+	recode := make(map[uint64]uint64)
+	var collapsed []string
+	for lab, c := range codes {
+		if lab == other {
+			continue
+		}
+		if counts[uint64(c)] >= mincount {
+			newcodes[lab] = c
+			continue
+		}
+		collapsed = append(collapsed, lab)
+		if counts[uint64(c)] > 0 {
+			recode[uint64(c)] = uint64(oc)
+		}
+	}
+
+	// Codes without labels are left as they are.
+	for x := range counts {
+		if _, ok := labels[int(x)]; !ok {
+			slog.Warn("code has no label and is not collapsed", "var", vname, "code", x)
+		}
+	}
+
+	for bn := 0; bn < conf.NumBuckets; bn++ {
+		if err := rewrite(bn, recode); err != nil {
+			return err
+		}
+	}
+
+	if err := config.WriteFactorCodes(vname, newcodes, conf); err != nil {
+		return err
+	}
+	cf, err := config.ReadCodeFiles(conf)
+	if err != nil {
+		return err
+	}
+	cf[vname] = vname
+	if err := config.WriteCodeFiles(conf, cf); err != nil {
+		return err
+	}
+
+	sort.Strings(collapsed)
+	slog.Info("collapsed levels", "var", vname, "levels", len(collapsed), "into", other, "code", oc)
+	for _, lab := range collapsed {
+		slog.Info("collapsed level", "label", lab, "rows", counts[uint64(codes[lab])])
+	}
+
+	return nil
+}
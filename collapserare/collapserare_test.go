@@ -0,0 +1,61 @@
+package collapserare_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kshedden/gocols/collapserare"
+	"github.com/kshedden/gocols/coltest"
+	"github.com/kshedden/gocols/config"
+)
+
+func TestCollapseRare(t *testing.T) {
+
+	// The codes are a=0, b=1, c=2 and d=3.  Bucket 0 holds a, a, b,
+	// a and bucket 1 holds b, c, d.
+	const data = "s\na\nb\na\nc\nb\nd\na\n"
+
+	for _, tc := range []struct {
+		flags []string
+		want  []string
+		codes map[string]int
+	}{
+		{
+			flags: []string{"-min-count=2"},
+			want:  []string{"a", "a", "b", "a", "b", "Other", "Other"},
+			codes: map[string]int{"a": 0, "b": 1, "Other": 4},
+		},
+		{
+			flags: []string{"-min-count=1"},
+			want:  []string{"a", "a", "b", "a", "b", "c", "d"},
+			codes: map[string]int{"a": 0, "b": 1, "c": 2, "d": 3, "Other": 4},
+		},
+		{
+			// The collapsed level takes the code of b.
+			flags: []string{"-min-count=3", "-other=b"},
+			want:  []string{"a", "a", "b", "a", "b", "b", "b"},
+			codes: map[string]int{"a": 0, "b": 1},
+		},
+	} {
+		dir := coltest.CSV(t, data)
+		if err := collapserare.Run(append([]string{"-sourcedir=" + dir, "-var=s", "-q"}, tc.flags...)); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := coltest.Records(t, dir); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: got %q, expected %q", tc.flags, got, tc.want)
+		}
+
+		conf, err := config.GetConfig(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		codes, err := config.GetFactorCodes("s", conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(codes, tc.codes) {
+			t.Errorf("%v: got codes %v, expected %v", tc.flags, codes, tc.codes)
+		}
+	}
+}
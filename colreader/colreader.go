@@ -0,0 +1,391 @@
+// Package colreader reads the columns of a data set into slices, for
+// programs that use the data directly rather than through the
+// commands.  For example
+//
+//	ds, err := colreader.OpenDataset("/data/claims")
+//	...
+//	for k := 0; k < ds.NumBuckets(); k++ {
+//		income, err := ds.Bucket(k).Float64("income")
+//		...
+//	}
+//
+// The compression, the data types and the variable-length and delta
+// encodings are handled internally, and the number of values read is
+// checked against the number of rows recorded in meta.json.  Float64
+// accepts variables of any numeric data type, Int64 accepts integer
+// variables, and Uint64 accepts unsigned integer variables (bool values
+// are read as 0 and 1).  Bool returns the values of a bool variable,
+// Time returns the values of a date32 or timestamp64 variable, and
+// Labels returns the labels of a factor-coded variable.  Missing values
+// are read as placeholders, and Valid reports which values are present.
+// Dataset.Iter reads the rows of some variables in chunks of a given
+// size, for algorithms that stream over the data.
+package colreader
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kshedden/gocols/config"
+)
+
+// Dataset is a data set opened for reading.
+type Dataset struct {
+
+	// The directory containing the data set
+	Path string
+
+	// Configuration information for the data set
+	Config *config.Config
+
+	// The labels of the factor-coded variables, read when first
+	// needed
+	labels map[string]map[int]string
+	mut    sync.Mutex
+}
+
+// OpenDataset opens the data set in the given directory.
+func OpenDataset(pa string) (*Dataset, error) {
+
+	conf, err := config.GetConfig(pa)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Dataset{Path: pa, Config: conf, labels: make(map[string]map[int]string)}, nil
+}
+
+// NumBuckets returns the number of buckets in the data set.
+func (d *Dataset) NumBuckets() int {
+	return d.Config.NumBuckets
+}
+
+// Vars returns the names of the variables, in alphabetical order.
+func (d *Dataset) Vars() ([]string, error) {
+	return d.Bucket(0).Vars()
+}
+
+// IsFactor returns true if a variable is factor-coded.
+func (d *Dataset) IsFactor(vname string) bool {
+	return config.HasFactorCodes(vname, d.Config)
+}
+
+// Codes returns the map from labels to codes of a factor-coded
+// variable.
+func (d *Dataset) Codes(vname string) (map[string]int, error) {
+	return config.GetFactorCodes(vname, d.Config)
+}
+
+// revcodes returns the map from codes to labels of a factor-coded
+// variable.
+func (d *Dataset) revcodes(vname string) (map[int]string, error) {
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	if labels, ok := d.labels[vname]; ok {
+		return labels, nil
+	}
+
+	codes, err := d.Codes(vname)
+	if err != nil {
+		return nil, err
+	}
+	d.labels[vname] = config.RevCodes(codes)
+
+	return d.labels[vname], nil
+}
+
+// Bucket returns one bucket of the data set.  No files are read until
+// the data are requested.
+func (d *Dataset) Bucket(k int) *Bucket {
+	return &Bucket{ds: d, num: k}
+}
+
+// Bucket gives access to the columns of one bucket.  A Bucket is safe
+// for concurrent use.
+type Bucket struct {
+	ds  *Dataset
+	num int
+
+	dtypes map[string]string
+	mut    sync.Mutex
+}
+
+// Num returns the position of the bucket in the data set.
+func (b *Bucket) Num() int {
+	return b.num
+}
+
+// Dtypes returns the data type of each variable in the bucket.  The
+// map must not be modified.
+func (b *Bucket) Dtypes() (map[string]string, error) {
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if b.dtypes != nil {
+		return b.dtypes, nil
+	}
+
+	if b.num < 0 || b.num >= b.ds.Config.NumBuckets {
+		return nil, fmt.Errorf("bucket %d does not exist, the data set has %d buckets", b.num, b.ds.Config.NumBuckets)
+	}
+
+	dtypes, err := config.ReadDtypes(b.num, b.ds.Path)
+	if err != nil {
+		return nil, err
+	}
+	b.dtypes = dtypes
+
+	return dtypes, nil
+}
+
+// Dtype returns the data type of a variable in the bucket.
+func (b *Bucket) Dtype(vname string) (string, error) {
+
+	dtypes, err := b.Dtypes()
+	if err != nil {
+		return "", err
+	}
+
+	dt, ok := dtypes[vname]
+	if !ok {
+		return "", fmt.Errorf("variable %s not found in bucket %d", vname, b.num)
+	}
+
+	return dt, nil
+}
+
+// Vars returns the names of the variables in the bucket, in
+// alphabetical order.
+func (b *Bucket) Vars() ([]string, error) {
+
+	dtypes, err := b.Dtypes()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for vn := range dtypes {
+		names = append(names, vn)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// NumRows returns the number of rows in the bucket, from its meta.json
+// file if there is one.
+func (b *Bucket) NumRows() (int, error) {
+	if _, err := b.Dtypes(); err != nil {
+		return 0, err
+	}
+	return config.NumRows(b.num, b.ds.Path)
+}
+
+// open returns a reader for a variable.
+func (b *Bucket) open(vname string) (*config.ColumnReader, error) {
+
+	dt, err := b.Dtype(vname)
+	if err != nil {
+		return nil, err
+	}
+
+	return config.OpenReader(b.num, b.ds.Path, vname, dt)
+}
+
+// check confirms that the number of values read from a variable agrees
+// with the number of rows recorded in meta.json.
+func (b *Bucket) check(vname string, n int) error {
+	if err := config.CheckRows(b.num, b.ds.Path, n); err != nil {
+		return fmt.Errorf("variable %s: %v", vname, err)
+	}
+	return nil
+}
+
+// Float64 returns the values of a numeric variable.
+func (b *Bucket) Float64(vname string) ([]float64, error) {
+
+	rdr, err := b.open(vname)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+
+	var x []float64
+	for {
+		v, err := rdr.Float()
+		if err == io.EOF {
+			return x, b.check(vname, len(x))
+		} else if err != nil {
+			return nil, fmt.Errorf("variable %s in bucket %d: %v", vname, b.num, err)
+		}
+		x = append(x, v)
+	}
+}
+
+// Int64 returns the values of an integer variable.  Unsigned values
+// larger than the largest int64 are an error.
+func (b *Bucket) Int64(vname string) ([]int64, error) {
+
+	rdr, err := b.open(vname)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+
+	if dt := rdr.Dtype(); dt == "float32" || dt == "float64" {
+		return nil, fmt.Errorf("variable %s has dtype %s in bucket %d, not an integer type", vname, dt, b.num)
+	}
+
+	var x []int64
+	for {
+		v, err := rdr.Int()
+		if err == io.EOF {
+			return x, b.check(vname, len(x))
+		} else if err != nil {
+			return nil, fmt.Errorf("variable %s in bucket %d: %v", vname, b.num, err)
+		}
+		x = append(x, v)
+	}
+}
+
+// Uint64 returns the values of an unsigned integer variable.
+func (b *Bucket) Uint64(vname string) ([]uint64, error) {
+
+	rdr, err := b.open(vname)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+
+	if dt := rdr.Dtype(); dt == "float32" || dt == "float64" || dt == "varint" || config.IsTime(dt) {
+		return nil, fmt.Errorf("variable %s has dtype %s in bucket %d, not an unsigned integer type", vname, dt, b.num)
+	}
+
+	var x []uint64
+	for {
+		v, err := rdr.Uint()
+		if err == io.EOF {
+			return x, b.check(vname, len(x))
+		} else if err != nil {
+			return nil, fmt.Errorf("variable %s in bucket %d: %v", vname, b.num, err)
+		}
+		x = append(x, v)
+	}
+}
+
+// Bool returns the values of a bool variable.
+func (b *Bucket) Bool(vname string) ([]bool, error) {
+
+	rdr, err := b.open(vname)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+
+	var x []bool
+	for {
+		v, err := rdr.Bool()
+		if err == io.EOF {
+			return x, b.check(vname, len(x))
+		} else if err != nil {
+			return nil, fmt.Errorf("variable %s in bucket %d: %v", vname, b.num, err)
+		}
+		x = append(x, v)
+	}
+}
+
+// Time returns the values of a date32 or timestamp64 variable, in UTC.
+func (b *Bucket) Time(vname string) ([]time.Time, error) {
+
+	rdr, err := b.open(vname)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+
+	dt := rdr.Dtype()
+	if !config.IsTime(dt) {
+		return nil, fmt.Errorf("variable %s has dtype %s in bucket %d, not date32 or timestamp64", vname, dt, b.num)
+	}
+
+	var x []time.Time
+	for {
+		v, err := rdr.Int()
+		if err == io.EOF {
+			return x, b.check(vname, len(x))
+		} else if err != nil {
+			return nil, fmt.Errorf("variable %s in bucket %d: %v", vname, b.num, err)
+		}
+		if dt == "date32" {
+			x = append(x, time.Unix(v*86400, 0).UTC())
+		} else {
+			x = append(x, time.UnixMicro(v).UTC())
+		}
+	}
+}
+
+// Valid returns, for each row, true if the variable has a value and
+// false if the value is missing.  If the variable has no missing values
+// in the bucket, nil is returned.
+func (b *Bucket) Valid(vname string) ([]bool, error) {
+
+	if _, err := b.Dtype(vname); err != nil {
+		return nil, err
+	}
+
+	nr, err := config.OpenNulls(b.num, b.ds.Path, vname)
+	if err != nil {
+		return nil, err
+	}
+	defer nr.Close()
+	if !nr.HasNulls() {
+		return nil, nil
+	}
+
+	var x []bool
+	for {
+		v, err := nr.Valid()
+		if err == io.EOF {
+			return x, b.check(vname, len(x))
+		} else if err != nil {
+			return nil, fmt.Errorf("variable %s in bucket %d: %v", vname, b.num, err)
+		}
+		x = append(x, v)
+	}
+}
+
+// Labels returns the labels of a factor-coded variable.  Codes without
+// a label are an error.
+func (b *Bucket) Labels(vname string) ([]string, error) {
+
+	if !b.ds.IsFactor(vname) {
+		return nil, fmt.Errorf("variable %s is not factor-coded", vname)
+	}
+
+	labels, err := b.ds.revcodes(vname)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := b.Uint64(vname)
+	if err != nil {
+		return nil, err
+	}
+
+	x := make([]string, len(codes))
+	for i, c := range codes {
+		lab, ok := labels[int(c)]
+		if !ok {
+			return nil, fmt.Errorf("code %d of variable %s in bucket %d has no label", c, vname, b.num)
+		}
+		x[i] = lab
+	}
+
+	return x, nil
+}
@@ -0,0 +1,320 @@
+package colreader
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/kshedden/gocols/config"
+)
+
+// Chunk holds consecutive rows of some variables from one bucket.
+// Each variable is held in its natural type: float variables as
+// float64, varint, date32 and timestamp64 variables as int64 (days
+// or microseconds since 1970-01-01 for dates and times) and unsigned
+// integer variables as uint64.
+type Chunk struct {
+
+	// The bucket holding the rows
+	Bucket int
+
+	// The position of the first row within the bucket
+	Offset int
+
+	// The variables
+	Vars []string
+
+	pos   map[string]int
+	data  []interface{}
+	nrows int
+	fbuf  [][]float64
+}
+
+// Len returns the number of rows in the chunk.
+func (c *Chunk) Len() int {
+	return c.nrows
+}
+
+// Data returns the values of a variable, as a []float64, []int64 or
+// []uint64, or nil if the variable is not in the chunk.
+func (c *Chunk) Data(vname string) interface{} {
+	j, ok := c.pos[vname]
+	if !ok {
+		return nil
+	}
+	return c.data[j]
+}
+
+// Float64 returns the values of a variable converted to float64, or
+// nil if the variable is not in the chunk.
+func (c *Chunk) Float64(vname string) []float64 {
+
+	j, ok := c.pos[vname]
+	if !ok {
+		return nil
+	}
+
+	switch x := c.data[j].(type) {
+	case []float64:
+		return x
+	case []int64:
+		f := c.fbuf[j][0:0]
+		for _, v := range x {
+			f = append(f, float64(v))
+		}
+		c.fbuf[j] = f
+		return f
+	case []uint64:
+		f := c.fbuf[j][0:0]
+		for _, v := range x {
+			f = append(f, float64(v))
+		}
+		c.fbuf[j] = f
+		return f
+	}
+
+	return nil
+}
+
+// Int64 returns the values of a varint, date32 or timestamp64
+// variable, or nil if the variable is not in the chunk or has another
+// data type.
+func (c *Chunk) Int64(vname string) []int64 {
+	x, _ := c.Data(vname).([]int64)
+	return x
+}
+
+// Uint64 returns the values of an unsigned integer variable, or nil if
+// the variable is not in the chunk or has another data type.
+func (c *Chunk) Uint64(vname string) []uint64 {
+	x, _ := c.Data(vname).([]uint64)
+	return x
+}
+
+// Iter reads the rows of a data set in chunks.  The slices of a chunk
+// are reused, and are only valid until the next call to Next.
+//
+//	it := ds.Iter([]string{"age", "income"}, 10000)
+//	defer it.Close()
+//	for it.Next() {
+//		c := it.Chunk()
+//		age, income := c.Float64("age"), c.Float64("income")
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iter struct {
+	ds      *Dataset
+	size    int
+	buckets []int
+
+	// The position in buckets of the current bucket
+	bpos int
+
+	rdrs  []*config.ColumnReader
+	chunk Chunk
+	err   error
+}
+
+// Iter returns an iterator over chunks of at most size rows of the
+// given variables.  The buckets are visited in order, or only the
+// given buckets are visited.  A chunk never spans two buckets.
+func (d *Dataset) Iter(vars []string, size int, buckets ...int) *Iter {
+
+	if len(buckets) == 0 {
+		for k := 0; k < d.Config.NumBuckets; k++ {
+			buckets = append(buckets, k)
+		}
+	}
+
+	it := &Iter{ds: d, size: size, buckets: buckets, bpos: -1}
+	it.chunk.Vars = vars
+	it.chunk.pos = make(map[string]int)
+	for j, vn := range vars {
+		it.chunk.pos[vn] = j
+	}
+	it.chunk.data = make([]interface{}, len(vars))
+	it.chunk.fbuf = make([][]float64, len(vars))
+
+	if size < 1 {
+		it.err = fmt.Errorf("the chunk size must be positive")
+	} else if len(vars) == 0 {
+		it.err = fmt.Errorf("no variables")
+	}
+
+	return it
+}
+
+// Chunk returns the current chunk.
+func (it *Iter) Chunk() *Chunk {
+	return &it.chunk
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *Iter) Err() error {
+	return it.err
+}
+
+// Close closes the open column files.
+func (it *Iter) Close() error {
+
+	var first error
+	for _, r := range it.rdrs {
+		if err := r.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	it.rdrs = nil
+
+	return first
+}
+
+// open opens the columns of the next bucket.
+func (it *Iter) open() error {
+
+	if err := it.Close(); err != nil {
+		return err
+	}
+
+	it.bpos++
+	if it.bpos >= len(it.buckets) {
+		return io.EOF
+	}
+	b := it.ds.Bucket(it.buckets[it.bpos])
+
+	c := &it.chunk
+	c.Bucket = b.num
+	c.Offset = 0
+	c.nrows = 0
+
+	for j, vn := range c.Vars {
+		r, err := b.open(vn)
+		if err != nil {
+			return err
+		}
+		it.rdrs = append(it.rdrs, r)
+
+		switch r.Dtype() {
+		case "float32", "float64":
+			if _, ok := c.data[j].([]float64); !ok {
+				c.data[j] = make([]float64, 0, it.size)
+			}
+		case "varint", "date32", "timestamp64":
+			if _, ok := c.data[j].([]int64); !ok {
+				c.data[j] = make([]int64, 0, it.size)
+			}
+		default:
+			if _, ok := c.data[j].([]uint64); !ok {
+				c.data[j] = make([]uint64, 0, it.size)
+			}
+		}
+	}
+
+	return nil
+}
+
+// read reads up to it.size values of column j into the chunk, and
+// returns the number of values read.
+func (it *Iter) read(j int) (int, error) {
+
+	r := it.rdrs[j]
+	c := &it.chunk
+
+	switch x := c.data[j].(type) {
+	case []float64:
+		x = x[0:0]
+		for len(x) < it.size {
+			v, err := r.Float()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return 0, err
+			}
+			x = append(x, v)
+		}
+		c.data[j] = x
+		return len(x), nil
+	case []int64:
+		x = x[0:0]
+		for len(x) < it.size {
+			v, err := r.Int()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return 0, err
+			}
+			x = append(x, v)
+		}
+		c.data[j] = x
+		return len(x), nil
+	case []uint64:
+		x = x[0:0]
+		for len(x) < it.size {
+			v, err := r.Uint()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return 0, err
+			}
+			x = append(x, v)
+		}
+		c.data[j] = x
+		return len(x), nil
+	}
+
+	return 0, fmt.Errorf("unexpected column type %T", c.data[j])
+}
+
+// Next advances to the next chunk, returning false when the data are
+// exhausted or an error occurs.
+func (it *Iter) Next() bool {
+
+	if it.err != nil {
+		return false
+	}
+
+	c := &it.chunk
+	c.Offset += c.nrows
+
+	for {
+		if it.rdrs == nil {
+			if err := it.open(); err == io.EOF {
+				return false
+			} else if err != nil {
+				it.err = err
+				return false
+			}
+		}
+
+		n := -1
+		for j, vn := range c.Vars {
+			m, err := it.read(j)
+			if err != nil {
+				it.err = fmt.Errorf("variable %s in bucket %d: %v", vn, c.Bucket, err)
+				return false
+			}
+			if n == -1 {
+				n = m
+			} else if m != n {
+				it.err = fmt.Errorf("variable %s in bucket %d has %d rows, but %s has %d",
+					vn, c.Bucket, c.Offset+m, c.Vars[0], c.Offset+n)
+				return false
+			}
+		}
+
+		if n > 0 {
+			c.nrows = n
+			return true
+		}
+
+		// The bucket is exhausted.
+		if err := config.CheckRows(c.Bucket, it.ds.Path, c.Offset); err != nil {
+			it.err = err
+			return false
+		}
+		if err := it.Close(); err != nil {
+			it.err = err
+			return false
+		}
+	}
+}
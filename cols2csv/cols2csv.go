@@ -0,0 +1,251 @@
+// Cols2csv writes a data set, or one of its buckets, as delimited
+// text, with one line per record.  This is the inverse of csv2cols.
+// By default all variables are written, in alphabetical order,
+// factor-coded variables are written using their labels, and variables
+// with an id map (see config.IdMap) are written using the identifiers
+// that their ids were mapped from, as given to csv2cols -idmap.  Missing
+// values, marked in the validity bitmap of a variable (see
+// config.NullSuffix), are written as the -na string, which is empty by
+// default.  Every variable must have the number of rows recorded for
+// its bucket (see config.NumRows), so that a truncated or damaged
+// column is reported as an error rather than shortening the output.
+
+package cols2csv
+
+import (
+	"bufio"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The variables to write
+	vars []string
+
+	// The bucket to write, or -1 for all buckets
+	bucket int
+
+	// If true, write labels in place of factor codes, and identifiers
+	// in place of mapped ids
+	decode bool
+
+	// The text written for missing values
+	na string
+
+	// Configuration information for the data set
+	conf *config.Config
+
+	// The reverse factor codes of the variables, nil for variables
+	// that are not decoded
+	labels []map[int]string
+
+	// The id maps of the variables, nil for variables that are not
+	// decoded
+	idmaps []*config.IdMap
+
+	out *csv.Writer
+)
+
+// dobucket writes the records of one bucket.
+func dobucket(bn int) error {
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return err
+	}
+	nrows, err := config.NumRows(bn, sourcedir)
+	if err != nil {
+		return err
+	}
+
+	rdrs := make([]*config.ColumnReader, len(vars))
+	nrdrs := make([]*config.NullReader, len(vars))
+	for j, vn := range vars {
+		dt, ok := dtypes[vn]
+		if !ok {
+			return fmt.Errorf("variable %s not found in bucket %d", vn, bn)
+		}
+		rdrs[j], err = config.OpenReader(bn, sourcedir, vn, dt)
+		if err != nil {
+			return err
+		}
+		defer rdrs[j].Close()
+		nrdrs[j], err = config.OpenNulls(bn, sourcedir, vn)
+		if err != nil {
+			return err
+		}
+		defer nrdrs[j].Close()
+	}
+
+	rec := make([]string, len(vars))
+	for i := 0; i < nrows; i++ {
+		for j := range rdrs {
+			if labels[j] != nil {
+				var x uint64
+				x, err = rdrs[j].Uint()
+				lab, ok := labels[j][int(x)]
+				if ok {
+					rec[j] = lab
+				} else {
+					rec[j] = strconv.FormatUint(x, 10)
+				}
+			} else if idmaps[j] != nil {
+				var x uint64
+				x, err = rdrs[j].Uint()
+				if err == nil {
+					var ok bool
+					if rec[j], ok = idmaps[j].Label(x); !ok {
+						err = fmt.Errorf("id %d of %s in bucket %d is not in its id map", x, vars[j], bn)
+					}
+				}
+			} else {
+				rec[j], err = rdrs[j].Text()
+			}
+			if err == nil {
+				var valid bool
+				valid, err = nrdrs[j].Valid()
+				if !valid {
+					rec[j] = na
+				}
+			}
+			if err == io.EOF {
+				return fmt.Errorf("variable %s in bucket %d has %d rows, expected %d", vars[j], bn, i, nrows)
+			} else if err != nil {
+				return err
+			}
+		}
+
+		if err := out.Write(rec); err != nil {
+			return err
+		}
+	}
+
+	for j := range rdrs {
+		if _, err := rdrs[j].Text(); err != io.EOF {
+			if err == nil {
+				err = fmt.Errorf("variable %s in bucket %d has more than %d rows", vars[j], bn, nrows)
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run runs cols2csv with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("cols2csv", flag.ContinueOnError)
+	var vlist, dl, outname string
+	var header bool
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&vlist, "vars", "", "comma-separated variables to write (default all)")
+	fs.IntVar(&bucket, "bucket", -1, "bucket to write (default all)")
+	fs.BoolVar(&header, "header", true, "write a header line with the variable names")
+	fs.StringVar(&dl, "delim", ",", "field delimiter")
+	fs.BoolVar(&decode, "decode", true, "write labels for factor-coded variables and identifiers for variables with an id map")
+	fs.StringVar(&na, "na", "", "text written for missing values")
+	fs.StringVar(&outname, "out", "", "output file (default stdout)")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" || len([]rune(dl)) != 1 {
+		return cli.Usage("usage:\ncols2csv -sourcedir=... [-vars=...] [-bucket=...] [-header=false] [-delim=...] [-decode=false] [-na=...] [-out=...]\n\n")
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	if bucket >= conf.NumBuckets {
+		return fmt.Errorf("Bucket %d does not exist, %s has %d buckets", bucket, sourcedir, conf.NumBuckets)
+	}
+
+	if vlist != "" {
+		vars = strings.Split(vlist, ",")
+	} else {
+		dtypes, err := config.ReadDtypes(0, sourcedir)
+		if err != nil {
+			return err
+		}
+		vars = nil
+		for vn := range dtypes {
+			vars = append(vars, vn)
+		}
+		sort.Strings(vars)
+	}
+
+	labels = make([]map[int]string, len(vars))
+	idmaps = make([]*config.IdMap, len(vars))
+	if decode {
+		for j, vn := range vars {
+			if config.HasFactorCodes(vn, conf) {
+				codes, err := config.GetFactorCodes(vn, conf)
+				if err != nil {
+					return err
+				}
+				labels[j] = config.RevCodes(codes)
+			} else if config.HasIdMap(vn, conf) {
+				idmaps[j], err = config.ReadIdMap(vn, conf)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	w := os.Stdout
+	if outname != "" {
+		w, err = os.Create(outname)
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+	}
+	bw := bufio.NewWriter(w)
+	out = csv.NewWriter(bw)
+	out.Comma = []rune(dl)[0]
+
+	if header {
+		if err := out.Write(vars); err != nil {
+			return err
+		}
+	}
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		if bucket >= 0 && k != bucket {
+			continue
+		}
+		if err := dobucket(k); err != nil {
+			return err
+		}
+	}
+
+	out.Flush()
+	if err := out.Error(); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if outname != "" {
+		return w.Close()
+	}
+
+	return nil
+}
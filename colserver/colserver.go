@@ -0,0 +1,72 @@
+// Colserver serves the columns of a data set over gRPC, using the
+// Columns service of the colservice package, so that programs on other
+// machines can stream the columns with colservice.Client without a
+// shared file system.  The data set may be remote (see s3store,
+// gcsstore and httpstore).
+//
+// The connections are not encrypted unless -cert and -key name a TLS
+// certificate and key.  The server logs to stderr, or to the file
+// given by -log (see the logging package).
+
+package colserver
+
+import (
+	"flag"
+	"log/slog"
+	"net"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/colservice"
+	_ "github.com/kshedden/gocols/gcsstore"
+	_ "github.com/kshedden/gocols/httpstore"
+	_ "github.com/kshedden/gocols/s3store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Run runs colserver with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("colserver", flag.ContinueOnError)
+	var sourcedir, addr, cert, key string
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&addr, "addr", "localhost:9090", "address to listen on")
+	fs.StringVar(&cert, "cert", "", "TLS certificate file")
+	fs.StringVar(&key, "key", "", "TLS key file")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" || (cert == "") != (key == "") {
+		return cli.Usage("usage:\ncolserver -sourcedir=... [-addr=host:port] [-cert=... -key=...]\n\n")
+	}
+
+	logger := slog.Default()
+
+	srv, err := colservice.NewServer(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	var opts []grpc.ServerOption
+	if cert != "" {
+		creds, err := credentials.NewServerTLSFromFile(cert, key)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	gs := grpc.NewServer(opts...)
+	colservice.RegisterColumnsServer(gs, srv)
+
+	logger.Info("serving columns", "sourcedir", sourcedir, "addr", addr)
+	err = gs.Serve(lis)
+	logger.Error("server stopped", "err", err)
+	return cli.Status(1)
+}
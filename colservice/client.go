@@ -0,0 +1,99 @@
+package colservice
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Client reads the columns of a data set from a Columns server.
+type Client struct {
+	conn *grpc.ClientConn
+	cl   ColumnsClient
+}
+
+// Dial connects to a Columns server at host:port.  Without options the
+// connection is not encrypted; credentials can be given with
+// grpc.WithTransportCredentials.
+func Dial(addr string, opts ...grpc.DialOption) (*Client, error) {
+
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	opts = append(opts, grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(1<<30)))
+
+	conn, err := grpc.NewClient(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{conn: conn, cl: NewColumnsClient(conn)}, nil
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Config returns the layout of the data set.
+func (c *Client) Config(ctx context.Context) (*ConfigReply, error) {
+	return c.cl.GetConfig(ctx, &ConfigRequest{})
+}
+
+// Bucket returns the data types of the variables and the number of
+// rows of a bucket.
+func (c *Client) Bucket(ctx context.Context, bucket int) (*BucketReply, error) {
+	return c.cl.GetBucket(ctx, &BucketRequest{Bucket: int32(bucket)})
+}
+
+// ColumnReader receives the chunks of a column.
+type ColumnReader struct {
+	stream Columns_ReadColumnClient
+	cancel context.CancelFunc
+}
+
+// ReadColumn starts reading nrows rows of a variable in a bucket,
+// beginning at row start.  If nrows is zero, all the remaining rows are
+// read.  The rows are sent in chunks of chunkrows rows, or of
+// DefaultChunkRows rows if chunkrows is zero.  The reader must be
+// closed.
+func (c *Client) ReadColumn(ctx context.Context, bucket int, vname string, start, nrows int64, chunkrows int) (*ColumnReader, error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := c.cl.ReadColumn(ctx, &ReadColumnRequest{
+		Bucket:    int32(bucket),
+		Variable:  vname,
+		Start:     start,
+		NumRows:   nrows,
+		ChunkRows: int32(chunkrows),
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &ColumnReader{stream: stream, cancel: cancel}, nil
+}
+
+// Next returns the next chunk of the column.  io.EOF is returned once
+// all the rows have been received.
+func (r *ColumnReader) Next() (*ColumnChunk, error) {
+	return r.stream.Recv()
+}
+
+// Close stops the transfer, if it is not complete.
+func (r *ColumnReader) Close() error {
+	r.cancel()
+	return nil
+}
+
+// Len returns the number of rows in a chunk.
+func (c *ColumnChunk) Len() int {
+	return len(c.Uints) + len(c.Ints) + len(c.Floats) + len(c.Texts)
+}
+
+// IsValid returns true if row i of a chunk has a value.
+func (c *ColumnChunk) IsValid(i int) bool {
+	return len(c.Valid) == 0 || c.Valid[i]
+}
@@ -0,0 +1,530 @@
+// The Columns service streams the columns of a data set to remote
+// readers, in chunks of rows.  See the colservice package for the Go
+// client and server, and the colserver command.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: colservice.proto
+
+package colservice
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ConfigRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConfigRequest) Reset() {
+	*x = ConfigRequest{}
+	mi := &file_colservice_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConfigRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConfigRequest) ProtoMessage() {}
+
+func (x *ConfigRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_colservice_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConfigRequest.ProtoReflect.Descriptor instead.
+func (*ConfigRequest) Descriptor() ([]byte, []int) {
+	return file_colservice_proto_rawDescGZIP(), []int{0}
+}
+
+type ConfigReply struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The number of buckets
+	NumBuckets int32 `protobuf:"varint,1,opt,name=num_buckets,json=numBuckets,proto3" json:"num_buckets,omitempty"`
+	// The compression of the column files, as in conf.json.  Values are
+	// sent decompressed.
+	Compression string `protobuf:"bytes,2,opt,name=compression,proto3" json:"compression,omitempty"`
+	// The attributes of the data set
+	Attrs map[string]string `protobuf:"bytes,3,rep,name=attrs,proto3" json:"attrs,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// The routing variable and method, empty if unknown
+	RoutingIdVar  string `protobuf:"bytes,4,opt,name=routing_id_var,json=routingIdVar,proto3" json:"routing_id_var,omitempty"`
+	RoutingMethod string `protobuf:"bytes,5,opt,name=routing_method,json=routingMethod,proto3" json:"routing_method,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConfigReply) Reset() {
+	*x = ConfigReply{}
+	mi := &file_colservice_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConfigReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConfigReply) ProtoMessage() {}
+
+func (x *ConfigReply) ProtoReflect() protoreflect.Message {
+	mi := &file_colservice_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConfigReply.ProtoReflect.Descriptor instead.
+func (*ConfigReply) Descriptor() ([]byte, []int) {
+	return file_colservice_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ConfigReply) GetNumBuckets() int32 {
+	if x != nil {
+		return x.NumBuckets
+	}
+	return 0
+}
+
+func (x *ConfigReply) GetCompression() string {
+	if x != nil {
+		return x.Compression
+	}
+	return ""
+}
+
+func (x *ConfigReply) GetAttrs() map[string]string {
+	if x != nil {
+		return x.Attrs
+	}
+	return nil
+}
+
+func (x *ConfigReply) GetRoutingIdVar() string {
+	if x != nil {
+		return x.RoutingIdVar
+	}
+	return ""
+}
+
+func (x *ConfigReply) GetRoutingMethod() string {
+	if x != nil {
+		return x.RoutingMethod
+	}
+	return ""
+}
+
+type BucketRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Bucket        int32                  `protobuf:"varint,1,opt,name=bucket,proto3" json:"bucket,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BucketRequest) Reset() {
+	*x = BucketRequest{}
+	mi := &file_colservice_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BucketRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BucketRequest) ProtoMessage() {}
+
+func (x *BucketRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_colservice_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BucketRequest.ProtoReflect.Descriptor instead.
+func (*BucketRequest) Descriptor() ([]byte, []int) {
+	return file_colservice_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *BucketRequest) GetBucket() int32 {
+	if x != nil {
+		return x.Bucket
+	}
+	return 0
+}
+
+type BucketReply struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The data type of each variable, as in dtypes.json
+	Dtypes map[string]string `protobuf:"bytes,1,rep,name=dtypes,proto3" json:"dtypes,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// The number of rows
+	NumRows       int64 `protobuf:"varint,2,opt,name=num_rows,json=numRows,proto3" json:"num_rows,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BucketReply) Reset() {
+	*x = BucketReply{}
+	mi := &file_colservice_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BucketReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BucketReply) ProtoMessage() {}
+
+func (x *BucketReply) ProtoReflect() protoreflect.Message {
+	mi := &file_colservice_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BucketReply.ProtoReflect.Descriptor instead.
+func (*BucketReply) Descriptor() ([]byte, []int) {
+	return file_colservice_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *BucketReply) GetDtypes() map[string]string {
+	if x != nil {
+		return x.Dtypes
+	}
+	return nil
+}
+
+func (x *BucketReply) GetNumRows() int64 {
+	if x != nil {
+		return x.NumRows
+	}
+	return 0
+}
+
+type ReadColumnRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Bucket   int32                  `protobuf:"varint,1,opt,name=bucket,proto3" json:"bucket,omitempty"`
+	Variable string                 `protobuf:"bytes,2,opt,name=variable,proto3" json:"variable,omitempty"`
+	// The first row to read
+	Start int64 `protobuf:"varint,3,opt,name=start,proto3" json:"start,omitempty"`
+	// The number of rows to read, or all remaining rows if zero
+	NumRows int64 `protobuf:"varint,4,opt,name=num_rows,json=numRows,proto3" json:"num_rows,omitempty"`
+	// The number of rows in each chunk, or a default if zero
+	ChunkRows     int32 `protobuf:"varint,5,opt,name=chunk_rows,json=chunkRows,proto3" json:"chunk_rows,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReadColumnRequest) Reset() {
+	*x = ReadColumnRequest{}
+	mi := &file_colservice_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReadColumnRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReadColumnRequest) ProtoMessage() {}
+
+func (x *ReadColumnRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_colservice_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReadColumnRequest.ProtoReflect.Descriptor instead.
+func (*ReadColumnRequest) Descriptor() ([]byte, []int) {
+	return file_colservice_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ReadColumnRequest) GetBucket() int32 {
+	if x != nil {
+		return x.Bucket
+	}
+	return 0
+}
+
+func (x *ReadColumnRequest) GetVariable() string {
+	if x != nil {
+		return x.Variable
+	}
+	return ""
+}
+
+func (x *ReadColumnRequest) GetStart() int64 {
+	if x != nil {
+		return x.Start
+	}
+	return 0
+}
+
+func (x *ReadColumnRequest) GetNumRows() int64 {
+	if x != nil {
+		return x.NumRows
+	}
+	return 0
+}
+
+func (x *ReadColumnRequest) GetChunkRows() int32 {
+	if x != nil {
+		return x.ChunkRows
+	}
+	return 0
+}
+
+// ColumnChunk holds the values of consecutive rows of a column.  Only
+// one of the value fields is set, according to the data type: uints
+// for unsigned integers and bool (as 0 or 1), ints for varint, date32
+// (days since 1970-01-01) and timestamp64 (microseconds since
+// 1970-01-01T00:00:00Z), floats for float32 and float64, and texts for
+// text.
+type ColumnChunk struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The row of the first value in the bucket
+	Start  int64     `protobuf:"varint,1,opt,name=start,proto3" json:"start,omitempty"`
+	Dtype  string    `protobuf:"bytes,2,opt,name=dtype,proto3" json:"dtype,omitempty"`
+	Uints  []uint64  `protobuf:"varint,3,rep,packed,name=uints,proto3" json:"uints,omitempty"`
+	Ints   []int64   `protobuf:"zigzag64,4,rep,packed,name=ints,proto3" json:"ints,omitempty"`
+	Floats []float64 `protobuf:"fixed64,5,rep,packed,name=floats,proto3" json:"floats,omitempty"`
+	Texts  []string  `protobuf:"bytes,6,rep,name=texts,proto3" json:"texts,omitempty"`
+	// Whether each row has a value.  Empty if the variable has no
+	// missing values in the bucket.
+	Valid         []bool `protobuf:"varint,7,rep,packed,name=valid,proto3" json:"valid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ColumnChunk) Reset() {
+	*x = ColumnChunk{}
+	mi := &file_colservice_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ColumnChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ColumnChunk) ProtoMessage() {}
+
+func (x *ColumnChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_colservice_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ColumnChunk.ProtoReflect.Descriptor instead.
+func (*ColumnChunk) Descriptor() ([]byte, []int) {
+	return file_colservice_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ColumnChunk) GetStart() int64 {
+	if x != nil {
+		return x.Start
+	}
+	return 0
+}
+
+func (x *ColumnChunk) GetDtype() string {
+	if x != nil {
+		return x.Dtype
+	}
+	return ""
+}
+
+func (x *ColumnChunk) GetUints() []uint64 {
+	if x != nil {
+		return x.Uints
+	}
+	return nil
+}
+
+func (x *ColumnChunk) GetInts() []int64 {
+	if x != nil {
+		return x.Ints
+	}
+	return nil
+}
+
+func (x *ColumnChunk) GetFloats() []float64 {
+	if x != nil {
+		return x.Floats
+	}
+	return nil
+}
+
+func (x *ColumnChunk) GetTexts() []string {
+	if x != nil {
+		return x.Texts
+	}
+	return nil
+}
+
+func (x *ColumnChunk) GetValid() []bool {
+	if x != nil {
+		return x.Valid
+	}
+	return nil
+}
+
+var File_colservice_proto protoreflect.FileDescriptor
+
+const file_colservice_proto_rawDesc = "" +
+	"\n" +
+	"\x10colservice.proto\x12\x11gocols.colservice\"\x0f\n" +
+	"\rConfigRequest\"\x98\x02\n" +
+	"\vConfigReply\x12\x1f\n" +
+	"\vnum_buckets\x18\x01 \x01(\x05R\n" +
+	"numBuckets\x12 \n" +
+	"\vcompression\x18\x02 \x01(\tR\vcompression\x12?\n" +
+	"\x05attrs\x18\x03 \x03(\v2).gocols.colservice.ConfigReply.AttrsEntryR\x05attrs\x12$\n" +
+	"\x0erouting_id_var\x18\x04 \x01(\tR\froutingIdVar\x12%\n" +
+	"\x0erouting_method\x18\x05 \x01(\tR\rroutingMethod\x1a8\n" +
+	"\n" +
+	"AttrsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"'\n" +
+	"\rBucketRequest\x12\x16\n" +
+	"\x06bucket\x18\x01 \x01(\x05R\x06bucket\"\xa7\x01\n" +
+	"\vBucketReply\x12B\n" +
+	"\x06dtypes\x18\x01 \x03(\v2*.gocols.colservice.BucketReply.DtypesEntryR\x06dtypes\x12\x19\n" +
+	"\bnum_rows\x18\x02 \x01(\x03R\anumRows\x1a9\n" +
+	"\vDtypesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x97\x01\n" +
+	"\x11ReadColumnRequest\x12\x16\n" +
+	"\x06bucket\x18\x01 \x01(\x05R\x06bucket\x12\x1a\n" +
+	"\bvariable\x18\x02 \x01(\tR\bvariable\x12\x14\n" +
+	"\x05start\x18\x03 \x01(\x03R\x05start\x12\x19\n" +
+	"\bnum_rows\x18\x04 \x01(\x03R\anumRows\x12\x1d\n" +
+	"\n" +
+	"chunk_rows\x18\x05 \x01(\x05R\tchunkRows\"\xa7\x01\n" +
+	"\vColumnChunk\x12\x14\n" +
+	"\x05start\x18\x01 \x01(\x03R\x05start\x12\x14\n" +
+	"\x05dtype\x18\x02 \x01(\tR\x05dtype\x12\x14\n" +
+	"\x05uints\x18\x03 \x03(\x04R\x05uints\x12\x12\n" +
+	"\x04ints\x18\x04 \x03(\x12R\x04ints\x12\x16\n" +
+	"\x06floats\x18\x05 \x03(\x01R\x06floats\x12\x14\n" +
+	"\x05texts\x18\x06 \x03(\tR\x05texts\x12\x14\n" +
+	"\x05valid\x18\a \x03(\bR\x05valid2\xfd\x01\n" +
+	"\aColumns\x12M\n" +
+	"\tGetConfig\x12 .gocols.colservice.ConfigRequest\x1a\x1e.gocols.colservice.ConfigReply\x12M\n" +
+	"\tGetBucket\x12 .gocols.colservice.BucketRequest\x1a\x1e.gocols.colservice.BucketReply\x12T\n" +
+	"\n" +
+	"ReadColumn\x12$.gocols.colservice.ReadColumnRequest\x1a\x1e.gocols.colservice.ColumnChunk0\x01B'Z%github.com/kshedden/gocols/colserviceb\x06proto3"
+
+var (
+	file_colservice_proto_rawDescOnce sync.Once
+	file_colservice_proto_rawDescData []byte
+)
+
+func file_colservice_proto_rawDescGZIP() []byte {
+	file_colservice_proto_rawDescOnce.Do(func() {
+		file_colservice_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_colservice_proto_rawDesc), len(file_colservice_proto_rawDesc)))
+	})
+	return file_colservice_proto_rawDescData
+}
+
+var file_colservice_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_colservice_proto_goTypes = []any{
+	(*ConfigRequest)(nil),     // 0: gocols.colservice.ConfigRequest
+	(*ConfigReply)(nil),       // 1: gocols.colservice.ConfigReply
+	(*BucketRequest)(nil),     // 2: gocols.colservice.BucketRequest
+	(*BucketReply)(nil),       // 3: gocols.colservice.BucketReply
+	(*ReadColumnRequest)(nil), // 4: gocols.colservice.ReadColumnRequest
+	(*ColumnChunk)(nil),       // 5: gocols.colservice.ColumnChunk
+	nil,                       // 6: gocols.colservice.ConfigReply.AttrsEntry
+	nil,                       // 7: gocols.colservice.BucketReply.DtypesEntry
+}
+var file_colservice_proto_depIdxs = []int32{
+	6, // 0: gocols.colservice.ConfigReply.attrs:type_name -> gocols.colservice.ConfigReply.AttrsEntry
+	7, // 1: gocols.colservice.BucketReply.dtypes:type_name -> gocols.colservice.BucketReply.DtypesEntry
+	0, // 2: gocols.colservice.Columns.GetConfig:input_type -> gocols.colservice.ConfigRequest
+	2, // 3: gocols.colservice.Columns.GetBucket:input_type -> gocols.colservice.BucketRequest
+	4, // 4: gocols.colservice.Columns.ReadColumn:input_type -> gocols.colservice.ReadColumnRequest
+	1, // 5: gocols.colservice.Columns.GetConfig:output_type -> gocols.colservice.ConfigReply
+	3, // 6: gocols.colservice.Columns.GetBucket:output_type -> gocols.colservice.BucketReply
+	5, // 7: gocols.colservice.Columns.ReadColumn:output_type -> gocols.colservice.ColumnChunk
+	5, // [5:8] is the sub-list for method output_type
+	2, // [2:5] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_colservice_proto_init() }
+func file_colservice_proto_init() {
+	if File_colservice_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_colservice_proto_rawDesc), len(file_colservice_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   8,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_colservice_proto_goTypes,
+		DependencyIndexes: file_colservice_proto_depIdxs,
+		MessageInfos:      file_colservice_proto_msgTypes,
+	}.Build()
+	File_colservice_proto = out.File
+	file_colservice_proto_goTypes = nil
+	file_colservice_proto_depIdxs = nil
+}
@@ -0,0 +1,211 @@
+// The Columns service streams the columns of a data set to remote
+// readers, in chunks of rows.  See the colservice package for the Go
+// client and server, and the colserver command.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: colservice.proto
+
+package colservice
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Columns_GetConfig_FullMethodName  = "/gocols.colservice.Columns/GetConfig"
+	Columns_GetBucket_FullMethodName  = "/gocols.colservice.Columns/GetBucket"
+	Columns_ReadColumn_FullMethodName = "/gocols.colservice.Columns/ReadColumn"
+)
+
+// ColumnsClient is the client API for Columns service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ColumnsClient interface {
+	// GetConfig returns the layout of the data set.
+	GetConfig(ctx context.Context, in *ConfigRequest, opts ...grpc.CallOption) (*ConfigReply, error)
+	// GetBucket returns the variables and number of rows of a bucket.
+	GetBucket(ctx context.Context, in *BucketRequest, opts ...grpc.CallOption) (*BucketReply, error)
+	// ReadColumn streams the values of a variable in a bucket.
+	ReadColumn(ctx context.Context, in *ReadColumnRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ColumnChunk], error)
+}
+
+type columnsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewColumnsClient(cc grpc.ClientConnInterface) ColumnsClient {
+	return &columnsClient{cc}
+}
+
+func (c *columnsClient) GetConfig(ctx context.Context, in *ConfigRequest, opts ...grpc.CallOption) (*ConfigReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ConfigReply)
+	err := c.cc.Invoke(ctx, Columns_GetConfig_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *columnsClient) GetBucket(ctx context.Context, in *BucketRequest, opts ...grpc.CallOption) (*BucketReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BucketReply)
+	err := c.cc.Invoke(ctx, Columns_GetBucket_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *columnsClient) ReadColumn(ctx context.Context, in *ReadColumnRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ColumnChunk], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Columns_ServiceDesc.Streams[0], Columns_ReadColumn_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ReadColumnRequest, ColumnChunk]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Columns_ReadColumnClient = grpc.ServerStreamingClient[ColumnChunk]
+
+// ColumnsServer is the server API for Columns service.
+// All implementations must embed UnimplementedColumnsServer
+// for forward compatibility.
+type ColumnsServer interface {
+	// GetConfig returns the layout of the data set.
+	GetConfig(context.Context, *ConfigRequest) (*ConfigReply, error)
+	// GetBucket returns the variables and number of rows of a bucket.
+	GetBucket(context.Context, *BucketRequest) (*BucketReply, error)
+	// ReadColumn streams the values of a variable in a bucket.
+	ReadColumn(*ReadColumnRequest, grpc.ServerStreamingServer[ColumnChunk]) error
+	mustEmbedUnimplementedColumnsServer()
+}
+
+// UnimplementedColumnsServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedColumnsServer struct{}
+
+func (UnimplementedColumnsServer) GetConfig(context.Context, *ConfigRequest) (*ConfigReply, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetConfig not implemented")
+}
+func (UnimplementedColumnsServer) GetBucket(context.Context, *BucketRequest) (*BucketReply, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetBucket not implemented")
+}
+func (UnimplementedColumnsServer) ReadColumn(*ReadColumnRequest, grpc.ServerStreamingServer[ColumnChunk]) error {
+	return status.Error(codes.Unimplemented, "method ReadColumn not implemented")
+}
+func (UnimplementedColumnsServer) mustEmbedUnimplementedColumnsServer() {}
+func (UnimplementedColumnsServer) testEmbeddedByValue()                 {}
+
+// UnsafeColumnsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ColumnsServer will
+// result in compilation errors.
+type UnsafeColumnsServer interface {
+	mustEmbedUnimplementedColumnsServer()
+}
+
+func RegisterColumnsServer(s grpc.ServiceRegistrar, srv ColumnsServer) {
+	// If the following call panics, it indicates UnimplementedColumnsServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Columns_ServiceDesc, srv)
+}
+
+func _Columns_GetConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ColumnsServer).GetConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Columns_GetConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ColumnsServer).GetConfig(ctx, req.(*ConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Columns_GetBucket_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BucketRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ColumnsServer).GetBucket(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Columns_GetBucket_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ColumnsServer).GetBucket(ctx, req.(*BucketRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Columns_ReadColumn_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ReadColumnRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ColumnsServer).ReadColumn(m, &grpc.GenericServerStream[ReadColumnRequest, ColumnChunk]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Columns_ReadColumnServer = grpc.ServerStreamingServer[ColumnChunk]
+
+// Columns_ServiceDesc is the grpc.ServiceDesc for Columns service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Columns_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gocols.colservice.Columns",
+	HandlerType: (*ColumnsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetConfig",
+			Handler:    _Columns_GetConfig_Handler,
+		},
+		{
+			MethodName: "GetBucket",
+			Handler:    _Columns_GetBucket_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ReadColumn",
+			Handler:       _Columns_ReadColumn_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "colservice.proto",
+}
@@ -0,0 +1,210 @@
+// Package colservice streams the columns of a data set over gRPC, so
+// that programs on other machines can read them without a shared file
+// system.  The Columns service is defined in colservice.proto.  Server
+// implements it for a data set, and is run by the colserver command;
+// Client reads from it.
+//
+// Columns are read in chunks of rows, starting at any row, with the
+// values decoded into the field of ColumnChunk matching the data type
+// and the validity bitmap of the variable (see config.NullSuffix), if
+// any, in the Valid field.
+package colservice
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative colservice.proto
+
+import (
+	"context"
+	"io"
+
+	"github.com/kshedden/gocols/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultChunkRows is the number of rows sent in each chunk, unless the
+// request asks for another number.
+const DefaultChunkRows = 1 << 16
+
+// maxChunkRows limits the size of the messages holding a chunk.
+const maxChunkRows = 1 << 20
+
+// Server implements the Columns service for a data set.
+type Server struct {
+	UnimplementedColumnsServer
+
+	// The directory containing the data set
+	SourceDir string
+
+	conf *config.Config
+}
+
+// NewServer returns a server for the data set in a directory.
+func NewServer(sourcedir string) (*Server, error) {
+
+	conf, err := config.GetConfig(sourcedir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{SourceDir: sourcedir, conf: conf}, nil
+}
+
+// checkbucket returns an error if a bucket does not exist.
+func (s *Server) checkbucket(bucket int32) error {
+	if bucket < 0 || int(bucket) >= s.conf.NumBuckets {
+		return status.Errorf(codes.InvalidArgument, "bucket %d does not exist, the data set has %d buckets", bucket, s.conf.NumBuckets)
+	}
+	return nil
+}
+
+func (s *Server) GetConfig(ctx context.Context, req *ConfigRequest) (*ConfigReply, error) {
+
+	rep := &ConfigReply{
+		NumBuckets:  int32(s.conf.NumBuckets),
+		Compression: s.conf.Compression,
+		Attrs:       s.conf.Attrs,
+	}
+	if s.conf.Routing != nil {
+		rep.RoutingIdVar = s.conf.Routing.IdVar
+		rep.RoutingMethod = s.conf.Routing.Method
+	}
+
+	return rep, nil
+}
+
+func (s *Server) GetBucket(ctx context.Context, req *BucketRequest) (*BucketReply, error) {
+
+	if err := s.checkbucket(req.Bucket); err != nil {
+		return nil, err
+	}
+
+	dtypes, err := config.ReadDtypes(int(req.Bucket), s.SourceDir)
+	if err != nil {
+		return nil, err
+	}
+	n, err := config.NumRows(int(req.Bucket), s.SourceDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BucketReply{Dtypes: dtypes, NumRows: int64(n)}, nil
+}
+
+func (s *Server) ReadColumn(req *ReadColumnRequest, stream Columns_ReadColumnServer) error {
+
+	if err := s.checkbucket(req.Bucket); err != nil {
+		return err
+	}
+	bn := int(req.Bucket)
+	if req.Start < 0 || req.NumRows < 0 || req.ChunkRows < 0 || req.ChunkRows > maxChunkRows {
+		return status.Errorf(codes.InvalidArgument, "invalid start %d, num_rows %d or chunk_rows %d", req.Start, req.NumRows, req.ChunkRows)
+	}
+	chunkrows := int(req.ChunkRows)
+	if chunkrows == 0 {
+		chunkrows = DefaultChunkRows
+	}
+
+	dtypes, err := config.ReadDtypes(bn, s.SourceDir)
+	if err != nil {
+		return err
+	}
+	dt, ok := dtypes[req.Variable]
+	if !ok {
+		return status.Errorf(codes.NotFound, "variable %s not found in bucket %d", req.Variable, bn)
+	}
+
+	rdr, err := config.OpenReader(bn, s.SourceDir, req.Variable, dt)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+	nr, err := config.OpenNulls(bn, s.SourceDir, req.Variable)
+	if err != nil {
+		return err
+	}
+	defer nr.Close()
+
+	// Move to the first row.
+	if err := rdr.Skip(int(req.Start)); err == io.EOF {
+		return status.Errorf(codes.OutOfRange, "start %d is past the end of bucket %d", req.Start, bn)
+	} else if err != nil {
+		return err
+	}
+	for i := int64(0); i < req.Start && nr.HasNulls(); i++ {
+		if _, err := nr.Valid(); err != nil {
+			return err
+		}
+	}
+
+	row := req.Start
+	for req.NumRows == 0 || row < req.Start+req.NumRows {
+		if err := stream.Context().Err(); err != nil {
+			return err
+		}
+
+		chunk := &ColumnChunk{Start: row, Dtype: dt}
+		var n int
+		for n < chunkrows && (req.NumRows == 0 || row < req.Start+req.NumRows) {
+			err := readvalue(rdr, chunk)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+			if nr.HasNulls() {
+				valid, err := nr.Valid()
+				if err != nil {
+					return err
+				}
+				chunk.Valid = append(chunk.Valid, valid)
+			}
+			n++
+			row++
+		}
+		if n == 0 {
+			break
+		}
+		if err := stream.Send(chunk); err != nil {
+			return err
+		}
+		if n < chunkrows {
+			break
+		}
+	}
+
+	return nil
+}
+
+// readvalue reads the next value of a column into the field of a chunk
+// matching its data type.
+func readvalue(rdr *config.ColumnReader, chunk *ColumnChunk) error {
+
+	switch rdr.Dtype() {
+	case "text":
+		x, err := rdr.Text()
+		if err != nil {
+			return err
+		}
+		chunk.Texts = append(chunk.Texts, x)
+	case "float32", "float64":
+		x, err := rdr.Float()
+		if err != nil {
+			return err
+		}
+		chunk.Floats = append(chunk.Floats, x)
+	case "varint", "date32", "timestamp64":
+		x, err := rdr.Int()
+		if err != nil {
+			return err
+		}
+		chunk.Ints = append(chunk.Ints, x)
+	default:
+		x, err := rdr.Uint()
+		if err != nil {
+			return err
+		}
+		chunk.Uints = append(chunk.Uints, x)
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+// Package coltest holds what the tests of the commands share: small
+// data sets created from delimited text by csv2cols, their records
+// read back by cols2csv, and the output that a command prints.  The
+// data sets are written to temporary directories removed at the end of
+// each test.
+
+package coltest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kshedden/gocols/cols2csv"
+	"github.com/kshedden/gocols/csv2cols"
+)
+
+// Dir returns the path of a new directory named name inside a
+// temporary directory of the test, which is not created, for use as
+// the target of a command.
+func Dir(t testing.TB, name string) string {
+	return filepath.Join(t.TempDir(), name)
+}
+
+// CSV creates a data set from delimited text whose first line is a
+// header, and returns its directory.  The records are placed in two
+// buckets in round-robin order unless flags, which are given to
+// csv2cols after its defaults, say otherwise.
+func CSV(t testing.TB, text string, flags ...string) string {
+
+	t.Helper()
+
+	tmp := t.TempDir()
+	fn := filepath.Join(tmp, "data.csv")
+	if err := ioutil.WriteFile(fn, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(tmp, "data")
+	args := append([]string{"-files=" + fn, "-targetdir=" + dir, "-buckets=2", "-q"}, flags...)
+	if err := csv2cols.Run(args); err != nil {
+		t.Fatalf("csv2cols %s: %v", strings.Join(flags, " "), err)
+	}
+
+	return dir
+}
+
+// Records returns the records of a data set as written by cols2csv,
+// one line per record without the header, in the order of the buckets.
+// The flags are given to cols2csv after its defaults.
+func Records(t testing.TB, dir string, flags ...string) []string {
+
+	t.Helper()
+
+	fn := filepath.Join(t.TempDir(), "out.csv")
+	args := append([]string{"-sourcedir=" + dir, "-out=" + fn, "-header=false", "-q"}, flags...)
+	if err := cols2csv.Run(args); err != nil {
+		t.Fatalf("cols2csv %s: %v", dir, err)
+	}
+
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return lines(string(b))
+}
+
+// Stdout runs a command with os.Stdout redirected, and returns the
+// lines that it printed and its error.
+func Stdout(t testing.TB, run func(args []string) error, args ...string) ([]string, error) {
+
+	t.Helper()
+
+	fid, err := ioutil.TempFile(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fid.Close()
+
+	stdout := os.Stdout
+	os.Stdout = fid
+	err = run(append([]string{"-q"}, args...))
+	os.Stdout = stdout
+
+	b, rerr := ioutil.ReadFile(fid.Name())
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+
+	return lines(string(b)), err
+}
+
+// lines splits text into lines, without the final newline.
+func lines(s string) []string {
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
@@ -0,0 +1,645 @@
+// Package colwriter creates data sets from Go programs.  The variables
+// and their data types are declared when the data set is created, and
+// the data are then added either one row at a time, or as column
+// chunks written to a given bucket.  For example
+//
+//	vars := []colwriter.Var{
+//		{Name: "id", Dtype: "uvarint"},
+//		{Name: "income", Dtype: "float64"},
+//		{Name: "state", Dtype: "uint8", Factor: true},
+//	}
+//	conf := &config.Config{NumBuckets: 10, Routing: &config.Routing{IdVar: "id", Method: "hash"}}
+//	ds, err := colwriter.Create("/data/survey", conf, vars)
+//	...
+//	err = ds.AppendRow(uint64(17), 52000.0, "MI")
+//	...
+//	err = ds.Close()
+//
+// The package writes conf.json, the dtypes.json and meta.json files of
+// each bucket, the compressed column files and the codes of the factor-coded
+// variables.  Rows appended to the data set are placed in buckets
+// using the routing in the configuration, or in round-robin order if
+// there is none.  Every column of a bucket must have the same number
+// of rows when the data set is closed.
+package colwriter
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/kshedden/gocols/config"
+)
+
+// Var declares a variable of a data set.
+type Var struct {
+
+	// The name of the variable
+	Name string
+
+	// The data type used to store the variable
+	Dtype string
+
+	// If true, the values are string labels, which are stored as
+	// integer codes with an unsigned integer data type
+	Factor bool
+}
+
+// Dataset is a data set being written.
+type Dataset struct {
+
+	// The directory containing the data set
+	Path string
+
+	// Configuration information for the data set
+	Config *config.Config
+
+	vars  []Var
+	vpos  map[string]int
+	idpos int
+
+	buckets []*BucketWriter
+
+	// The codes of the factor-coded variables, indexed like vars
+	codes []map[string]int
+	cmut  sync.Mutex
+
+	// The bucket receiving the next row, without routing
+	next int
+	mut  sync.Mutex
+}
+
+// Create creates a data set in the given directory, which must not
+// exist.  Empty Compression and CodesDir fields of conf are set to the
+// default compression and a Codes directory within the data set.
+func Create(pa string, conf *config.Config, vars []Var) (*Dataset, error) {
+
+	if conf.NumBuckets < 1 {
+		return nil, fmt.Errorf("the number of buckets must be positive")
+	}
+	if len(vars) == 0 {
+		return nil, fmt.Errorf("no variables")
+	}
+
+	d := &Dataset{Path: pa, Config: conf, vars: vars, vpos: make(map[string]int), idpos: -1}
+	d.codes = make([]map[string]int, len(vars))
+	for j, v := range vars {
+		if _, ok := d.vpos[v.Name]; ok {
+			return nil, fmt.Errorf("variable %s is declared more than once", v.Name)
+		}
+		d.vpos[v.Name] = j
+		if err := checkdtype(v); err != nil {
+			return nil, err
+		}
+		if v.Factor {
+			d.codes[j] = make(map[string]int)
+		}
+	}
+
+	if conf.Routing != nil {
+		j, ok := d.vpos[conf.Routing.IdVar]
+		if !ok || vars[j].Factor || isfloat(vars[j].Dtype) || vars[j].Dtype == "varint" || config.IsTime(vars[j].Dtype) {
+			return nil, fmt.Errorf("the routing variable %s must be declared with an unsigned integer dtype", conf.Routing.IdVar)
+		}
+		d.idpos = j
+	}
+
+	if conf.Compression == "" {
+		conf.Compression = config.DefaultCompression
+	}
+	if _, err := config.GetCodec(conf.Compression); err != nil {
+		return nil, err
+	}
+	if conf.CodesDir == "" {
+		conf.CodesDir = path.Join(pa, "Codes")
+	}
+
+	if _, err := os.Stat(pa); !os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s already exists", pa)
+	}
+	if err := os.MkdirAll(pa, 0755); err != nil {
+		return nil, err
+	}
+	if err := config.WriteConfig(pa, conf); err != nil {
+		return nil, err
+	}
+
+	dtypes := make(map[string]string)
+	for _, v := range vars {
+		dtypes[v.Name] = v.Dtype
+	}
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		b, err := d.newbucket(k, dtypes)
+		if err != nil {
+			d.closefiles()
+			return nil, err
+		}
+		d.buckets = append(d.buckets, b)
+	}
+
+	return d, nil
+}
+
+func isfloat(dt string) bool {
+	return dt == "float32" || dt == "float64"
+}
+
+// checkdtype confirms that a variable has a supported data type.
+func checkdtype(v Var) error {
+
+	_, ok := config.DTsize[v.Dtype]
+	switch v.Dtype {
+	case "uvarint", "varint", "delta-uvarint", "bool":
+		ok = true
+	}
+	if !ok {
+		return fmt.Errorf("unsupported dtype %s for variable %s", v.Dtype, v.Name)
+	}
+
+	if v.Factor && (isfloat(v.Dtype) || v.Dtype == "varint" || v.Dtype == "delta-uvarint" || v.Dtype == "bool" || config.IsTime(v.Dtype)) {
+		return fmt.Errorf("factor-coded variable %s must have an unsigned integer dtype other than delta-uvarint", v.Name)
+	}
+
+	return nil
+}
+
+// newbucket creates the directory and column files of a bucket.
+func (d *Dataset) newbucket(k int, dtypes map[string]string) (*BucketWriter, error) {
+
+	b := &BucketWriter{ds: d, num: k}
+	b.nrows = make([]int, len(d.vars))
+	b.last = make([]uint64, len(d.vars))
+
+	if err := os.MkdirAll(config.BucketPath(k, d.Path), 0755); err != nil {
+		return nil, err
+	}
+	if err := config.WriteDtypes(k, d.Path, dtypes); err != nil {
+		return nil, err
+	}
+
+	for _, v := range d.vars {
+		w, f, err := config.CreateColumn(k, d.Path, v.Name)
+		if err != nil {
+			b.close()
+			return nil, err
+		}
+		if v.Dtype == "bool" {
+			w = config.NewBoolWriter(w)
+		}
+		b.wtrs = append(b.wtrs, w)
+		b.fids = append(b.fids, f)
+	}
+
+	return b, nil
+}
+
+// Bucket returns the writer for one bucket.
+func (d *Dataset) Bucket(k int) *BucketWriter {
+	return d.buckets[k]
+}
+
+// AppendRow adds a row to the data set, with one value for each
+// variable in the order of declaration.  The values may be float64,
+// float32, int, int64, uint64, bool for bool variables, time.Time for
+// date32 and timestamp64 variables or, for factor-coded variables,
+// string.  Integer values of date32 and timestamp64 variables are
+// taken to be days or microseconds since 1970-01-01.
+// The row is placed in the bucket given by the routing, or in
+// round-robin order if there is no routing.
+func (d *Dataset) AppendRow(vals ...interface{}) error {
+
+	if len(vals) != len(d.vars) {
+		return fmt.Errorf("got %d values, expected %d", len(vals), len(d.vars))
+	}
+
+	var k int
+	if d.idpos >= 0 {
+		id, err := d.tobits(d.idpos, vals[d.idpos])
+		if err != nil {
+			return err
+		}
+		k, err = config.Route(d.Config, id)
+		if err != nil {
+			return err
+		}
+	} else {
+		d.mut.Lock()
+		k = d.next
+		d.next = (d.next + 1) % d.Config.NumBuckets
+		d.mut.Unlock()
+	}
+
+	return d.buckets[k].AppendRow(vals...)
+}
+
+// code returns the code of a label of a factor-coded variable, giving
+// new labels the next code.
+func (d *Dataset) code(j int, lab string) uint64 {
+
+	d.cmut.Lock()
+	defer d.cmut.Unlock()
+
+	c, ok := d.codes[j][lab]
+	if !ok {
+		c = len(d.codes[j])
+		d.codes[j][lab] = c
+	}
+
+	return uint64(c)
+}
+
+// tobits converts a value of variable j to the representation of
+// config.WriteBits for its data type.
+func (d *Dataset) tobits(j int, x interface{}) (uint64, error) {
+
+	v := d.vars[j]
+
+	if v.Factor {
+		lab, ok := x.(string)
+		if !ok {
+			return 0, fmt.Errorf("variable %s is factor-coded, and needs a string value, not %T", v.Name, x)
+		}
+		return d.code(j, lab), nil
+	}
+
+	if v.Dtype == "bool" {
+		y, ok := x.(bool)
+		if !ok {
+			return 0, fmt.Errorf("variable %s has dtype bool, and needs a bool value, not %T", v.Name, x)
+		}
+		if y {
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	if t, ok := x.(time.Time); ok {
+		var y int64
+		var err error
+		switch v.Dtype {
+		case "date32":
+			y, err = config.DateOf(t)
+		case "timestamp64":
+			y, err = config.TimestampOf(t)
+		default:
+			return 0, fmt.Errorf("variable %s has dtype %s, and cannot hold a time", v.Name, v.Dtype)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("variable %s: %v", v.Name, err)
+		}
+		return uint64(y), nil
+	}
+
+	var f float64
+	switch y := x.(type) {
+	case float64:
+		f = y
+	case float32:
+		f = float64(y)
+	case int:
+		return intbits(v, int64(y))
+	case int64:
+		return intbits(v, y)
+	case uint64:
+		if isfloat(v.Dtype) {
+			return math.Float64bits(float64(y)), nil
+		}
+		if (v.Dtype == "varint" || config.IsTime(v.Dtype)) && y > math.MaxInt64 {
+			return 0, fmt.Errorf("value %d of variable %s is out of the range of %s", y, v.Name, v.Dtype)
+		}
+		return y, nil
+	default:
+		return 0, fmt.Errorf("unsupported value of type %T for variable %s", x, v.Name)
+	}
+
+	if !isfloat(v.Dtype) {
+		return 0, fmt.Errorf("variable %s has dtype %s, and needs an integer value", v.Name, v.Dtype)
+	}
+	return math.Float64bits(f), nil
+}
+
+// intbits converts a signed integer value of a variable.
+func intbits(v Var, x int64) (uint64, error) {
+
+	switch {
+	case isfloat(v.Dtype):
+		return math.Float64bits(float64(x)), nil
+	case v.Dtype == "varint" || config.IsTime(v.Dtype):
+		return uint64(x), nil
+	case x < 0:
+		return 0, fmt.Errorf("value %d of variable %s is negative", x, v.Name)
+	}
+
+	return uint64(x), nil
+}
+
+// closefiles closes the column files of every bucket, returning the
+// first error.
+func (d *Dataset) closefiles() error {
+
+	var first error
+	for _, b := range d.buckets {
+		if err := b.close(); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+// Close closes the column files, saves the factor codes, and confirms
+// that the columns of each bucket have the same length, recording the
+// number of rows in the meta.json file of each bucket.
+func (d *Dataset) Close() error {
+
+	if err := d.closefiles(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(d.Config.CodesDir, 0755); err != nil {
+		return err
+	}
+
+	cf := make(map[string]string)
+	for j, v := range d.vars {
+		if v.Factor {
+			cf[v.Name] = v.Name
+			if err := config.WriteFactorCodes(v.Name, d.codes[j], d.Config); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := config.WriteCodeFiles(d.Config, cf); err != nil {
+		return err
+	}
+
+	for _, b := range d.buckets {
+		for j, v := range d.vars {
+			if b.nrows[j] != b.nrows[0] {
+				return fmt.Errorf("variable %s in bucket %d has %d rows, but %s has %d",
+					v.Name, b.num, b.nrows[j], d.vars[0].Name, b.nrows[0])
+			}
+		}
+		if err := config.RecordRows(b.num, d.Path, b.nrows[0]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BucketWriter writes the columns of one bucket.  A BucketWriter is
+// safe for concurrent use, and different buckets can be written in
+// parallel.
+type BucketWriter struct {
+	ds  *Dataset
+	num int
+
+	wtrs []io.WriteCloser
+	fids []io.Closer
+
+	// The number of values written to each column
+	nrows []int
+
+	// The most recent value of each delta-uvarint column
+	last []uint64
+
+	mut sync.Mutex
+}
+
+// put writes one value of variable j, given in the representation of
+// config.WriteBits.  The caller holds the lock.
+func (b *BucketWriter) put(j int, x uint64) error {
+
+	if b.wtrs == nil {
+		return fmt.Errorf("bucket %d is closed", b.num)
+	}
+
+	v := b.ds.vars[j]
+	w := b.wtrs[j]
+
+	var err error
+	if v.Dtype == "delta-uvarint" {
+		if x < b.last[j] {
+			return fmt.Errorf("value %d of variable %s is smaller than the preceding value %d in bucket %d", x, v.Name, b.last[j], b.num)
+		}
+		err = config.WriteUint(w, "uvarint", x-b.last[j])
+		b.last[j] = x
+	} else {
+		err = config.WriteBits(w, v.Dtype, x)
+	}
+	if err != nil {
+		return fmt.Errorf("variable %s: %v", v.Name, err)
+	}
+	b.nrows[j]++
+
+	return nil
+}
+
+// AppendRow adds a row to the bucket.  The values are given as in
+// Dataset.AppendRow.  The row is not checked against the routing.
+func (b *BucketWriter) AppendRow(vals ...interface{}) error {
+
+	if len(vals) != len(b.ds.vars) {
+		return fmt.Errorf("got %d values, expected %d", len(vals), len(b.ds.vars))
+	}
+
+	bits := make([]uint64, len(vals))
+	for j, x := range vals {
+		var err error
+		bits[j], err = b.ds.tobits(j, x)
+		if err != nil {
+			return err
+		}
+	}
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	for j, x := range bits {
+		if err := b.put(j, x); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// column returns the position of a variable.
+func (b *BucketWriter) column(vname string) (int, error) {
+	j, ok := b.ds.vpos[vname]
+	if !ok {
+		return 0, fmt.Errorf("variable %s was not declared", vname)
+	}
+	return j, nil
+}
+
+// Float64 appends values to a numeric variable.
+func (b *BucketWriter) Float64(vname string, x []float64) error {
+
+	j, err := b.column(vname)
+	if err != nil {
+		return err
+	}
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	for _, y := range x {
+		bits, err := b.ds.tobits(j, y)
+		if err != nil {
+			return err
+		}
+		if err := b.put(j, bits); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Int64 appends values to a numeric variable.
+func (b *BucketWriter) Int64(vname string, x []int64) error {
+
+	j, err := b.column(vname)
+	if err != nil {
+		return err
+	}
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	for _, y := range x {
+		bits, err := b.ds.tobits(j, y)
+		if err != nil {
+			return err
+		}
+		if err := b.put(j, bits); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Uint64 appends values to a numeric variable.
+func (b *BucketWriter) Uint64(vname string, x []uint64) error {
+
+	j, err := b.column(vname)
+	if err != nil {
+		return err
+	}
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	for _, y := range x {
+		bits, err := b.ds.tobits(j, y)
+		if err != nil {
+			return err
+		}
+		if err := b.put(j, bits); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Bool appends values to a bool variable.
+func (b *BucketWriter) Bool(vname string, x []bool) error {
+
+	j, err := b.column(vname)
+	if err != nil {
+		return err
+	}
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	for _, y := range x {
+		bits, err := b.ds.tobits(j, y)
+		if err != nil {
+			return err
+		}
+		if err := b.put(j, bits); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Time appends values to a date32 or timestamp64 variable.
+func (b *BucketWriter) Time(vname string, x []time.Time) error {
+
+	j, err := b.column(vname)
+	if err != nil {
+		return err
+	}
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	for _, y := range x {
+		bits, err := b.ds.tobits(j, y)
+		if err != nil {
+			return err
+		}
+		if err := b.put(j, bits); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Labels appends labels to a factor-coded variable.
+func (b *BucketWriter) Labels(vname string, x []string) error {
+
+	j, err := b.column(vname)
+	if err != nil {
+		return err
+	}
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	for _, y := range x {
+		bits, err := b.ds.tobits(j, y)
+		if err != nil {
+			return err
+		}
+		if err := b.put(j, bits); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// close closes the column files of the bucket.
+func (b *BucketWriter) close() error {
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	var first error
+	for j := range b.wtrs {
+		if err := b.wtrs[j].Close(); err != nil && first == nil {
+			first = err
+		}
+		if err := b.fids[j].Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	b.wtrs, b.fids = nil, nil
+
+	return first
+}
@@ -0,0 +1,502 @@
+// Compact stores each numeric variable of a data set with the data
+// type that takes the least space while representing every value
+// exactly.  The values of each variable are scanned to find the
+// uncompressed size of the column under each candidate data type:
+//
+//   - unsigned integer types (uint8 to uint64 and uvarint) when the
+//     values are non-negative whole numbers,
+//   - varint when the values are whole numbers,
+//   - float32 and float64 for float variables, with float32 only when
+//     every value is exactly representable.
+//
+// The smallest candidate is used, and the current type is kept when
+// there is a tie.  Factor-coded variables are kept unsigned, and
+// delta-uvarint, text, bool, date32 and timestamp64 variables are left
+// unchanged.
+//
+// A report gives the data types and the uncompressed sizes of each
+// variable before and after, and the sizes of the column files.  With
+// -dryrun, only the uncompressed sizes are reported and nothing is
+// changed.  As in cast, the new columns replace the old ones only after
+// every bucket has been converted.
+
+package compact
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The variables to compact
+	vars []string
+
+	// If true, only report the new data types
+	dryrun bool
+
+	// Configuration information for the data set
+	conf *config.Config
+)
+
+// colstats summarizes the values of one variable.
+type colstats struct {
+
+	// The data types of the variable in the buckets
+	dtypes map[string]bool
+
+	// The number of values
+	n int64
+
+	// The uncompressed size of the current columns
+	size int64
+
+	// True if every value is a whole number, and if every value is
+	// exactly representable as float32
+	whole, f32 bool
+
+	// True if any value is negative
+	neg bool
+
+	// True if any value is too large for varint
+	novarint bool
+
+	// The largest value, if no value is negative
+	max uint64
+
+	// The total uncompressed sizes as uvarint and as varint
+	uvsize, vsize int64
+}
+
+// uvlen returns the number of bytes of a value stored as uvarint.
+func uvlen(x uint64) int64 {
+	if x == 0 {
+		return 1
+	}
+	return int64(bits.Len64(x)+6) / 7
+}
+
+// vlen returns the number of bytes of a value stored as varint.
+func vlen(x int64) int64 {
+	return uvlen(uint64(x<<1) ^ uint64(x>>63))
+}
+
+// isfloat returns true for the floating point data types.
+func isfloat(dt string) bool {
+	return dt == "float32" || dt == "float64"
+}
+
+// addint records a whole value.
+func (s *colstats) addint(x int64) {
+	if x >= 0 {
+		s.adduint(uint64(x))
+		return
+	}
+	s.neg = true
+	s.vsize += vlen(x)
+}
+
+// adduint records a non-negative whole value.
+func (s *colstats) adduint(x uint64) {
+	if x > s.max {
+		s.max = x
+	}
+	s.uvsize += uvlen(x)
+	if x > math.MaxInt64 {
+		s.novarint = true
+	} else {
+		s.vsize += vlen(int64(x))
+	}
+}
+
+// scan updates the summary with the values of a variable in one
+// bucket.
+func (s *colstats) scan(bn int, vn, dt string) error {
+
+	s.dtypes[dt] = true
+
+	rdr, err := config.OpenReader(bn, sourcedir, vn, dt)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+
+	for {
+		switch {
+		case isfloat(dt):
+			x, err := rdr.Float()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if x != float64(float32(x)) && !math.IsNaN(x) {
+				s.f32 = false
+			}
+			if x != math.Trunc(x) || math.IsInf(x, 0) || math.Abs(x) >= math.MaxInt64 {
+				s.whole = false
+			} else if s.whole {
+				s.addint(int64(x))
+			}
+			s.size += int64(config.DTsize[dt])
+		case dt == "varint":
+			x, err := rdr.Int()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			s.addint(x)
+			s.size += vlen(x)
+		default:
+			x, err := rdr.Uint()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			s.adduint(x)
+			if dt == "uvarint" {
+				s.size += uvlen(x)
+			} else {
+				s.size += int64(config.DTsize[dt])
+			}
+		}
+		s.n++
+	}
+}
+
+// choose returns the smallest data type for a variable and its
+// uncompressed size, or the current type if it is no larger.
+func choose(s *colstats, cur string, factor bool) (string, int64) {
+
+	best, size := cur, s.size
+	try := func(dt string, m int64) {
+		if m < size {
+			best, size = dt, m
+		}
+	}
+
+	if isfloat(cur) {
+		try("float64", 8*s.n)
+		if s.f32 {
+			try("float32", 4*s.n)
+		}
+		if !s.whole {
+			return best, size
+		}
+	}
+
+	if !s.neg {
+		for _, dt := range []string{"uint8", "uint16", "uint32", "uint64"} {
+			if s.max <= math.MaxUint64>>uint(64-8*config.DTsize[dt]) {
+				try(dt, int64(config.DTsize[dt])*s.n)
+				break
+			}
+		}
+		try("uvarint", s.uvsize)
+	}
+
+	if !factor && !s.novarint {
+		try("varint", s.vsize)
+	}
+
+	return best, size
+}
+
+// convert reads the next value from a column and returns it in the
+// representation of config.WriteBits for the data type dt.
+func convert(rdr *config.ColumnReader, dt string) (uint64, error) {
+
+	src := rdr.Dtype()
+
+	switch {
+	case isfloat(src):
+		x, err := rdr.Float()
+		switch {
+		case isfloat(dt):
+			return math.Float64bits(x), err
+		case dt == "varint":
+			return uint64(int64(x)), err
+		}
+		return uint64(x), err
+	case src == "varint":
+		x, err := rdr.Int()
+		if isfloat(dt) {
+			return math.Float64bits(float64(x)), err
+		}
+		return uint64(x), err
+	}
+
+	x, err := rdr.Uint()
+	if isfloat(dt) {
+		return math.Float64bits(float64(x)), err
+	}
+	return x, err
+}
+
+// rewrite writes a variable of one bucket with a new data type to a
+// temporary file.
+func rewrite(bn int, vn, dt string) error {
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return err
+	}
+
+	rdr, err := config.OpenReader(bn, sourcedir, vn, dtypes[vn])
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+
+	wtr, fid, err := config.CreateColumn(bn, sourcedir, vn+".tmp")
+	if err != nil {
+		return err
+	}
+
+	for {
+		var x uint64
+		x, err = convert(rdr, dt)
+		if err == io.EOF {
+			err = nil
+			break
+		} else if err != nil {
+			break
+		}
+		if err = config.WriteBits(wtr, dt, x); err != nil {
+			break
+		}
+	}
+
+	err1 := wtr.Close()
+	err2 := fid.Close()
+	for _, e := range []error{err, err1, err2} {
+		if e != nil {
+			return fmt.Errorf("variable %s, bucket %d: %v", vn, bn, e)
+		}
+	}
+
+	return nil
+}
+
+// replacebucket moves the new columns of one bucket into place and
+// records their data types, updating meta.json if it exists.
+func replacebucket(bn int, newtypes map[string]string) error {
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return err
+	}
+
+	for vn, dt := range newtypes {
+		if dtypes[vn] == dt {
+			os.Remove(config.ColumnPath(bn, sourcedir, vn+".tmp"))
+			continue
+		}
+		err := os.Rename(config.ColumnPath(bn, sourcedir, vn+".tmp"), config.ColumnPath(bn, sourcedir, vn))
+		if err != nil {
+			return err
+		}
+		dtypes[vn] = dt
+	}
+
+	if err := config.WriteDtypes(bn, sourcedir, dtypes); err != nil {
+		return err
+	}
+
+	if meta, err := config.ReadMeta(bn, sourcedir); err == nil {
+		meta, err = config.ComputeMeta(bn, sourcedir, meta.IdVar)
+		if err != nil {
+			return err
+		}
+		return config.WriteMeta(bn, sourcedir, meta)
+	}
+
+	return nil
+}
+
+// filesize returns the total size of the column files of a variable.
+func filesize(vn string) (int64, error) {
+
+	var n int64
+	for k := 0; k < conf.NumBuckets; k++ {
+		fi, err := os.Stat(config.ColumnPath(k, sourcedir, vn))
+		if err != nil {
+			return 0, err
+		}
+		n += fi.Size()
+	}
+
+	return n, nil
+}
+
+// cleanup removes the temporary files.
+func cleanup(newtypes map[string]string) {
+	for k := 0; k < conf.NumBuckets; k++ {
+		for vn := range newtypes {
+			os.Remove(config.ColumnPath(k, sourcedir, vn+".tmp"))
+		}
+	}
+}
+
+// olddtype describes the data types of a variable before compaction.
+func olddtype(s *colstats) string {
+	var dts []string
+	for dt := range s.dtypes {
+		dts = append(dts, dt)
+	}
+	sort.Strings(dts)
+	if len(dts) == 1 {
+		return dts[0]
+	}
+	return "mixed: " + strings.Join(dts, ",")
+}
+
+// Run runs compact with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("compact", flag.ContinueOnError)
+	var vlist string
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&vlist, "vars", "", "comma-separated variables to compact (default all)")
+	fs.BoolVar(&dryrun, "dryrun", false, "report the new data types without changing the data")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" {
+		return cli.Usage("usage:\ncompact -sourcedir=... [-vars=...] [-dryrun]\n\n")
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	dtypes, err := config.ReadDtypes(0, sourcedir)
+	if err != nil {
+		return err
+	}
+	if vlist != "" {
+		vars = strings.Split(vlist, ",")
+	} else {
+		for vn := range dtypes {
+			vars = append(vars, vn)
+		}
+	}
+	sort.Strings(vars)
+
+	stats := make(map[string]*colstats)
+	for _, vn := range vars {
+		if dt := dtypes[vn]; dt != "delta-uvarint" && dt != "text" && dt != "bool" && !config.IsTime(dt) {
+			stats[vn] = &colstats{dtypes: make(map[string]bool), whole: true, f32: true}
+		}
+	}
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		bdtypes, err := config.ReadDtypes(k, sourcedir)
+		if err != nil {
+			return err
+		}
+		for _, vn := range vars {
+			dt, ok := bdtypes[vn]
+			if !ok {
+				return fmt.Errorf("Variable %s not found in bucket %d", vn, k)
+			}
+			s, ok := stats[vn]
+			if !ok || dt == "delta-uvarint" || dt == "text" || dt == "bool" || config.IsTime(dt) {
+				delete(stats, vn)
+				continue
+			}
+			if err := s.scan(k, vn, dt); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The new data types, for variables that change in some bucket
+	newtypes := make(map[string]string)
+	newsize := make(map[string]int64)
+	for vn, s := range stats {
+		dt, size := choose(s, dtypes[vn], config.HasFactorCodes(vn, conf))
+		newsize[vn] = size
+		if len(s.dtypes) > 1 || !s.dtypes[dt] {
+			newtypes[vn] = dt
+		}
+	}
+
+	oldfiles := make(map[string]int64)
+	if !dryrun {
+		for _, vn := range vars {
+			if oldfiles[vn], err = filesize(vn); err != nil {
+				return err
+			}
+		}
+		for k := 0; k < conf.NumBuckets; k++ {
+			for vn, dt := range newtypes {
+				if err := rewrite(k, vn, dt); err != nil {
+					cleanup(newtypes)
+					return err
+				}
+			}
+		}
+		for k := 0; k < conf.NumBuckets; k++ {
+			if err := replacebucket(k, newtypes); err != nil {
+				return err
+			}
+		}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if dryrun {
+		fmt.Fprintf(tw, "Variable\tOld\tNew\tRaw before\tRaw after\n")
+	} else {
+		fmt.Fprintf(tw, "Variable\tOld\tNew\tRaw before\tRaw after\tFile before\tFile after\n")
+	}
+	var saved int64
+	for _, vn := range vars {
+		s, ok := stats[vn]
+		if !ok {
+			continue
+		}
+		newdt := newtypes[vn]
+		if newdt == "" {
+			newdt = olddtype(s)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d", vn, olddtype(s), newdt, s.size, newsize[vn])
+		if dryrun {
+			saved += s.size - newsize[vn]
+			fmt.Fprintf(tw, "\n")
+			continue
+		}
+		n, err := filesize(vn)
+		if err != nil {
+			return err
+		}
+		saved += oldfiles[vn] - n
+		fmt.Fprintf(tw, "\t%d\t%d\n", oldfiles[vn], n)
+	}
+	tw.Flush()
+
+	if dryrun {
+		fmt.Printf("\n%d variables would change, saving %d uncompressed bytes\n", len(newtypes), saved)
+	} else {
+		fmt.Printf("\n%d variables changed, saving %d bytes on disk\n", len(newtypes), saved)
+	}
+
+	return nil
+}
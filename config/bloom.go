@@ -0,0 +1,175 @@
+package config
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"time"
+)
+
+// BloomSuffix is appended to the name of a variable to obtain the name
+// of the file, in the bucket directory, holding a Bloom filter of its
+// values.
+const BloomSuffix = ".bloom.json"
+
+// Bloom is a Bloom filter of the values of an unsigned integer
+// variable in a bucket.  It can show that a bucket holds none of a set
+// of values without reading the column.  The filter records the size
+// and modification time of the column file it was built from, and is
+// only used while they are unchanged.
+type Bloom struct {
+
+	// The number of hash functions
+	K int
+
+	// The bits of the filter
+	Bits []byte
+
+	// The size of the column file when the filter was built
+	Size int64
+
+	// The modification time of the column file when the filter was
+	// built
+	ModTime time.Time
+}
+
+// NewBloom returns an empty Bloom filter sized for n values, with a
+// false positive rate of about fpr.
+func NewBloom(n int, fpr float64) *Bloom {
+
+	if n < 1 {
+		n = 1
+	}
+
+	// The optimal number of bits and hash functions
+	m := math.Ceil(-float64(n) * math.Log(fpr) / (math.Ln2 * math.Ln2))
+	k := int(math.Round(m / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+
+	return &Bloom{K: k, Bits: make([]byte, (int(m)+7)/8)}
+}
+
+// mix64 scrambles the bits of x (the finalizer of SplitMix64).
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// positions calls f with the position of each bit of the filter for a
+// value, using double hashing.  Iteration stops if f returns false.
+func (b *Bloom) positions(x uint64, f func(uint64) bool) {
+
+	m := uint64(8 * len(b.Bits))
+	h1 := mix64(x)
+	h2 := mix64(x^0x9e3779b97f4a7c15) | 1
+	for i := 0; i < b.K; i++ {
+		if !f((h1 + uint64(i)*h2) % m) {
+			return
+		}
+	}
+}
+
+// Add adds a value to the filter.
+func (b *Bloom) Add(x uint64) {
+	b.positions(x, func(j uint64) bool {
+		b.Bits[j/8] |= 1 << (j % 8)
+		return true
+	})
+}
+
+// Test returns false if the value was certainly not added to the
+// filter, and true if it may have been.
+func (b *Bloom) Test(x uint64) bool {
+	found := true
+	b.positions(x, func(j uint64) bool {
+		found = b.Bits[j/8]&(1<<(j%8)) != 0
+		return found
+	})
+	return found
+}
+
+// stamp returns the size and modification time of the column file of
+// a variable in a bucket, which identify the version of the column
+// that an index was built from.
+func stamp(bucket int, pa, vname string) (int64, time.Time, error) {
+
+	fi, err := StatFile(ColumnPath(bucket, pa, vname))
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return fi.Size(), fi.ModTime(), nil
+}
+
+// fresh returns true if the column file of a variable in a bucket still
+// has the given size and modification time.  The times are compared to
+// the second, since web servers and object stores report no finer
+// times, so that an index built from a local copy of a data set remains
+// usable once the data set is published.
+func fresh(bucket int, pa, vname string, size int64, mtime time.Time) (bool, error) {
+
+	sz, mt, err := stamp(bucket, pa, vname)
+	if err != nil {
+		return false, err
+	}
+
+	return sz == size && mt.Unix() == mtime.Unix(), nil
+}
+
+// BloomPath returns the path of the file holding the Bloom filter of a
+// variable in a bucket.
+func BloomPath(bucket int, pa, vname string) string {
+	return Join(BucketPath(bucket, pa), vname+BloomSuffix)
+}
+
+// WriteBloom saves the Bloom filter of a variable in a bucket, stamped
+// with the current size and modification time of the column file.
+func WriteBloom(bucket int, pa, vname string, b *Bloom) error {
+
+	var err error
+	b.Size, b.ModTime, err = stamp(bucket, pa, vname)
+	if err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	return WriteFile(BloomPath(bucket, pa, vname), buf)
+}
+
+// ReadBloom returns the Bloom filter of a variable in a bucket.  If
+// there is no filter, or the column file has changed since the filter
+// was built, nil is returned with no error.
+func ReadBloom(bucket int, pa, vname string) (*Bloom, error) {
+
+	buf, err := ReadFile(BloomPath(bucket, pa, vname))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	b := new(Bloom)
+	if err := json.Unmarshal(buf, b); err != nil {
+		return nil, err
+	}
+
+	ok, err := fresh(bucket, pa, vname, b.Size, b.ModTime)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || len(b.Bits) == 0 {
+		return nil, nil
+	}
+
+	return b, nil
+}
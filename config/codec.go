@@ -0,0 +1,224 @@
+package config
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec describes how the column files of a data set are compressed.
+// Every codec must accept a file holding several concatenated
+// streams, since appending to a column writes a new stream after the
+// existing data.
+type Codec struct {
+
+	// The name of the codec, as stored in Config.Compression
+	Name string
+
+	// The file name extension of the column files
+	Ext string
+
+	// NewReader returns a reader for the decompressed contents of r
+	NewReader func(r io.Reader) (io.Reader, error)
+
+	// NewWriter returns a writer that compresses data into w.  The
+	// writer must be closed to flush the data.
+	NewWriter func(w io.Writer) (io.WriteCloser, error)
+}
+
+// DefaultCompression is used for data sets whose configuration does
+// not name a codec.
+const DefaultCompression = "snappy"
+
+var (
+	codecs = map[string]*Codec{
+		"snappy": {
+			Name:      "snappy",
+			Ext:       ".bin.sz",
+			NewReader: func(r io.Reader) (io.Reader, error) { return snappy.NewReader(r), nil },
+			NewWriter: func(w io.Writer) (io.WriteCloser, error) { return snappy.NewBufferedWriter(w), nil },
+		},
+		"gzip": {
+			Name:      "gzip",
+			Ext:       ".bin.gz",
+			NewReader: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+			NewWriter: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		},
+		"zstd": {
+			Name: "zstd",
+			Ext:  ".bin.zst",
+			NewReader: func(r io.Reader) (io.Reader, error) {
+				d, err := zstd.NewReader(r)
+				if err != nil {
+					return nil, err
+				}
+				return d.IOReadCloser(), nil
+			},
+			NewWriter: func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) },
+		},
+		"none": {
+			Name:      "none",
+			Ext:       ".bin",
+			NewReader: func(r io.Reader) (io.Reader, error) { return r, nil },
+			NewWriter: func(w io.Writer) (io.WriteCloser, error) { return nopWriteCloser{w}, nil },
+		},
+	}
+	codecmut sync.RWMutex
+
+	// The codecs of the data sets that have been accessed, by path
+	dircodecs sync.Map
+)
+
+// dircodec holds the codecs of a data set.
+type dircodec struct {
+
+	// The codec named by Config.Compression
+	def *Codec
+
+	// The codecs of the variables named in Config.ColumnCompression
+	cols map[string]*Codec
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// RegisterCodec makes a codec available under its name.
+func RegisterCodec(c *Codec) {
+	codecmut.Lock()
+	codecs[c.Name] = c
+	codecmut.Unlock()
+}
+
+// GetCodec returns the codec with the given name.  The empty name
+// refers to the default codec.  A compression level can be given for
+// gzip (1 to 9) and zstd (1 to 22) by appending it to the name, as in
+// "zstd-19".  Files written at any level are read in the same way, so
+// the level only affects writing.
+func GetCodec(name string) (*Codec, error) {
+
+	if name == "" {
+		name = DefaultCompression
+	}
+
+	codecmut.RLock()
+	c, ok := codecs[name]
+	codecmut.RUnlock()
+	if ok {
+		return c, nil
+	}
+
+	if i := strings.LastIndex(name, "-"); i > 0 {
+		base, lev := name[0:i], name[i+1:]
+		level, err := strconv.Atoi(lev)
+		if err == nil {
+			return levelCodec(name, base, level)
+		}
+	}
+
+	return nil, fmt.Errorf("unknown compression %q", name)
+}
+
+// levelCodec returns a codec that writes with the given compression
+// level, and registers it under name.
+func levelCodec(name, base string, level int) (*Codec, error) {
+
+	bc, err := GetCodec(base)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Codec{Name: name, Ext: bc.Ext, NewReader: bc.NewReader}
+	switch base {
+	case "gzip":
+		if level < gzip.BestSpeed || level > gzip.BestCompression {
+			return nil, fmt.Errorf("invalid gzip level %d in compression %q", level, name)
+		}
+		c.NewWriter = func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriterLevel(w, level) }
+	case "zstd":
+		if level < 1 || level > 22 {
+			return nil, fmt.Errorf("invalid zstd level %d in compression %q", level, name)
+		}
+		opt := zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level))
+		c.NewWriter = func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w, opt) }
+	default:
+		return nil, fmt.Errorf("compression %s does not have levels", base)
+	}
+
+	RegisterCodec(c)
+
+	return c, nil
+}
+
+// DirCodec returns the codec used by the data set in directory pa, as
+// named in its configuration.  The result is cached, so the
+// configuration is read only once per data set.
+func DirCodec(pa string) (*Codec, error) {
+
+	dc, err := getdircodec(pa)
+	if err != nil {
+		return nil, err
+	}
+
+	return dc.def, nil
+}
+
+// ColumnCodec returns the codec used for a variable by the data set in
+// directory pa: the codec named for the variable in
+// Config.ColumnCompression, or else the codec of the data set.  A
+// ".tmp" suffix on the variable name, used for temporary columns, is
+// ignored, so that a temporary column can be renamed into place, as
+// are TextSuffix and NullSuffix.
+func ColumnCodec(pa, vname string) (*Codec, error) {
+
+	dc, err := getdircodec(pa)
+	if err != nil {
+		return nil, err
+	}
+
+	vname = strings.TrimSuffix(vname, TextSuffix)
+	vname = strings.TrimSuffix(vname, NullSuffix)
+	if c, ok := dc.cols[strings.TrimSuffix(vname, ".tmp")]; ok {
+		return c, nil
+	}
+
+	return dc.def, nil
+}
+
+// getdircodec returns the codecs of a data set, reading its
+// configuration if they are not cached.
+func getdircodec(pa string) (*dircodec, error) {
+
+	pa = Join(pa)
+	if dc, ok := dircodecs.Load(pa); ok {
+		return dc.(*dircodec), nil
+	}
+
+	conf, err := GetConfig(pa)
+	if err != nil {
+		return nil, err
+	}
+
+	dc := &dircodec{cols: make(map[string]*Codec)}
+	dc.def, err = GetCodec(conf.Compression)
+	if err != nil {
+		return nil, err
+	}
+	for vn, name := range conf.ColumnCompression {
+		dc.cols[vn], err = GetCodec(name)
+		if err != nil {
+			return nil, fmt.Errorf("variable %s: %v", vn, err)
+		}
+	}
+	dircodecs.Store(pa, dc)
+
+	return dc, nil
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestGetCodec(t *testing.T) {
+
+	for _, tc := range []struct {
+		name string
+		ext  string
+		err  string
+	}{
+		{"", ".bin.sz", ""},
+		{"snappy", ".bin.sz", ""},
+		{"gzip-9", ".bin.gz", ""},
+		{"zstd-19", ".bin.zst", ""},
+		{"none", ".bin", ""},
+		{"gzip-0", "", "invalid gzip level 0"},
+		{"zstd-23", "", "invalid zstd level 23"},
+		{"snappy-3", "", "compression snappy does not have levels"},
+		{"lz4", "", `unknown compression "lz4"`},
+	} {
+		c, err := GetCodec(tc.name)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%q: got error %v, expected %q", tc.name, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.name, err)
+		} else if c.Ext != tc.ext {
+			t.Errorf("%q: got extension %s, expected %s", tc.name, c.Ext, tc.ext)
+		}
+	}
+}
+
+// TestCodecStreams checks that each codec reads back what it wrote,
+// including several streams written one after the other, as when a
+// column is appended to.
+func TestCodecStreams(t *testing.T) {
+
+	var parts [][]byte
+	for _, s := range []string{"", "a", strings.Repeat("gocols ", 10000)} {
+		parts = append(parts, []byte(s))
+	}
+
+	for _, name := range []string{"snappy", "gzip", "gzip-1", "zstd", "zstd-3", "none"} {
+		c, err := GetCodec(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tc := range []struct {
+			desc    string
+			streams [][]byte
+		}{
+			{"empty", [][]byte{parts[0]}},
+			{"one stream", [][]byte{parts[2]}},
+			{"three streams", parts},
+			{"repeated", [][]byte{parts[1], parts[2], parts[1]}},
+		} {
+			var buf, want bytes.Buffer
+			for _, p := range tc.streams {
+				w, err := c.NewWriter(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := w.Write(p); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+				want.Write(p)
+			}
+
+			r, err := c.NewReader(&buf)
+			if err != nil {
+				t.Fatalf("%s, %s: %v", name, tc.desc, err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%s, %s: %v", name, tc.desc, err)
+			}
+			if !bytes.Equal(got, want.Bytes()) {
+				t.Errorf("%s, %s: got %d bytes, expected %d", name, tc.desc, len(got), want.Len())
+			}
+		}
+	}
+}
@@ -0,0 +1,905 @@
+package config
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"strconv"
+	"sync"
+
+	"github.com/golang/snappy"
+)
+
+// TextSuffix is appended to the name of a text variable to obtain the
+// name, as used by ColumnPath, of the file holding the bytes of its
+// values.  The column file of a text variable holds the length of
+// each value as a uvarint, and the bytes of the values are
+// concatenated in the second file, so that high-cardinality strings
+// can be stored without factor coding.
+const TextSuffix = ".bytes"
+
+// ColumnFiles returns the names, as used by ColumnPath, of the files
+// holding a variable with the given data type.  The first name is the
+// column file.  Text variables also have a file holding the bytes of
+// their values.
+func ColumnFiles(vname, dtype string) []string {
+	if dtype == "text" {
+		return []string{vname, vname + TextSuffix}
+	}
+	return []string{vname}
+}
+
+// ColumnPath returns the path to the data file holding the given
+// variable in the given bucket.  The file name extension depends on
+// the compression of the variable (see ColumnCodec); the extension of
+// the default codec is used if the configuration cannot be read.
+func ColumnPath(bucket int, pa, vname string) string {
+	c, err := ColumnCodec(pa, vname)
+	if err != nil {
+		c, _ = GetCodec(DefaultCompression)
+	}
+	return Join(BucketPath(bucket, pa), vname+c.Ext)
+}
+
+// decoder holds a snappy reader and a buffer on top of it.  The
+// decoders are large, so they are kept in a pool and reused rather
+// than being allocated for every column that is read.
+type decoder struct {
+	sr *snappy.Reader
+	br *bufio.Reader
+}
+
+var decoderPool = sync.Pool{
+	New: func() interface{} {
+		sr := snappy.NewReader(nil)
+		return &decoder{sr: sr, br: bufio.NewReader(sr)}
+	},
+}
+
+// pooledFile closes a column file and returns its decoder to the
+// pool.
+type pooledFile struct {
+	fid io.ReadCloser
+	dec *decoder
+}
+
+// codecFile closes a column file and the decompressor reading from
+// it, for codecs other than snappy.
+type codecFile struct {
+	fid io.ReadCloser
+	rdr io.Reader
+}
+
+func (p *codecFile) Close() error {
+	if c, ok := p.rdr.(io.Closer); ok {
+		c.Close()
+	}
+	return p.fid.Close()
+}
+
+func (p *pooledFile) Close() error {
+	if p.dec == nil {
+		return nil
+	}
+	p.dec.sr.Reset(nil)
+	p.dec.br.Reset(p.dec.sr)
+	decoderPool.Put(p.dec)
+	p.dec = nil
+	return p.fid.Close()
+}
+
+// CountRead, if not nil, is called with the number of bytes read from
+// a column file of a bucket, each time bytes are read by a reader from
+// OpenColumn or OpenReader, so that a command can measure its input.
+// The bytes are counted as stored, before decompression.  A
+// memory-mapped column is counted in full when it is opened.  It may
+// be called from several goroutines at once.
+var CountRead func(bucket int, n int)
+
+// countingFile reports the bytes read from a column file to a
+// CountRead function.
+type countingFile struct {
+	io.ReadCloser
+	bucket int
+	count  func(int, int)
+}
+
+func (f *countingFile) Read(p []byte) (int, error) {
+	n, err := f.ReadCloser.Read(p)
+	if n > 0 {
+		f.count(f.bucket, n)
+	}
+	return n, err
+}
+
+// OpenColumn returns a buffered reader for the decompressed contents
+// of a variable in a bucket, along with a closer which should be
+// closed by the caller.  The reader must not be used after the closer
+// is closed, since it is reused for other columns.
+func OpenColumn(bucket int, pa, vname string) (*bufio.Reader, io.Closer, error) {
+
+	codec, err := ColumnCodec(pa, vname)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fid, err := OpenFile(ColumnPath(bucket, pa, vname))
+	if err != nil {
+		return nil, nil, err
+	}
+	if CountRead != nil {
+		fid = &countingFile{ReadCloser: fid, bucket: bucket, count: CountRead}
+	}
+
+	if codec.Name != "snappy" {
+		rdr, err := codec.NewReader(fid)
+		if err != nil {
+			fid.Close()
+			return nil, nil, err
+		}
+		return bufio.NewReader(rdr), &codecFile{fid: fid, rdr: rdr}, nil
+	}
+
+	dec := decoderPool.Get().(*decoder)
+	dec.sr.Reset(fid)
+	dec.br.Reset(dec.sr)
+
+	return dec.br, &pooledFile{fid: fid, dec: dec}, nil
+}
+
+// CreateColumn returns a writer that compresses data into the file
+// holding a variable in a bucket, along with the underlying file.  The
+// writer must be closed before the file.
+func CreateColumn(bucket int, pa, vname string) (io.WriteCloser, io.Closer, error) {
+
+	codec, err := ColumnCodec(pa, vname)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fid, err := CreateFile(ColumnPath(bucket, pa, vname))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wtr, err := codec.NewWriter(fid)
+	if err != nil {
+		fid.Close()
+		return nil, nil, err
+	}
+
+	return wtr, fid, nil
+}
+
+// AppendColumn is like CreateColumn, but the data are compressed as a
+// new stream written after the existing contents of the file, so that
+// reading the file yields the existing values followed by the new
+// values.  The file is created if it does not exist.
+func AppendColumn(bucket int, pa, vname string) (io.WriteCloser, io.Closer, error) {
+
+	codec, err := ColumnCodec(pa, vname)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fid, err := AppendFile(ColumnPath(bucket, pa, vname))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wtr, err := codec.NewWriter(fid)
+	if err != nil {
+		fid.Close()
+		return nil, nil, err
+	}
+
+	return wtr, fid, nil
+}
+
+// ColumnReader reads the values of one column in a bucket, decoding
+// them according to the column's data type.
+type ColumnReader struct {
+	br    *bufio.Reader
+	fid   io.Closer
+	dtype string
+
+	// The most recent value of a delta-uvarint column
+	last uint64
+
+	// The bytes of the values of a text column
+	tr   *bufio.Reader
+	tfid io.Closer
+
+	// The unread values of the current byte of a bool column
+	cur   byte
+	nbits int
+
+	// The memory-mapped column file and the position of the next
+	// value, if the column is mapped (see UseMmap)
+	mm  []byte
+	pos int
+
+	// The block of encoded values read by Uints
+	blk []byte
+}
+
+// OpenReader returns a reader for the values of a variable in a
+// bucket, where dtype is the data type of the variable.  With UseMmap,
+// uncompressed fixed-width columns are memory-mapped.
+func OpenReader(bucket int, pa, vname, dtype string) (*ColumnReader, error) {
+
+	if UseMmap {
+		if r := openmapped(bucket, pa, vname, dtype); r != nil {
+			return r, nil
+		}
+	}
+
+	br, fid, err := OpenColumn(bucket, pa, vname)
+	if err != nil {
+		return nil, err
+	}
+	r := &ColumnReader{br: br, fid: fid, dtype: dtype}
+
+	if dtype == "text" {
+		r.tr, r.tfid, err = OpenColumn(bucket, pa, vname+TextSuffix)
+		if err != nil {
+			fid.Close()
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// Dtype returns the data type of the column.
+func (r *ColumnReader) Dtype() string {
+	return r.dtype
+}
+
+// Uint reads the next value of a column with an unsigned integer data
+// type.  io.EOF is returned when the column is exhausted.
+func (r *ColumnReader) Uint() (uint64, error) {
+
+	if r.mm != nil {
+		return r.mappedUint()
+	}
+
+	if r.dtype == "bool" {
+		return r.bit()
+	}
+
+	if r.dtype == "delta-uvarint" {
+		// Each stored value is the difference from the
+		// preceding value.
+		d, err := binary.ReadUvarint(r.br)
+		if err != nil {
+			return 0, err
+		}
+		r.last += d
+		return r.last, nil
+	}
+
+	return ReadUint(r.br, r.dtype)
+}
+
+// Uints reads up to len(x) values of a column with an unsigned integer
+// data type into x, returning the number of values read.  Values of
+// fixed-width data types are read in one block and decoded from it,
+// which is much faster than calling Uint for each value.  io.EOF is
+// returned, with no values, when the column is exhausted.
+func (r *ColumnReader) Uints(x []uint64) (int, error) {
+
+	w, ok := DTsize[r.dtype]
+	switch r.dtype {
+	case "uint8", "uint16", "uint32", "uint64":
+	default:
+		ok = false
+	}
+
+	if !ok {
+		for i := range x {
+			v, err := r.Uint()
+			if err == io.EOF && i > 0 {
+				return i, nil
+			} else if err != nil {
+				return i, err
+			}
+			x[i] = v
+		}
+		return len(x), nil
+	}
+
+	var b []byte
+	if r.mm != nil {
+		if r.pos == len(r.mm) {
+			return 0, io.EOF
+		}
+		b = r.mm[r.pos:]
+		if len(b) > len(x)*w {
+			b = b[0 : len(x)*w]
+		}
+		r.pos += len(b)
+	} else {
+		if cap(r.blk) < len(x)*w {
+			r.blk = make([]byte, len(x)*w)
+		}
+		m, err := io.ReadFull(r.br, r.blk[0:len(x)*w])
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		b = r.blk[0:m]
+	}
+	if len(b)%w != 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	n := len(b) / w
+	switch w {
+	case 1:
+		for i := 0; i < n; i++ {
+			x[i] = uint64(b[i])
+		}
+	case 2:
+		for i := 0; i < n; i++ {
+			x[i] = uint64(binary.LittleEndian.Uint16(b[2*i:]))
+		}
+	case 4:
+		for i := 0; i < n; i++ {
+			x[i] = uint64(binary.LittleEndian.Uint32(b[4*i:]))
+		}
+	default:
+		for i := 0; i < n; i++ {
+			x[i] = binary.LittleEndian.Uint64(b[8*i:])
+		}
+	}
+
+	return n, nil
+}
+
+// bit reads the next value of a bool column as 0 or 1.
+func (r *ColumnReader) bit() (uint64, error) {
+
+	if r.nbits == 0 {
+		c, err := r.br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		r.cur, r.nbits = c, 8
+
+		// The last byte ends with the end marker, its highest one
+		// bit.
+		if _, err := r.br.Peek(1); err == io.EOF {
+			if c == 0 {
+				return 0, fmt.Errorf("bool column has no end marker")
+			}
+			r.nbits = bits.Len8(c) - 1
+			if r.nbits == 0 {
+				return 0, io.EOF
+			}
+		} else if err != nil {
+			return 0, err
+		}
+	}
+
+	x := uint64(r.cur & 1)
+	r.cur >>= 1
+	r.nbits--
+
+	return x, nil
+}
+
+// Bool reads the next value of a bool column.  io.EOF is returned when
+// the column is exhausted.
+func (r *ColumnReader) Bool() (bool, error) {
+
+	if r.dtype != "bool" {
+		return false, fmt.Errorf("dtype %s is not bool", r.dtype)
+	}
+
+	x, err := r.bit()
+	return x == 1, err
+}
+
+// Int reads the next value of a column with a signed (varint, date32
+// or timestamp64) or unsigned integer data type.  io.EOF is returned
+// when the column is exhausted.
+func (r *ColumnReader) Int() (int64, error) {
+
+	if r.mm != nil && IsTime(r.dtype) {
+		return r.mappedInt()
+	}
+	if r.dtype == "varint" || IsTime(r.dtype) {
+		return ReadInt(r.br, r.dtype)
+	}
+
+	x, err := r.Uint()
+	if err == nil && x > math.MaxInt64 {
+		return 0, fmt.Errorf("value %d does not fit in int64", x)
+	}
+	return int64(x), err
+}
+
+// Float reads the next value of a column with any numeric data type,
+// converted to float64.  io.EOF is returned when the column is
+// exhausted.
+func (r *ColumnReader) Float() (float64, error) {
+
+	if r.mm != nil {
+		return r.mappedFloat()
+	}
+
+	if r.dtype == "delta-uvarint" || r.dtype == "bool" {
+		x, err := r.Uint()
+		return float64(x), err
+	}
+
+	return ReadFloat(r.br, r.dtype)
+}
+
+// Text reads the next value of a text column, or of a column with any
+// numeric data type formatted as a string.  Bool values are formatted
+// as true or false, and dates and times as described by DateFormat
+// and TimestampFormat.  io.EOF is returned when the column is
+// exhausted.
+func (r *ColumnReader) Text() (string, error) {
+
+	if r.dtype == "text" {
+		b, err := r.TextBytes(nil)
+		return string(b), err
+	}
+
+	if r.dtype == "bool" {
+		x, err := r.Bool()
+		return strconv.FormatBool(x), err
+	}
+
+	if r.dtype == "delta-uvarint" {
+		x, err := r.Uint()
+		return strconv.FormatUint(x, 10), err
+	}
+
+	if r.mm != nil {
+		return r.mappedText()
+	}
+
+	return ReadText(r.br, r.dtype)
+}
+
+// TextBytes reads the next value of a text column, appending its bytes
+// to buf.  io.EOF is returned when the column is exhausted.
+func (r *ColumnReader) TextBytes(buf []byte) ([]byte, error) {
+
+	if r.dtype != "text" {
+		return nil, fmt.Errorf("dtype %s is not text", r.dtype)
+	}
+
+	n, err := binary.ReadUvarint(r.br)
+	if err != nil {
+		return nil, err
+	}
+
+	m := len(buf)
+	buf = append(buf, make([]byte, n)...)
+	if _, err := io.ReadFull(r.tr, buf[m:]); err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// Bits reads the next value of a column with any data type as 64 bits:
+// the value of unsigned integers (0 or 1 for bool), the two's
+// complement of varints, dates and times, and the IEEE 754 bits of
+// floats (float32 values are converted to float64).  The value can be
+// written with WriteBits.  Text columns have no such representation.
+// io.EOF is returned when the column is exhausted.
+func (r *ColumnReader) Bits() (uint64, error) {
+
+	switch r.dtype {
+	case "float32", "float64":
+		x, err := r.Float()
+		return math.Float64bits(x), err
+	case "varint", "date32", "timestamp64":
+		x, err := r.Int()
+		return uint64(x), err
+	}
+
+	return r.Uint()
+}
+
+// Skip discards the next n values of a column.  Values of fixed-width
+// data types are discarded without being decoded.  io.EOF is returned
+// if the column has fewer than n values left.
+func (r *ColumnReader) Skip(n int) error {
+
+	if w, ok := DTsize[r.dtype]; ok && r.mm != nil {
+		if r.pos+n*w > len(r.mm) {
+			r.pos = len(r.mm)
+			return io.EOF
+		}
+		r.pos += n * w
+		return nil
+	} else if ok {
+		_, err := r.br.Discard(n * w)
+		return err
+	}
+
+	for i := 0; i < n; i++ {
+		var err error
+		if r.dtype == "text" {
+			_, err = r.Text()
+		} else {
+			_, err = r.Bits()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close closes the underlying files.
+func (r *ColumnReader) Close() error {
+	if r.tfid != nil {
+		r.tfid.Close()
+	}
+	return r.fid.Close()
+}
+
+// ReadUint reads one value from a column with an unsigned integer
+// data type (uint8, uint16, uint32, uint64 or uvarint).  io.EOF is
+// returned when the column is exhausted.
+func ReadUint(br *bufio.Reader, dtype string) (uint64, error) {
+
+	if dtype == "uvarint" {
+		return binary.ReadUvarint(br)
+	}
+
+	var b [8]byte
+	w, ok := DTsize[dtype]
+	if !ok || w > len(b) {
+		return 0, fmt.Errorf("unsupported dtype %s", dtype)
+	}
+	if _, err := io.ReadFull(br, b[0:w]); err != nil {
+		return 0, err
+	}
+
+	switch dtype {
+	case "uint8":
+		return uint64(b[0]), nil
+	case "uint16":
+		return uint64(binary.LittleEndian.Uint16(b[0:2])), nil
+	case "uint32":
+		return uint64(binary.LittleEndian.Uint32(b[0:4])), nil
+	case "uint64":
+		return binary.LittleEndian.Uint64(b[0:8]), nil
+	}
+
+	return 0, fmt.Errorf("dtype %s is not an unsigned integer type", dtype)
+}
+
+// ReadInt reads one value from a column with a signed integer data
+// type (varint, date32 or timestamp64).  io.EOF is returned when the
+// column is exhausted.
+func ReadInt(br *bufio.Reader, dtype string) (int64, error) {
+
+	switch dtype {
+	case "varint":
+		return binary.ReadVarint(br)
+	case "date32":
+		var b [4]byte
+		if _, err := io.ReadFull(br, b[:]); err != nil {
+			return 0, err
+		}
+		return int64(int32(binary.LittleEndian.Uint32(b[:]))), nil
+	case "timestamp64":
+		var b [8]byte
+		if _, err := io.ReadFull(br, b[:]); err != nil {
+			return 0, err
+		}
+		return int64(binary.LittleEndian.Uint64(b[:])), nil
+	}
+
+	return 0, fmt.Errorf("dtype %s is not a signed integer type", dtype)
+}
+
+// WriteInt writes one value to a column with a signed integer data
+// type.  An error is returned if the value does not fit in the data
+// type.
+func WriteInt(w io.Writer, dtype string, x int64) error {
+
+	var b [binary.MaxVarintLen64]byte
+	var m int
+
+	switch dtype {
+	case "varint":
+		m = binary.PutVarint(b[:], x)
+	case "date32":
+		if x < math.MinInt32 || x > math.MaxInt32 {
+			return fmt.Errorf("value %d does not fit in %s", x, dtype)
+		}
+		binary.LittleEndian.PutUint32(b[:], uint32(int32(x)))
+		m = 4
+	case "timestamp64":
+		binary.LittleEndian.PutUint64(b[:], uint64(x))
+		m = 8
+	default:
+		return fmt.Errorf("dtype %s is not a signed integer type", dtype)
+	}
+
+	_, err := w.Write(b[0:m])
+	return err
+}
+
+// WriteUint writes one value to a column with an unsigned integer
+// data type.  An error is returned if the value does not fit in the
+// data type.  Bool values, 0 or 1, must be written to a BoolWriter.
+func WriteUint(w io.Writer, dtype string, x uint64) error {
+
+	var b [binary.MaxVarintLen64]byte
+	var m int
+
+	switch dtype {
+	case "bool":
+		bw, ok := w.(*BoolWriter)
+		if !ok {
+			return fmt.Errorf("bool values must be written with a BoolWriter")
+		}
+		if x > 1 {
+			return fmt.Errorf("value %d is not a bool", x)
+		}
+		return bw.Put(x == 1)
+	case "uvarint":
+		m = binary.PutUvarint(b[:], x)
+	case "uint8":
+		if x > math.MaxUint8 {
+			return fmt.Errorf("value %d does not fit in %s", x, dtype)
+		}
+		b[0] = uint8(x)
+		m = 1
+	case "uint16":
+		if x > math.MaxUint16 {
+			return fmt.Errorf("value %d does not fit in %s", x, dtype)
+		}
+		binary.LittleEndian.PutUint16(b[:], uint16(x))
+		m = 2
+	case "uint32":
+		if x > math.MaxUint32 {
+			return fmt.Errorf("value %d does not fit in %s", x, dtype)
+		}
+		binary.LittleEndian.PutUint32(b[:], uint32(x))
+		m = 4
+	case "uint64":
+		binary.LittleEndian.PutUint64(b[:], x)
+		m = 8
+	default:
+		return fmt.Errorf("dtype %s is not an unsigned integer type", dtype)
+	}
+
+	_, err := w.Write(b[0:m])
+	return err
+}
+
+// WriteBits writes one value, in the representation returned by
+// ColumnReader.Bits, to a column of any data type other than
+// delta-uvarint.
+func WriteBits(w io.Writer, dtype string, x uint64) error {
+
+	switch dtype {
+	case "float32":
+		return WriteUint(w, "uint32", uint64(math.Float32bits(float32(math.Float64frombits(x)))))
+	case "float64":
+		return WriteUint(w, "uint64", x)
+	case "varint", "date32", "timestamp64":
+		return WriteInt(w, dtype, int64(x))
+	}
+
+	return WriteUint(w, dtype, x)
+}
+
+// BoolWriter packs the values of a bool column, eight values to a
+// byte with the first value in the lowest bit.  After the last value a
+// one bit is written, followed by zero bits to the end of the byte, so
+// that the number of values can be found from the data.  Since the end
+// marker is written by Close, a bool column cannot be extended by
+// appending to its file.
+type BoolWriter struct {
+	w   io.WriteCloser
+	cur byte
+	n   uint
+}
+
+// NewBoolWriter returns a writer packing the values of a bool column
+// into w, which is typically obtained from CreateColumn.
+func NewBoolWriter(w io.WriteCloser) *BoolWriter {
+	return &BoolWriter{w: w}
+}
+
+// Put writes one value.
+func (b *BoolWriter) Put(x bool) error {
+
+	if x {
+		b.cur |= 1 << b.n
+	}
+	b.n++
+
+	if b.n == 8 {
+		if _, err := b.w.Write([]byte{b.cur}); err != nil {
+			return err
+		}
+		b.cur, b.n = 0, 0
+	}
+
+	return nil
+}
+
+// Write writes one value for each byte of p, which must be 0 or 1.
+func (b *BoolWriter) Write(p []byte) (int, error) {
+
+	for i, c := range p {
+		if c > 1 {
+			return i, fmt.Errorf("value %d is not a bool", c)
+		}
+		if err := b.Put(c == 1); err != nil {
+			return i, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Close writes the last values and the end marker, and closes the
+// underlying writer.
+func (b *BoolWriter) Close() error {
+
+	_, err := b.w.Write([]byte{b.cur | 1<<b.n})
+	if err2 := b.w.Close(); err == nil {
+		err = err2
+	}
+
+	return err
+}
+
+// WriteText writes one value of a text column, its length to the
+// column file and its bytes to the file holding the bytes of the
+// values.
+func WriteText(w, tw io.Writer, x string) error {
+
+	var b [binary.MaxVarintLen64]byte
+	m := binary.PutUvarint(b[:], uint64(len(x)))
+	if _, err := w.Write(b[0:m]); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(tw, x)
+	return err
+}
+
+// ReadFloat reads one value from a column of any numeric data type,
+// converting it to float64.  io.EOF is returned when the column is
+// exhausted.
+func ReadFloat(br *bufio.Reader, dtype string) (float64, error) {
+
+	switch dtype {
+	case "varint", "date32", "timestamp64":
+		x, err := ReadInt(br, dtype)
+		return float64(x), err
+	case "float32":
+		var b [4]byte
+		if _, err := io.ReadFull(br, b[:]); err != nil {
+			return 0, err
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b[:]))), nil
+	case "float64":
+		var b [8]byte
+		if _, err := io.ReadFull(br, b[:]); err != nil {
+			return 0, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b[:])), nil
+	}
+
+	x, err := ReadUint(br, dtype)
+	return float64(x), err
+}
+
+// CountRows returns the number of values stored in a column of the
+// given data type.  The values of a text column are counted from their
+// lengths.
+func CountRows(bucket int, pa, vname, dtype string) (int, error) {
+
+	rdr, fid, err := OpenColumn(bucket, pa, vname)
+	if err != nil {
+		return 0, err
+	}
+	defer fid.Close()
+
+	if dtype == "uvarint" || dtype == "varint" || dtype == "delta-uvarint" || dtype == "text" {
+		// Each varint ends with the only byte having its high bit
+		// cleared.
+		var n int
+		b := make([]byte, 64*1024)
+		for {
+			m, err := rdr.Read(b)
+			for _, c := range b[0:m] {
+				if c < 0x80 {
+					n++
+				}
+			}
+			if err == io.EOF {
+				return n, nil
+			} else if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if dtype == "bool" {
+		// The values fill every byte but the last, which holds
+		// the end marker above the remaining values.
+		var nb int
+		var last byte
+		b := make([]byte, 64*1024)
+		for {
+			m, err := rdr.Read(b)
+			if m > 0 {
+				nb += m
+				last = b[m-1]
+			}
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return 0, err
+			}
+		}
+		if nb == 0 {
+			return 0, nil
+		} else if last == 0 {
+			return 0, fmt.Errorf("bool column has no end marker")
+		}
+		return 8*(nb-1) + bits.Len8(last) - 1, nil
+	}
+
+	w, ok := DTsize[dtype]
+	if !ok {
+		return 0, fmt.Errorf("unsupported dtype %s", dtype)
+	}
+	nb, err := io.Copy(io.Discard, rdr)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(nb) / w, nil
+}
+
+// ReadText reads one value from a column of any numeric data type and
+// returns it formatted as a string.  io.EOF is returned when the
+// column is exhausted.
+func ReadText(br *bufio.Reader, dtype string) (string, error) {
+
+	switch dtype {
+	case "varint":
+		x, err := binary.ReadVarint(br)
+		return strconv.FormatInt(x, 10), err
+	case "date32":
+		x, err := ReadInt(br, dtype)
+		return FormatDate(x), err
+	case "timestamp64":
+		x, err := ReadInt(br, dtype)
+		return FormatTimestamp(x), err
+	case "float32":
+		x, err := ReadFloat(br, dtype)
+		return strconv.FormatFloat(x, 'g', -1, 32), err
+	case "float64":
+		x, err := ReadFloat(br, dtype)
+		return strconv.FormatFloat(x, 'g', -1, 64), err
+	}
+
+	x, err := ReadUint(br, dtype)
+	return strconv.FormatUint(x, 10), err
+}
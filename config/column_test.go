@@ -0,0 +1,232 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/golang/snappy"
+)
+
+// dataset writes a data set with one bucket holding nvar uvarint
+// variables of n values in directory pa, compressed with the given
+// codec.  Value i of variable j is i*nvar+j.
+func dataset(t testing.TB, pa, compression string, nvar, n int) {
+
+	if err := MkdirAll(BucketPath(0, pa)); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteConfig(pa, &Config{NumBuckets: 1, Compression: compression}); err != nil {
+		t.Fatal(err)
+	}
+
+	dtypes := make(map[string]string)
+	for j := 0; j < nvar; j++ {
+		vn := fmt.Sprintf("v%d", j)
+		dtypes[vn] = "uvarint"
+		w, fid, err := CreateColumn(0, pa, vn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			if err := WriteUint(w, "uvarint", uint64(i*nvar+j)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := fid.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := WriteDtypes(0, pa, dtypes); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// check reads the first m values of variable j, and returns an error
+// if any of them is wrong.
+func check(pa string, nvar, j, m int) error {
+
+	r, err := OpenReader(0, pa, fmt.Sprintf("v%d", j), "uvarint")
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for i := 0; i < m; i++ {
+		x, err := r.Uint()
+		if err != nil {
+			return fmt.Errorf("v%d, row %d: %v", j, i, err)
+		}
+		if x != uint64(i*nvar+j) {
+			return fmt.Errorf("v%d, row %d: got %d, expected %d", j, i, x, i*nvar+j)
+		}
+	}
+
+	return nil
+}
+
+func TestPooledDecoders(t *testing.T) {
+
+	const nvar, n = 6, 5000
+
+	for _, compression := range []string{"snappy", "gzip", "zstd", "none"} {
+		pa := filepath.Join(t.TempDir(), compression)
+		dataset(t, pa, compression, nvar, n)
+
+		for _, tc := range []struct {
+			name string
+
+			// The number of values read before the reader is
+			// closed
+			m int
+		}{
+			{"all", n},
+			{"partial", n / 3},
+			{"none", 0},
+		} {
+			// Decoders released after a partial read must not
+			// pass buffered data to the next column.
+			var wg sync.WaitGroup
+			errs := make(chan error, 8)
+			for g := 0; g < 8; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					for k := 0; k < 3*nvar; k++ {
+						j := (g + k) % nvar
+						if err := check(pa, nvar, j, tc.m); err != nil {
+							errs <- err
+							return
+						}
+						if err := check(pa, nvar, (j+1)%nvar, n); err != nil {
+							errs <- err
+							return
+						}
+					}
+				}(g)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Errorf("%s, %s: %v", compression, tc.name, err)
+			}
+		}
+	}
+}
+
+// BenchmarkOpenColumn reads many short snappy columns, with decoders
+// from the pool and with a new decoder for each column.
+func BenchmarkOpenColumn(b *testing.B) {
+
+	const nvar, n = 8, 500
+
+	pa := filepath.Join(b.TempDir(), "data")
+	dataset(b, pa, "snappy", nvar, n)
+
+	read := func(b *testing.B, br *bufio.Reader) {
+		for i := 0; i < n; i++ {
+			if _, err := ReadUint(br, "uvarint"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for k := 0; k < b.N; k++ {
+			br, fid, err := OpenColumn(0, pa, fmt.Sprintf("v%d", k%nvar))
+			if err != nil {
+				b.Fatal(err)
+			}
+			read(b, br)
+			fid.Close()
+		}
+	})
+
+	b.Run("fresh", func(b *testing.B) {
+		b.ReportAllocs()
+		for k := 0; k < b.N; k++ {
+			fid, err := OpenFile(ColumnPath(0, pa, fmt.Sprintf("v%d", k%nvar)))
+			if err != nil {
+				b.Fatal(err)
+			}
+			read(b, bufio.NewReader(snappy.NewReader(fid)))
+			fid.Close()
+		}
+	})
+}
+
+func TestUints(t *testing.T) {
+
+	// Value i of each variable is i/4.
+	const n = 1000
+	dts := []string{"uint8", "uint16", "uint32", "uint64", "uvarint", "delta-uvarint"}
+	defer func() { UseMmap = false }()
+
+	for _, compression := range []string{"snappy", "none"} {
+		pa := filepath.Join(t.TempDir(), compression)
+		if err := MkdirAll(BucketPath(0, pa)); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteConfig(pa, &Config{NumBuckets: 1, Compression: compression}); err != nil {
+			t.Fatal(err)
+		}
+		for _, dt := range dts {
+			w, fid, err := CreateColumn(0, pa, dt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < n; i++ {
+				x, wdt := uint64(i/4), dt
+				if dt == "delta-uvarint" {
+					x, wdt = x-uint64((i-1)/4), "uvarint"
+				}
+				if err := WriteUint(w, wdt, x); err != nil {
+					t.Fatal(err)
+				}
+			}
+			w.Close()
+			fid.Close()
+		}
+
+		for _, mmap := range []bool{false, true} {
+			UseMmap = mmap
+			for _, dt := range dts {
+				for _, bs := range []int{1, 7, 256, n, 4096} {
+					r, err := OpenReader(0, pa, dt, dt)
+					if err != nil {
+						t.Fatal(err)
+					}
+					blk := make([]uint64, bs)
+					var got []uint64
+					for {
+						k, err := r.Uints(blk)
+						if err == io.EOF {
+							break
+						} else if err != nil {
+							t.Fatalf("%s, %s: %v", compression, dt, err)
+						}
+						got = append(got, blk[0:k]...)
+					}
+					r.Close()
+
+					ok := len(got) == n
+					for i := 0; ok && i < n; i++ {
+						ok = got[i] == uint64(i/4)
+					}
+					if !ok {
+						t.Errorf("%s, %s, mmap=%v, blocks of %d: got %d values, not 0, 0, 0, 0, 1, ...",
+							compression, dt, mmap, bs, len(got))
+					}
+				}
+			}
+		}
+	}
+}
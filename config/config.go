@@ -1,110 +1,234 @@
 package config
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"os"
 	"path"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
 )
 
+// Config is the configuration of a data set, stored in conf.json,
+// conf.yaml or conf.toml in the directory of the data set.  The keys
+// are the field names in all three formats.
 type Config struct {
 
+	// The version of the layout of the data set on disk.  Data sets
+	// written before the version was recorded have version 0.
+	FormatVersion int `json:",omitempty" yaml:"FormatVersion,omitempty" toml:",omitempty"`
+
 	// The number of buckets in the data set
-	NumBuckets int
+	NumBuckets int `yaml:"NumBuckets"`
+
+	// The compression type for the raw data, one of the names
+	// registered with RegisterCodec (snappy, gzip, zstd or none)
+	Compression string `yaml:"Compression"`
 
-	// The compression type for the raw data
-	Compression string
+	// The compression of particular variables, by variable name,
+	// overriding Compression
+	ColumnCompression map[string]string `json:",omitempty" yaml:"ColumnCompression,omitempty" toml:",omitempty"`
 
 	// The path where corresponding factor code information is
 	// stored
-	CodesDir string
+	CodesDir string `yaml:"CodesDir"`
+
+	// How records are assigned to buckets, if known
+	Routing *Routing `json:",omitempty" yaml:"Routing,omitempty" toml:",omitempty"`
+
+	// Arbitrary information about the data set, e.g. the source
+	// system, the extraction date or the cohort definition.  The
+	// attributes are kept by the commands that copy a data set.
+	Attrs map[string]string `json:",omitempty" yaml:"Attrs,omitempty" toml:",omitempty"`
+}
+
+// Routing describes how records are assigned to buckets based on the
+// value of an id variable, so that new records can be placed in the
+// same bucket as existing records with the same id.
+type Routing struct {
+
+	// The variable whose value determines the bucket
+	IdVar string `yaml:"IdVar"`
+
+	// The routing method, one of "modulo" (id mod NumBuckets),
+	// "hash" (a hash of the id mod NumBuckets) or "range"
+	Method string `yaml:"Method"`
+
+	// For range routing, Bounds[k] is the smallest id placed in
+	// bucket k.  The bounds are non-decreasing.
+	Bounds []uint64 `json:",omitempty" yaml:"Bounds,omitempty" toml:",omitempty"`
 }
 
 var (
 	// Size in bytes of each data type.
-	DTsize = map[string]int{"uint8": 1, "uint16": 2, "uint32": 4, "uint64": 8, "float32": 4, "float64": 8}
+	DTsize = map[string]int{"uint8": 1, "uint16": 2, "uint32": 4, "uint64": 8, "float32": 4, "float64": 8, "date32": 4, "timestamp64": 8}
 )
 
-// GetConfig reads a configuration file from the given path and returns it.
-func GetConfig(pa string) *Config {
+var (
+	// The names of the configuration file in the supported formats,
+	// in the order in which they are looked for.
+	configFiles = []string{"conf.json", "conf.yaml", "conf.yml", "conf.toml"}
+)
 
-	fid, err := os.Open(path.Join(pa, "conf.json"))
+// ConfigPath returns the path of the configuration file of the data set
+// in the given directory.  If the directory has no configuration file,
+// the path of conf.json is returned.
+func ConfigPath(pa string) string {
+
+	for _, fn := range configFiles {
+		fn = Join(pa, fn)
+		if _, err := StatFile(fn); err == nil {
+			return fn
+		}
+	}
+
+	return Join(pa, configFiles[0])
+}
+
+// GetConfig reads a configuration file from the given path and returns
+// it.  The format of the file, JSON, YAML or TOML, is determined by its
+// extension.
+func GetConfig(pa string) (*Config, error) {
+
+	fn := ConfigPath(pa)
+	b, err := ReadFile(fn)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	defer fid.Close()
 
-	dec := json.NewDecoder(fid)
 	conf := new(Config)
-	err = dec.Decode(conf)
+	switch path.Ext(fn) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, conf)
+	case ".toml":
+		_, err = toml.Decode(string(b), conf)
+	default:
+		err = json.Unmarshal(b, conf)
+	}
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("cannot read configuration in %s: %v", pa, err)
+	}
+	// The codes directory recorded in a data set that was copied to
+	// remote storage names a local directory, so the Codes directory
+	// of the data set is used instead.
+	if IsRemote(pa) && !IsRemote(conf.CodesDir) {
+		conf.CodesDir = Join(pa, "Codes")
+	}
+	if conf.FormatVersion > FormatVersion {
+		return nil, fmt.Errorf("%s has format version %d, but only versions up to %d are supported",
+			pa, conf.FormatVersion, FormatVersion)
 	}
-	return conf
+	return conf, nil
 }
 
-// WriteConfig writes the given configuration file to the provided path.
-func WriteConfig(pa string, conf *Config) {
+// WriteConfig writes the given configuration file to the provided
+// path.  A configuration without a format version is given the current
+// version, since the data set is being written with the current
+// layout.  The configuration is written in the format of the existing
+// configuration file, or as conf.json if there is none.  Since JSON is
+// also YAML, a JSON configuration can be converted to YAML by renaming
+// conf.json to conf.yaml; later writes then use YAML.
+func WriteConfig(pa string, conf *Config) error {
 
-	fid, err := os.Create(path.Join(pa, "conf.json"))
-	if err != nil {
-		panic(err)
+	if conf.FormatVersion == 0 {
+		conf.FormatVersion = FormatVersion
 	}
-	defer fid.Close()
 
-	enc := json.NewEncoder(fid)
-	err = enc.Encode(conf)
+	// The compression may have changed.
+	dircodecs.Delete(Join(pa))
+
+	fn := ConfigPath(pa)
+	var buf bytes.Buffer
+	var err error
+	switch path.Ext(fn) {
+	case ".yaml", ".yml":
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		err = enc.Encode(conf)
+	case ".toml":
+		err = toml.NewEncoder(&buf).Encode(conf)
+	default:
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		err = enc.Encode(conf)
+	}
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("cannot write configuration in %s: %v", pa, err)
 	}
+
+	return WriteFile(fn, buf.Bytes())
 }
 
 // BucketPath returns the path to the given bucket.
 func BucketPath(bucket int, pa string) string {
 	b := fmt.Sprintf("%04d", bucket)
-	return path.Join(pa, "Buckets", b)
+	return Join(pa, "Buckets", b)
 }
 
 // ReadDtypes returns a map describing the column data types map for a
 // given bucket.  The dtypes map associates variable names with their
 // data type (e.g. uint8).
-func ReadDtypes(bucket int, pa string) map[string]string {
+func ReadDtypes(bucket int, pa string) (map[string]string, error) {
 
 	dtypes := make(map[string]string)
 
 	p := BucketPath(bucket, pa)
-	fn := path.Join(p, "dtypes.json")
+	fn := Join(p, "dtypes.json")
 
-	fid, err := os.Open(fn)
+	fid, err := OpenFile(fn)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer fid.Close()
 	dec := json.NewDecoder(fid)
 	err = dec.Decode(&dtypes)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("cannot read %s: %v", fn, err)
 	}
 
-	return dtypes
+	return dtypes, nil
+}
+
+// WriteDtypes saves the column data types map for a given bucket.
+func WriteDtypes(bucket int, pa string, dtypes map[string]string) error {
+
+	p := BucketPath(bucket, pa)
+	fn := Join(p, "dtypes.json")
+
+	fid, err := CreateFile(fn)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(fid)
+	err = enc.Encode(dtypes)
+	if err != nil {
+		fid.Close()
+		return err
+	}
+	return fid.Close()
 }
 
 // GetFactorCodes returns a map from strings to integers describing a
 // factor-coded variable.
-func GetFactorCodes(varname string, conf *Config) map[string]int {
+func GetFactorCodes(varname string, conf *Config) (map[string]int, error) {
 
 	// Determine the code group
-	pa := path.Join(conf.CodesDir, "CodeFiles.json")
-	fid, err := os.Open(pa)
+	pa := Join(conf.CodesDir, "CodeFiles.json")
+	fid, err := OpenFile(pa)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer fid.Close()
 	dec := json.NewDecoder(fid)
 	cf := make(map[string]string)
 	err = dec.Decode(&cf)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("cannot read %s: %v", pa, err)
 	}
 	grp, ok := cf[varname]
 	if !ok {
@@ -113,12 +237,10 @@ func GetFactorCodes(varname string, conf *Config) map[string]int {
 	}
 
 	// Read the codes
-	pa = path.Join(conf.CodesDir, grp+"Codes.json")
-	fid, err = os.Open(pa)
+	pa = Join(conf.CodesDir, grp+"Codes.json")
+	fid, err = OpenFile(pa)
 	if err != nil {
-		msg := fmt.Sprintf("Can't open codes file %s\n", pa)
-		os.Stderr.WriteString(msg)
-		os.Exit(1)
+		return nil, fmt.Errorf("can't open codes file %s", pa)
 	}
 	defer fid.Close()
 
@@ -126,10 +248,10 @@ func GetFactorCodes(varname string, conf *Config) map[string]int {
 	mp := make(map[string]int)
 	err = dec.Decode(&mp)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("cannot read %s: %v", pa, err)
 	}
 
-	return mp
+	return mp, nil
 }
 
 // RevFactorCodes returns the reverse factor coding map, associating
@@ -144,3 +266,114 @@ func RevCodes(codes map[string]int) map[int]string {
 
 	return rcodes
 }
+
+// HasFactorCodes returns true if the given variable (or code group)
+// has a codes file in the codes directory.
+func HasFactorCodes(varname string, conf *Config) bool {
+
+	cf, err := ReadCodeFiles(conf)
+	if err != nil {
+		return false
+	}
+	grp, ok := cf[varname]
+	if !ok {
+		grp = varname
+	}
+
+	_, err = StatFile(Join(conf.CodesDir, grp+"Codes.json"))
+	return err == nil
+}
+
+// ReadCodeFiles returns the map from variable names to code groups.
+// An empty map is returned if the data set has no such map.
+func ReadCodeFiles(conf *Config) (map[string]string, error) {
+
+	cf := make(map[string]string)
+
+	fn := Join(conf.CodesDir, "CodeFiles.json")
+	fid, err := OpenFile(fn)
+	if os.IsNotExist(err) {
+		return cf, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer fid.Close()
+
+	dec := json.NewDecoder(fid)
+	err = dec.Decode(&cf)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %v", fn, err)
+	}
+
+	return cf, nil
+}
+
+// WriteCodeFiles saves the map from variable names to code groups.
+func WriteCodeFiles(conf *Config, cf map[string]string) error {
+
+	fid, err := CreateFile(Join(conf.CodesDir, "CodeFiles.json"))
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(fid)
+	err = enc.Encode(cf)
+	if err != nil {
+		fid.Close()
+		return err
+	}
+	return fid.Close()
+}
+
+// WriteFactorCodes saves the map from labels to integer codes for a
+// code group.
+func WriteFactorCodes(grp string, codes map[string]int, conf *Config) error {
+
+	fid, err := CreateFile(Join(conf.CodesDir, grp+"Codes.json"))
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(fid)
+	err = enc.Encode(codes)
+	if err != nil {
+		fid.Close()
+		return err
+	}
+	return fid.Close()
+}
+
+// HashID returns the hash of an id value used by hash routing.
+func HashID(id uint64) uint64 {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], id)
+	h := fnv.New64a()
+	h.Write(b[:])
+	return h.Sum64()
+}
+
+// Route returns the bucket where a record with the given id belongs,
+// according to the routing information in conf.
+func Route(conf *Config, id uint64) (int, error) {
+
+	r := conf.Routing
+	if r == nil {
+		return 0, fmt.Errorf("data set has no routing information")
+	}
+
+	switch r.Method {
+	case "modulo":
+		return int(id % uint64(conf.NumBuckets)), nil
+	case "hash":
+		return int(HashID(id) % uint64(conf.NumBuckets)), nil
+	case "range":
+		// Find the last bucket whose lower bound is at most id.
+		k := sort.Search(len(r.Bounds), func(i int) bool { return r.Bounds[i] > id })
+		if k == 0 {
+			return 0, nil
+		}
+		return k - 1, nil
+	}
+
+	return 0, fmt.Errorf("unknown routing method %s", r.Method)
+}
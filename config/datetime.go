@@ -0,0 +1,109 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// The values of a date32 column are the number of days since
+// 1970-01-01, stored as little-endian int32 values, and the values of a
+// timestamp64 column are the number of microseconds since
+// 1970-01-01T00:00:00Z, stored as little-endian int64 values.  Both are
+// signed, so that earlier dates can be stored.  The values are
+// rendered as text in the formats below, in UTC.
+
+const (
+	// The format of date32 values as text
+	DateFormat = "2006-01-02"
+
+	// The format of timestamp64 values as text
+	TimestampFormat = "2006-01-02T15:04:05.999999Z07:00"
+)
+
+var (
+	// Additional formats accepted by ParseTimestamp
+	timestampFormats = []string{
+		"2006-01-02 15:04:05.999999",
+		"2006-01-02T15:04:05.999999",
+		DateFormat,
+	}
+)
+
+// IsTime returns true if the data type holds dates or times.
+func IsTime(dtype string) bool {
+	return dtype == "date32" || dtype == "timestamp64"
+}
+
+// FormatDate returns a date32 value, in days since 1970-01-01, as text.
+func FormatDate(days int64) string {
+	return time.Unix(days*86400, 0).UTC().Format(DateFormat)
+}
+
+// ParseDate returns the date32 value, in days since 1970-01-01, of a
+// date given as YYYY-MM-DD.
+func ParseDate(s string) (int64, error) {
+
+	t, err := time.Parse(DateFormat, strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse %q as a date", s)
+	}
+
+	return DateOf(t)
+}
+
+// DateOf returns the date32 value of the day holding a time, in UTC.
+func DateOf(t time.Time) (int64, error) {
+
+	// Round toward the start of the day for times before 1970.
+	s := t.Unix()
+	d := s / 86400
+	if s%86400 < 0 {
+		d--
+	}
+	if d < math.MinInt32 || d > math.MaxInt32 {
+		return 0, fmt.Errorf("date %v does not fit in date32", t)
+	}
+
+	return d, nil
+}
+
+// FormatTimestamp returns a timestamp64 value, in microseconds since
+// 1970-01-01T00:00:00Z, as text.
+func FormatTimestamp(us int64) string {
+	return time.UnixMicro(us).UTC().Format(TimestampFormat)
+}
+
+// ParseTimestamp returns the timestamp64 value, in microseconds since
+// 1970-01-01T00:00:00Z, of a time given in RFC 3339 format.  Times
+// without a time zone, with a space or a T between the date and the
+// time, are taken to be UTC, as are dates without a time.
+func ParseTimestamp(s string) (int64, error) {
+
+	s = strings.TrimSpace(s)
+	t, err := time.Parse(time.RFC3339Nano, s)
+	for _, f := range timestampFormats {
+		if err == nil {
+			break
+		}
+		t, err = time.Parse(f, s)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse %q as a timestamp", s)
+	}
+
+	return TimestampOf(t)
+}
+
+// TimestampOf returns the timestamp64 value of a time.  Fractions of a
+// microsecond are dropped.
+func TimestampOf(t time.Time) (int64, error) {
+
+	// UnixMicro overflows outside of about 290,000 years around 1970.
+	if y := t.Year(); y < -290000 || y > 290000 {
+		return 0, fmt.Errorf("time %v does not fit in timestamp64", t)
+	}
+
+	return t.UnixMicro(), nil
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// IdMap maps string identifiers, such as UUIDs, to the integer ids
+// stored in an id variable, so that the variable can be used for
+// routing, indexing and selection like any unsigned integer id.  The
+// ids are given in order of first appearance, starting at zero.
+//
+// The map of a variable is saved in the codes directory as
+// <variable>IdMap.json, holding a JSON list of the identifiers in which
+// the identifier of id k is at position k.  Unlike factor codes, the
+// map is meant for variables with a distinct value in almost every
+// record, so it is only loaded by the commands that translate ids.
+type IdMap struct {
+	ids    map[string]uint64
+	labels []string
+}
+
+// NewIdMap returns an empty id map.
+func NewIdMap() *IdMap {
+	return &IdMap{ids: make(map[string]uint64), labels: []string{}}
+}
+
+// Lookup returns the id of an identifier, and false if the identifier
+// is not in the map.
+func (m *IdMap) Lookup(s string) (uint64, bool) {
+	id, ok := m.ids[s]
+	return id, ok
+}
+
+// Add returns the id of an identifier, adding identifiers that are not
+// in the map with the next unused id.  The empty string is not a valid
+// identifier.
+func (m *IdMap) Add(s string) (uint64, error) {
+
+	if id, ok := m.ids[s]; ok {
+		return id, nil
+	}
+	if s == "" {
+		return 0, fmt.Errorf("missing identifier")
+	}
+
+	id := uint64(len(m.labels))
+	m.ids[s] = id
+	m.labels = append(m.labels, s)
+
+	return id, nil
+}
+
+// Label returns the identifier of an id, and false if the id is not in
+// the map.
+func (m *IdMap) Label(id uint64) (string, bool) {
+	if id >= uint64(len(m.labels)) {
+		return "", false
+	}
+	return m.labels[id], true
+}
+
+// Len returns the number of identifiers in the map.
+func (m *IdMap) Len() int {
+	return len(m.labels)
+}
+
+// idmappath returns the path of the id map of a variable.
+func idmappath(varname string, conf *Config) string {
+	return Join(conf.CodesDir, varname+"IdMap.json")
+}
+
+// HasIdMap returns true if the given variable has an id map in the
+// codes directory.
+func HasIdMap(varname string, conf *Config) bool {
+	_, err := StatFile(idmappath(varname, conf))
+	return err == nil
+}
+
+// ReadIdMap returns the id map of a variable.
+func ReadIdMap(varname string, conf *Config) (*IdMap, error) {
+
+	pa := idmappath(varname, conf)
+	b, err := ReadFile(pa)
+	if err != nil {
+		return nil, err
+	}
+
+	m := NewIdMap()
+	if err := json.Unmarshal(b, &m.labels); err != nil {
+		return nil, fmt.Errorf("cannot read %s: %v", pa, err)
+	}
+	for k, s := range m.labels {
+		if _, ok := m.ids[s]; ok {
+			return nil, fmt.Errorf("identifier %q appears more than once in %s", s, pa)
+		}
+		m.ids[s] = uint64(k)
+	}
+
+	return m, nil
+}
+
+// WriteIdMap saves the id map of a variable.
+func WriteIdMap(varname string, m *IdMap, conf *Config) error {
+
+	fid, err := CreateFile(idmappath(varname, conf))
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(fid)
+	err = enc.Encode(m.labels)
+	if err != nil {
+		fid.Close()
+		return err
+	}
+	return fid.Close()
+}
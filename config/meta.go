@@ -0,0 +1,397 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Meta summarizes the contents of a bucket, so that the lengths of the
+// columns can be checked and reads can be planned without
+// decompressing the data.  It is stored as meta.json in the bucket
+// directory.
+type Meta struct {
+
+	// The number of rows in the bucket
+	NumRows int
+
+	// The size in bytes of the (compressed) column file of each
+	// variable.  The keys are the names given by BucketFiles, so a
+	// text variable or a variable with a validity bitmap has more
+	// than one entry.
+	Sizes map[string]int64
+
+	// The id variable, if any, whose range is recorded
+	IdVar string `json:",omitempty"`
+
+	// The smallest and largest values of IdVar in the bucket.  They
+	// are zero if the bucket is empty.
+	IdMin, IdMax uint64
+
+	// The checksum of the column file of each variable, see
+	// FileChecksum, with the same keys as Sizes
+	Checksums map[string]string `json:",omitempty"`
+
+	// The ranges of other unsigned integer variables in the bucket,
+	// see AddRanges
+	Ranges map[string]Range `json:",omitempty"`
+}
+
+// Range holds the smallest and largest values of a variable in a
+// bucket, so that a reader looking for particular values can skip the
+// buckets that cannot hold them.  The placeholders of missing values
+// are included.
+type Range struct {
+	Min, Max uint64
+}
+
+// Range returns the range of a variable recorded in the summary, either
+// as the id variable or in Ranges.  The second return value is false if
+// no range is recorded for the variable.
+func (meta *Meta) Range(vname string) (Range, bool) {
+
+	if vname == meta.IdVar {
+		return Range{Min: meta.IdMin, Max: meta.IdMax}, true
+	}
+
+	r, ok := meta.Ranges[vname]
+	return r, ok
+}
+
+var crctable = crc32.MakeTable(crc32.Castagnoli)
+
+// FileChecksum returns the CRC-32C checksum of the contents of a file,
+// in hexadecimal.
+func FileChecksum(fn string) (string, error) {
+
+	fid, err := OpenFile(fn)
+	if err != nil {
+		return "", err
+	}
+	defer fid.Close()
+
+	h := crc32.New(crctable)
+	if _, err := io.Copy(h, fid); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%08x", h.Sum32()), nil
+}
+
+// metapath returns the path of the meta.json file of a bucket.
+func metapath(bucket int, pa string) string {
+	return Join(BucketPath(bucket, pa), "meta.json")
+}
+
+// ReadMeta returns the summary saved for a bucket.
+func ReadMeta(bucket int, pa string) (*Meta, error) {
+
+	b, err := ReadFile(metapath(bucket, pa))
+	if err != nil {
+		return nil, err
+	}
+
+	meta := new(Meta)
+	err = json.Unmarshal(b, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	return meta, nil
+}
+
+// WriteMeta saves the summary of a bucket.
+func WriteMeta(bucket int, pa string, meta *Meta) error {
+
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	return WriteFile(metapath(bucket, pa), b)
+}
+
+// RowMeta summarizes a bucket whose number of rows is known, recording
+// the sizes and checksums of the column files without decoding them.
+func RowMeta(bucket int, pa string, nrows int) (*Meta, error) {
+
+	dtypes, err := ReadDtypes(bucket, pa)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &Meta{NumRows: nrows, Sizes: make(map[string]int64), Checksums: make(map[string]string)}
+
+	for vn, dt := range dtypes {
+		for _, cf := range BucketFiles(bucket, pa, vn, dt) {
+			fn := ColumnPath(bucket, pa, cf)
+			fi, err := StatFile(fn)
+			if err != nil {
+				return nil, err
+			}
+			meta.Sizes[cf] = fi.Size()
+			meta.Checksums[cf], err = FileChecksum(fn)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return meta, nil
+}
+
+// RecordRows saves the summary of a bucket holding nrows rows.  It is
+// used by programs that write a bucket, and so know its number of
+// rows.
+func RecordRows(bucket int, pa string, nrows int) error {
+
+	meta, err := RowMeta(bucket, pa, nrows)
+	if err != nil {
+		return err
+	}
+
+	return WriteMeta(bucket, pa, meta)
+}
+
+// ComputeMeta summarizes a bucket, including the checksums of the
+// column files.  If idvar is not empty, its column is read to obtain
+// the number of rows and the range of the ids; otherwise the rows of
+// the first variable (in sorted order) are counted.  The ranges
+// recorded in an existing summary of the bucket are recomputed.
+func ComputeMeta(bucket int, pa, idvar string) (*Meta, error) {
+
+	dtypes, err := ReadDtypes(bucket, pa)
+	if err != nil {
+		return nil, err
+	}
+
+	var ranged []string
+	if old, err := ReadMeta(bucket, pa); err == nil {
+		ranged, err = rangevars(bucket, pa, old)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	meta, err := RowMeta(bucket, pa, 0)
+	if err != nil {
+		return nil, err
+	}
+	meta.IdVar = idvar
+	if err := AddRanges(bucket, pa, meta, ranged); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for vn := range dtypes {
+		names = append(names, vn)
+	}
+	sort.Strings(names)
+
+	if idvar == "" {
+		if len(names) > 0 {
+			meta.NumRows, err = CountRows(bucket, pa, names[0], dtypes[names[0]])
+			if err != nil {
+				return nil, err
+			}
+		}
+		return meta, nil
+	}
+
+	rdr, err := OpenReader(bucket, pa, idvar, dtypes[idvar])
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+
+	for {
+		x, err := rdr.Uint()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if meta.NumRows == 0 || x < meta.IdMin {
+			meta.IdMin = x
+		}
+		if meta.NumRows == 0 || x > meta.IdMax {
+			meta.IdMax = x
+		}
+		meta.NumRows++
+	}
+
+	return meta, nil
+}
+
+// isunsigned returns true if the data type holds unsigned integers.
+func isunsigned(dtype string) bool {
+	return strings.HasPrefix(dtype, "uint") || dtype == "uvarint" || dtype == "delta-uvarint"
+}
+
+// rangevars returns the variables whose ranges are recorded in a
+// summary of a bucket, and which still hold unsigned integers, in
+// sorted order.
+func rangevars(bucket int, pa string, meta *Meta) ([]string, error) {
+
+	if len(meta.Ranges) == 0 {
+		return nil, nil
+	}
+
+	dtypes, err := ReadDtypes(bucket, pa)
+	if err != nil {
+		return nil, err
+	}
+
+	var vnames []string
+	for vn := range meta.Ranges {
+		if isunsigned(dtypes[vn]) {
+			vnames = append(vnames, vn)
+		}
+	}
+	sort.Strings(vnames)
+
+	return vnames, nil
+}
+
+// AddRanges reads the columns of some unsigned integer variables in a
+// bucket, and records their ranges in the summary of the bucket,
+// replacing any ranges recorded earlier.  The ranges of empty buckets
+// are zero.
+func AddRanges(bucket int, pa string, meta *Meta, vnames []string) error {
+
+	dtypes, err := ReadDtypes(bucket, pa)
+	if err != nil {
+		return err
+	}
+
+	meta.Ranges = nil
+	for _, vn := range vnames {
+		dt, ok := dtypes[vn]
+		if !ok {
+			return fmt.Errorf("variable %s not found in bucket %d", vn, bucket)
+		}
+		if !isunsigned(dt) {
+			return fmt.Errorf("variable %s has dtype %s, not an unsigned integer type", vn, dt)
+		}
+
+		rdr, err := OpenReader(bucket, pa, vn, dt)
+		if err != nil {
+			return err
+		}
+		var r Range
+		for i := 0; ; i++ {
+			x, err := rdr.Uint()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				rdr.Close()
+				return err
+			}
+			if i == 0 || x < r.Min {
+				r.Min = x
+			}
+			if i == 0 || x > r.Max {
+				r.Max = x
+			}
+		}
+		if err := rdr.Close(); err != nil {
+			return err
+		}
+
+		if meta.Ranges == nil {
+			meta.Ranges = make(map[string]Range)
+		}
+		meta.Ranges[vn] = r
+	}
+
+	return nil
+}
+
+// UpdateMeta refreshes the summary of a bucket after some of its
+// columns have been rewritten or added, without changing the number of
+// rows.  The range of the id variable, if any, and the other recorded
+// ranges are recomputed.  Nothing is done if the bucket has no summary.
+func UpdateMeta(bucket int, pa string) error {
+
+	meta, err := ReadMeta(bucket, pa)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if meta.IdVar != "" {
+		meta, err = ComputeMeta(bucket, pa, meta.IdVar)
+	} else {
+		ranged, err := rangevars(bucket, pa, meta)
+		if err != nil {
+			return err
+		}
+		meta, err = RowMeta(bucket, pa, meta.NumRows)
+		if err == nil {
+			err = AddRanges(bucket, pa, meta, ranged)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return WriteMeta(bucket, pa, meta)
+}
+
+// NumRows returns the number of rows in a bucket, from its summary if
+// there is one, and otherwise by counting the values of the first
+// variable (in sorted order).
+func NumRows(bucket int, pa string) (int, error) {
+
+	if meta, err := ReadMeta(bucket, pa); err == nil {
+		return meta.NumRows, nil
+	}
+
+	dtypes, err := ReadDtypes(bucket, pa)
+	if err != nil {
+		return 0, err
+	}
+
+	var names []string
+	for vn := range dtypes {
+		names = append(names, vn)
+	}
+	if len(names) == 0 {
+		return 0, nil
+	}
+	sort.Strings(names)
+
+	return CountRows(bucket, pa, names[0], dtypes[names[0]])
+}
+
+// RowsHint returns the number of rows recorded in the summary of a
+// bucket, or zero if it has no summary.  Unlike NumRows it never reads
+// a column, so it is suited to sizing buffers before a bucket is read.
+func RowsHint(bucket int, pa string) int {
+	meta, err := ReadMeta(bucket, pa)
+	if err != nil {
+		return 0
+	}
+	return meta.NumRows
+}
+
+// CheckRows confirms that n, the number of values read from a column
+// of a bucket, agrees with the number of rows recorded in the summary
+// of the bucket, if there is one.
+func CheckRows(bucket int, pa string, n int) error {
+
+	meta, err := ReadMeta(bucket, pa)
+	if err != nil {
+		return nil
+	}
+	if n != meta.NumRows {
+		return fmt.Errorf("%d rows were read from bucket %d, but meta.json records %d", n, bucket, meta.NumRows)
+	}
+
+	return nil
+}
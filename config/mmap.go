@@ -0,0 +1,145 @@
+package config
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// UseMmap makes OpenReader memory-map the column files of fixed-width
+// variables (see DTsize) that are stored without compression (the
+// "none" codec) on the local file system.  The values are then decoded
+// directly from the mapped pages, rather than being copied through a
+// read buffer.  It is off by default, and commands turn it on with
+// -mmap.  Columns that cannot be mapped, including all columns on
+// systems without mmap, are read as usual.
+var UseMmap bool
+
+// mapping unmaps a column file when the reader is closed.
+type mapping struct {
+	data []byte
+}
+
+func (m *mapping) Close() error {
+	if m.data == nil {
+		return nil
+	}
+	err := munmap(m.data)
+	m.data = nil
+	return err
+}
+
+// openmapped returns a reader decoding the values of a variable from
+// its memory-mapped column file, or nil if the column cannot be
+// mapped.
+func openmapped(bucket int, pa, vname, dtype string) *ColumnReader {
+
+	if _, ok := DTsize[dtype]; !ok || IsRemote(pa) {
+		return nil
+	}
+	if c, err := ColumnCodec(pa, vname); err != nil || c.Name != "none" {
+		return nil
+	}
+
+	data, err := mmap(ColumnPath(bucket, pa, vname))
+	if err != nil {
+		return nil
+	}
+	if CountRead != nil {
+		CountRead(bucket, len(data))
+	}
+
+	return &ColumnReader{mm: data, fid: &mapping{data: data}, dtype: dtype}
+}
+
+// mapped returns the next value of a memory-mapped column as its
+// little-endian bits.
+func (r *ColumnReader) mapped() (uint64, error) {
+
+	w := DTsize[r.dtype]
+	if r.pos+w > len(r.mm) {
+		if r.pos == len(r.mm) {
+			return 0, io.EOF
+		}
+		r.pos = len(r.mm)
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	b := r.mm[r.pos : r.pos+w]
+	r.pos += w
+
+	switch w {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.LittleEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.LittleEndian.Uint32(b)), nil
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+// mappedUint reads the next value of a memory-mapped column with an
+// unsigned integer data type.
+func (r *ColumnReader) mappedUint() (uint64, error) {
+	switch r.dtype {
+	case "uint8", "uint16", "uint32", "uint64":
+		return r.mapped()
+	}
+	return 0, fmt.Errorf("dtype %s is not an unsigned integer type", r.dtype)
+}
+
+// mappedInt reads the next value of a memory-mapped date32 or
+// timestamp64 column.
+func (r *ColumnReader) mappedInt() (int64, error) {
+	x, err := r.mapped()
+	if r.dtype == "date32" {
+		return int64(int32(x)), err
+	}
+	return int64(x), err
+}
+
+// mappedFloat reads the next value of a memory-mapped column as a
+// float64.
+func (r *ColumnReader) mappedFloat() (float64, error) {
+
+	switch r.dtype {
+	case "float32":
+		x, err := r.mapped()
+		return float64(math.Float32frombits(uint32(x))), err
+	case "float64":
+		x, err := r.mapped()
+		return math.Float64frombits(x), err
+	case "date32", "timestamp64":
+		x, err := r.mappedInt()
+		return float64(x), err
+	}
+
+	x, err := r.mappedUint()
+	return float64(x), err
+}
+
+// mappedText reads the next value of a memory-mapped column formatted
+// as by ReadText.
+func (r *ColumnReader) mappedText() (string, error) {
+
+	switch r.dtype {
+	case "date32":
+		x, err := r.mappedInt()
+		return FormatDate(x), err
+	case "timestamp64":
+		x, err := r.mappedInt()
+		return FormatTimestamp(x), err
+	case "float32":
+		x, err := r.mappedFloat()
+		return strconv.FormatFloat(x, 'g', -1, 32), err
+	case "float64":
+		x, err := r.mappedFloat()
+		return strconv.FormatFloat(x, 'g', -1, 64), err
+	}
+
+	x, err := r.mappedUint()
+	return strconv.FormatUint(x, 10), err
+}
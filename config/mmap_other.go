@@ -0,0 +1,14 @@
+//go:build !unix
+
+package config
+
+import "errors"
+
+// mmap is not supported on this system, so columns are read as usual.
+func mmap(name string) ([]byte, error) {
+	return nil, errors.New("memory mapping is not supported")
+}
+
+func munmap(data []byte) error {
+	return nil
+}
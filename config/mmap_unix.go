@@ -0,0 +1,37 @@
+//go:build unix
+
+package config
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmap maps a local file into memory, read-only.
+func mmap(name string) ([]byte, error) {
+
+	fid, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer fid.Close()
+
+	fi, err := fid.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() == 0 {
+		// Empty files cannot be mapped.
+		return []byte{}, nil
+	}
+
+	return syscall.Mmap(int(fid.Fd()), 0, int(fi.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// munmap unmaps a file mapped by mmap.
+func munmap(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return syscall.Munmap(data)
+}
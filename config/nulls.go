@@ -0,0 +1,152 @@
+package config
+
+import (
+	"io"
+	"os"
+)
+
+// NullSuffix is appended to the name of a variable to obtain the name,
+// as used by ColumnPath, of the file holding its validity bitmap.  The
+// bitmap holds one bool value per row, packed as by BoolWriter, which
+// is true if the row has a value and false if the value is missing.
+// The column file holds a placeholder in the rows with missing values:
+// zero, NaN for floats or an empty string for text.  The bitmap is
+// optional, and a bucket without it has no missing values of the
+// variable.
+const NullSuffix = ".null"
+
+// HasNulls returns true if a variable has a validity bitmap in a
+// bucket.
+func HasNulls(bucket int, pa, vname string) bool {
+	_, err := StatFile(ColumnPath(bucket, pa, vname+NullSuffix))
+	return err == nil
+}
+
+// BucketFiles returns the names, as used by ColumnPath, of the files
+// holding a variable in a bucket: the files given by ColumnFiles,
+// followed by the validity bitmap if the variable has one in the
+// bucket.
+func BucketFiles(bucket int, pa, vname, dtype string) []string {
+
+	files := ColumnFiles(vname, dtype)
+	if HasNulls(bucket, pa, vname) {
+		files = append(files, vname+NullSuffix)
+	}
+
+	return files
+}
+
+// NullReader reads the validity bitmap of a variable in a bucket, in
+// step with the values of the variable.
+type NullReader struct {
+	r *ColumnReader
+}
+
+// OpenNulls returns a reader for the validity bitmap of a variable in
+// a bucket.  If the variable has no bitmap in the bucket, the reader
+// reports every value as present.
+func OpenNulls(bucket int, pa, vname string) (*NullReader, error) {
+
+	r, err := OpenReader(bucket, pa, vname+NullSuffix, "bool")
+	if os.IsNotExist(err) {
+		return &NullReader{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &NullReader{r: r}, nil
+}
+
+// HasNulls returns true if the variable has a validity bitmap, so that
+// some of its values may be missing.
+func (n *NullReader) HasNulls() bool {
+	return n.r != nil
+}
+
+// Valid reads whether the next row has a value.  io.EOF is returned
+// when the bitmap is exhausted, but never if the variable has no
+// bitmap.
+func (n *NullReader) Valid() (bool, error) {
+
+	if n.r == nil {
+		return true, nil
+	}
+
+	return n.r.Bool()
+}
+
+// Close closes the bitmap file, if there is one.
+func (n *NullReader) Close() error {
+
+	if n.r == nil {
+		return nil
+	}
+
+	return n.r.Close()
+}
+
+// NullWriter writes the validity bitmap of a variable in a bucket.
+// The bitmap is only created when the first missing value is written,
+// so that a variable with no missing values has no bitmap.
+type NullWriter struct {
+	bucket    int
+	pa, vname string
+
+	// The number of rows with values that precede the first missing
+	// value, before the bitmap is created
+	nvalid int
+
+	bw  *BoolWriter
+	fid io.Closer
+}
+
+// CreateNulls returns a writer for the validity bitmap of a variable
+// in a bucket.
+func CreateNulls(bucket int, pa, vname string) *NullWriter {
+	return &NullWriter{bucket: bucket, pa: pa, vname: vname}
+}
+
+// Put records whether the next row has a value.
+func (n *NullWriter) Put(valid bool) error {
+
+	if n.bw == nil {
+		if valid {
+			n.nvalid++
+			return nil
+		}
+
+		w, fid, err := CreateColumn(n.bucket, n.pa, n.vname+NullSuffix)
+		if err != nil {
+			return err
+		}
+		n.bw, n.fid = NewBoolWriter(w), fid
+		for ; n.nvalid > 0; n.nvalid-- {
+			if err := n.bw.Put(true); err != nil {
+				return err
+			}
+		}
+	}
+
+	return n.bw.Put(valid)
+}
+
+// Close finishes the bitmap.  If no missing value was written, an
+// existing bitmap of the variable in the bucket is removed.
+func (n *NullWriter) Close() error {
+
+	if n.bw == nil {
+		err := RemoveFile(ColumnPath(n.bucket, n.pa, n.vname+NullSuffix))
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return err
+	}
+
+	err := n.bw.Close()
+	if err2 := n.fid.Close(); err == nil {
+		err = err2
+	}
+	n.bw = nil
+
+	return err
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+)
+
+// SortIndexSuffix is appended to the name of a variable to obtain the
+// name of the file, in the bucket directory, holding a sparse index of
+// its values in a bucket sorted by the variable.
+const SortIndexSuffix = ".index.json"
+
+// SortIndex is a sparse index of an unsigned integer variable in a
+// bucket whose rows are sorted by the variable.  It records the value
+// of every Step-th row, so that the rows holding a range of values can
+// be found without reading the whole column.  Like Bloom, the index
+// records the size and modification time of the column file it was
+// built from, and is only used while they are unchanged.
+type SortIndex struct {
+
+	// The number of rows between consecutive entries
+	Step int
+
+	// The values in rows 0, Step, 2*Step, ...
+	Values []uint64
+
+	// The number of rows in the bucket
+	NumRows int
+
+	// The size of the column file when the index was built
+	Size int64
+
+	// The modification time of the column file when the index was
+	// built
+	ModTime time.Time
+}
+
+// Rows returns the rows, from start up to but not including end, that
+// may hold values between lo and hi inclusive.  No other row holds such
+// a value.
+func (ix *SortIndex) Rows(lo, hi uint64) (int, int) {
+
+	// The first entry holding lo or more.  The rows holding lo may
+	// start in the preceding block.
+	j := sort.Search(len(ix.Values), func(i int) bool { return ix.Values[i] >= lo })
+	start := 0
+	if j > 0 {
+		start = (j - 1) * ix.Step
+	}
+
+	// The first entry holding more than hi, where every later row
+	// also holds more than hi.
+	k := sort.Search(len(ix.Values), func(i int) bool { return ix.Values[i] > hi })
+	end := ix.NumRows
+	if k < len(ix.Values) {
+		end = k * ix.Step
+	}
+
+	if end < start {
+		end = start
+	}
+	return start, end
+}
+
+// SortIndexPath returns the path of the file holding the sparse index
+// of a variable in a bucket.
+func SortIndexPath(bucket int, pa, vname string) string {
+	return Join(BucketPath(bucket, pa), vname+SortIndexSuffix)
+}
+
+// WriteSortIndex saves the sparse index of a variable in a bucket,
+// stamped with the current size and modification time of the column
+// file.
+func WriteSortIndex(bucket int, pa, vname string, ix *SortIndex) error {
+
+	var err error
+	ix.Size, ix.ModTime, err = stamp(bucket, pa, vname)
+	if err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(ix)
+	if err != nil {
+		return err
+	}
+
+	return WriteFile(SortIndexPath(bucket, pa, vname), buf)
+}
+
+// ReadSortIndex returns the sparse index of a variable in a bucket.  If
+// there is no index, or the column file has changed since the index
+// was built, nil is returned with no error.
+func ReadSortIndex(bucket int, pa, vname string) (*SortIndex, error) {
+
+	buf, err := ReadFile(SortIndexPath(bucket, pa, vname))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	ix := new(SortIndex)
+	if err := json.Unmarshal(buf, ix); err != nil {
+		return nil, err
+	}
+
+	ok, err := fresh(bucket, pa, vname, ix.Size, ix.ModTime)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || ix.Step < 1 {
+		return nil, nil
+	}
+
+	return ix, nil
+}
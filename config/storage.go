@@ -0,0 +1,318 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Storage gives access to the files of data sets kept somewhere other
+// than the local file system, such as an object store.  The names
+// passed to its methods are full URLs, such as
+// s3://bucket/prefix/Buckets/0000/dtypes.json.  Missing files are
+// reported with errors for which os.IsNotExist is true.
+type Storage interface {
+
+	// Open returns a reader streaming the contents of a file.
+	Open(name string) (io.ReadCloser, error)
+
+	// Create returns a writer for a new file, replacing any file with
+	// the same name.  The file is complete once the writer is
+	// closed without error.
+	Create(name string) (io.WriteCloser, error)
+
+	// Stat returns the size and modification time of a file.
+	Stat(name string) (os.FileInfo, error)
+
+	// Remove deletes a file.
+	Remove(name string) error
+
+	// Rename moves a file, replacing any file with the new name.
+	Rename(oldname, newname string) error
+
+	// ReadDir returns the names of the files in a directory.
+	ReadDir(name string) ([]string, error)
+}
+
+var (
+	storages   = make(map[string]Storage)
+	storagemut sync.RWMutex
+)
+
+// RegisterStorage makes a storage available for the paths starting
+// with scheme://.
+func RegisterStorage(scheme string, s Storage) {
+	storagemut.Lock()
+	storages[scheme] = s
+	storagemut.Unlock()
+}
+
+// scheme returns the URL scheme of a path, or the empty string for a
+// local path.
+func scheme(name string) string {
+	if i := strings.Index(name, "://"); i > 0 {
+		return name[0:i]
+	}
+	return ""
+}
+
+// IsRemote returns true if a path is a URL, naming a file or directory
+// that is accessed through a registered Storage.
+func IsRemote(name string) bool {
+	return scheme(name) != ""
+}
+
+// getstorage returns the storage holding a file, or nil for a local
+// file.
+func getstorage(name string) (Storage, error) {
+
+	sc := scheme(name)
+	if sc == "" {
+		return nil, nil
+	}
+
+	storagemut.RLock()
+	s, ok := storages[sc]
+	storagemut.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no storage is registered for %s://, as needed for %s", sc, name)
+	}
+
+	return s, nil
+}
+
+// Join joins path elements like path.Join, but keeps the scheme of a
+// URL in the first element intact.
+func Join(elem ...string) string {
+
+	if len(elem) == 0 || !IsRemote(elem[0]) {
+		return path.Join(elem...)
+	}
+
+	i := strings.Index(elem[0], "://") + 3
+	rest := append([]string{elem[0][i:]}, elem[1:]...)
+	return elem[0][0:i] + path.Join(rest...)
+}
+
+// OpenFile opens a file for reading, locally or through a Storage.
+func OpenFile(name string) (io.ReadCloser, error) {
+
+	s, err := getstorage(name)
+	if err != nil {
+		return nil, err
+	} else if s == nil {
+		return os.Open(name)
+	}
+
+	return s.Open(name)
+}
+
+// CreateFile creates a file, locally or through a Storage.
+func CreateFile(name string) (io.WriteCloser, error) {
+
+	s, err := getstorage(name)
+	if err != nil {
+		return nil, err
+	} else if s == nil {
+		return os.Create(name)
+	}
+
+	return s.Create(name)
+}
+
+// AppendFile opens a file for appending, creating it if it does not
+// exist.  Only local files can be appended to.
+func AppendFile(name string) (io.WriteCloser, error) {
+
+	if IsRemote(name) {
+		return nil, fmt.Errorf("cannot append to %s, only local files can be appended to", name)
+	}
+
+	return os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+}
+
+// StatFile returns information about a file, locally or through a
+// Storage.
+func StatFile(name string) (os.FileInfo, error) {
+
+	s, err := getstorage(name)
+	if err != nil {
+		return nil, err
+	} else if s == nil {
+		return os.Stat(name)
+	}
+
+	return s.Stat(name)
+}
+
+// RemoveFile deletes a file, locally or through a Storage.
+func RemoveFile(name string) error {
+
+	s, err := getstorage(name)
+	if err != nil {
+		return err
+	} else if s == nil {
+		return os.Remove(name)
+	}
+
+	return s.Remove(name)
+}
+
+// RenameFile moves a file, locally or within a Storage.
+func RenameFile(oldname, newname string) error {
+
+	s, err := getstorage(oldname)
+	if err != nil {
+		return err
+	} else if s == nil {
+		return os.Rename(oldname, newname)
+	}
+	if scheme(newname) != scheme(oldname) {
+		return fmt.Errorf("cannot move %s to %s", oldname, newname)
+	}
+
+	return s.Rename(oldname, newname)
+}
+
+// ReadDir returns the names of the files in a directory, locally or
+// through a Storage.
+func ReadDir(name string) ([]string, error) {
+
+	s, err := getstorage(name)
+	if err != nil {
+		return nil, err
+	} else if s != nil {
+		return s.ReadDir(name)
+	}
+
+	fl, err := ioutil.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, fi := range fl {
+		names = append(names, fi.Name())
+	}
+
+	return names, nil
+}
+
+// SyncFile commits the contents of a local file or directory to
+// stable storage.  Objects in a Storage are durable once written, so
+// nothing is done for a URL.
+func SyncFile(name string) error {
+
+	if IsRemote(name) {
+		return nil
+	}
+
+	fid, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer fid.Close()
+
+	return fid.Sync()
+}
+
+// MkdirAll creates a local directory and its parents.  Object stores
+// have no directories, so nothing is done for a URL.
+func MkdirAll(name string) error {
+
+	if IsRemote(name) {
+		return nil
+	}
+
+	return os.MkdirAll(name, 0755)
+}
+
+// ReadFile returns the contents of a file, locally or through a
+// Storage.
+func ReadFile(name string) ([]byte, error) {
+
+	rdr, err := OpenFile(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+
+	return ioutil.ReadAll(rdr)
+}
+
+// WriteFile saves data to a file, locally or through a Storage.
+func WriteFile(name string, data []byte) error {
+
+	if !IsRemote(name) {
+		return ioutil.WriteFile(name, data, 0644)
+	}
+
+	wtr, err := CreateFile(name)
+	if err != nil {
+		return err
+	}
+	if _, err := wtr.Write(data); err != nil {
+		wtr.Close()
+		return err
+	}
+
+	return wtr.Close()
+}
+
+// CheckTarget returns an error if a data set would be written to
+// targetdir while reading from sourcedir, and the two directories are
+// the same or one of them contains the other, so that writing the
+// target could overwrite the source.  Local paths are compared after
+// resolving symbolic links.
+func CheckTarget(sourcedir, targetdir string) error {
+
+	s, t := Join(sourcedir), Join(targetdir)
+	if !IsRemote(s) && !IsRemote(t) {
+		s, t = realpath(s), realpath(t)
+	}
+
+	switch {
+	case s == t:
+		return fmt.Errorf("cannot have targetdir equal to sourcedir (%s)", sourcedir)
+	case within(t, s):
+		return fmt.Errorf("cannot have targetdir %s inside sourcedir %s", targetdir, sourcedir)
+	case within(s, t):
+		return fmt.Errorf("cannot have sourcedir %s inside targetdir %s", sourcedir, targetdir)
+	}
+
+	return nil
+}
+
+// realpath returns the absolute form of a local path with the symbolic
+// links resolved, for the part of the path that exists.
+func realpath(name string) string {
+
+	name, err := filepath.Abs(name)
+	if err != nil {
+		return name
+	}
+
+	var rest []string
+	for {
+		if r, err := filepath.EvalSymlinks(name); err == nil {
+			return filepath.Join(append([]string{r}, rest...)...)
+		}
+		dir, base := filepath.Split(name)
+		dir = filepath.Clean(dir)
+		if dir == name {
+			return filepath.Join(append([]string{name}, rest...)...)
+		}
+		rest = append([]string{base}, rest...)
+		name = dir
+	}
+}
+
+// within returns true if the path a is strictly inside the directory
+// b.
+func within(a, b string) bool {
+	return strings.HasPrefix(a, strings.TrimSuffix(b, "/")+"/")
+}
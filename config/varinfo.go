@@ -0,0 +1,99 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// VarInfo describes a variable for the people using the data.  The
+// descriptions of the variables of a data set are stored in
+// variables.json in the directory of the data set, and are not needed
+// to read the data.
+type VarInfo struct {
+
+	// A short human-readable label
+	Label string `json:",omitempty"`
+
+	// The units of measurement
+	Units string `json:",omitempty"`
+
+	// Notes on the provenance of the variable, e.g. the source
+	// system or how it was derived
+	Notes string `json:",omitempty"`
+}
+
+// varinfopath returns the path of the variables.json file of a data
+// set.
+func varinfopath(pa string) string {
+	return Join(pa, "variables.json")
+}
+
+// ReadVarInfo returns the descriptions of the variables of a data set,
+// by variable name.  The map is empty if no variable is described.
+func ReadVarInfo(pa string) (map[string]*VarInfo, error) {
+
+	info := make(map[string]*VarInfo)
+
+	b, err := ReadFile(varinfopath(pa))
+	if os.IsNotExist(err) {
+		return info, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &info)
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// WriteVarInfo saves the descriptions of the variables of a data set.
+// Empty descriptions are dropped, and the file is removed if no
+// variable is described.
+func WriteVarInfo(pa string, info map[string]*VarInfo) error {
+
+	keep := make(map[string]*VarInfo)
+	for vn, vi := range info {
+		if vi != nil && *vi != (VarInfo{}) {
+			keep[vn] = vi
+		}
+	}
+
+	if len(keep) == 0 {
+		err := RemoveFile(varinfopath(pa))
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return err
+	}
+
+	b, err := json.MarshalIndent(keep, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return WriteFile(varinfopath(pa), append(b, '\n'))
+}
+
+// CopyVarInfo copies the descriptions of the variables from the data
+// set in sp to the data set in dp.  If keep is not nil, only the
+// variables for which it returns true are copied.
+func CopyVarInfo(sp, dp string, keep func(vname string) bool) error {
+
+	info, err := ReadVarInfo(sp)
+	if err != nil {
+		return err
+	}
+
+	if keep != nil {
+		for vn := range info {
+			if !keep(vn) {
+				delete(info, vn)
+			}
+		}
+	}
+
+	return WriteVarInfo(dp, info)
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+)
+
+// FormatVersion is the version of the layout of data sets written by
+// this package.  It is increased when the files of a data set change
+// in a way that older programs cannot read, and a Migration from the
+// previous version is registered.
+const FormatVersion = 1
+
+// Migration upgrades a data set from one format version to the next.
+type Migration struct {
+
+	// The version upgraded from
+	From int
+
+	// A short description of the changes
+	Description string
+
+	// Apply changes the files of the data set in the given
+	// directory.  The format version in conf.json is updated by
+	// Migrate after Apply succeeds.
+	Apply func(pa string, conf *Config) error
+}
+
+// migrations holds the migrations in order of their From version.
+var migrations = []*Migration{
+	{
+		From:        0,
+		Description: "record the format version in conf.json",
+		Apply:       func(string, *Config) error { return nil },
+	},
+}
+
+// Migrations returns the migrations needed to bring a data set with
+// the given format version up to FormatVersion.
+func Migrations(version int) []*Migration {
+
+	var m []*Migration
+	for _, mg := range migrations {
+		if mg.From >= version {
+			m = append(m, mg)
+		}
+	}
+
+	return m
+}
+
+// Migrate upgrades the data set in the given directory to
+// FormatVersion, one version at a time, recording the new version in
+// conf.json after each step.  The migrations that were applied are
+// returned.
+func Migrate(pa string) ([]*Migration, error) {
+
+	conf, err := GetConfig(pa)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []*Migration
+	for _, mg := range Migrations(conf.FormatVersion) {
+		if mg.From != conf.FormatVersion {
+			return done, fmt.Errorf("no migration from format version %d", conf.FormatVersion)
+		}
+		if err := mg.Apply(pa, conf); err != nil {
+			return done, fmt.Errorf("migrating from format version %d: %v", mg.From, err)
+		}
+		conf.FormatVersion = mg.From + 1
+		if err := WriteConfig(pa, conf); err != nil {
+			return done, err
+		}
+		done = append(done, mg)
+	}
+
+	return done, nil
+}
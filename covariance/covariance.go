@@ -0,0 +1,178 @@
+// Covariance computes the covariance matrix of a set of numeric
+// variables and writes it to stdout as CSV.  The co-moments are
+// accumulated with a one-pass updating algorithm, which is stable
+// even when the means are large relative to the standard deviations.
+//
+// NaN values are handled by pairwise deletion (each covariance uses
+// all rows where both variables are observed) or listwise deletion
+// (only rows where all variables are observed are used).
+
+package covariance
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The variables to include
+	vars []string
+
+	// Either "pairwise" or "listwise"
+	missing string
+
+	// Configuration information for the data set
+	conf *config.Config
+)
+
+// comoment accumulates the covariance of two variables.
+type comoment struct {
+	n      float64
+	mx, my float64
+	c      float64
+}
+
+func (cm *comoment) update(x, y float64) {
+	cm.n++
+	dx := x - cm.mx
+	cm.mx += dx / cm.n
+	cm.my += (y - cm.my) / cm.n
+	cm.c += dx * (y - cm.my)
+}
+
+func (cm *comoment) cov() float64 {
+	if cm.n < 2 {
+		return math.NaN()
+	}
+	return cm.c / (cm.n - 1)
+}
+
+// dobucket adds the rows of one bucket to the co-moments, where cm[i][j]
+// holds the co-moment for variables i <= j.
+func dobucket(bn int, cm [][]*comoment) error {
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return err
+	}
+
+	rdrs := make([]*config.ColumnReader, len(vars))
+	for j, vn := range vars {
+		if _, ok := dtypes[vn]; !ok {
+			return fmt.Errorf("Variable %s not found in bucket %d", vn, bn)
+		}
+		rdrs[j], err = config.OpenReader(bn, sourcedir, vn, dtypes[vn])
+		if err != nil {
+			return err
+		}
+		defer rdrs[j].Close()
+	}
+
+	x := make([]float64, len(vars))
+	for {
+		var err error
+		for j := range vars {
+			x[j], err = rdrs[j].Float()
+			if err == io.EOF && j == 0 {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+
+		if missing == "listwise" {
+			skip := false
+			for _, v := range x {
+				if math.IsNaN(v) {
+					skip = true
+					break
+				}
+			}
+			if skip {
+				continue
+			}
+		}
+
+		for i := range vars {
+			if math.IsNaN(x[i]) {
+				continue
+			}
+			for j := i; j < len(vars); j++ {
+				if !math.IsNaN(x[j]) {
+					cm[i][j].update(x[i], x[j])
+				}
+			}
+		}
+	}
+}
+
+// Run runs covariance with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("covariance", flag.ContinueOnError)
+	var vlist string
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&vlist, "vars", "", "comma-separated numeric variables")
+	fs.StringVar(&missing, "missing", "pairwise", "NaN handling (pairwise or listwise)")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" || vlist == "" {
+		return cli.Usage("usage:\ncovariance -sourcedir=... -vars=... [-missing=...]\n\n")
+	}
+
+	if missing != "pairwise" && missing != "listwise" {
+		return fmt.Errorf("Unknown missing value handling %s, must be pairwise or listwise", missing)
+	}
+
+	vars = strings.Split(vlist, ",")
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	cm := make([][]*comoment, len(vars))
+	for i := range cm {
+		cm[i] = make([]*comoment, len(vars))
+		for j := i; j < len(vars); j++ {
+			cm[i][j] = new(comoment)
+		}
+	}
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		if err := dobucket(k, cm); err != nil {
+			return err
+		}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	fmt.Fprintf(out, ",%s\n", strings.Join(vars, ","))
+	for i, vn := range vars {
+		fmt.Fprintf(out, "%s", vn)
+		for j := range vars {
+			c := cm[i][j]
+			if j < i {
+				c = cm[j][i]
+			}
+			fmt.Fprintf(out, ",%v", c.cov())
+		}
+		fmt.Fprintf(out, "\n")
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package covariance_test
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kshedden/gocols/coltest"
+	"github.com/kshedden/gocols/covariance"
+)
+
+func TestCovariance(t *testing.T) {
+
+	// z is missing in the second row.
+	dir := coltest.CSV(t, "x,y,z\n1,2,2.0\n2,4,\n3,5,4.0\n4,9,8.0\n")
+
+	for _, tc := range []struct {
+		missing string
+		want    [3][3]float64
+	}{
+		{
+			missing: "pairwise",
+			want: [3][3]float64{
+				{5.0 / 3, 11.0 / 3, 13.0 / 3},
+				{11.0 / 3, 26.0 / 3, 32.0 / 3},
+				{13.0 / 3, 32.0 / 3, 28.0 / 3},
+			},
+		},
+		{
+			missing: "listwise",
+			want: [3][3]float64{
+				{7.0 / 3, 31.0 / 6, 13.0 / 3},
+				{31.0 / 6, 37.0 / 3, 32.0 / 3},
+				{13.0 / 3, 32.0 / 3, 28.0 / 3},
+			},
+		},
+	} {
+		out, err := coltest.Stdout(t, covariance.Run, "-sourcedir="+dir, "-vars=x,y,z", "-missing="+tc.missing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out) != 4 || out[0] != ",x,y,z" {
+			t.Fatalf("%s: got %q", tc.missing, out)
+		}
+		for i, line := range out[1:] {
+			f := strings.Split(line, ",")
+			if len(f) != 4 || f[0] != []string{"x", "y", "z"}[i] {
+				t.Fatalf("%s: got row %q", tc.missing, line)
+			}
+			for j, s := range f[1:] {
+				c, err := strconv.ParseFloat(s, 64)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if math.Abs(c-tc.want[i][j]) > 1e-12 {
+					t.Errorf("%s: covariance %d,%d is %v, expected %v", tc.missing, i, j, c, tc.want[i][j])
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,648 @@
+// Csv2cols converts one or more delimited text files into a
+// columnized data set.  The first line of each file is a header naming
+// the variables, and all files must have the same header.
+//
+// The data types are given by -schema, a comma-separated list of
+// name:dtype pairs as in gen, or are inferred from the first -infer
+// records of the first file: columns holding only non-negative integers
+// are stored as uvarint, other integer columns as varint, other numeric
+// columns as float64, columns holding only true and false (in any of
+// the spellings true, True and TRUE) as bool, columns holding only
+// dates (YYYY-MM-DD) as date32, columns holding only dates and times as
+// timestamp64, and the remaining columns are factor-coded strings.
+// The labels of string columns are coded in order of first appearance
+// and saved in the Codes directory.  Columns given the dtype text in
+// the schema are stored as variable-length strings without factor
+// coding, which suits free text with many distinct values.  Columns
+// given the dtype bool hold values such as true, false, 1 or 0, and
+// are stored one bit per value.  Columns with the dtype date32 hold
+// dates as YYYY-MM-DD, stored as days since 1970-01-01, and columns
+// with the dtype timestamp64 hold times in RFC 3339 format, stored as
+// microseconds since 1970-01-01T00:00:00Z.  Times without a time zone
+// are taken to be UTC.
+//
+// Empty fields and fields equal to -na are missing values in the
+// columns other than string and text columns.  They are stored as zero
+// (NaN in float columns) and marked in the validity bitmap of the
+// variable (see config.NullSuffix), and are ignored when inferring the
+// data types.
+//
+// If -idvar is given, records are placed in buckets by routing on its
+// value (-routing modulo or hash), and the routing is recorded in the
+// configuration.  Otherwise records are assigned to buckets in
+// round-robin order.
+//
+// With -idmap, the values of -idvar are string identifiers such as
+// UUIDs.  Each identifier is given an integer id in order of first
+// appearance, which is stored as a uint64 id variable and used for
+// routing, and the map from identifiers to ids is saved in the Codes
+// directory (see config.IdMap), so that select and append can
+// translate the identifiers.
+
+package csv2cols
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The input files
+	files []string
+
+	// The field delimiter
+	delim rune
+
+	// The names and data types of the variables
+	names, dtypes []string
+
+	// The number of records used to infer the data types
+	ninfer int
+
+	// The number of buckets
+	nbuckets int
+
+	// The variable used to route records to buckets, if any
+	idvar string
+
+	// The routing method, modulo or hash
+	routing string
+
+	// If true, the values of idvar are string identifiers that are
+	// mapped to integer ids
+	useidmap bool
+
+	// The map from the identifiers of idvar to ids, if useidmap
+	idm *config.IdMap
+
+	// The directory where the data set will be written
+	targetdir string
+
+	// The compression of the column files
+	compression string
+
+	// If true, overwrite existing files
+	replace bool
+
+	// Configuration information for the data set
+	conf *config.Config
+
+	// The codes of the string variables, indexed like names
+	codes []map[string]int
+
+	// The writers for each bucket and variable
+	wtrs [][]io.WriteCloser
+	fids [][]io.Closer
+
+	// The writers for the bytes of the text variables, nil for the
+	// other variables
+	twtrs [][]io.WriteCloser
+	tfids [][]io.Closer
+
+	// The writers for the validity bitmaps, nil for the string and
+	// text variables
+	nwtrs [][]*config.NullWriter
+
+	// The value, besides an empty field, denoting a missing value
+	na string
+
+	// The number of records written to each bucket
+	nrows []int
+)
+
+// isna returns true if a field holds a missing value.
+func isna(x string) bool {
+	return x == "" || x == na
+}
+
+// nullable returns true if the values of a variable with the given
+// data type can be missing.
+func nullable(dt string) bool {
+	return dt != "string" && dt != "text"
+}
+
+// newreader returns a CSV reader for the given file.
+func newreader(fid io.Reader) *csv.Reader {
+	rdr := csv.NewReader(bufio.NewReader(fid))
+	rdr.Comma = delim
+	rdr.ReuseRecord = true
+	return rdr
+}
+
+// parseschema reads the variable names and data types from the
+// -schema flag, and confirms that they match the header.
+func parseschema(schema string, header []string) error {
+
+	dtm := make(map[string]string)
+	for _, f := range strings.Split(schema, ",") {
+		v := strings.Split(f, ":")
+		if len(v) != 2 {
+			return fmt.Errorf("invalid schema entry %s, expected name:dtype", f)
+		}
+		_, ok := config.DTsize[v[1]]
+		switch v[1] {
+		case "uvarint", "varint", "string", "text", "bool":
+			ok = true
+		}
+		if !ok {
+			return fmt.Errorf("unsupported dtype %s for variable %s", v[1], v[0])
+		}
+		dtm[v[0]] = v[1]
+	}
+
+	for _, vn := range header {
+		dt, ok := dtm[vn]
+		if !ok {
+			return fmt.Errorf("variable %s is not in the schema", vn)
+		}
+		dtypes = append(dtypes, dt)
+	}
+
+	return nil
+}
+
+// infer guesses the data types from the first records of a file.
+func infer(fname string) error {
+
+	fid, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer fid.Close()
+
+	rdr := newreader(fid)
+	if _, err := rdr.Read(); err != nil {
+		return err
+	}
+
+	// The narrowest type seen so far in each column: 0 for
+	// non-negative integers, 1 for integers, 2 for floats and 3
+	// for strings.
+	kind := make([]int, len(names))
+
+	// Whether each column could hold dates or times: 0 for dates, 1
+	// for times and 2 for neither.
+	tkind := make([]int, len(names))
+
+	// Whether each column has held only true and false so far.
+	bkind := make([]bool, len(names))
+	for j := range bkind {
+		bkind[j] = true
+	}
+	for i := 0; i < ninfer; i++ {
+		rec, err := rdr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		for j, x := range rec {
+			if isna(x) {
+				continue
+			}
+			k := 3
+			if _, err := strconv.ParseUint(x, 10, 64); err == nil {
+				k = 0
+			} else if _, err := strconv.ParseInt(x, 10, 64); err == nil {
+				k = 1
+			} else if _, err := strconv.ParseFloat(x, 64); err == nil {
+				k = 2
+			}
+			if k > kind[j] {
+				kind[j] = k
+			}
+			t := 2
+			if _, err := config.ParseDate(x); err == nil {
+				t = 0
+			} else if _, err := config.ParseTimestamp(x); err == nil {
+				t = 1
+			}
+			if t > tkind[j] {
+				tkind[j] = t
+			}
+			if _, err := strconv.ParseBool(x); err != nil || len(x) < 4 {
+				// The digits 0 and 1 and the letters t and f
+				// are not taken to be booleans.
+				bkind[j] = false
+			}
+		}
+	}
+
+	for j, k := range kind {
+		if k == 3 && bkind[j] {
+			dtypes = append(dtypes, "bool")
+			continue
+		}
+		if k == 3 && tkind[j] < 2 {
+			dtypes = append(dtypes, []string{"date32", "timestamp64"}[tkind[j]])
+			continue
+		}
+		dtypes = append(dtypes, []string{"uvarint", "varint", "float64", "string"}[k])
+	}
+
+	return nil
+}
+
+// storedtype returns the data type used to store a variable.
+func storedtype(dt string) string {
+	if dt == "string" {
+		return "uint32"
+	}
+	return dt
+}
+
+// setup creates the directories and opens the column files.
+func setup() error {
+
+	wtrs = make([][]io.WriteCloser, nbuckets)
+	fids = make([][]io.Closer, nbuckets)
+	twtrs = make([][]io.WriteCloser, nbuckets)
+	tfids = make([][]io.Closer, nbuckets)
+	nwtrs = make([][]*config.NullWriter, nbuckets)
+	nrows = make([]int, nbuckets)
+
+	dtm := make(map[string]string)
+	for j, vn := range names {
+		dtm[vn] = storedtype(dtypes[j])
+	}
+
+	for k := 0; k < nbuckets; k++ {
+		err := os.MkdirAll(config.BucketPath(k, targetdir), 0755)
+		if err != nil {
+			return err
+		}
+		err = config.WriteDtypes(k, targetdir, dtm)
+		if err != nil {
+			return err
+		}
+		twtrs[k] = make([]io.WriteCloser, len(names))
+		tfids[k] = make([]io.Closer, len(names))
+		nwtrs[k] = make([]*config.NullWriter, len(names))
+		for j, vn := range names {
+			w, f, err := config.CreateColumn(k, targetdir, vn)
+			if err != nil {
+				return err
+			}
+			if dtypes[j] == "bool" {
+				w = config.NewBoolWriter(w)
+			}
+			wtrs[k] = append(wtrs[k], w)
+			fids[k] = append(fids[k], f)
+			if dtypes[j] == "text" {
+				twtrs[k][j], tfids[k][j], err = config.CreateColumn(k, targetdir, vn+config.TextSuffix)
+				if err != nil {
+					return err
+				}
+			}
+			if nullable(dtypes[j]) {
+				nwtrs[k][j] = config.CreateNulls(k, targetdir, vn)
+			}
+		}
+	}
+
+	codes = make([]map[string]int, len(names))
+	for j := range names {
+		if dtypes[j] == "string" {
+			codes[j] = make(map[string]int)
+		}
+	}
+
+	return nil
+}
+
+// putvalue writes one value of a variable to a bucket.
+func putvalue(bn, j int, x string) error {
+
+	w := wtrs[bn][j]
+	dt := dtypes[j]
+
+	if nullable(dt) {
+		if isna(x) {
+			var z uint64
+			if strings.HasPrefix(dt, "float") {
+				z = math.Float64bits(math.NaN())
+			}
+			if err := config.WriteBits(w, dt, z); err != nil {
+				return err
+			}
+			return nwtrs[bn][j].Put(false)
+		}
+		if err := nwtrs[bn][j].Put(true); err != nil {
+			return err
+		}
+	}
+
+	switch dt {
+	case "string":
+		c, ok := codes[j][x]
+		if !ok {
+			c = len(codes[j])
+			codes[j][x] = c
+		}
+		return config.WriteUint(w, "uint32", uint64(c))
+	case "text":
+		return config.WriteText(w, twtrs[bn][j], x)
+	case "bool":
+		v, err := strconv.ParseBool(x)
+		if err != nil {
+			return err
+		}
+		return w.(*config.BoolWriter).Put(v)
+	case "date32":
+		v, err := config.ParseDate(x)
+		if err != nil {
+			return err
+		}
+		return config.WriteInt(w, dt, v)
+	case "timestamp64":
+		v, err := config.ParseTimestamp(x)
+		if err != nil {
+			return err
+		}
+		return config.WriteInt(w, dt, v)
+	case "varint":
+		v, err := strconv.ParseInt(x, 10, 64)
+		if err != nil {
+			return err
+		}
+		var b [binary.MaxVarintLen64]byte
+		m := binary.PutVarint(b[:], v)
+		_, err = w.Write(b[0:m])
+		return err
+	case "float32", "float64":
+		v, err := strconv.ParseFloat(x, 64)
+		if err != nil {
+			return err
+		}
+		if dt == "float32" {
+			return binary.Write(w, binary.LittleEndian, float32(v))
+		}
+		return binary.Write(w, binary.LittleEndian, v)
+	}
+
+	v, err := strconv.ParseUint(x, 10, 64)
+	if err != nil {
+		return err
+	}
+	return config.WriteUint(w, dt, v)
+}
+
+// convert writes the records of one file, where pos is the number of
+// records already written.  The number of records in the file is
+// returned.
+func convert(fname string, idpos, pos int) (int, error) {
+
+	fid, err := os.Open(fname)
+	if err != nil {
+		return 0, err
+	}
+	defer fid.Close()
+
+	rdr := newreader(fid)
+	header, err := rdr.Read()
+	if err != nil {
+		return 0, err
+	}
+	if strings.Join(header, ",") != strings.Join(names, ",") {
+		return 0, fmt.Errorf("the header of %s differs from the header of %s", fname, files[0])
+	}
+
+	var n int
+	for {
+		rec, err := rdr.Read()
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+
+		bn := (pos + n) % nbuckets
+		if idpos >= 0 {
+			var id uint64
+			if idm != nil {
+				// The id is written in place of the identifier.
+				if id, err = idm.Add(rec[idpos]); err == nil {
+					rec[idpos] = strconv.FormatUint(id, 10)
+				}
+			} else {
+				id, err = strconv.ParseUint(rec[idpos], 10, 64)
+			}
+			if err != nil {
+				return n, fmt.Errorf("%s line %d: invalid id %q", fname, n+2, rec[idpos])
+			}
+			bn, err = config.Route(conf, id)
+			if err != nil {
+				return n, err
+			}
+		}
+
+		for j, x := range rec {
+			err = putvalue(bn, j, x)
+			if err != nil {
+				return n, fmt.Errorf("%s line %d, variable %s: %v", fname, n+2, names[j], err)
+			}
+		}
+		nrows[bn]++
+		n++
+	}
+}
+
+// finish closes the column files, and saves the number of rows in each
+// bucket and the factor codes.
+func finish() error {
+
+	for k := range wtrs {
+		for j := range wtrs[k] {
+			if err := wtrs[k][j].Close(); err != nil {
+				return err
+			}
+			if err := fids[k][j].Close(); err != nil {
+				return err
+			}
+			if twtrs[k][j] != nil {
+				if err := twtrs[k][j].Close(); err != nil {
+					return err
+				}
+				if err := tfids[k][j].Close(); err != nil {
+					return err
+				}
+			}
+			if nwtrs[k][j] != nil {
+				if err := nwtrs[k][j].Close(); err != nil {
+					return err
+				}
+			}
+		}
+		if err := config.RecordRows(k, targetdir, nrows[k]); err != nil {
+			return err
+		}
+	}
+
+	err := os.MkdirAll(conf.CodesDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	cf := make(map[string]string)
+	for j, vn := range names {
+		if codes[j] != nil {
+			cf[vn] = vn
+			err = config.WriteFactorCodes(vn, codes[j], conf)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if idm != nil {
+		if err := config.WriteIdMap(idvar, idm, conf); err != nil {
+			return err
+		}
+	}
+
+	return config.WriteCodeFiles(conf, cf)
+}
+
+// readheader returns the header of a file.
+func readheader(fname string) ([]string, error) {
+
+	fid, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer fid.Close()
+
+	header, err := newreader(fid).Read()
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]string(nil), header...), nil
+}
+
+// Run runs csv2cols with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("csv2cols", flag.ContinueOnError)
+	var flist, schema, dl string
+	fs.StringVar(&flist, "files", "", "comma-separated input files")
+	fs.StringVar(&dl, "delim", ",", "field delimiter")
+	fs.StringVar(&schema, "schema", "", "comma-separated name:dtype pairs (default is to infer the dtypes)")
+	fs.IntVar(&ninfer, "infer", 1000, "number of records used to infer the dtypes")
+	fs.StringVar(&na, "na", "NA", "value denoting a missing value, besides an empty field")
+	fs.IntVar(&nbuckets, "buckets", 10, "number of buckets")
+	fs.StringVar(&idvar, "idvar", "", "variable used to route records to buckets")
+	fs.StringVar(&routing, "routing", "modulo", "routing method for -idvar, modulo or hash")
+	fs.BoolVar(&useidmap, "idmap", false, "-idvar holds string identifiers such as UUIDs, mapped to integer ids")
+	fs.StringVar(&targetdir, "targetdir", "", "destination directory")
+	fs.StringVar(&compression, "compression", config.DefaultCompression, "compression of the column files (snappy, gzip, zstd or none)")
+	fs.BoolVar(&replace, "replace", false, "overwrite existing files")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	dtypes = nil
+
+	files = fs.Args()
+	if flist != "" {
+		files = append(strings.Split(flist, ","), files...)
+	}
+
+	if len(files) == 0 || targetdir == "" || nbuckets < 1 || len([]rune(dl)) != 1 ||
+		(routing != "modulo" && routing != "hash") || (useidmap && idvar == "") {
+		return cli.Usage("usage:\ncsv2cols -targetdir=... [-schema=...] [-delim=...] [-buckets=...] [-idvar=... [-routing=modulo|hash] [-idmap]] file...\n\n")
+	}
+	delim = []rune(dl)[0]
+
+	if _, err := config.GetCodec(compression); err != nil {
+		return err
+	}
+
+	if !replace {
+		_, err := os.Stat(targetdir)
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("Use -replace=true to overwrite existing contents of %s", targetdir)
+		}
+	}
+
+	var err error
+	names, err = readheader(files[0])
+	if err != nil {
+		return err
+	}
+
+	if schema != "" {
+		err = parseschema(schema, names)
+	} else {
+		err = infer(files[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	idpos := -1
+	for j, vn := range names {
+		if vn == idvar {
+			idpos = j
+		}
+	}
+	if idpos >= 0 && useidmap {
+		dtypes[idpos] = "uint64"
+		idm = config.NewIdMap()
+	}
+	if idvar != "" && (idpos == -1 || dtypes[idpos] == "string" || dtypes[idpos] == "text" || dtypes[idpos] == "bool" || dtypes[idpos] == "varint" || config.IsTime(dtypes[idpos]) ||
+		strings.HasPrefix(dtypes[idpos], "float")) {
+		return fmt.Errorf("The id variable %s must be in the data with an unsigned integer dtype", idvar)
+	}
+
+	err = os.MkdirAll(targetdir, 0755)
+	if err != nil {
+		return err
+	}
+
+	conf = &config.Config{
+		NumBuckets:  nbuckets,
+		Compression: compression,
+		CodesDir:    path.Join(targetdir, "Codes"),
+	}
+	if idvar != "" {
+		conf.Routing = &config.Routing{IdVar: idvar, Method: routing}
+	}
+	if err := config.WriteConfig(targetdir, conf); err != nil {
+		return err
+	}
+
+	if err := setup(); err != nil {
+		return err
+	}
+
+	var pos int
+	for _, fname := range files {
+		n, err := convert(fname, idpos, pos)
+		if err != nil {
+			return err
+		}
+		pos += n
+	}
+
+	if err := finish(); err != nil {
+		return err
+	}
+
+	slog.Info("wrote records", "records", pos, "buckets", nbuckets)
+
+	return nil
+}
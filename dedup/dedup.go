@@ -0,0 +1,248 @@
+// Dedup creates a copy of a columnized dataset with duplicate records
+// removed.  Records are duplicates if they have the same values of all
+// the key variables given with -keys.  Of each set of duplicates, the
+// first record in the bucket is kept, or the last with -keep=last.
+//
+// Duplicates are only detected within a bucket, so the data should be
+// routed on the keys (or on a subset of them) for all duplicates to be
+// found.  Only the key values of each bucket are held in memory, not
+// the full records.
+//
+// A report of the run, with the numbers of records read and kept in
+// each bucket, is saved in targetdir as report.json (see the report
+// package), also when the run fails.
+
+package dedup
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+	"github.com/kshedden/gocols/report"
+	"github.com/kshedden/gocols/subset"
+)
+
+var (
+	// The variables identifying duplicate records
+	keys []string
+
+	// Which duplicate to keep, first or last
+	keep string
+
+	// The directory where the deduplicated data will be stored
+	targetdir string
+
+	// The directory where the full data are stored
+	sourcedir string
+
+	// Configuration information for the source data
+	conf *config.Config
+
+	// If true, overwrite existing files
+	replace bool
+
+	// If true, read back each column after writing it to confirm
+	// that it round-trips
+	verifywrite bool
+
+	// Copies the retained rows to the target directory
+	copier *subset.Copier
+
+	// The report saved in the target directory
+	rpt *report.Report
+
+	// The number of buckets processed in parallel
+	concurrency int
+
+	// The numbers of records read and retained
+	nread, nkept int
+
+	// The first error encountered while processing the buckets
+	firsterr error
+	errmut   sync.Mutex
+
+	sem chan bool
+)
+
+// readkeys returns the key of each record in a bucket, formed from the
+// 64-bit values of the key variables.
+func readkeys(bn int) ([]string, error) {
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return nil, err
+	}
+
+	var kb [][]byte
+	for j, vn := range keys {
+		dt, ok := dtypes[vn]
+		if !ok {
+			return nil, fmt.Errorf("variable %s not found in bucket %d", vn, bn)
+		}
+		rdr, err := config.OpenReader(bn, sourcedir, vn, dt)
+		if err != nil {
+			return nil, err
+		}
+
+		var i int
+		for ; ; i++ {
+			x, err := rdr.Bits()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				rdr.Close()
+				return nil, err
+			}
+			if j == 0 {
+				kb = append(kb, make([]byte, 8*len(keys)))
+			} else if i >= len(kb) {
+				break
+			}
+			binary.LittleEndian.PutUint64(kb[i][8*j:], x)
+		}
+		rdr.Close()
+		if i != len(kb) {
+			return nil, fmt.Errorf("variable %s in bucket %d does not have %d rows", vn, bn, len(kb))
+		}
+	}
+
+	ky := make([]string, len(kb))
+	for i, b := range kb {
+		ky[i] = string(b)
+	}
+
+	return ky, nil
+}
+
+// mask returns the records of a bucket to retain.
+func mask(bn int) ([]bool, error) {
+
+	ky, err := readkeys(bn)
+	if err != nil {
+		return nil, err
+	}
+
+	// The position of the retained record with each key
+	pos := make(map[string]int)
+	for i, k := range ky {
+		if _, ok := pos[k]; !ok || keep == "last" {
+			pos[k] = i
+		}
+	}
+
+	ix := make([]bool, len(ky))
+	for _, i := range pos {
+		ix[i] = true
+	}
+
+	return ix, nil
+}
+
+// dobucket removes the duplicates from one bucket.
+func dobucket(bn int) {
+
+	defer func() { <-sem }()
+
+	ix, err := mask(bn)
+	if err == nil {
+		err = copier.CopyBucket(bn, ix)
+	}
+
+	errmut.Lock()
+	defer errmut.Unlock()
+	if err != nil {
+		if firsterr == nil {
+			firsterr = err
+		}
+		return
+	}
+	rpt.Mask(bn, ix)
+	nread += len(ix)
+	for _, b := range ix {
+		if b {
+			nkept++
+		}
+	}
+}
+
+// Run runs dedup with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("dedup", flag.ContinueOnError)
+	var klist string
+	fs.StringVar(&klist, "keys", "", "comma-separated variables identifying duplicate records")
+	fs.StringVar(&keep, "keep", "first", "which duplicate to keep, first or last")
+	fs.StringVar(&targetdir, "targetdir", "", "destination directory")
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.BoolVar(&replace, "replace", false, "overwrite existing files")
+	fs.BoolVar(&verifywrite, "verify-write", false, "read back each column after writing to confirm it round-trips")
+	fs.IntVar(&concurrency, "concurrency", runtime.NumCPU(), "number of buckets or variables processed in parallel")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	nread, nkept = 0, 0
+	firsterr = nil
+
+	if concurrency < 1 {
+		return errors.New("-concurrency must be positive")
+	}
+
+	if klist == "" || targetdir == "" || sourcedir == "" || (keep != "first" && keep != "last") {
+		return cli.Usage("usage:\ndedup -keys=... -targetdir=... -sourcedir=... [-keep=first|last]\n\n")
+	}
+	keys = strings.Split(klist, ",")
+
+	if !replace {
+		_, err := os.Stat(targetdir)
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("Use -replace=true to overwrite existing contents of %s", targetdir)
+		}
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	copier = &subset.Copier{SourceDir: sourcedir, TargetDir: targetdir, Verify: verifywrite}
+	if err := copier.Setup(conf); err != nil {
+		return err
+	}
+
+	rpt = report.New(fs, args, sourcedir, targetdir, conf.NumBuckets)
+
+	sem = make(chan bool, concurrency)
+	copier.Sem = sem
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		sem <- true
+		go dobucket(k)
+	}
+
+	for k := 0; k < concurrency; k++ {
+		sem <- true
+	}
+
+	if err := rpt.Write(firsterr); err != nil {
+		return err
+	}
+	if firsterr != nil {
+		return firsterr
+	}
+
+	slog.Info("removed duplicates", "kept", nkept, "read", nread, "removed", nread-nkept)
+
+	return nil
+}
@@ -0,0 +1,191 @@
+// Deltauvarint converts a sorted unsigned integer variable to the
+// delta-uvarint data type, in which each value is stored as a uvarint
+// holding the difference from the preceding value in the bucket (the
+// first value is stored as is).  For sorted data the differences are
+// small, so the column is usually much smaller than a uvarint column.
+// Readers reconstruct the values from the cumulative sums of the
+// differences.
+//
+// The variable must be non-decreasing within every bucket.  If it is
+// not, nothing is changed and the program exits with an error.  With
+// -decode, a delta-uvarint variable is converted back to uvarint.
+
+package deltauvarint
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The variable to convert
+	vname string
+
+	// If true, convert from delta-uvarint to uvarint
+	decode bool
+
+	// Configuration information for the data set
+	conf *config.Config
+)
+
+// getdtype returns the data type of the variable in a bucket.
+func getdtype(bn int) (string, error) {
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return "", err
+	}
+	dt, ok := dtypes[vname]
+	if !ok {
+		return "", fmt.Errorf("Variable %s not found in bucket %d", vname, bn)
+	}
+
+	return dt, nil
+}
+
+// filesize returns the size in bytes of a file.
+func filesize(fn string) (int64, error) {
+	fi, err := os.Stat(fn)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// convert writes the converted variable for one bucket to a temporary
+// column.  It returns an error if the values are not sorted, in which
+// case the temporary column is incomplete.
+func convert(bn int, tmpname string) error {
+
+	dt, err := getdtype(bn)
+	if err != nil {
+		return err
+	}
+	if decode && dt != "delta-uvarint" {
+		return fmt.Errorf("Variable %s has dtype %s in bucket %d, expected delta-uvarint", vname, dt, bn)
+	}
+
+	rdr, err := config.OpenReader(bn, sourcedir, vname, dt)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+
+	wtr, fid, err := config.CreateColumn(bn, sourcedir, tmpname)
+	if err != nil {
+		return err
+	}
+	defer fid.Close()
+	defer wtr.Close()
+
+	var last uint64
+	for i := 0; ; i++ {
+		x, err := rdr.Uint()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		y := x
+		if !decode {
+			if x < last {
+				return fmt.Errorf("Variable %s is not sorted in bucket %d (row %d)", vname, bn, i)
+			}
+			y = x - last
+			last = x
+		}
+
+		err = config.WriteUint(wtr, "uvarint", y)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run runs deltauvarint with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("deltauvarint", flag.ContinueOnError)
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&vname, "var", "", "variable to convert")
+	fs.BoolVar(&decode, "decode", false, "convert from delta-uvarint back to uvarint")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" || vname == "" {
+		return cli.Usage("usage:\ndeltauvarint -sourcedir=... -var=... [-decode]\n\n")
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	// Write all buckets to temporary columns, so that nothing is
+	// changed if any bucket is not sorted.
+	tmpname := vname + ".tmp"
+	for k := 0; k < conf.NumBuckets; k++ {
+		if err := convert(k, tmpname); err != nil {
+			for k := 0; k < conf.NumBuckets; k++ {
+				os.Remove(config.ColumnPath(k, sourcedir, tmpname))
+			}
+			return err
+		}
+	}
+
+	newdt := "delta-uvarint"
+	if decode {
+		newdt = "uvarint"
+	}
+
+	var oldsize, newsize int64
+	for k := 0; k < conf.NumBuckets; k++ {
+		fn := config.ColumnPath(k, sourcedir, vname)
+		tn := config.ColumnPath(k, sourcedir, tmpname)
+		m, err := filesize(fn)
+		if err != nil {
+			return err
+		}
+		n, err := filesize(tn)
+		if err != nil {
+			return err
+		}
+		oldsize += m
+		newsize += n
+
+		err = os.Rename(tn, fn)
+		if err != nil {
+			return err
+		}
+
+		dtypes, err := config.ReadDtypes(k, sourcedir)
+		if err != nil {
+			return err
+		}
+		dtypes[vname] = newdt
+		if err := config.WriteDtypes(k, sourcedir, dtypes); err != nil {
+			return err
+		}
+		if err := config.UpdateMeta(k, sourcedir); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("converted variable", "var", vname, "dtype", newdt, "oldsize", oldsize, "newsize", newsize)
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package deltauvarint_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kshedden/gocols/coltest"
+	"github.com/kshedden/gocols/config"
+	"github.com/kshedden/gocols/deltauvarint"
+)
+
+// column returns delimited text holding a variable x with the given
+// values, and a variable y.
+func column(x func(i int) int) string {
+	var b strings.Builder
+	b.WriteString("x,y\n")
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&b, "%d,%d\n", x(i), i%7)
+	}
+	return b.String()
+}
+
+// state returns the data type and the total size of the column files
+// of x.
+func state(t *testing.T, dir string) (string, int64) {
+	var dt string
+	var size int64
+	for k := 0; k < 2; k++ {
+		dtypes, err := config.ReadDtypes(k, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dt = dtypes["x"]
+		fi, err := config.StatFile(config.ColumnPath(k, dir, "x"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += fi.Size()
+	}
+	return dt, size
+}
+
+func TestDeltaUvarint(t *testing.T) {
+
+	for _, tc := range []struct {
+		name string
+		x    func(i int) int
+		err  string
+	}{
+		{
+			name: "sorted",
+			x:    func(i int) int { return 5000000 + 3*i },
+		},
+		{
+			name: "ties",
+			x:    func(i int) int { return 5000000 + i/4 },
+		},
+		{
+			// Row 51 of bucket 1 is record 103.
+			name: "unsorted",
+			x: func(i int) int {
+				if i == 103 {
+					return 0
+				}
+				return 5000000 + i
+			},
+			err: "Variable x is not sorted in bucket 1 (row 51)",
+		},
+	} {
+		dir := coltest.CSV(t, column(tc.x), "-compression=none")
+		want := coltest.Records(t, dir)
+		_, size := state(t, dir)
+
+		err := deltauvarint.Run([]string{"-sourcedir=" + dir, "-var=x", "-q"})
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: got error %v, expected %q", tc.name, err, tc.err)
+			}
+			if dt, _ := state(t, dir); dt != "uvarint" {
+				t.Errorf("%s: x has dtype %s after a failed conversion", tc.name, dt)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		dt, dsize := state(t, dir)
+		if dt != "delta-uvarint" || dsize >= size/2 {
+			t.Errorf("%s: x has dtype %s and %d bytes, expected delta-uvarint with less than half of %d bytes",
+				tc.name, dt, dsize, size)
+		}
+		if got := coltest.Records(t, dir); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %q, expected %q", tc.name, got, want)
+		}
+
+		if err := deltauvarint.Run([]string{"-sourcedir=" + dir, "-var=x", "-decode", "-q"}); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if dt, usize := state(t, dir); dt != "uvarint" || usize != size {
+			t.Errorf("%s: after decoding, x has dtype %s and %d bytes, expected uvarint with %d bytes",
+				tc.name, dt, usize, size)
+		}
+		if got := coltest.Records(t, dir); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: after decoding, got %q, expected %q", tc.name, got, want)
+		}
+	}
+}
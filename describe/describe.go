@@ -0,0 +1,252 @@
+// Describe prints an overview of a data set: the number of buckets,
+// the compression, the routing, the attributes, the total number of
+// rows and, for each variable, its data type, the total size of its
+// column files, whether it is factor-coded and its description (see
+// varinfo).
+//
+// The number of rows is taken from the meta.json or manifest.json file
+// of each bucket (see bucketmanifest), and is reported as unknown if
+// some bucket has neither.  Variables with different data types in
+// different buckets are flagged.
+//
+// The data set may be in Amazon S3 (s3://), Google Cloud Storage
+// (gs://) or on a web server (http:// or https://), in which case only
+// the small metadata files are downloaded.
+
+package describe
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+	_ "github.com/kshedden/gocols/gcsstore"
+	_ "github.com/kshedden/gocols/httpstore"
+	_ "github.com/kshedden/gocols/s3store"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// Configuration information for the data set
+	conf *config.Config
+)
+
+// variable summarizes one variable over the buckets.
+type variable struct {
+
+	// The data types used for the variable, with the number of
+	// buckets using each
+	dtypes map[string]int
+
+	// The number of buckets containing the variable
+	nbuckets int
+
+	// The total size of the column files
+	size int64
+}
+
+// numrows returns the number of rows in a bucket, from its meta.json
+// or manifest.json file.  If neither exists, false is returned.
+func numrows(bn int) (int, bool) {
+
+	if meta, err := config.ReadMeta(bn, sourcedir); err == nil {
+		return meta.NumRows, true
+	}
+
+	b, err := config.ReadFile(config.Join(config.BucketPath(bn, sourcedir), "manifest.json"))
+	if err != nil {
+		return 0, false
+	}
+	var man struct{ NumRows int }
+	if err := json.Unmarshal(b, &man); err != nil {
+		return 0, false
+	}
+
+	return man.NumRows, true
+}
+
+// dtypestr describes the data types of a variable.
+func (v *variable) dtypestr() string {
+
+	var dts []string
+	for dt := range v.dtypes {
+		dts = append(dts, dt)
+	}
+	sort.Strings(dts)
+	if len(dts) == 1 {
+		return dts[0]
+	}
+
+	for j, dt := range dts {
+		dts[j] = fmt.Sprintf("%s(%d)", dt, v.dtypes[dt])
+	}
+	return "mixed: " + strings.Join(dts, ",")
+}
+
+// factorstr describes the factor coding of a variable.
+func factorstr(vn string, cf map[string]string) string {
+
+	if !config.HasFactorCodes(vn, conf) {
+		return ""
+	}
+
+	grp, ok := cf[vn]
+	if !ok {
+		grp = vn
+	}
+	codes, err := config.GetFactorCodes(vn, conf)
+	if err != nil {
+		return fmt.Sprintf("factor (group %s, unreadable codes: %v)", grp, err)
+	}
+
+	return fmt.Sprintf("factor (group %s, %d levels)", grp, len(codes))
+}
+
+// Run runs describe with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("describe", flag.ContinueOnError)
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" {
+		return cli.Usage("usage:\ndescribe -sourcedir=...\n\n")
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	vars := make(map[string]*variable)
+	nrows, rowsknown := 0, true
+	for k := 0; k < conf.NumBuckets; k++ {
+		dtypes, err := config.ReadDtypes(k, sourcedir)
+		if err != nil {
+			return err
+		}
+		for vn, dt := range dtypes {
+			v := vars[vn]
+			if v == nil {
+				v = &variable{dtypes: make(map[string]int)}
+				vars[vn] = v
+			}
+			v.dtypes[dt]++
+			v.nbuckets++
+			if fi, err := config.StatFile(config.ColumnPath(k, sourcedir, vn)); err == nil {
+				v.size += fi.Size()
+			}
+		}
+
+		n, ok := numrows(k)
+		nrows += n
+		rowsknown = rowsknown && ok
+	}
+
+	compression := conf.Compression
+	if compression == "" {
+		compression = config.DefaultCompression
+	}
+
+	fmt.Printf("Data set:    %s\n", sourcedir)
+	fmt.Printf("Format:      version %d\n", conf.FormatVersion)
+	fmt.Printf("Buckets:     %d\n", conf.NumBuckets)
+	fmt.Printf("Compression: %s\n", compression)
+	if len(conf.ColumnCompression) > 0 {
+		var cc []string
+		for vn, c := range conf.ColumnCompression {
+			cc = append(cc, vn+"="+c)
+		}
+		sort.Strings(cc)
+		fmt.Printf("             %s\n", strings.Join(cc, ", "))
+	}
+	fmt.Printf("Codes:       %s\n", conf.CodesDir)
+	if conf.Routing != nil {
+		fmt.Printf("Routing:     %s on %s\n", conf.Routing.Method, conf.Routing.IdVar)
+	}
+	if len(conf.Attrs) > 0 {
+		var keys []string
+		for k := range conf.Attrs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for j, k := range keys {
+			lab := ""
+			if j == 0 {
+				lab = "Attributes:"
+			}
+			fmt.Printf("%-13s%s=%s\n", lab, k, conf.Attrs[k])
+		}
+	}
+	if rowsknown {
+		fmt.Printf("Rows:        %d\n", nrows)
+	} else {
+		fmt.Printf("Rows:        unknown (run bucketmanifest to record them)\n")
+	}
+	fmt.Printf("Variables:   %d\n\n", len(vars))
+
+	cf, err := config.ReadCodeFiles(conf)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for vn := range vars {
+		names = append(names, vn)
+	}
+	sort.Strings(names)
+
+	info, err := config.ReadVarInfo(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if len(info) > 0 {
+		fmt.Fprintf(tw, "Variable\tDtype\tBytes\tCoding\tLabel\tUnits\n")
+	} else {
+		fmt.Fprintf(tw, "Variable\tDtype\tBytes\tCoding\n")
+	}
+	for _, vn := range names {
+		v := vars[vn]
+		coding := factorstr(vn, cf)
+		if v.nbuckets < conf.NumBuckets {
+			coding = strings.TrimSpace(fmt.Sprintf("%s (missing from %d buckets)", coding, conf.NumBuckets-v.nbuckets))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s", vn, v.dtypestr(), v.size, coding)
+		if len(info) > 0 {
+			vi := info[vn]
+			if vi == nil {
+				vi = new(config.VarInfo)
+			}
+			fmt.Fprintf(tw, "\t%s\t%s", vi.Label, vi.Units)
+		}
+		fmt.Fprintf(tw, "\n")
+	}
+	tw.Flush()
+
+	// The notes are often long, so they follow the table.
+	var header bool
+	for _, vn := range names {
+		if vi := info[vn]; vi != nil && vi.Notes != "" {
+			if !header {
+				fmt.Printf("\nNotes:\n")
+				header = true
+			}
+			fmt.Printf("  %s: %s\n", vn, vi.Notes)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,384 @@
+// Diffsets compares two data sets and reports their differences: the
+// number of buckets, the compression and the routing, the variables
+// and data types of each bucket, the number of rows of each variable
+// in each bucket, and the factor codes.  With -values, the values of
+// the variables found in both data sets are also compared, row by row
+// within each bucket.  Factor-coded variables are compared by label,
+// so data sets that code the same labels differently agree.  Float
+// values agree if they differ by at most -tol, and NaN agrees with NaN.
+//
+// The program exits with a non-zero status if any difference is found.
+
+package diffsets
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The data sets being compared
+	dira, dirb string
+
+	// Configuration information for the data sets
+	confa, confb *config.Config
+
+	// If true, compare the values
+	values bool
+
+	// The largest difference between float values that agree
+	tol float64
+
+	// The largest number of differing values reported for a
+	// variable in a bucket
+	maxreport int
+
+	// The number of differences found
+	ndiff int
+)
+
+// report prints a difference.
+func report(format string, args ...interface{}) {
+	ndiff++
+	fmt.Printf(format+"\n", args...)
+}
+
+// sortedkeys returns the names in the union of two dtypes maps.
+func sortedkeys(a, b map[string]string) []string {
+
+	m := make(map[string]bool)
+	for k := range a {
+		m[k] = true
+	}
+	for k := range b {
+		m[k] = true
+	}
+
+	var names []string
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// compareconf compares the configurations.
+func compareconf() {
+
+	if confa.NumBuckets != confb.NumBuckets {
+		report("buckets: %d vs %d", confa.NumBuckets, confb.NumBuckets)
+	}
+
+	ca, cb := confa.Compression, confb.Compression
+	if ca == "" {
+		ca = config.DefaultCompression
+	}
+	if cb == "" {
+		cb = config.DefaultCompression
+	}
+	if ca != cb {
+		report("compression: %s vs %s", ca, cb)
+	}
+
+	if !reflect.DeepEqual(confa.Routing, confb.Routing) {
+		ra, rb := "none", "none"
+		if r := confa.Routing; r != nil {
+			ra = fmt.Sprintf("%s on %s", r.Method, r.IdVar)
+		}
+		if r := confb.Routing; r != nil {
+			rb = fmt.Sprintf("%s on %s", r.Method, r.IdVar)
+		}
+		report("routing: %s vs %s", ra, rb)
+	}
+}
+
+// getlabels returns the reverse factor codes of a variable, or nil if
+// it is not factor-coded.
+func getlabels(vn string, conf *config.Config) (map[int]string, error) {
+
+	if !config.HasFactorCodes(vn, conf) {
+		return nil, nil
+	}
+	codes, err := config.GetFactorCodes(vn, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return config.RevCodes(codes), nil
+}
+
+// comparecodes compares the factor codes of the variables, returning
+// the labels of the variables that are factor-coded in both data sets.
+func comparecodes(names []string) (map[string][2]map[int]string, error) {
+
+	labels := make(map[string][2]map[int]string)
+	for _, vn := range names {
+		la, err := getlabels(vn, confa)
+		if err != nil {
+			return nil, err
+		}
+		lb, err := getlabels(vn, confb)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case la == nil && lb == nil:
+			continue
+		case la == nil || lb == nil:
+			report("variable %s: factor-coded in only one data set", vn)
+			continue
+		}
+		labels[vn] = [2]map[int]string{la, lb}
+
+		ca, cb := invert(la), invert(lb)
+		var only []string
+		var recoded int
+		for lab, c := range ca {
+			d, ok := cb[lab]
+			if !ok {
+				only = append(only, fmt.Sprintf("%q (a)", lab))
+			} else if c != d {
+				recoded++
+			}
+		}
+		for lab := range cb {
+			if _, ok := ca[lab]; !ok {
+				only = append(only, fmt.Sprintf("%q (b)", lab))
+			}
+		}
+		sort.Strings(only)
+		if len(only) > 0 {
+			report("variable %s: labels in only one data set: %s", vn, strings.Join(only, ", "))
+		}
+		if recoded > 0 {
+			report("variable %s: %d labels have different codes", vn, recoded)
+		}
+	}
+
+	return labels, nil
+}
+
+// invert returns the map from labels to codes.
+func invert(rev map[int]string) map[string]int {
+	m := make(map[string]int)
+	for c, lab := range rev {
+		m[lab] = c
+	}
+	return m
+}
+
+// comparebucket compares the variables and row counts of one bucket,
+// and their values if requested.
+func comparebucket(bn int, labels map[string][2]map[int]string) error {
+
+	dta, err := config.ReadDtypes(bn, dira)
+	if err != nil {
+		return err
+	}
+	dtb, err := config.ReadDtypes(bn, dirb)
+	if err != nil {
+		return err
+	}
+
+	for _, vn := range sortedkeys(dta, dtb) {
+		a, oka := dta[vn]
+		b, okb := dtb[vn]
+		switch {
+		case !okb:
+			report("bucket %d, variable %s: only in a", bn, vn)
+			continue
+		case !oka:
+			report("bucket %d, variable %s: only in b", bn, vn)
+			continue
+		case a != b:
+			report("bucket %d, variable %s: dtype %s vs %s", bn, vn, a, b)
+		}
+
+		na, err := config.CountRows(bn, dira, vn, a)
+		if err != nil {
+			return err
+		}
+		nb, err := config.CountRows(bn, dirb, vn, b)
+		if err != nil {
+			return err
+		}
+		if na != nb {
+			report("bucket %d, variable %s: %d rows vs %d rows", bn, vn, na, nb)
+			continue
+		}
+
+		if values {
+			lab, ok := labels[vn]
+			if !ok {
+				err = comparevalues(bn, vn, a, b, nil)
+			} else {
+				err = comparevalues(bn, vn, a, b, &lab)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// text formats a value read from a column, using the labels if given.
+func text(rdr *config.ColumnReader, labels map[int]string) (string, float64, error) {
+
+	if labels != nil {
+		x, err := rdr.Uint()
+		if err != nil {
+			return "", 0, err
+		}
+		lab, ok := labels[int(x)]
+		if !ok {
+			lab = fmt.Sprintf("code %d", x)
+		}
+		return lab, 0, nil
+	}
+
+	switch rdr.Dtype() {
+	case "float32", "float64":
+		x, err := rdr.Float()
+		return fmt.Sprintf("%g", x), x, err
+	}
+
+	s, err := rdr.Text()
+	return s, 0, err
+}
+
+// comparevalues compares the values of a variable in one bucket.
+func comparevalues(bn int, vn, dta, dtb string, labels *[2]map[int]string) error {
+
+	ra, err := config.OpenReader(bn, dira, vn, dta)
+	if err != nil {
+		return err
+	}
+	defer ra.Close()
+	rb, err := config.OpenReader(bn, dirb, vn, dtb)
+	if err != nil {
+		return err
+	}
+	defer rb.Close()
+
+	var la, lb map[int]string
+	if labels != nil {
+		la, lb = labels[0], labels[1]
+	}
+	isfloat := strings.HasPrefix(dta, "float") || strings.HasPrefix(dtb, "float")
+
+	var n int
+	for i := 0; ; i++ {
+		sa, xa, err := text(ra, la)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		sb, xb, err := text(rb, lb)
+		if err != nil {
+			return err
+		}
+
+		if isfloat && labels == nil {
+			// Integers compared with floats are converted.
+			if !strings.HasPrefix(dta, "float") {
+				xa, _ = strconv.ParseFloat(sa, 64)
+			}
+			if !strings.HasPrefix(dtb, "float") {
+				xb, _ = strconv.ParseFloat(sb, 64)
+			}
+			if math.Abs(xa-xb) <= tol || (math.IsNaN(xa) && math.IsNaN(xb)) {
+				continue
+			}
+		} else if sa == sb {
+			continue
+		}
+
+		n++
+		if n <= maxreport {
+			report("bucket %d, variable %s, row %d: %s vs %s", bn, vn, i, sa, sb)
+		}
+	}
+
+	if n > maxreport {
+		ndiff += n - maxreport
+		fmt.Printf("bucket %d, variable %s: %d more differing rows\n", bn, vn, n-maxreport)
+	}
+
+	return nil
+}
+
+// Run runs diffsets with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("diffsets", flag.ContinueOnError)
+	fs.StringVar(&dira, "a", "", "first data set")
+	fs.StringVar(&dirb, "b", "", "second data set")
+	fs.BoolVar(&values, "values", false, "compare the values")
+	fs.Float64Var(&tol, "tol", 0, "largest difference between float values that agree")
+	fs.IntVar(&maxreport, "max", 10, "largest number of differing values reported per variable and bucket")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	ndiff = 0
+
+	if dira == "" || dirb == "" || tol < 0 {
+		return cli.Usage("usage:\ndiffsets -a=... -b=... [-values [-tol=...] [-max=...]]\n\n")
+	}
+
+	var err error
+	confa, err = config.GetConfig(dira)
+	if err != nil {
+		return err
+	}
+	confb, err = config.GetConfig(dirb)
+	if err != nil {
+		return err
+	}
+
+	compareconf()
+
+	dta, err := config.ReadDtypes(0, dira)
+	if err != nil {
+		return err
+	}
+	dtb, err := config.ReadDtypes(0, dirb)
+	if err != nil {
+		return err
+	}
+	labels, err := comparecodes(sortedkeys(dta, dtb))
+	if err != nil {
+		return err
+	}
+
+	nb := confa.NumBuckets
+	if confb.NumBuckets < nb {
+		nb = confb.NumBuckets
+	}
+	for k := 0; k < nb; k++ {
+		if err := comparebucket(k, labels); err != nil {
+			return err
+		}
+	}
+
+	if ndiff > 0 {
+		return fmt.Errorf("Found %d differences", ndiff)
+	}
+	fmt.Printf("No differences found\n")
+
+	return nil
+}
@@ -0,0 +1,318 @@
+// Dropvars removes variables from a data set in place.  The column
+// files, validity bitmaps and indexes of the variables are deleted from
+// every bucket, and the variables are removed from dtypes.json, from
+// the manifest.json and meta.json files of the buckets if there are
+// any, from the map of code groups, from the compression settings and
+// from the descriptions of the variables.  Code groups that are no
+// longer used by any variable are deleted.  The routing variable cannot
+// be dropped.
+//
+// With -dryrun, the files that would be deleted are listed, with
+// their total size, and nothing is changed.
+
+package dropvars
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The variables to drop
+	drop map[string]bool
+
+	// If true, only list what would be removed
+	dryrun bool
+
+	// Configuration information for the data set
+	conf *config.Config
+)
+
+// remove deletes a file, or lists it in a dry run.  The size of the
+// file is returned.
+func remove(fn string) (int64, error) {
+
+	fi, err := os.Stat(fn)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	if dryrun {
+		fmt.Println(fn)
+		return fi.Size(), nil
+	}
+
+	return fi.Size(), os.Remove(fn)
+}
+
+// updatemanifest removes the dropped variables from the manifest.json
+// file of a bucket, if there is one.
+func updatemanifest(bn int) error {
+
+	fn := path.Join(config.BucketPath(bn, sourcedir), "manifest.json")
+	b, err := ioutil.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var man map[string]interface{}
+	if err := json.Unmarshal(b, &man); err != nil {
+		return fmt.Errorf("cannot read %s: %v", fn, err)
+	}
+	cols, _ := man["Columns"].([]interface{})
+	var keep []interface{}
+	for _, c := range cols {
+		cm, _ := c.(map[string]interface{})
+		if vn, _ := cm["Name"].(string); !drop[vn] {
+			keep = append(keep, c)
+		}
+	}
+	man["Columns"] = keep
+
+	b, err = json.Marshal(man)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(fn, append(b, '\n'), 0644)
+}
+
+// dobucket removes the dropped variables from one bucket.  The number
+// of bytes removed is returned.
+func dobucket(bn int) (int64, error) {
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return 0, err
+	}
+
+	var found []string
+	for vn := range drop {
+		if dt, ok := dtypes[vn]; ok {
+			found = append(found, config.BucketFiles(bn, sourcedir, vn, dt)...)
+			delete(dtypes, vn)
+		}
+	}
+	if len(found) == 0 {
+		return 0, nil
+	}
+
+	// Update the data types first, so that an interruption leaves
+	// unused files rather than missing columns.
+	if !dryrun {
+		if err := config.WriteDtypes(bn, sourcedir, dtypes); err != nil {
+			return 0, err
+		}
+	}
+
+	var size int64
+	for _, fn := range found {
+		n, err := remove(config.ColumnPath(bn, sourcedir, fn))
+		if err != nil {
+			return size, err
+		}
+		size += n
+	}
+	for vn := range drop {
+		for _, fn := range []string{config.BloomPath(bn, sourcedir, vn), config.SortIndexPath(bn, sourcedir, vn)} {
+			n, err := remove(fn)
+			if err != nil {
+				return size, err
+			}
+			size += n
+		}
+	}
+
+	if dryrun {
+		return size, nil
+	}
+
+	if err := updatemanifest(bn); err != nil {
+		return size, err
+	}
+
+	if meta, err := config.ReadMeta(bn, sourcedir); err == nil {
+		idvar := meta.IdVar
+		if drop[idvar] {
+			idvar = ""
+		}
+		meta, err = config.ComputeMeta(bn, sourcedir, idvar)
+		if err != nil {
+			return size, err
+		}
+		if err := config.WriteMeta(bn, sourcedir, meta); err != nil {
+			return size, err
+		}
+	}
+
+	return size, nil
+}
+
+// dropcodes removes the dropped variables from the map of code groups,
+// and deletes the code groups that are no longer used.  The number of
+// bytes removed is returned.
+func dropcodes(vnames []string) (int64, error) {
+
+	cf, err := config.ReadCodeFiles(conf)
+	if err != nil {
+		return 0, err
+	}
+
+	group := func(vn string) string {
+		if grp, ok := cf[vn]; ok {
+			return grp
+		}
+		return vn
+	}
+
+	used := make(map[string]bool)
+	for _, vn := range vnames {
+		if !drop[vn] {
+			used[group(vn)] = true
+		}
+	}
+
+	var size int64
+	done := make(map[string]bool)
+	for vn := range drop {
+		grp := group(vn)
+		if used[grp] || done[grp] {
+			continue
+		}
+		done[grp] = true
+		n, err := remove(path.Join(conf.CodesDir, grp+"Codes.json"))
+		if err != nil {
+			return size, err
+		}
+		size += n
+	}
+
+	var changed bool
+	for vn := range drop {
+		if _, ok := cf[vn]; ok {
+			delete(cf, vn)
+			changed = true
+		}
+	}
+	if changed && !dryrun {
+		if err := config.WriteCodeFiles(conf, cf); err != nil {
+			return size, err
+		}
+	}
+
+	return size, nil
+}
+
+// Run runs dropvars with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("dropvars", flag.ContinueOnError)
+	var vlist string
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&vlist, "vars", "", "comma-separated variables to drop")
+	fs.BoolVar(&dryrun, "dryrun", false, "list the files that would be removed, without removing them")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" || vlist == "" {
+		return cli.Usage("usage:\ndropvars -sourcedir=... -vars=... [-dryrun]\n\n")
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	dtypes, err := config.ReadDtypes(0, sourcedir)
+	if err != nil {
+		return err
+	}
+
+	drop = make(map[string]bool)
+	for _, vn := range strings.Split(vlist, ",") {
+		if _, ok := dtypes[vn]; !ok {
+			return fmt.Errorf("Variable %s not found in bucket 0", vn)
+		}
+		if conf.Routing != nil && vn == conf.Routing.IdVar {
+			return fmt.Errorf("Variable %s is used to route records to buckets, and cannot be dropped", vn)
+		}
+		drop[vn] = true
+	}
+	if len(drop) == len(dtypes) {
+		return errors.New("Every variable would be dropped")
+	}
+
+	var size int64
+	for k := 0; k < conf.NumBuckets; k++ {
+		n, err := dobucket(k)
+		if err != nil {
+			return err
+		}
+		size += n
+	}
+
+	var vnames []string
+	for vn := range dtypes {
+		vnames = append(vnames, vn)
+	}
+	n, err := dropcodes(vnames)
+	if err != nil {
+		return err
+	}
+	size += n
+
+	if !dryrun {
+		var changed bool
+		for vn := range drop {
+			if _, ok := conf.ColumnCompression[vn]; ok {
+				delete(conf.ColumnCompression, vn)
+				changed = true
+			}
+		}
+		if changed {
+			if err := config.WriteConfig(sourcedir, conf); err != nil {
+				return err
+			}
+		}
+
+		info, err := config.ReadVarInfo(sourcedir)
+		if err != nil {
+			return err
+		}
+		if len(info) > 0 {
+			for vn := range drop {
+				delete(info, vn)
+			}
+			if err := config.WriteVarInfo(sourcedir, info); err != nil {
+				return err
+			}
+		}
+	}
+
+	if dryrun {
+		fmt.Printf("Would drop %d variables, removing %d bytes\n", len(drop), size)
+	} else {
+		slog.Info("dropped variables", "vars", len(drop), "bytes", size)
+	}
+
+	return nil
+}
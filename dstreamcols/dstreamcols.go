@@ -0,0 +1,131 @@
+// Package dstreamcols exposes a data set as a Dstream from
+// github.com/kshedden/dstream, so that it can be used with the
+// statmodel regression code.  Each chunk of the Dstream holds rows
+// from one bucket, and the buckets are opened one at a time as the
+// Dstream advances.
+//
+// By default float variables are given as []float64, varint variables
+// as []int64 and unsigned integer variables as []uint64.  If Float is
+// set, every variable is given as []float64, which is what the
+// statmodel packages expect.
+package dstreamcols
+
+import (
+	"github.com/kshedden/dstream/dstream"
+	"github.com/kshedden/gocols/colreader"
+	"github.com/kshedden/gocols/config"
+)
+
+// Options describes the contents of a Dstream.
+type Options struct {
+
+	// The variables to include
+	Vars []string
+
+	// The largest number of rows in a chunk
+	ChunkSize int
+
+	// The buckets to read, or all buckets if empty
+	Buckets []int
+
+	// If true, every variable is converted to float64
+	Float bool
+}
+
+// Dstream reads a data set as a dstream.Dstream.
+type Dstream struct {
+	ds   *colreader.Dataset
+	opts Options
+	it   *colreader.Iter
+	err  error
+}
+
+var _ dstream.Dstream = (*Dstream)(nil)
+
+// New returns a Dstream reading the given data set.
+func New(ds *colreader.Dataset, opts Options) *Dstream {
+	d := &Dstream{ds: ds, opts: opts}
+	d.Reset()
+	return d
+}
+
+// Next advances to the next chunk, returning false when the data are
+// exhausted or an error occurs.  Err returns the error, if any.
+func (d *Dstream) Next() bool {
+	if d.it.Next() {
+		return true
+	}
+	d.err = d.it.Err()
+	return false
+}
+
+// Err returns the error that stopped the Dstream, if any.
+func (d *Dstream) Err() error {
+	return d.err
+}
+
+// Names returns the names of the variables.
+func (d *Dstream) Names() []string {
+	return d.opts.Vars
+}
+
+// NumVar returns the number of variables.
+func (d *Dstream) NumVar() int {
+	return len(d.opts.Vars)
+}
+
+// Get returns the values of a variable in the current chunk, or nil if
+// the variable is not included.
+func (d *Dstream) Get(vname string) interface{} {
+	c := d.it.Chunk()
+	if d.opts.Float {
+		if x := c.Float64(vname); x != nil {
+			return x
+		}
+		return nil
+	}
+	return c.Data(vname)
+}
+
+// GetPos returns the values of the variable at position j in the
+// current chunk.
+func (d *Dstream) GetPos(j int) interface{} {
+	return d.Get(d.opts.Vars[j])
+}
+
+// NumObs returns the number of rows, using the meta.json files of the
+// buckets, or -1 if a bucket has no meta.json file.
+func (d *Dstream) NumObs() int {
+
+	buckets := d.opts.Buckets
+	if len(buckets) == 0 {
+		for k := 0; k < d.ds.NumBuckets(); k++ {
+			buckets = append(buckets, k)
+		}
+	}
+
+	var n int
+	for _, k := range buckets {
+		meta, err := config.ReadMeta(k, d.ds.Path)
+		if err != nil {
+			return -1
+		}
+		n += meta.NumRows
+	}
+
+	return n
+}
+
+// Reset restarts the Dstream at the first row.
+func (d *Dstream) Reset() {
+	if d.it != nil {
+		d.it.Close()
+	}
+	d.it = d.ds.Iter(d.opts.Vars, d.opts.ChunkSize, d.opts.Buckets...)
+	d.err = nil
+}
+
+// Close closes the open column files.
+func (d *Dstream) Close() {
+	d.it.Close()
+}
@@ -0,0 +1,213 @@
+// Exportarrow writes a data set as Apache Arrow IPC data, either in
+// the streaming format or in the random access file format (also
+// known as Feather version 2), selected with -format.  By default each
+// bucket is written to its own file in the output directory, named
+// part-NNNN.arrow (file format) or part-NNNN.arrows (streaming
+// format).  With -merge, all buckets are written to a single file,
+// with one record batch per bucket.
+//
+// The columns are converted as in exportparquet.  Factor-coded
+// variables are written as dictionary-encoded strings holding their
+// labels, unless -decode=false is given, in which case their codes are
+// written and the labels are attached to the field metadata.
+
+package exportarrow
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/kshedden/gocols/arrowcols"
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The directory where the Arrow files are written
+	outdir string
+
+	// The file written with -merge
+	outfile string
+
+	// The IPC format, stream or file
+	format string
+
+	// The variables to write
+	vars []string
+
+	// If true, write factor-coded variables using their labels
+	decode bool
+
+	// Configuration information for the data set
+	conf *config.Config
+
+	// Reads the buckets as Arrow records
+	conv *arrowcols.Converter
+)
+
+// recordWriter is implemented by the IPC stream and file writers.
+type recordWriter interface {
+	Write(rec arrow.Record) error
+	Close() error
+}
+
+// ipcfile writes Arrow IPC data to a file.
+type ipcfile struct {
+	recordWriter
+	fid io.Closer
+}
+
+// Close finishes the IPC data and closes the file.
+func (f *ipcfile) Close() error {
+	err := f.recordWriter.Close()
+	if err2 := f.fid.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// newwriter creates an Arrow IPC file.
+func newwriter(fn string) (*ipcfile, error) {
+
+	fid, err := os.Create(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == "stream" {
+		wtr := ipc.NewWriter(fid, ipc.WithSchema(conv.Schema))
+		return &ipcfile{recordWriter: wtr, fid: fid}, nil
+	}
+
+	wtr, err := ipc.NewFileWriter(fid, ipc.WithSchema(conv.Schema))
+	if err != nil {
+		fid.Close()
+		return nil, err
+	}
+
+	return &ipcfile{recordWriter: wtr, fid: fid}, nil
+}
+
+// writebucket writes one bucket as a record batch.
+func writebucket(wtr *ipcfile, bn int) error {
+
+	rec, err := conv.Bucket(bn)
+	if err != nil {
+		return err
+	}
+	defer rec.Release()
+
+	return wtr.Write(rec)
+}
+
+// dosplit writes each bucket to its own file.
+func dosplit() error {
+
+	ext := ".arrow"
+	if format == "stream" {
+		ext = ".arrows"
+	}
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		wtr, err := newwriter(path.Join(outdir, fmt.Sprintf("part-%04d%s", k, ext)))
+		if err != nil {
+			return err
+		}
+		if err := writebucket(wtr, k); err != nil {
+			wtr.Close()
+			return err
+		}
+		if err := wtr.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// domerge writes all buckets to a single file.
+func domerge() error {
+
+	wtr, err := newwriter(outfile)
+	if err != nil {
+		return err
+	}
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		if err := writebucket(wtr, k); err != nil {
+			wtr.Close()
+			return err
+		}
+	}
+
+	return wtr.Close()
+}
+
+// Run runs exportarrow with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("exportarrow", flag.ContinueOnError)
+	var vlist string
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&outdir, "outdir", "", "directory for the per-bucket Arrow files")
+	fs.StringVar(&outfile, "merge", "", "write all buckets to this single Arrow file")
+	fs.StringVar(&format, "format", "file", "IPC format, file (Feather v2) or stream")
+	fs.StringVar(&vlist, "vars", "", "comma-separated variables to write (default all)")
+	fs.BoolVar(&decode, "decode", true, "write labels for factor-coded variables")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" || (outdir == "") == (outfile == "") || (format != "file" && format != "stream") {
+		return cli.Usage("usage:\nexportarrow -sourcedir=... (-outdir=... | -merge=...) [-format=file|stream] [-vars=...] [-decode=false]\n\n")
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	if vlist != "" {
+		vars = strings.Split(vlist, ",")
+	} else {
+		dtypes, err := config.ReadDtypes(0, sourcedir)
+		if err != nil {
+			return err
+		}
+		for vn := range dtypes {
+			vars = append(vars, vn)
+		}
+		sort.Strings(vars)
+	}
+
+	conv, err = arrowcols.NewConverter(sourcedir, vars, decode)
+	if err != nil {
+		return err
+	}
+	defer conv.Release()
+
+	if outfile != "" {
+		err = domerge()
+	} else {
+		if err := os.MkdirAll(outdir, 0755); err != nil {
+			return err
+		}
+		err = dosplit()
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+// Exportparquet writes a data set as Apache Parquet.  By default each
+// bucket is written to its own file, part-NNNN.parquet, in the output
+// directory.  With -merge, all buckets are written to a single file,
+// with one row group per bucket.
+//
+// Unsigned integer, varint and float columns are mapped to the
+// corresponding Parquet integer and floating point types.  Factor-coded
+// variables are written as dictionary-encoded strings holding their
+// labels, unless -decode=false is given, in which case their codes are
+// written.  The gocols data type of each column, and the labels of
+// factor-coded variables that are not decoded, are recorded in the
+// Arrow schema stored in the file.
+
+package exportparquet
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/compress"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/kshedden/gocols/arrowcols"
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The directory where the Parquet files are written
+	outdir string
+
+	// The file written with -merge
+	outfile string
+
+	// The variables to write
+	vars []string
+
+	// If true, write factor-coded variables using their labels
+	decode bool
+
+	// Configuration information for the data set
+	conf *config.Config
+
+	// Reads the buckets as Arrow records
+	conv *arrowcols.Converter
+)
+
+// newwriter creates a Parquet file.
+func newwriter(fn string) (*pqarrow.FileWriter, error) {
+
+	fid, err := os.Create(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	props := parquet.NewWriterProperties(parquet.WithCompression(compress.Codecs.Snappy))
+	arrprops := pqarrow.NewArrowWriterProperties(pqarrow.WithStoreSchema())
+
+	wtr, err := pqarrow.NewFileWriter(conv.Schema, fid, props, arrprops)
+	if err != nil {
+		fid.Close()
+		return nil, err
+	}
+
+	return wtr, nil
+}
+
+// writebucket writes one bucket as a row group.
+func writebucket(wtr *pqarrow.FileWriter, bn int) error {
+
+	rec, err := conv.Bucket(bn)
+	if err != nil {
+		return err
+	}
+	defer rec.Release()
+
+	// Write creates a new row group for each record.
+	return wtr.Write(rec)
+}
+
+// dosplit writes each bucket to its own file.
+func dosplit() error {
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		wtr, err := newwriter(path.Join(outdir, fmt.Sprintf("part-%04d.parquet", k)))
+		if err != nil {
+			return err
+		}
+		if err := writebucket(wtr, k); err != nil {
+			wtr.Close()
+			return err
+		}
+		if err := wtr.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// domerge writes all buckets to a single file.
+func domerge() error {
+
+	wtr, err := newwriter(outfile)
+	if err != nil {
+		return err
+	}
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		if err := writebucket(wtr, k); err != nil {
+			wtr.Close()
+			return err
+		}
+	}
+
+	return wtr.Close()
+}
+
+// Run runs exportparquet with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("exportparquet", flag.ContinueOnError)
+	var vlist string
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&outdir, "outdir", "", "directory for the per-bucket Parquet files")
+	fs.StringVar(&outfile, "merge", "", "write all buckets to this single Parquet file")
+	fs.StringVar(&vlist, "vars", "", "comma-separated variables to write (default all)")
+	fs.BoolVar(&decode, "decode", true, "write labels for factor-coded variables")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" || (outdir == "") == (outfile == "") {
+		return cli.Usage("usage:\nexportparquet -sourcedir=... (-outdir=... | -merge=...) [-vars=...] [-decode=false]\n\n")
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	if vlist != "" {
+		vars = strings.Split(vlist, ",")
+	} else {
+		dtypes, err := config.ReadDtypes(0, sourcedir)
+		if err != nil {
+			return err
+		}
+		for vn := range dtypes {
+			vars = append(vars, vn)
+		}
+		sort.Strings(vars)
+	}
+
+	conv, err = arrowcols.NewConverter(sourcedir, vars, decode)
+	if err != nil {
+		return err
+	}
+	defer conv.Release()
+
+	if outfile != "" {
+		err = domerge()
+	} else {
+		if err := os.MkdirAll(outdir, 0755); err != nil {
+			return err
+		}
+		err = dosplit()
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
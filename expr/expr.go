@@ -0,0 +1,210 @@
+// Package expr evaluates arithmetic and logical expressions involving
+// the variables of a data set, for example "age >= 65 && income <
+// 20000".  Expressions use Go syntax, and are limited to numeric
+// literals, variable names, parentheses, the arithmetic operators
+// + - * / %, the comparison operators == != < <= > >=, and the logical
+// operators && || !, and the functions abs, exp, log, sqrt and pow
+// (e.g. "weight / pow(height, 2)").  All values are float64, with
+// logical values represented as 1 (true) and 0 (false).
+
+package expr
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math"
+	"strconv"
+)
+
+// Expr is a compiled expression.
+type Expr struct {
+
+	// The names of the variables referenced in the expression, in
+	// order of first appearance
+	Vars []string
+
+	eval func(vals []float64) float64
+}
+
+// Parse compiles an expression.
+func Parse(s string) (*Expr, error) {
+
+	tree, err := parser.ParseExpr(s)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse expression %q: %v", s, err)
+	}
+
+	e := new(Expr)
+	e.eval, err = e.compile(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// Eval evaluates the expression, where vals[j] is the value of the
+// variable Vars[j].
+func (e *Expr) Eval(vals []float64) float64 {
+	return e.eval(vals)
+}
+
+// Test returns true if the expression evaluates to a non-zero value.
+func (e *Expr) Test(vals []float64) bool {
+	return e.eval(vals) != 0
+}
+
+// varpos returns the position of a variable in e.Vars, adding it if
+// needed.
+func (e *Expr) varpos(name string) int {
+	for j, vn := range e.Vars {
+		if vn == name {
+			return j
+		}
+	}
+	e.Vars = append(e.Vars, name)
+	return len(e.Vars) - 1
+}
+
+func bool2float(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// compile converts a syntax tree into a function.
+func (e *Expr) compile(node ast.Expr) (func([]float64) float64, error) {
+
+	switch n := node.(type) {
+
+	case *ast.ParenExpr:
+		return e.compile(n.X)
+
+	case *ast.Ident:
+		j := e.varpos(n.Name)
+		return func(v []float64) float64 { return v[j] }, nil
+
+	case *ast.BasicLit:
+		if n.Kind != token.INT && n.Kind != token.FLOAT {
+			return nil, fmt.Errorf("unsupported literal %s", n.Value)
+		}
+		x, err := strconv.ParseFloat(n.Value, 64)
+		if err != nil {
+			return nil, err
+		}
+		return func([]float64) float64 { return x }, nil
+
+	case *ast.UnaryExpr:
+		f, err := e.compile(n.X)
+		if err != nil {
+			return nil, err
+		}
+		switch n.Op {
+		case token.SUB:
+			return func(v []float64) float64 { return -f(v) }, nil
+		case token.ADD:
+			return f, nil
+		case token.NOT:
+			return func(v []float64) float64 { return bool2float(f(v) == 0) }, nil
+		}
+		return nil, fmt.Errorf("unsupported operator %s", n.Op)
+
+	case *ast.BinaryExpr:
+		f, err := e.compile(n.X)
+		if err != nil {
+			return nil, err
+		}
+		g, err := e.compile(n.Y)
+		if err != nil {
+			return nil, err
+		}
+		return binary(n.Op, f, g)
+
+	case *ast.CallExpr:
+		return e.call(n)
+	}
+
+	return nil, fmt.Errorf("unsupported expression of type %T", node)
+}
+
+// funcs holds the functions of one argument.
+var funcs = map[string]func(float64) float64{
+	"abs":  math.Abs,
+	"exp":  math.Exp,
+	"log":  math.Log,
+	"sqrt": math.Sqrt,
+}
+
+// call compiles a function call.
+func (e *Expr) call(n *ast.CallExpr) (func([]float64) float64, error) {
+
+	id, ok := n.Fun.(*ast.Ident)
+	if !ok {
+		return nil, fmt.Errorf("unsupported function call")
+	}
+
+	var args []func([]float64) float64
+	for _, a := range n.Args {
+		f, err := e.compile(a)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, f)
+	}
+
+	if id.Name == "pow" {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("pow takes 2 arguments, not %d", len(args))
+		}
+		f, g := args[0], args[1]
+		return func(v []float64) float64 { return math.Pow(f(v), g(v)) }, nil
+	}
+
+	h, ok := funcs[id.Name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", id.Name)
+	}
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s takes 1 argument, not %d", id.Name, len(args))
+	}
+	f := args[0]
+	return func(v []float64) float64 { return h(f(v)) }, nil
+}
+
+// binary returns a function applying a binary operator.
+func binary(op token.Token, f, g func([]float64) float64) (func([]float64) float64, error) {
+
+	switch op {
+	case token.ADD:
+		return func(v []float64) float64 { return f(v) + g(v) }, nil
+	case token.SUB:
+		return func(v []float64) float64 { return f(v) - g(v) }, nil
+	case token.MUL:
+		return func(v []float64) float64 { return f(v) * g(v) }, nil
+	case token.QUO:
+		return func(v []float64) float64 { return f(v) / g(v) }, nil
+	case token.REM:
+		return func(v []float64) float64 { return math.Mod(f(v), g(v)) }, nil
+	case token.EQL:
+		return func(v []float64) float64 { return bool2float(f(v) == g(v)) }, nil
+	case token.NEQ:
+		return func(v []float64) float64 { return bool2float(f(v) != g(v)) }, nil
+	case token.LSS:
+		return func(v []float64) float64 { return bool2float(f(v) < g(v)) }, nil
+	case token.LEQ:
+		return func(v []float64) float64 { return bool2float(f(v) <= g(v)) }, nil
+	case token.GTR:
+		return func(v []float64) float64 { return bool2float(f(v) > g(v)) }, nil
+	case token.GEQ:
+		return func(v []float64) float64 { return bool2float(f(v) >= g(v)) }, nil
+	case token.LAND:
+		return func(v []float64) float64 { return bool2float(f(v) != 0 && g(v) != 0) }, nil
+	case token.LOR:
+		return func(v []float64) float64 { return bool2float(f(v) != 0 || g(v) != 0) }, nil
+	}
+
+	return nil, fmt.Errorf("unsupported operator %s", op)
+}
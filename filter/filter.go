@@ -0,0 +1,233 @@
+// Filter creates a copy of a columnized dataset, retaining only those
+// records for which a logical expression involving the variables is
+// true, e.g. -where="age >= 65 && income < 20000".  See the expr
+// package for the supported expressions.  The numbers of rows scanned
+// and retained in each bucket are saved in targetdir as report.json
+// (see the report package).
+
+package filter
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+	"github.com/kshedden/gocols/expr"
+	"github.com/kshedden/gocols/report"
+	"github.com/kshedden/gocols/subset"
+)
+
+var (
+	// The selection criterion
+	where *expr.Expr
+
+	// The directory where the selected data will be stored
+	targetdir string
+
+	// The directory where the full data are stored
+	sourcedir string
+
+	// Configuration information for the source data
+	conf *config.Config
+
+	// If true, overwrite existing files
+	replace bool
+
+	// Copies the selected rows to the target directory
+	copier *subset.Copier
+
+	// The report saved in the target directory
+	rpt *report.Report
+
+	// The total numbers of rows selected and scanned
+	nsel, nrow int
+	mut        sync.Mutex
+
+	// The number of buckets processed in parallel
+	concurrency int
+
+	sem chan bool
+
+	// The first error from any bucket
+	firsterr error
+	errmut   sync.Mutex
+)
+
+// seterr records an error from a bucket, if it is the first one.
+func seterr(err error) {
+	errmut.Lock()
+	if firsterr == nil {
+		firsterr = err
+	}
+	errmut.Unlock()
+}
+
+// getix returns a boolean vector indicating which rows satisfy the
+// selection criterion.
+func getix(bn int) ([]bool, error) {
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return nil, err
+	}
+
+	rdrs := make([]*config.ColumnReader, len(where.Vars))
+	for j, vn := range where.Vars {
+		dt, ok := dtypes[vn]
+		if !ok {
+			return nil, fmt.Errorf("Variable %s not found in bucket %d", vn, bn)
+		}
+		rdrs[j], err = config.OpenReader(bn, sourcedir, vn, dt)
+		if err != nil {
+			return nil, err
+		}
+		defer rdrs[j].Close()
+	}
+
+	ix := make([]bool, 0, config.RowsHint(bn, sourcedir))
+	if len(rdrs) == 0 {
+		// The expression is constant, so only the number of rows
+		// is needed.
+		n, err := config.NumRows(bn, sourcedir)
+		if err != nil {
+			return nil, err
+		}
+		ix = make([]bool, n)
+		f := where.Test(nil)
+		for i := range ix {
+			ix[i] = f
+		}
+	}
+
+	vals := make([]float64, len(rdrs))
+	for len(rdrs) > 0 {
+		var err error
+		for j := range rdrs {
+			vals[j], err = rdrs[j].Float()
+			if err == io.EOF && j == 0 {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		ix = append(ix, where.Test(vals))
+	}
+	if err := config.CheckRows(bn, sourcedir, len(ix)); err != nil {
+		return nil, err
+	}
+
+	var m int
+	for _, f := range ix {
+		if f {
+			m++
+		}
+	}
+	mut.Lock()
+	nsel += m
+	nrow += len(ix)
+	mut.Unlock()
+
+	return ix, nil
+}
+
+// dobucket does the selection on one bucket
+func dobucket(bn int) {
+
+	defer func() { <-sem }()
+
+	ix, err := getix(bn)
+	if err != nil {
+		seterr(err)
+		return
+	}
+	if err := copier.CopyBucket(bn, ix); err != nil {
+		seterr(err)
+		return
+	}
+	rpt.Mask(bn, ix)
+}
+
+// Run runs filter with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("filter", flag.ContinueOnError)
+	var wh string
+	fs.StringVar(&wh, "where", "", "selection criterion")
+	fs.StringVar(&targetdir, "targetdir", "", "destination directory")
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.BoolVar(&replace, "replace", false, "overwrite existing files")
+	fs.IntVar(&concurrency, "concurrency", runtime.NumCPU(), "number of buckets or variables processed in parallel")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	nsel, nrow = 0, 0
+	firsterr = nil
+
+	if concurrency < 1 {
+		return errors.New("-concurrency must be positive")
+	}
+
+	if wh == "" || targetdir == "" || sourcedir == "" {
+		return cli.Usage("usage:\nfilter -where=... -targetdir=... -sourcedir=...\n\n")
+	}
+
+	var err error
+	where, err = expr.Parse(wh)
+	if err != nil {
+		return err
+	}
+
+	if !replace {
+		_, err := os.Stat(targetdir)
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("Use -replace=true to overwrite existing contents of %s", targetdir)
+		}
+	}
+
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	copier = &subset.Copier{SourceDir: sourcedir, TargetDir: targetdir}
+	if err := copier.Setup(conf); err != nil {
+		return err
+	}
+
+	rpt = report.New(fs, args, sourcedir, targetdir, conf.NumBuckets)
+
+	sem = make(chan bool, concurrency)
+	copier.Sem = sem
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		sem <- true
+		go dobucket(k)
+	}
+
+	for k := 0; k < concurrency; k++ {
+		sem <- true
+	}
+
+	if firsterr != nil {
+		return firsterr
+	}
+
+	if err := rpt.Write(nil); err != nil {
+		return err
+	}
+
+	slog.Info("selected rows", "selected", nsel, "rows", nrow)
+
+	return nil
+}
@@ -0,0 +1,441 @@
+// Flightserver serves a data set as Apache Arrow Flight streams, so
+// that clients such as pyarrow can read selections of it directly
+// into Arrow tables or pandas data frames:
+//
+//	client = pyarrow.flight.connect("grpc://localhost:8815")
+//	cmd = json.dumps({"Vars": ["id", "age"], "IdVar": "id", "Ids": [5, 17]})
+//	info = client.get_flight_info(pyarrow.flight.FlightDescriptor.for_command(cmd))
+//	df = pandas.concat(client.do_get(ep.ticket).read_pandas() for ep in info.endpoints)
+//
+// A flight is described by a command holding a JSON object with the
+// optional fields Vars (the variables, default all), IdVar and Ids
+// (select the rows whose value of IdVar is among the Ids), Where (an
+// expression that the selected rows satisfy, see the expr package) and
+// Codes (if true, factor-coded variables are sent as codes rather than
+// as labels).  An empty command describes the whole data set.  The
+// flight has one endpoint per bucket, which can be read in parallel,
+// and ListFlights lists the whole data set.  The command may also be
+// used directly as a ticket, to read all the buckets in one stream.
+//
+// The columns are converted as in exportarrow (see arrowcols).  As in
+// select, a bucket is left out without reading its columns if the
+// range of IdVar recorded in its meta.json file, or its Bloom filter
+// built by index, shows that it holds none of the ids.
+//
+// The log goes to stderr unless -log names a file (see the logging
+// package).  With -v each bucket sent is logged with its number of
+// rows.
+
+package flightserver
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow/flight"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/kshedden/gocols/arrowcols"
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+	"github.com/kshedden/gocols/expr"
+	_ "github.com/kshedden/gocols/gcsstore"
+	_ "github.com/kshedden/gocols/httpstore"
+	_ "github.com/kshedden/gocols/s3store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// Configuration information for the data set
+	conf *config.Config
+
+	// The data types of the variables, from the first bucket
+	dtypes map[string]string
+
+	// The names of the variables, in alphabetical order
+	varnames []string
+
+	// Logging
+	logger *slog.Logger
+)
+
+// request describes a flight, and with Bucket set, one of its
+// endpoints.
+type request struct {
+	Vars  []string `json:",omitempty"`
+	IdVar string   `json:",omitempty"`
+	Ids   []uint64 `json:",omitempty"`
+	Where string   `json:",omitempty"`
+	Codes bool     `json:",omitempty"`
+
+	// The bucket read by an endpoint, or nil for all buckets
+	Bucket *int `json:",omitempty"`
+
+	where *expr.Expr
+}
+
+// parserequest decodes and checks a command or ticket.
+func parserequest(b []byte) (*request, error) {
+
+	req := new(request)
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, req); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "cannot parse request: %v", err)
+		}
+	}
+
+	if len(req.Vars) == 0 {
+		req.Vars = varnames
+	}
+	for _, vn := range req.Vars {
+		if _, ok := dtypes[vn]; !ok {
+			return nil, status.Errorf(codes.NotFound, "variable %s not found", vn)
+		}
+	}
+
+	if req.IdVar != "" {
+		dt, ok := dtypes[req.IdVar]
+		if !ok {
+			return nil, status.Errorf(codes.NotFound, "variable %s not found", req.IdVar)
+		}
+		if !strings.HasPrefix(dt, "uint") && dt != "uvarint" && dt != "delta-uvarint" {
+			return nil, status.Errorf(codes.InvalidArgument, "variable %s has dtype %s, not an unsigned integer type", req.IdVar, dt)
+		}
+		sort.Slice(req.Ids, func(i, j int) bool { return req.Ids[i] < req.Ids[j] })
+	} else if len(req.Ids) > 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "Ids were given without IdVar")
+	}
+
+	if req.Where != "" {
+		var err error
+		req.where, err = expr.Parse(req.Where)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		for _, vn := range req.where.Vars {
+			if _, ok := dtypes[vn]; !ok {
+				return nil, status.Errorf(codes.NotFound, "variable %s not found", vn)
+			}
+		}
+	}
+
+	if req.Bucket != nil && (*req.Bucket < 0 || *req.Bucket >= conf.NumBuckets) {
+		return nil, status.Errorf(codes.InvalidArgument, "bucket %d does not exist", *req.Bucket)
+	}
+
+	return req, nil
+}
+
+// hasid returns true if one of the requested ids, which are sorted, is
+// between lo and hi inclusive.
+func (req *request) hasid(lo, hi uint64) bool {
+	k := sort.Search(len(req.Ids), func(i int) bool { return req.Ids[i] >= lo })
+	return k < len(req.Ids) && req.Ids[k] <= hi
+}
+
+// skippable returns true if the range recorded in meta.json or the
+// Bloom filter built by index shows that a bucket holds none of the
+// requested ids.
+func (req *request) skippable(bn int) (bool, error) {
+
+	if req.IdVar == "" {
+		return false, nil
+	}
+	if len(req.Ids) == 0 {
+		return true, nil
+	}
+
+	if meta, err := config.ReadMeta(bn, sourcedir); err == nil {
+		if r, ok := meta.Range(req.IdVar); ok && !req.hasid(r.Min, r.Max) {
+			return true, nil
+		}
+	}
+
+	if len(req.Ids) > 1<<16 {
+		return false, nil
+	}
+	b, err := config.ReadBloom(bn, sourcedir, req.IdVar)
+	if err != nil || b == nil {
+		return false, err
+	}
+	for _, x := range req.Ids {
+		if b.Test(x) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// mask returns the rows of a bucket that are selected, nil if all rows
+// are selected, and false if no row is selected.
+func (req *request) mask(bn int) ([]bool, bool, error) {
+
+	if req.IdVar == "" && req.where == nil {
+		return nil, true, nil
+	}
+	if skip, err := req.skippable(bn); err != nil || skip {
+		return nil, false, err
+	}
+
+	var idr *config.ColumnReader
+	if req.IdVar != "" {
+		var err error
+		idr, err = config.OpenReader(bn, sourcedir, req.IdVar, dtypes[req.IdVar])
+		if err != nil {
+			return nil, false, err
+		}
+		defer idr.Close()
+	}
+
+	var wrdrs []*config.ColumnReader
+	if req.where != nil {
+		for _, vn := range req.where.Vars {
+			rdr, err := config.OpenReader(bn, sourcedir, vn, dtypes[vn])
+			if err != nil {
+				return nil, false, err
+			}
+			defer rdr.Close()
+			wrdrs = append(wrdrs, rdr)
+		}
+	}
+
+	n, err := config.NumRows(bn, sourcedir)
+	if err != nil {
+		return nil, false, err
+	}
+
+	ix := make([]bool, n)
+	vals := make([]float64, len(wrdrs))
+	var m int
+	for i := range ix {
+		ix[i] = true
+		if idr != nil {
+			x, err := idr.Uint()
+			if err != nil {
+				return nil, false, err
+			}
+			ix[i] = req.hasid(x, x)
+		}
+		if req.where != nil {
+			for j, rdr := range wrdrs {
+				if vals[j], err = rdr.Float(); err != nil {
+					return nil, false, err
+				}
+			}
+			ix[i] = ix[i] && req.where.Test(vals)
+		}
+		if ix[i] {
+			m++
+		}
+	}
+
+	return ix, m > 0, nil
+}
+
+// server implements the Flight service.
+type server struct {
+	flight.BaseFlightServer
+}
+
+// flightinfo describes the flight for a command.
+func flightinfo(desc *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+
+	req, err := parserequest(desc.Cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	conv, err := arrowcols.NewConverter(sourcedir, req.Vars, !req.Codes)
+	if err != nil {
+		return nil, err
+	}
+	defer conv.Release()
+
+	info := &flight.FlightInfo{
+		Schema:           flight.SerializeSchema(conv.Schema, memory.DefaultAllocator),
+		FlightDescriptor: desc,
+		TotalRecords:     -1,
+		TotalBytes:       -1,
+		Ordered:          true,
+	}
+
+	var nrows int64
+	for bn := 0; bn < conf.NumBuckets; bn++ {
+		if skip, err := req.skippable(bn); err != nil {
+			return nil, err
+		} else if skip {
+			continue
+		}
+
+		k := bn
+		req.Bucket = &k
+		ticket, err := json.Marshal(req)
+		if err != nil {
+			return nil, err
+		}
+		info.Endpoint = append(info.Endpoint, &flight.FlightEndpoint{Ticket: &flight.Ticket{Ticket: ticket}})
+
+		if n, err := config.NumRows(bn, sourcedir); err == nil {
+			nrows += int64(n)
+		}
+	}
+	if req.IdVar == "" && req.where == nil {
+		info.TotalRecords = nrows
+	}
+
+	return info, nil
+}
+
+func (s *server) GetFlightInfo(ctx context.Context, desc *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+
+	if desc.Type != flight.DescriptorCMD {
+		return nil, status.Errorf(codes.InvalidArgument, "flights are described by commands")
+	}
+
+	return flightinfo(desc)
+}
+
+func (s *server) GetSchema(ctx context.Context, desc *flight.FlightDescriptor) (*flight.SchemaResult, error) {
+
+	req, err := parserequest(desc.Cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	conv, err := arrowcols.NewConverter(sourcedir, req.Vars, !req.Codes)
+	if err != nil {
+		return nil, err
+	}
+	defer conv.Release()
+
+	return &flight.SchemaResult{Schema: flight.SerializeSchema(conv.Schema, memory.DefaultAllocator)}, nil
+}
+
+func (s *server) ListFlights(crit *flight.Criteria, stream flight.FlightService_ListFlightsServer) error {
+
+	info, err := flightinfo(&flight.FlightDescriptor{Type: flight.DescriptorCMD, Cmd: []byte("{}")})
+	if err != nil {
+		return err
+	}
+
+	return stream.Send(info)
+}
+
+func (s *server) DoGet(ticket *flight.Ticket, stream flight.FlightService_DoGetServer) error {
+
+	req, err := parserequest(ticket.Ticket)
+	if err != nil {
+		return err
+	}
+
+	conv, err := arrowcols.NewConverter(sourcedir, req.Vars, !req.Codes)
+	if err != nil {
+		return err
+	}
+	defer conv.Release()
+
+	wtr := flight.NewRecordWriter(stream, ipc.WithSchema(conv.Schema))
+	defer wtr.Close()
+
+	for bn := 0; bn < conf.NumBuckets; bn++ {
+		if req.Bucket != nil && bn != *req.Bucket {
+			continue
+		}
+		if err := stream.Context().Err(); err != nil {
+			return err
+		}
+
+		ix, ok, err := req.mask(bn)
+		if err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+
+		rec, err := conv.Select(bn, ix)
+		if err != nil {
+			return err
+		}
+		logger.Debug("sending bucket", "bucket", bn, "rows", rec.NumRows())
+		err = wtr.Write(rec)
+		rec.Release()
+		if err == io.EOF {
+			// The client went away.
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run runs flightserver with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("flightserver", flag.ContinueOnError)
+	var addr, cert, key string
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&addr, "addr", "localhost:8815", "address to listen on")
+	fs.StringVar(&cert, "cert", "", "TLS certificate file")
+	fs.StringVar(&key, "key", "", "TLS key file")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	varnames = nil
+
+	if sourcedir == "" || (cert == "") != (key == "") {
+		return cli.Usage("usage:\nflightserver -sourcedir=... [-addr=host:port] [-cert=... -key=...]\n\n")
+	}
+
+	logger = slog.Default()
+
+	var err error
+
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	dtypes, err = config.ReadDtypes(0, sourcedir)
+	if err != nil {
+		return err
+	}
+	for vn := range dtypes {
+		varnames = append(varnames, vn)
+	}
+	sort.Strings(varnames)
+
+	var opts []grpc.ServerOption
+	if cert != "" {
+		creds, err := credentials.NewServerTLSFromFile(cert, key)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	srv := flight.NewServerWithMiddleware(nil, opts...)
+	srv.RegisterFlightService(&server{})
+	if err := srv.Init(addr); err != nil {
+		return err
+	}
+
+	logger.Info("serving Arrow Flight streams", "sourcedir", sourcedir, "addr", srv.Addr().String())
+	err = srv.Serve()
+	logger.Error("server stopped", "err", err)
+	return cli.Status(1)
+}
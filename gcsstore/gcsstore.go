@@ -0,0 +1,208 @@
+// Package gcsstore gives access to data sets kept in Google Cloud
+// Storage, under paths such as gs://bucket/prefix.  Importing the
+// package registers the storage with the config package, after which
+// the functions of config accept such paths wherever they accept a
+// directory:
+//
+//	import _ "github.com/kshedden/gocols/gcsstore"
+//
+// The credentials are found as by the gcloud command line tools, from
+// GOOGLE_APPLICATION_CREDENTIALS, the application default credentials
+// or the metadata server.  Files are read as streams and written by
+// resumable uploads, so no file is held whole in memory or on local
+// disk.
+package gcsstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/kshedden/gocols/config"
+	"google.golang.org/api/iterator"
+)
+
+// store implements config.Storage for GCS.  The client is created when
+// first needed, so that programs that never use GCS need no Google
+// credentials.
+type store struct {
+	once   sync.Once
+	client *storage.Client
+	err    error
+}
+
+func init() {
+	config.RegisterStorage("gs", &store{})
+}
+
+// setup creates the client.
+func (s *store) setup() error {
+
+	s.once.Do(func() {
+		s.client, s.err = storage.NewClient(context.Background())
+		if s.err != nil {
+			s.err = fmt.Errorf("cannot create the GCS client: %v", s.err)
+		}
+	})
+
+	return s.err
+}
+
+// object returns the handle of the object named by a gs:// URL.
+func (s *store) object(name string) (*storage.ObjectHandle, error) {
+
+	if err := s.setup(); err != nil {
+		return nil, err
+	}
+
+	u := strings.TrimPrefix(name, "gs://")
+	i := strings.Index(u, "/")
+	if i <= 0 {
+		return nil, fmt.Errorf("invalid GCS path %s, expected gs://bucket/key", name)
+	}
+
+	return s.client.Bucket(u[0:i]).Object(u[i+1:]), nil
+}
+
+// notexist converts the error for a missing object into one for which
+// os.IsNotExist is true.
+func notexist(op, name string, err error) error {
+
+	if errors.Is(err, storage.ErrObjectNotExist) || errors.Is(err, storage.ErrBucketNotExist) {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+
+	return err
+}
+
+func (s *store) Open(name string) (io.ReadCloser, error) {
+
+	obj, err := s.object(name)
+	if err != nil {
+		return nil, err
+	}
+
+	rdr, err := obj.NewReader(context.Background())
+	if err != nil {
+		return nil, notexist("open", name, err)
+	}
+
+	return rdr, nil
+}
+
+func (s *store) Create(name string) (io.WriteCloser, error) {
+
+	obj, err := s.object(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return obj.NewWriter(context.Background()), nil
+}
+
+// fileinfo describes an object.
+type fileinfo struct {
+	name    string
+	size    int64
+	modtime time.Time
+}
+
+func (fi *fileinfo) Name() string       { return fi.name }
+func (fi *fileinfo) Size() int64        { return fi.size }
+func (fi *fileinfo) Mode() os.FileMode  { return 0644 }
+func (fi *fileinfo) ModTime() time.Time { return fi.modtime }
+func (fi *fileinfo) IsDir() bool        { return false }
+func (fi *fileinfo) Sys() interface{}   { return nil }
+
+func (s *store) Stat(name string) (os.FileInfo, error) {
+
+	obj, err := s.object(name)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := obj.Attrs(context.Background())
+	if err != nil {
+		return nil, notexist("stat", name, err)
+	}
+
+	key := attrs.Name
+	return &fileinfo{
+		name:    key[strings.LastIndex(key, "/")+1:],
+		size:    attrs.Size,
+		modtime: attrs.Updated,
+	}, nil
+}
+
+func (s *store) Remove(name string) error {
+
+	obj, err := s.object(name)
+	if err != nil {
+		return err
+	}
+
+	return notexist("remove", name, obj.Delete(context.Background()))
+}
+
+// Rename copies the object to its new name and deletes the original,
+// since GCS cannot move objects.
+func (s *store) Rename(oldname, newname string) error {
+
+	src, err := s.object(oldname)
+	if err != nil {
+		return err
+	}
+	dst, err := s.object(newname)
+	if err != nil {
+		return err
+	}
+
+	if _, err := dst.CopierFrom(src).Run(context.Background()); err != nil {
+		return notexist("rename", oldname, err)
+	}
+
+	return s.Remove(oldname)
+}
+
+func (s *store) ReadDir(name string) ([]string, error) {
+
+	if err := s.setup(); err != nil {
+		return nil, err
+	}
+	u := strings.TrimPrefix(strings.TrimSuffix(name, "/")+"/", "gs://")
+	i := strings.Index(u, "/")
+	if i <= 0 {
+		return nil, fmt.Errorf("invalid GCS path %s, expected gs://bucket/prefix", name)
+	}
+	prefix := u[i+1:]
+
+	var names []string
+	it := s.client.Bucket(u[0:i]).Objects(context.Background(), &storage.Query{
+		Prefix:    prefix,
+		Delimiter: "/",
+	})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, notexist("readdir", name, err)
+		}
+		// Entries for subdirectories only have a prefix.
+		if attrs.Name == "" {
+			continue
+		}
+		names = append(names, strings.TrimPrefix(attrs.Name, prefix))
+	}
+	if len(names) == 0 {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrNotExist}
+	}
+
+	return names, nil
+}
@@ -0,0 +1,314 @@
+// Gen creates a synthetic data set with a given schema, populated
+// with random values of the appropriate types.  This is useful for
+// testing and for demonstrating the tools.
+//
+// The schema is a comma-separated list of name:dtype pairs, for example
+// "id:uint64,age:uint8,income:float64,state:string".  String variables
+// are factor-coded, with labels L0, L1, ... stored in the Codes
+// directory.  Bool variables are true or false with equal probability,
+// and date32 and timestamp64 variables hold random dates and times in
+// the years 2000 to 2029.  If -idvar names a variable in the
+// schema, it holds the record numbers 0, 1, 2, ... and records are
+// placed in buckets by modulo routing, which is recorded in the
+// configuration.
+// Otherwise records are assigned to buckets in round-robin order.
+
+package gen
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory where the data set will be written
+	targetdir string
+
+	// The names and data types of the variables
+	names, dtypes []string
+
+	// The number of records
+	nrows int
+
+	// The number of buckets
+	nbuckets int
+
+	// The number of levels of each string variable
+	nlevels int
+
+	// Seed for the random number generator
+	seed int64
+
+	// The variable holding the record numbers, if any
+	idvar string
+
+	// The compression of the column files
+	compression string
+
+	// If true, overwrite existing files
+	replace bool
+)
+
+// parseschema reads the variable names and data types.
+func parseschema(schema string) error {
+
+	for _, f := range strings.Split(schema, ",") {
+		v := strings.Split(f, ":")
+		if len(v) != 2 {
+			return fmt.Errorf("Invalid schema entry %s, expected name:dtype", f)
+		}
+		_, ok := config.DTsize[v[1]]
+		switch v[1] {
+		case "uvarint", "varint", "delta-uvarint", "string", "bool":
+			ok = true
+		}
+		if !ok {
+			return fmt.Errorf("Unsupported dtype %s for variable %s", v[1], v[0])
+		}
+		names = append(names, v[0])
+		dtypes = append(dtypes, v[1])
+	}
+
+	return nil
+}
+
+// codetype returns the smallest data type holding the codes of a
+// string variable.
+func codetype() string {
+	switch {
+	case nlevels <= math.MaxUint8+1:
+		return "uint8"
+	case nlevels <= math.MaxUint16+1:
+		return "uint16"
+	}
+	return "uint32"
+}
+
+// storedtype returns the data type used to store a variable.
+func storedtype(dt string) string {
+	if dt == "string" {
+		return codetype()
+	}
+	return dt
+}
+
+// writecodes saves the factor codes for the string variables.
+func writecodes(conf *config.Config) error {
+
+	err := os.MkdirAll(conf.CodesDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	codes := make(map[string]int)
+	for j := 0; j < nlevels; j++ {
+		codes[fmt.Sprintf("L%d", j)] = j
+	}
+
+	cf := make(map[string]string)
+	for j, vn := range names {
+		if dtypes[j] == "string" {
+			cf[vn] = vn
+			if err := config.WriteFactorCodes(vn, codes, conf); err != nil {
+				return err
+			}
+		}
+	}
+	if err := config.WriteCodeFiles(conf, cf); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// putvalue writes one random value of the given data type.
+func putvalue(w io.Writer, rng *rand.Rand, dt string) error {
+
+	var err error
+	switch dt {
+	case "uint8", "uint16", "uint32", "uint64":
+		nbits := 8 * uint(config.DTsize[dt])
+		err = config.WriteUint(w, dt, rng.Uint64()>>(64-nbits))
+	case "uvarint":
+		err = config.WriteUint(w, dt, uint64(rng.Int63n(1000000)))
+	case "delta-uvarint":
+		// Store the differences of an increasing sequence.
+		err = config.WriteUint(w, "uvarint", uint64(rng.Int63n(100)))
+	case "varint":
+		var b [binary.MaxVarintLen64]byte
+		m := binary.PutVarint(b[:], rng.Int63n(2000000)-1000000)
+		_, err = w.Write(b[0:m])
+	case "float32":
+		err = binary.Write(w, binary.LittleEndian, float32(rng.NormFloat64()))
+	case "float64":
+		err = binary.Write(w, binary.LittleEndian, rng.NormFloat64())
+	case "date32":
+		// Days from 2000-01-01 to 2029-12-31
+		err = config.WriteInt(w, dt, 10957+rng.Int63n(10958))
+	case "timestamp64":
+		err = config.WriteInt(w, dt, (10957+rng.Int63n(10958))*86400000000+rng.Int63n(86400000000))
+	case "string":
+		err = config.WriteUint(w, codetype(), uint64(rng.Intn(nlevels)))
+	case "bool":
+		err = w.(*config.BoolWriter).Put(rng.Intn(2) == 1)
+	}
+
+	return err
+}
+
+// dobucket writes the records for one bucket.
+func dobucket(bn int) error {
+
+	err := os.MkdirAll(config.BucketPath(bn, targetdir), 0755)
+	if err != nil {
+		return err
+	}
+
+	dtm := make(map[string]string)
+	for j, vn := range names {
+		dtm[vn] = storedtype(dtypes[j])
+	}
+	if err := config.WriteDtypes(bn, targetdir, dtm); err != nil {
+		return err
+	}
+
+	var wtrs []io.WriteCloser
+	var fids []io.Closer
+	for j, vn := range names {
+		w, f, err := config.CreateColumn(bn, targetdir, vn)
+		if err != nil {
+			return err
+		}
+		if dtypes[j] == "bool" {
+			w = config.NewBoolWriter(w)
+		}
+		wtrs = append(wtrs, w)
+		fids = append(fids, f)
+	}
+
+	rng := rand.New(rand.NewSource(seed + int64(bn)))
+
+	// Records bn, bn+nbuckets, bn+2*nbuckets, ... belong to this
+	// bucket.
+	var n int
+	for i := bn; i < nrows; i += nbuckets {
+		n++
+		for j, vn := range names {
+			if vn == idvar {
+				err := config.WriteUint(wtrs[j], dtm[vn], uint64(i))
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			if err := putvalue(wtrs[j], rng, dtypes[j]); err != nil {
+				return err
+			}
+		}
+	}
+
+	for j := range wtrs {
+		if err := wtrs[j].Close(); err != nil {
+			return err
+		}
+		if err := fids[j].Close(); err != nil {
+			return err
+		}
+	}
+
+	if err := config.RecordRows(bn, targetdir, n); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Run runs gen with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("gen", flag.ContinueOnError)
+	var schema string
+	fs.StringVar(&schema, "schema", "", "comma-separated name:dtype pairs")
+	fs.IntVar(&nrows, "rows", 1000, "number of records")
+	fs.IntVar(&nbuckets, "buckets", 10, "number of buckets")
+	fs.IntVar(&nlevels, "levels", 10, "number of levels of each string variable")
+	fs.Int64Var(&seed, "seed", 1, "seed for the random number generator")
+	fs.StringVar(&idvar, "idvar", "", "variable holding the record numbers")
+	fs.StringVar(&targetdir, "targetdir", "", "destination directory")
+	fs.StringVar(&compression, "compression", config.DefaultCompression, "compression of the column files (snappy, gzip, zstd or none)")
+	fs.BoolVar(&replace, "replace", false, "overwrite existing files")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	names, dtypes = nil, nil
+
+	if schema == "" || targetdir == "" || nrows < 0 || nbuckets < 1 || nlevels < 1 {
+		return cli.Usage("usage:\ngen -schema=... -targetdir=... [-rows=...] [-buckets=...] [-levels=...] [-seed=...] [-idvar=...]\n\n")
+	}
+
+	if err := parseschema(schema); err != nil {
+		return err
+	}
+
+	if _, err := config.GetCodec(compression); err != nil {
+		return err
+	}
+
+	if idvar != "" {
+		ok := false
+		for j, vn := range names {
+			if vn == idvar {
+				ok = dtypes[j] == "uint32" || dtypes[j] == "uint64" || dtypes[j] == "uvarint"
+			}
+		}
+		if !ok {
+			return fmt.Errorf("The id variable %s must be in the schema with dtype uint32, uint64 or uvarint", idvar)
+		}
+	}
+
+	if !replace {
+		_, err := os.Stat(targetdir)
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("Use -replace=true to overwrite existing contents of %s", targetdir)
+		}
+	}
+
+	err := os.MkdirAll(targetdir, 0755)
+	if err != nil {
+		return err
+	}
+
+	conf := &config.Config{
+		NumBuckets:  nbuckets,
+		Compression: compression,
+		CodesDir:    path.Join(targetdir, "Codes"),
+	}
+	if idvar != "" {
+		conf.Routing = &config.Routing{IdVar: idvar, Method: "modulo"}
+	}
+	if err := config.WriteConfig(targetdir, conf); err != nil {
+		return err
+	}
+	if err := writecodes(conf); err != nil {
+		return err
+	}
+
+	for k := 0; k < nbuckets; k++ {
+		if err := dobucket(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package gen_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kshedden/gocols/coltest"
+	"github.com/kshedden/gocols/config"
+	"github.com/kshedden/gocols/gen"
+)
+
+func TestGen(t *testing.T) {
+
+	const schema = "id:uvarint,s:string,b:bool,u:uint16,v:varint,f:float64,d:date32"
+
+	dir := coltest.Dir(t, "data")
+	if err := gen.Run([]string{"-schema=" + schema, "-targetdir=" + dir, "-rows=25",
+		"-buckets=3", "-levels=3", "-idvar=id", "-q"}); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := config.GetConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.NumBuckets != 3 || conf.Routing == nil || conf.Routing.IdVar != "id" || conf.Routing.Method != "modulo" {
+		t.Fatalf("got configuration %+v", conf)
+	}
+
+	want := map[string]string{"id": "uvarint", "s": "uint8", "b": "bool", "u": "uint16",
+		"v": "varint", "f": "float64", "d": "date32"}
+	for k := 0; k < 3; k++ {
+		dtypes, err := config.ReadDtypes(k, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(dtypes, want) {
+			t.Errorf("bucket %d: got dtypes %v, expected %v", k, dtypes, want)
+		}
+		n, err := config.NumRows(k, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != []int{9, 8, 8}[k] {
+			t.Errorf("bucket %d has %d rows", k, n)
+		}
+	}
+
+	// Record i is in bucket i mod 3.
+	recs := coltest.Records(t, dir, "-vars=id,s,b")
+	if len(recs) != 25 {
+		t.Fatalf("got %d records, expected 25", len(recs))
+	}
+	var i int
+	for k := 0; k < 3; k++ {
+		for id := k; id < 25; id += 3 {
+			f := strings.Split(recs[i], ",")
+			if f[0] != fmt.Sprint(id) {
+				t.Errorf("record %d has id %s, expected %d", i, f[0], id)
+			}
+			if f[1] != "L0" && f[1] != "L1" && f[1] != "L2" {
+				t.Errorf("record %d has label %s", i, f[1])
+			}
+			if f[2] != "true" && f[2] != "false" {
+				t.Errorf("record %d has bool %s", i, f[2])
+			}
+			i++
+		}
+	}
+
+	// The same seed gives the same data.
+	dir2 := coltest.Dir(t, "data")
+	if err := gen.Run([]string{"-schema=" + schema, "-targetdir=" + dir2, "-rows=25",
+		"-buckets=3", "-levels=3", "-idvar=id", "-q"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := coltest.Records(t, dir2), coltest.Records(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, expected %q", got, want)
+	}
+}
+
+func TestGenErrors(t *testing.T) {
+
+	for _, tc := range []struct {
+		flags []string
+		err   string
+	}{
+		{[]string{"-schema=x"}, "Invalid schema entry x"},
+		{[]string{"-schema=x:int8"}, "Unsupported dtype int8 for variable x"},
+		{[]string{"-schema=x:float64", "-idvar=x"}, "The id variable x must be in the schema"},
+		{[]string{"-schema=x:uint8", "-idvar=y"}, "The id variable y must be in the schema"},
+		{[]string{"-schema=x:uint8", "-compression=lz4"}, `unknown compression "lz4"`},
+	} {
+		err := gen.Run(append([]string{"-targetdir=" + coltest.Dir(t, "data"), "-q"}, tc.flags...))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%v: got error %v, expected %q", tc.flags, err, tc.err)
+		}
+	}
+}
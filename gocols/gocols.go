@@ -0,0 +1,224 @@
+// Gocols runs the gocols tools as subcommands of a single program:
+//
+//	gocols select -idvar=id -idfile=ids.txt -sourcedir=... -targetdir=...
+//	gocols describe -sourcedir=...
+//	gocols help
+//	gocols help select
+//
+// Each tool is a package of this repository with a Run function, which
+// gocols calls with the remaining arguments (see package cli), so that
+// all the tools parse their flags, read job files, log and report
+// errors in the same way.  The exit status is 0 on success, 2 for an
+// invalid flag, and otherwise 1.
+//
+// Most commands are named after their packages.  Those with longer
+// names, such as check-range for package checkrange, can still be run
+// with the package name.
+//
+// The flags of every tool can also be given in a job file named with
+// -config, and in environment variables such as GOCOLS_SOURCEDIR; see
+// package jobconfig.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kshedden/gocols/addvar"
+	appendcmd "github.com/kshedden/gocols/append"
+	"github.com/kshedden/gocols/attrs"
+	"github.com/kshedden/gocols/bucketmanifest"
+	"github.com/kshedden/gocols/buildrouting"
+	"github.com/kshedden/gocols/cast"
+	"github.com/kshedden/gocols/changedrows"
+	"github.com/kshedden/gocols/checkdtypes"
+	"github.com/kshedden/gocols/checkglobalsort"
+	"github.com/kshedden/gocols/checkintegrity"
+	"github.com/kshedden/gocols/checkrange"
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/collapserare"
+	"github.com/kshedden/gocols/cols2csv"
+	"github.com/kshedden/gocols/colserver"
+	"github.com/kshedden/gocols/compact"
+	"github.com/kshedden/gocols/covariance"
+	"github.com/kshedden/gocols/csv2cols"
+	"github.com/kshedden/gocols/dedup"
+	"github.com/kshedden/gocols/deltauvarint"
+	"github.com/kshedden/gocols/describe"
+	"github.com/kshedden/gocols/diffsets"
+	"github.com/kshedden/gocols/dropvars"
+	"github.com/kshedden/gocols/exportarrow"
+	"github.com/kshedden/gocols/exportparquet"
+	"github.com/kshedden/gocols/filter"
+	"github.com/kshedden/gocols/flightserver"
+	"github.com/kshedden/gocols/gen"
+	"github.com/kshedden/gocols/head"
+	"github.com/kshedden/gocols/importparquet"
+	"github.com/kshedden/gocols/index"
+	"github.com/kshedden/gocols/jaccard"
+	"github.com/kshedden/gocols/join"
+	"github.com/kshedden/gocols/merge"
+	"github.com/kshedden/gocols/migrate"
+	"github.com/kshedden/gocols/project"
+	"github.com/kshedden/gocols/rebucket"
+	"github.com/kshedden/gocols/recode"
+	"github.com/kshedden/gocols/recompress"
+	"github.com/kshedden/gocols/rename"
+	"github.com/kshedden/gocols/rolling"
+	"github.com/kshedden/gocols/sample"
+	selectcmd "github.com/kshedden/gocols/select"
+	"github.com/kshedden/gocols/serve"
+	"github.com/kshedden/gocols/shard"
+	"github.com/kshedden/gocols/sizetofrac"
+	"github.com/kshedden/gocols/sortrows"
+	"github.com/kshedden/gocols/stats"
+	"github.com/kshedden/gocols/stride"
+	"github.com/kshedden/gocols/tolong"
+	"github.com/kshedden/gocols/validate"
+	"github.com/kshedden/gocols/varinfo"
+	"github.com/kshedden/gocols/verify"
+)
+
+// command describes a tool.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+// commands lists the tools, in alphabetical order.
+var commands = []command{
+	{"addvar", "add variables computed from existing ones", addvar.Run},
+	{"append", "add records to an existing data set", appendcmd.Run},
+	{"attrs", "show or change the attributes of a data set", attrs.Run},
+	{"bucket-manifest", "write a descriptor and summary for each bucket", bucketmanifest.Run},
+	{"build-routing", "find and record how records are routed to buckets", buildrouting.Run},
+	{"cast", "change the data type of a variable", cast.Run},
+	{"changed-rows", "list the ids added, modified or removed between versions", changedrows.Run},
+	{"check-dtype-consistency", "confirm that data types agree across buckets", checkdtypes.Run},
+	{"check-global-sort", "confirm that a data set is sorted across buckets", checkglobalsort.Run},
+	{"check-integrity", "confirm that every factor code has a label", checkintegrity.Run},
+	{"check-range", "report values outside of a declared range", checkrange.Run},
+	{"collapse-rare", "combine the rare levels of a factor-coded variable", collapserare.Run},
+	{"cols2csv", "write a data set as delimited text", cols2csv.Run},
+	{"colserver", "serve the columns of a data set over gRPC", colserver.Run},
+	{"compact", "store numeric variables with the smallest data types", compact.Run},
+	{"covariance", "compute the covariance matrix of numeric variables", covariance.Run},
+	{"csv2cols", "convert delimited text files into a data set", csv2cols.Run},
+	{"dedup", "copy a data set with duplicate records removed", dedup.Run},
+	{"delta-uvarint", "store a sorted variable as delta-uvarint", deltauvarint.Run},
+	{"describe", "print an overview of a data set", describe.Run},
+	{"diff", "report the differences between two data sets", diffsets.Run},
+	{"dropvars", "remove variables from a data set", dropvars.Run},
+	{"export-parquet", "write a data set as Parquet", exportparquet.Run},
+	{"exportarrow", "write a data set as Arrow IPC data", exportarrow.Run},
+	{"filter", "copy the records satisfying an expression", filter.Run},
+	{"flightserver", "serve a data set as Arrow Flight streams", flightserver.Run},
+	{"gen", "create a synthetic data set", gen.Run},
+	{"head", "print the first rows of a bucket", head.Run},
+	{"importparquet", "convert Parquet files into a data set", importparquet.Run},
+	{"index", "build Bloom filters or sorted indexes of an id variable", index.Run},
+	{"jaccard", "compute the Jaccard index of the values of two columns", jaccard.Run},
+	{"join", "add variables from another data set, matching on an id", join.Run},
+	{"merge", "combine data sets with the same variables", merge.Run},
+	{"migrate", "upgrade a data set to the current format version", migrate.Run},
+	{"project", "copy a data set retaining only some variables", project.Run},
+	{"rebucket", "copy a data set into a different number of buckets", rebucket.Run},
+	{"recode", "change the labels of a factor-coded variable", recode.Run},
+	{"recompress", "change the compression of variables", recompress.Run},
+	{"rename", "rename variables", rename.Run},
+	{"rolling", "compute a rolling aggregate of a variable", rolling.Run},
+	{"sample", "copy a random sample of the records", sample.Run},
+	{"select", "copy the records with given ids", selectcmd.Run},
+	{"serve", "serve a data set over HTTP", serve.Run},
+	{"shard", "split a data set by the levels of a factor", shard.Run},
+	{"size-to-frac", "estimate the sampling fraction for a target size", sizetofrac.Run},
+	{"sort", "sort the rows of each bucket", sortrows.Run},
+	{"stats", "compute summary statistics of the variables", stats.Run},
+	{"stride", "copy every k'th record", stride.Run},
+	{"to-long", "write a data set as CSV in long format", tolong.Run},
+	{"validate", "check the structure of a data set", validate.Run},
+	{"varinfo", "show or change the descriptions of the variables", varinfo.Run},
+	{"verify", "detect corrupted column files using their checksums", verify.Run},
+}
+
+// aliases maps the earlier names of commands, which are the names of
+// their packages, to the current ones.
+var aliases = map[string]string{
+	"bucketmanifest":  "bucket-manifest",
+	"buildrouting":    "build-routing",
+	"changedrows":     "changed-rows",
+	"checkdtypes":     "check-dtype-consistency",
+	"checkglobalsort": "check-global-sort",
+	"checkintegrity":  "check-integrity",
+	"checkrange":      "check-range",
+	"collapserare":    "collapse-rare",
+	"deltauvarint":    "delta-uvarint",
+	"diffsets":        "diff",
+	"exportparquet":   "export-parquet",
+	"sizetofrac":      "size-to-frac",
+	"sortrows":        "sort",
+	"tolong":          "to-long",
+}
+
+// find returns the command with the given name or alias, or nil.
+func find(name string) *command {
+	if a, ok := aliases[name]; ok {
+		name = a
+	}
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// usage prints the list of commands.
+func usage() {
+
+	var b strings.Builder
+	b.WriteString("usage:\ngocols <command> [flags]\ngocols help [command]\n\nThe commands are:\n\n")
+	for _, c := range commands {
+		fmt.Fprintf(&b, "    %-24s %s\n", c.name, c.summary)
+	}
+	b.WriteString("\nRun 'gocols help <command>' for the flags of a command.\n")
+	os.Stderr.WriteString(b.String())
+}
+
+// fail reports an error and exits.
+func fail(format string, args ...interface{}) {
+	os.Stderr.WriteString("gocols: " + fmt.Sprintf(format, args...) + "\n")
+	os.Exit(1)
+}
+
+func main() {
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	name, args := os.Args[1], os.Args[2:]
+
+	switch name {
+	case "help", "-h", "-help", "--help":
+		if len(args) == 0 {
+			usage()
+			return
+		}
+		c := find(args[0])
+		if c == nil {
+			fail("unknown command %q; run 'gocols help' for a list", args[0])
+		}
+		os.Exit(cli.Exit(c.run([]string{"-h"})))
+	}
+
+	c := find(name)
+	if c == nil {
+		fail("unknown command %q; run 'gocols help' for a list", name)
+	}
+	os.Exit(cli.Exit(c.run(args)))
+}
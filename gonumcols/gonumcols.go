@@ -0,0 +1,93 @@
+// Package gonumcols loads numeric variables of a data set into a gonum
+// matrix, with one column per variable and one row per record.  Values
+// of every numeric data type are converted to float64, and factor
+// codes are loaded as numbers.  The whole selection is held in memory,
+// so this is meant for modest numbers of rows, such as the rows of a
+// few buckets.
+package gonumcols
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/kshedden/gocols/colreader"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Missing describes the handling of missing (NaN) values.
+type Missing int
+
+const (
+	// KeepMissing loads missing values as NaN.
+	KeepMissing Missing = iota
+
+	// DropMissing leaves out rows with a missing value in any of the
+	// variables.
+	DropMissing
+
+	// ErrorMissing returns an error if any value is missing.
+	ErrorMissing
+)
+
+// chunksize is the number of rows read at a time.
+const chunksize = 10000
+
+// Options controls the loading of a matrix.
+type Options struct {
+
+	// The buckets to read, or all buckets if empty
+	Buckets []int
+
+	// The handling of missing values
+	Missing Missing
+}
+
+// Load returns a matrix holding the given variables, whose columns
+// are in the order of vars.  The rows are in bucket order.  A nil
+// opts uses the default options.
+func Load(ds *colreader.Dataset, vars []string, opts *Options) (*mat.Dense, error) {
+
+	if opts == nil {
+		opts = new(Options)
+	}
+
+	it := ds.Iter(vars, chunksize, opts.Buckets...)
+	defer it.Close()
+
+	p := len(vars)
+	var data []float64
+	cols := make([][]float64, p)
+	for it.Next() {
+		c := it.Chunk()
+		for j, vn := range vars {
+			cols[j] = c.Float64(vn)
+		}
+
+	row:
+		for i := 0; i < c.Len(); i++ {
+			for j := range cols {
+				if !math.IsNaN(cols[j][i]) {
+					continue
+				}
+				switch opts.Missing {
+				case DropMissing:
+					continue row
+				case ErrorMissing:
+					return nil, fmt.Errorf("variable %s is missing in row %d of bucket %d", vars[j], c.Offset+i, c.Bucket)
+				}
+			}
+			for j := range cols {
+				data = append(data, cols[j][i])
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return nil, fmt.Errorf("no rows were loaded")
+	}
+
+	return mat.NewDense(len(data)/p, p, data), nil
+}
@@ -0,0 +1,194 @@
+// Head prints the first rows of a bucket, for a quick look at the
+// data.  By default all variables are shown, in alphabetical order, as
+// an aligned table, and factor-coded variables are shown using their
+// labels.  Missing values are shown as NA.  With -csv, the rows are
+// written as CSV instead.
+
+package head
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The bucket to show
+	bucket int
+
+	// The number of rows to show
+	nrows int
+
+	// The variables to show
+	vars []string
+
+	// If true, show labels in place of factor codes
+	decode bool
+
+	// If true, write CSV instead of a table
+	ascsv bool
+
+	// Configuration information for the data set
+	conf *config.Config
+
+	// The reverse factor codes of the variables, nil for variables
+	// that are not decoded
+	labels []map[int]string
+)
+
+// readrows returns the first rows of the bucket, formatted as strings.
+func readrows() ([][]string, error) {
+
+	dtypes, err := config.ReadDtypes(bucket, sourcedir)
+	if err != nil {
+		return nil, err
+	}
+
+	rdrs := make([]*config.ColumnReader, len(vars))
+	nrdrs := make([]*config.NullReader, len(vars))
+	for j, vn := range vars {
+		dt, ok := dtypes[vn]
+		if !ok {
+			return nil, fmt.Errorf("variable %s not found in bucket %d", vn, bucket)
+		}
+		rdrs[j], err = config.OpenReader(bucket, sourcedir, vn, dt)
+		if err != nil {
+			return nil, err
+		}
+		defer rdrs[j].Close()
+		nrdrs[j], err = config.OpenNulls(bucket, sourcedir, vn)
+		if err != nil {
+			return nil, err
+		}
+		defer nrdrs[j].Close()
+	}
+
+	var rows [][]string
+	for i := 0; i < nrows; i++ {
+		rec := make([]string, len(vars))
+		for j := range rdrs {
+			if labels[j] != nil {
+				var x uint64
+				x, err = rdrs[j].Uint()
+				lab, ok := labels[j][int(x)]
+				if ok {
+					rec[j] = lab
+				} else {
+					rec[j] = strconv.FormatUint(x, 10)
+				}
+			} else {
+				rec[j], err = rdrs[j].Text()
+			}
+			if err == nil {
+				var valid bool
+				valid, err = nrdrs[j].Valid()
+				if !valid {
+					rec[j] = "NA"
+				}
+			}
+			if err == io.EOF {
+				if j == 0 {
+					return rows, nil
+				}
+				return nil, fmt.Errorf("variable %s in bucket %d has fewer rows than %s", vars[j], bucket, vars[0])
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		rows = append(rows, rec)
+	}
+
+	return rows, nil
+}
+
+// Run runs head with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("head", flag.ContinueOnError)
+	var vlist string
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.IntVar(&bucket, "bucket", 0, "bucket to show")
+	fs.IntVar(&nrows, "n", 10, "number of rows to show")
+	fs.StringVar(&vlist, "vars", "", "comma-separated variables to show (default all)")
+	fs.BoolVar(&decode, "decode", true, "show labels for factor-coded variables")
+	fs.BoolVar(&ascsv, "csv", false, "write CSV instead of a table")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" || nrows < 0 || bucket < 0 {
+		return cli.Usage("usage:\nhead -sourcedir=... [-bucket=...] [-n=...] [-vars=...] [-decode=false] [-csv]\n\n")
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	if bucket >= conf.NumBuckets {
+		return fmt.Errorf("Bucket %d does not exist, %s has %d buckets", bucket, sourcedir, conf.NumBuckets)
+	}
+
+	if vlist != "" {
+		vars = strings.Split(vlist, ",")
+	} else {
+		dtypes, err := config.ReadDtypes(bucket, sourcedir)
+		if err != nil {
+			return err
+		}
+		for vn := range dtypes {
+			vars = append(vars, vn)
+		}
+		sort.Strings(vars)
+	}
+
+	labels = make([]map[int]string, len(vars))
+	if decode {
+		for j, vn := range vars {
+			if config.HasFactorCodes(vn, conf) {
+				codes, err := config.GetFactorCodes(vn, conf)
+				if err != nil {
+					return err
+				}
+				labels[j] = config.RevCodes(codes)
+			}
+		}
+	}
+
+	rows, err := readrows()
+	if err != nil {
+		return err
+	}
+
+	if ascsv {
+		out := csv.NewWriter(os.Stdout)
+		out.Write(vars)
+		out.WriteAll(rows)
+		if err := out.Error(); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\n", strings.Join(vars, "\t"))
+	for _, rec := range rows {
+		fmt.Fprintf(tw, "%s\n", strings.Join(rec, "\t"))
+	}
+	tw.Flush()
+
+	return nil
+}
@@ -0,0 +1,178 @@
+// Package httpstore gives read-only access to data sets published by a
+// web server, under base URLs such as https://example.org/data/set.
+// Importing the package registers the storage for http:// and
+// https:// with the config package, after which the functions of
+// config accept such URLs wherever they accept a source directory:
+//
+//	import _ "github.com/kshedden/gocols/httpstore"
+//
+// Each file is fetched with a GET request and read as a stream, so only
+// the columns actually used are downloaded.  Listing a directory, as
+// needed to copy the Codes directory, relies on the index page that
+// most static servers generate for a directory (autoindex), whose
+// links are taken as the names of the files.  A data set cannot be
+// written, so it can only be a source, never a target.
+package httpstore
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/kshedden/gocols/config"
+)
+
+// store implements config.Storage for web servers.
+type store struct {
+	client *http.Client
+}
+
+func init() {
+	s := &store{client: &http.Client{}}
+	config.RegisterStorage("http", s)
+	config.RegisterStorage("https", s)
+}
+
+// get issues a request for a URL, returning the response if its status
+// is 200.
+func (s *store) get(method, op, name string) (*http.Response, error) {
+
+	req, err := http.NewRequest(method, name, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusNotFound, http.StatusGone:
+		resp.Body.Close()
+		return nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("cannot %s %s: %s", op, name, resp.Status)
+	}
+}
+
+func (s *store) Open(name string) (io.ReadCloser, error) {
+
+	resp, err := s.get("GET", "open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// readonly returns the error for an attempt to change a file.
+func readonly(name string) error {
+	return fmt.Errorf("cannot write %s, data sets on web servers are read-only", name)
+}
+
+func (s *store) Create(name string) (io.WriteCloser, error) {
+	return nil, readonly(name)
+}
+
+func (s *store) Remove(name string) error {
+	return readonly(name)
+}
+
+func (s *store) Rename(oldname, newname string) error {
+	return readonly(newname)
+}
+
+// fileinfo describes a file on the server.
+type fileinfo struct {
+	name    string
+	size    int64
+	modtime time.Time
+}
+
+func (fi *fileinfo) Name() string       { return fi.name }
+func (fi *fileinfo) Size() int64        { return fi.size }
+func (fi *fileinfo) Mode() os.FileMode  { return 0444 }
+func (fi *fileinfo) ModTime() time.Time { return fi.modtime }
+func (fi *fileinfo) IsDir() bool        { return false }
+func (fi *fileinfo) Sys() interface{}   { return nil }
+
+// Stat uses a HEAD request.  The size is unknown (-1) if the server
+// does not send a Content-Length, and the modification time is zero if
+// it does not send a Last-Modified header.
+func (s *store) Stat(name string) (os.FileInfo, error) {
+
+	resp, err := s.get("HEAD", "stat", name)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	fi := &fileinfo{
+		name: name[strings.LastIndex(name, "/")+1:],
+		size: resp.ContentLength,
+	}
+	if lm := resp.Header.Get("Last-Modified"); lm != "" {
+		if t, err := http.ParseTime(lm); err == nil {
+			fi.modtime = t
+		}
+	}
+
+	return fi, nil
+}
+
+// hrefs matches the targets of the links in an index page.
+var hrefs = regexp.MustCompile(`(?i)href\s*=\s*"([^"]*)"`)
+
+// ReadDir returns the files linked from the index page of a directory.
+// Links to subdirectories, to other directories and to other sites,
+// and links with a query (such as the sorting links of Apache), are
+// ignored.
+func (s *store) ReadDir(name string) ([]string, error) {
+
+	base := strings.TrimSuffix(name, "/") + "/"
+	resp, err := s.get("GET", "readdir", base)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	page, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	bu, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, m := range hrefs.FindAllSubmatch(page, -1) {
+		u, err := bu.Parse(string(m[1]))
+		if err != nil || u.RawQuery != "" || u.Host != bu.Host {
+			continue
+		}
+		if !strings.HasPrefix(u.Path, bu.Path) {
+			continue
+		}
+		fn := strings.TrimPrefix(u.Path, bu.Path)
+		if fn == "" || strings.Contains(fn, "/") || seen[fn] {
+			continue
+		}
+		seen[fn] = true
+		names = append(names, fn)
+	}
+
+	return names, nil
+}
@@ -0,0 +1,97 @@
+// Package idrange holds sets of unsigned integer ids given as single
+// values and inclusive ranges such as 1000-2000, as used by select and
+// serve to choose records by the value of an id variable.
+
+package idrange
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Interval is an inclusive range of ids.
+type Interval struct {
+	Lo, Hi uint64
+}
+
+// Set is a set of ids, held as sorted, non-overlapping and
+// non-adjacent intervals.
+type Set []Interval
+
+// ParseInterval parses a single id or an inclusive range of ids such as
+// 1000-2000.
+func ParseInterval(s string) (Interval, error) {
+
+	s = strings.TrimSpace(s)
+	v := strings.SplitN(s, "-", 2)
+
+	lo, err := strconv.ParseUint(strings.TrimSpace(v[0]), 10, 64)
+	if err != nil {
+		return Interval{}, fmt.Errorf("invalid id %s", s)
+	}
+	if len(v) == 1 {
+		return Interval{lo, lo}, nil
+	}
+
+	hi, err := strconv.ParseUint(strings.TrimSpace(v[1]), 10, 64)
+	if err != nil || hi < lo {
+		return Interval{}, fmt.Errorf("invalid id range %s", s)
+	}
+
+	return Interval{lo, hi}, nil
+}
+
+// Parse parses ids and ranges of ids, as read by ParseInterval,
+// separated by commas or newlines.
+func Parse(s string) (Set, error) {
+
+	var a []Interval
+	for _, f := range strings.FieldsFunc(s, func(c rune) bool { return c == ',' || c == '\n' || c == '\r' }) {
+		if strings.TrimSpace(f) == "" {
+			continue
+		}
+		r, err := ParseInterval(f)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, r)
+	}
+
+	return NewSet(a), nil
+}
+
+// NewSet returns the set of the ids in the given intervals, which may
+// be in any order and may overlap.  The intervals are sorted in place.
+func NewSet(a []Interval) Set {
+
+	sort.Slice(a, func(i, j int) bool { return a[i].Lo < a[j].Lo })
+
+	// Merge overlapping and adjacent intervals.
+	var m Set
+	for _, r := range a {
+		if n := len(m); n > 0 && (r.Lo <= m[n-1].Hi || r.Lo == m[n-1].Hi+1) {
+			if r.Hi > m[n-1].Hi {
+				m[n-1].Hi = r.Hi
+			}
+			continue
+		}
+		m = append(m, r)
+	}
+
+	return m
+}
+
+// Contains returns true if and only if v is in the set.
+func (s Set) Contains(v uint64) bool {
+	k := sort.Search(len(s), func(i int) bool { return s[i].Hi >= v })
+	return k < len(s) && s[k].Lo <= v
+}
+
+// Overlaps returns true if and only if the set holds an id between lo
+// and hi inclusive.
+func (s Set) Overlaps(lo, hi uint64) bool {
+	k := sort.Search(len(s), func(i int) bool { return s[i].Hi >= lo })
+	return k < len(s) && s[k].Lo <= hi
+}
@@ -0,0 +1,731 @@
+// Importparquet converts one or more Apache Parquet files into a
+// columnized data set.  All files must have the same schema.
+//
+// Unsigned integer columns keep their width, signed integer columns are
+// stored as varint, float columns keep their width and boolean columns
+// are stored as uint8.  Date columns are stored as date32, and
+// timestamp columns of any unit as timestamp64 (microseconds since
+// 1970-01-01T00:00:00Z, so nanoseconds are truncated).  Null values of
+// the columns that are not factor-coded are stored as zero (NaN for
+// floats) and marked in the validity bitmap of the variable (see
+// config.NullSuffix).  String, binary and dictionary-encoded columns
+// become factor-coded variables stored as uint32, with their labels
+// saved in the Codes directory.  Files written by exportparquet carry
+// the original data types and code groups in their schema metadata, and
+// these are restored.
+//
+// Each row group is placed in a single bucket, with the row groups
+// assigned to the buckets in round-robin order.  If -idvar is given,
+// rows are instead placed in buckets by routing on its value, and the
+// routing is recorded in the configuration.
+
+package importparquet
+
+import (
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/kshedden/gocols/arrowcols"
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The input files
+	files []string
+
+	// The variables to import, all variables if empty
+	vars []string
+
+	// The number of buckets
+	nbuckets int
+
+	// The variable used to route rows to buckets, if any
+	idvar string
+
+	// The routing method, modulo or hash
+	routing string
+
+	// The directory where the data set will be written
+	targetdir string
+
+	// The compression of the column files
+	compression string
+
+	// If true, overwrite existing files
+	replace bool
+
+	// Configuration information for the data set
+	conf *config.Config
+
+	// The schema of the first file, restricted to the imported
+	// variables
+	schema *arrow.Schema
+
+	// The imported variables, indexed like the schema fields
+	cols []*column
+
+	// The factor codes of each code group
+	groups map[string]*codeset
+
+	// The writers for each bucket and variable
+	wtrs [][]io.WriteCloser
+	fids [][]io.Closer
+
+	// The writers of the validity bitmaps for each bucket and
+	// variable, nil for the factor-coded variables
+	nwtrs [][]*config.NullWriter
+
+	// The most recent value written to each delta-uvarint column,
+	// for each bucket
+	last [][]uint64
+
+	// The number of rows written to each bucket
+	nrows []int
+)
+
+// column describes how one Parquet column is stored.
+type column struct {
+	name string
+
+	// The data type of the stored column
+	dtype string
+
+	// The code group of a factor-coded variable, empty for other
+	// variables
+	group string
+}
+
+// codeset holds the factor codes of one code group.
+type codeset struct {
+	codes map[string]int
+	used  map[int]bool
+	next  int
+}
+
+// code returns the code of a label, assigning a new code if the label
+// has not been seen.  The preferred code is used if it is not already
+// in use.
+func (cs *codeset) code(lab string, pref int) int {
+
+	if c, ok := cs.codes[lab]; ok {
+		return c
+	}
+
+	c := pref
+	if c < 0 || cs.used[c] {
+		for cs.used[cs.next] {
+			cs.next++
+		}
+		c = cs.next
+	}
+	cs.codes[lab] = c
+	cs.used[c] = true
+
+	return c
+}
+
+// getcolumn determines how a Parquet column is stored.
+func getcolumn(f arrow.Field) (*column, error) {
+
+	c := &column{name: f.Name}
+
+	// The data type recorded by exportparquet, if any
+	var mdtype string
+	if i := f.Metadata.FindKey(arrowcols.DtypeKey); i >= 0 {
+		mdtype = f.Metadata.Values()[i]
+	}
+
+	typ := f.Type
+	switch typ.ID() {
+	case arrow.UINT8, arrow.UINT16, arrow.UINT32:
+		c.dtype = typ.Name()
+	case arrow.UINT64:
+		c.dtype = "uint64"
+		if mdtype == "uvarint" || mdtype == "delta-uvarint" {
+			c.dtype = mdtype
+		}
+	case arrow.INT8, arrow.INT16, arrow.INT32, arrow.INT64:
+		c.dtype = "varint"
+	case arrow.FLOAT32, arrow.FLOAT64:
+		c.dtype = typ.Name()
+	case arrow.BOOL:
+		c.dtype = "uint8"
+	case arrow.DATE32, arrow.DATE64:
+		c.dtype = "date32"
+	case arrow.TIMESTAMP:
+		c.dtype = "timestamp64"
+	case arrow.STRING, arrow.LARGE_STRING, arrow.BINARY, arrow.LARGE_BINARY:
+		c.dtype = "uint32"
+		c.group = f.Name
+	case arrow.DICTIONARY:
+		switch typ.(*arrow.DictionaryType).ValueType.ID() {
+		case arrow.STRING, arrow.LARGE_STRING, arrow.BINARY, arrow.LARGE_BINARY:
+		default:
+			return nil, fmt.Errorf("variable %s has unsupported type %s", f.Name, typ)
+		}
+		c.dtype = "uint32"
+		switch mdtype {
+		case "uint8", "uint16", "uint32", "uint64", "uvarint":
+			c.dtype = mdtype
+		}
+		c.group = f.Name
+		if i := f.Metadata.FindKey(arrowcols.GroupKey); i >= 0 {
+			c.group = f.Metadata.Values()[i]
+		}
+	default:
+		return nil, fmt.Errorf("variable %s has unsupported type %s", f.Name, typ)
+	}
+
+	return c, nil
+}
+
+// text returns the string value at position i of a string or binary
+// array.
+func text(a arrow.Array, i int) string {
+
+	if a.IsNull(i) {
+		return ""
+	}
+
+	switch a := a.(type) {
+	case *array.String:
+		return a.Value(i)
+	case *array.LargeString:
+		return a.Value(i)
+	case *array.Binary:
+		return string(a.Value(i))
+	case *array.LargeBinary:
+		return string(a.Value(i))
+	}
+
+	panic(fmt.Sprintf("unexpected array type %s", a.DataType()))
+}
+
+// uintval returns the value at position i of an unsigned integer or
+// boolean array.
+func uintval(a arrow.Array, i int) (uint64, error) {
+
+	if a.IsNull(i) {
+		return 0, fmt.Errorf("missing value at row %d", i)
+	}
+
+	switch a := a.(type) {
+	case *array.Uint8:
+		return uint64(a.Value(i)), nil
+	case *array.Uint16:
+		return uint64(a.Value(i)), nil
+	case *array.Uint32:
+		return uint64(a.Value(i)), nil
+	case *array.Uint64:
+		return a.Value(i), nil
+	case *array.Boolean:
+		if a.Value(i) {
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	return 0, fmt.Errorf("type %s is not an unsigned integer type", a.DataType())
+}
+
+// intval returns the value at position i of a signed integer array.
+func intval(a arrow.Array, i int) (int64, error) {
+
+	if a.IsNull(i) {
+		return 0, fmt.Errorf("missing value at row %d", i)
+	}
+
+	switch a := a.(type) {
+	case *array.Int8:
+		return int64(a.Value(i)), nil
+	case *array.Int16:
+		return int64(a.Value(i)), nil
+	case *array.Int32:
+		return int64(a.Value(i)), nil
+	case *array.Int64:
+		return a.Value(i), nil
+	}
+
+	return 0, fmt.Errorf("type %s is not a signed integer type", a.DataType())
+}
+
+// timeval returns the value at position i of a date or timestamp
+// array, as days or microseconds since 1970-01-01.
+func timeval(a arrow.Array, i int) (int64, error) {
+
+	if a.IsNull(i) {
+		return 0, fmt.Errorf("missing value at row %d", i)
+	}
+
+	switch a := a.(type) {
+	case *array.Date32:
+		return int64(a.Value(i)), nil
+	case *array.Date64:
+		// Milliseconds, rounded toward the start of the day
+		ms := int64(a.Value(i))
+		d := ms / 86400000
+		if ms%86400000 < 0 {
+			d--
+		}
+		return d, nil
+	case *array.Timestamp:
+		v := int64(a.Value(i))
+		switch a.DataType().(*arrow.TimestampType).Unit {
+		case arrow.Second:
+			return v * 1000000, nil
+		case arrow.Millisecond:
+			return v * 1000, nil
+		case arrow.Nanosecond:
+			return v / 1000, nil
+		}
+		return v, nil
+	}
+
+	return 0, fmt.Errorf("type %s is not a date or timestamp type", a.DataType())
+}
+
+// putvalue writes the value at position i of an array to column j of
+// a bucket.
+func putvalue(bn, j int, a arrow.Array, i int) error {
+
+	w := wtrs[bn][j]
+	c := cols[j]
+
+	if nw := nwtrs[bn][j]; nw != nil {
+		if a.IsNull(i) {
+			// The placeholder of a delta-uvarint column repeats
+			// the preceding value.
+			var err error
+			switch {
+			case c.dtype == "delta-uvarint":
+				err = config.WriteUint(w, "uvarint", 0)
+			case strings.HasPrefix(c.dtype, "float"):
+				err = config.WriteBits(w, c.dtype, math.Float64bits(math.NaN()))
+			default:
+				err = config.WriteBits(w, c.dtype, 0)
+			}
+			if err != nil {
+				return err
+			}
+			return nw.Put(false)
+		}
+		if err := nw.Put(true); err != nil {
+			return err
+		}
+	}
+
+	switch a := a.(type) {
+	case *array.Dictionary:
+		// Prefer the dictionary position as the code, so that
+		// files written by exportparquet keep their codes.
+		pref, lab := -1, ""
+		if !a.IsNull(i) {
+			pref = a.GetValueIndex(i)
+			lab = text(a.Dictionary(), pref)
+		}
+		return config.WriteUint(w, c.dtype, uint64(groups[c.group].code(lab, pref)))
+	case *array.String, *array.LargeString, *array.Binary, *array.LargeBinary:
+		code := groups[c.group].code(text(a, i), -1)
+		return config.WriteUint(w, c.dtype, uint64(code))
+	case *array.Float32:
+		v := float32(math.NaN())
+		if !a.IsNull(i) {
+			v = a.Value(i)
+		}
+		return binary.Write(w, binary.LittleEndian, v)
+	case *array.Float64:
+		v := math.NaN()
+		if !a.IsNull(i) {
+			v = a.Value(i)
+		}
+		return binary.Write(w, binary.LittleEndian, v)
+	}
+
+	if config.IsTime(c.dtype) {
+		v, err := timeval(a, i)
+		if err != nil {
+			return err
+		}
+		return config.WriteInt(w, c.dtype, v)
+	}
+
+	if c.dtype == "varint" {
+		v, err := intval(a, i)
+		if err != nil {
+			return err
+		}
+		var b [binary.MaxVarintLen64]byte
+		m := binary.PutVarint(b[:], v)
+		_, err = w.Write(b[0:m])
+		return err
+	}
+
+	v, err := uintval(a, i)
+	if err != nil {
+		return err
+	}
+	if c.dtype == "delta-uvarint" {
+		if v < last[bn][j] {
+			return fmt.Errorf("value %d is smaller than the preceding value in bucket %d", v, bn)
+		}
+		d := v - last[bn][j]
+		last[bn][j] = v
+		return config.WriteUint(w, "uvarint", d)
+	}
+	return config.WriteUint(w, c.dtype, v)
+}
+
+// setup determines the stored data types, creates the directories and
+// opens the column files.
+func setup() error {
+
+	groups = make(map[string]*codeset)
+	dtm := make(map[string]string)
+	for _, f := range schema.Fields() {
+		c, err := getcolumn(f)
+		if err != nil {
+			return err
+		}
+		cols = append(cols, c)
+		dtm[c.name] = c.dtype
+		if c.group != "" && groups[c.group] == nil {
+			groups[c.group] = &codeset{codes: make(map[string]int), used: make(map[int]bool)}
+		}
+	}
+
+	wtrs = make([][]io.WriteCloser, nbuckets)
+	fids = make([][]io.Closer, nbuckets)
+	nwtrs = make([][]*config.NullWriter, nbuckets)
+	last = make([][]uint64, nbuckets)
+	nrows = make([]int, nbuckets)
+
+	for k := 0; k < nbuckets; k++ {
+		err := os.MkdirAll(config.BucketPath(k, targetdir), 0755)
+		if err != nil {
+			return err
+		}
+		err = config.WriteDtypes(k, targetdir, dtm)
+		if err != nil {
+			return err
+		}
+		for _, c := range cols {
+			w, f, err := config.CreateColumn(k, targetdir, c.name)
+			if err != nil {
+				return err
+			}
+			wtrs[k] = append(wtrs[k], w)
+			fids[k] = append(fids[k], f)
+			var nw *config.NullWriter
+			if c.group == "" {
+				nw = config.CreateNulls(k, targetdir, c.name)
+			}
+			nwtrs[k] = append(nwtrs[k], nw)
+		}
+		last[k] = make([]uint64, len(cols))
+	}
+
+	return nil
+}
+
+// getschema returns the schema of a file, restricted to the imported
+// variables.
+func getschema(fr *pqarrow.FileReader) (*arrow.Schema, []int, error) {
+
+	full, err := fr.Schema()
+	if err != nil {
+		return nil, nil, err
+	}
+	var ix []int
+	if len(vars) == 0 {
+		for j := range full.Fields() {
+			ix = append(ix, j)
+		}
+		return full, ix, nil
+	}
+
+	var fields []arrow.Field
+	for _, vn := range vars {
+		jj := full.FieldIndices(vn)
+		if len(jj) != 1 {
+			return nil, nil, fmt.Errorf("variable %s not found", vn)
+		}
+		fields = append(fields, full.Field(jj[0]))
+		ix = append(ix, jj[0])
+	}
+
+	return arrow.NewSchema(fields, nil), ix, nil
+}
+
+// sameschema returns true if two schemas have the same variables with
+// the same types.
+func sameschema(s1, s2 *arrow.Schema) bool {
+
+	if s1.NumFields() != s2.NumFields() {
+		return false
+	}
+	for j := 0; j < s1.NumFields(); j++ {
+		f1, f2 := s1.Field(j), s2.Field(j)
+		if f1.Name != f2.Name || !arrow.TypeEqual(f1.Type, f2.Type) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// writerecord writes the rows of a record, from row group g, to the
+// buckets.  idpos is the position of the id variable, or -1.
+func writerecord(rec arrow.Record, g, idpos int) error {
+
+	n := int(rec.NumRows())
+	bns := make([]int, n)
+	for i := range bns {
+		bns[i] = g % nbuckets
+		if idpos >= 0 {
+			id, err := uintval(rec.Column(idpos), i)
+			if err != nil {
+				return fmt.Errorf("variable %s: %v", idvar, err)
+			}
+			bns[i], err = config.Route(conf, id)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for j := range cols {
+		a := rec.Column(j)
+		for i := 0; i < n; i++ {
+			if err := putvalue(bns[i], j, a, i); err != nil {
+				return fmt.Errorf("variable %s: %v", cols[j].name, err)
+			}
+		}
+	}
+
+	for _, bn := range bns {
+		nrows[bn]++
+	}
+
+	return nil
+}
+
+// openfile opens a Parquet file, returning its schema restricted to
+// the imported variables and the positions of these variables.
+func openfile(fname string) (*file.Reader, *pqarrow.FileReader, *arrow.Schema, []int, error) {
+
+	pf, err := file.OpenParquetFile(fname, false)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	fr, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{}, memory.NewGoAllocator())
+	if err != nil {
+		pf.Close()
+		return nil, nil, nil, nil, err
+	}
+
+	sc, ix, err := getschema(fr)
+	if err != nil {
+		pf.Close()
+		return nil, nil, nil, nil, fmt.Errorf("%s: %v", fname, err)
+	}
+
+	return pf, fr, sc, ix, nil
+}
+
+// convert writes the rows of one file, where ngroups is the number of
+// row groups already written.  The numbers of row groups and rows in
+// the file are returned.
+func convert(fname string, idpos, ngroups int) (int, int, error) {
+
+	pf, fr, sc, ix, err := openfile(fname)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer pf.Close()
+
+	if !sameschema(sc, schema) {
+		return 0, 0, fmt.Errorf("the schema of %s differs from the schema of %s", fname, files[0])
+	}
+
+	var nrows int
+	ng := pf.NumRowGroups()
+	for g := 0; g < ng; g++ {
+		tbl, err := fr.ReadRowGroups(context.Background(), ix, []int{g})
+		if err != nil {
+			return g, nrows, err
+		}
+
+		tr := array.NewTableReader(tbl, -1)
+		for tr.Next() {
+			rec := tr.Record()
+			if err := writerecord(rec, ngroups+g, idpos); err != nil {
+				tr.Release()
+				tbl.Release()
+				return g, nrows, fmt.Errorf("%s row group %d: %v", fname, g, err)
+			}
+			nrows += int(rec.NumRows())
+		}
+		tr.Release()
+		tbl.Release()
+	}
+
+	return ng, nrows, nil
+}
+
+// finish closes the column files, and saves the number of rows in each
+// bucket and the factor codes.
+func finish() error {
+
+	for k := range wtrs {
+		for j := range wtrs[k] {
+			if err := wtrs[k][j].Close(); err != nil {
+				return err
+			}
+			if err := fids[k][j].Close(); err != nil {
+				return err
+			}
+			if nwtrs[k][j] != nil {
+				if err := nwtrs[k][j].Close(); err != nil {
+					return err
+				}
+			}
+		}
+		if err := config.RecordRows(k, targetdir, nrows[k]); err != nil {
+			return err
+		}
+	}
+
+	err := os.MkdirAll(conf.CodesDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	cf := make(map[string]string)
+	for _, c := range cols {
+		if c.group != "" {
+			cf[c.name] = c.group
+		}
+	}
+	for grp, cs := range groups {
+		err = config.WriteFactorCodes(grp, cs.codes, conf)
+		if err != nil {
+			return err
+		}
+	}
+
+	return config.WriteCodeFiles(conf, cf)
+}
+
+// Run runs importparquet with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("importparquet", flag.ContinueOnError)
+	var flist, vlist string
+	fs.StringVar(&flist, "files", "", "comma-separated input files")
+	fs.StringVar(&vlist, "vars", "", "comma-separated variables to import (default all)")
+	fs.IntVar(&nbuckets, "buckets", 10, "number of buckets")
+	fs.StringVar(&idvar, "idvar", "", "variable used to route rows to buckets")
+	fs.StringVar(&routing, "routing", "modulo", "routing method for -idvar, modulo or hash")
+	fs.StringVar(&targetdir, "targetdir", "", "destination directory")
+	fs.StringVar(&compression, "compression", config.DefaultCompression, "compression of the column files (snappy, gzip, zstd or none)")
+	fs.BoolVar(&replace, "replace", false, "overwrite existing files")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	cols = nil
+
+	files = fs.Args()
+	if flist != "" {
+		files = append(strings.Split(flist, ","), files...)
+	}
+	if vlist != "" {
+		vars = strings.Split(vlist, ",")
+	}
+
+	if len(files) == 0 || targetdir == "" || nbuckets < 1 || (routing != "modulo" && routing != "hash") {
+		return cli.Usage("usage:\nimportparquet -targetdir=... [-vars=...] [-buckets=...] [-idvar=... [-routing=modulo|hash]] file...\n\n")
+	}
+
+	if _, err := config.GetCodec(compression); err != nil {
+		return err
+	}
+
+	if !replace {
+		_, err := os.Stat(targetdir)
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("Use -replace=true to overwrite existing contents of %s", targetdir)
+		}
+	}
+
+	err := os.MkdirAll(targetdir, 0755)
+	if err != nil {
+		return err
+	}
+
+	conf = &config.Config{
+		NumBuckets:  nbuckets,
+		Compression: compression,
+		CodesDir:    path.Join(targetdir, "Codes"),
+	}
+	if idvar != "" {
+		conf.Routing = &config.Routing{IdVar: idvar, Method: routing}
+	}
+	if err := config.WriteConfig(targetdir, conf); err != nil {
+		return err
+	}
+
+	// The stored data types are determined by the first file.
+	pf, _, sc, _, err := openfile(files[0])
+	if err != nil {
+		return err
+	}
+	pf.Close()
+	schema = sc
+	if err := setup(); err != nil {
+		return err
+	}
+
+	idpos := -1
+	for j, c := range cols {
+		if c.name == idvar && c.group == "" && c.dtype != "varint" && !config.IsTime(c.dtype) && !strings.HasPrefix(c.dtype, "float") {
+			idpos = j
+		}
+	}
+	if idvar != "" && idpos == -1 {
+		return fmt.Errorf("The id variable %s must be imported with an unsigned integer dtype", idvar)
+	}
+
+	var ngroups, nrows int
+	for _, fname := range files {
+		g, n, err := convert(fname, idpos, ngroups)
+		if err != nil {
+			return err
+		}
+		ngroups += g
+		nrows += n
+	}
+
+	if err := finish(); err != nil {
+		return err
+	}
+
+	slog.Info("wrote rows", "rows", nrows, "rowgroups", ngroups, "buckets", nbuckets)
+
+	return nil
+}
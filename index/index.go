@@ -0,0 +1,153 @@
+// Index builds a Bloom filter of the values of an id variable in each
+// bucket of a data set, saved in the bucket directory (see
+// config.BloomSuffix).  Select consults the filters to skip the buckets
+// holding none of the requested ids, without reading their columns.
+//
+// The filters are sized for a false positive rate of -fpr, the chance
+// that a bucket is read although it holds none of a given id.  A filter
+// is ignored once the column it was built from is rewritten, so the
+// index must be built again after the data change.
+//
+// With -sorted, the buckets must be sorted by the id variable (see
+// sortrows), and a sparse index recording the id in every -step-th row
+// is also saved (see config.SortIndex).  Select uses it to read only
+// the rows that may hold the requested ids, stopping once the ids are
+// passed.
+
+package index
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The variable to index
+	idvar string
+
+	// The false positive rate of the filters
+	fpr float64
+
+	// If true, also build sparse indexes of the sorted buckets
+	sorted bool
+
+	// The number of rows between the entries of a sparse index
+	step int
+
+	// Configuration information for the data set
+	conf *config.Config
+)
+
+// dobucket builds and saves the filter, and with -sorted the sparse
+// index, of one bucket, returning the number of values added.
+func dobucket(bn int) (int, error) {
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return 0, err
+	}
+	dt, ok := dtypes[idvar]
+	if !ok {
+		return 0, fmt.Errorf("variable %s not found in bucket %d", idvar, bn)
+	}
+	if !strings.HasPrefix(dt, "uint") && dt != "uvarint" && dt != "delta-uvarint" {
+		return 0, fmt.Errorf("variable %s has dtype %s in bucket %d, not an unsigned integer type", idvar, dt, bn)
+	}
+
+	nrows, err := config.NumRows(bn, sourcedir)
+	if err != nil {
+		return 0, err
+	}
+
+	rdr, err := config.OpenReader(bn, sourcedir, idvar, dt)
+	if err != nil {
+		return 0, err
+	}
+	defer rdr.Close()
+
+	b := config.NewBloom(nrows, fpr)
+	si := &config.SortIndex{Step: step}
+	var n int
+	var last uint64
+	for {
+		x, err := rdr.Uint()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return n, err
+		}
+		b.Add(x)
+		if sorted {
+			if n > 0 && x < last {
+				return n, fmt.Errorf("bucket %d is not sorted by %s", bn, idvar)
+			}
+			if n%step == 0 {
+				si.Values = append(si.Values, x)
+			}
+			last = x
+		}
+		n++
+	}
+
+	if err := config.CheckRows(bn, sourcedir, n); err != nil {
+		return n, err
+	}
+
+	if err := config.WriteBloom(bn, sourcedir, idvar, b); err != nil {
+		return n, err
+	}
+	if sorted {
+		si.NumRows = n
+		if err := config.WriteSortIndex(bn, sourcedir, idvar, si); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// Run runs index with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("index", flag.ContinueOnError)
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&idvar, "idvar", "", "id variable to index")
+	fs.Float64Var(&fpr, "fpr", 0.01, "false positive rate of the filters")
+	fs.BoolVar(&sorted, "sorted", false, "also build sparse indexes of the buckets, which must be sorted by idvar")
+	fs.IntVar(&step, "step", 1024, "number of rows between the entries of the sparse indexes")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" || idvar == "" || fpr <= 0 || fpr >= 1 || step < 1 {
+		return cli.Usage("usage:\nindex -sourcedir=... -idvar=... [-fpr=...] [-sorted [-step=...]]\n\n")
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	var nvals int
+	for k := 0; k < conf.NumBuckets; k++ {
+		n, err := dobucket(k)
+		if err != nil {
+			return err
+		}
+		nvals += n
+	}
+
+	slog.Info("indexed values", "values", nvals, "idvar", idvar, "buckets", conf.NumBuckets)
+
+	return nil
+}
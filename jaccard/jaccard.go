@@ -0,0 +1,127 @@
+// Jaccard computes the Jaccard index between the sets of distinct
+// values observed in two columns, which may belong to different data
+// sets.  Factor-coded variables are compared using their labels, so
+// that data sets with different integer codings can be compared.
+
+package jaccard
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directories containing the two data sets
+	sourcedir1, sourcedir2 string
+
+	// The variables to compare
+	vname1, vname2 string
+)
+
+// distinct returns the set of distinct values of a variable in a data
+// set.  If the variable is factor-coded the values are the labels,
+// otherwise they are the integer values formatted as strings.
+func distinct(sourcedir, vname string) (map[string]bool, error) {
+
+	conf, err := config.GetConfig(sourcedir)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make(map[uint64]bool)
+	for k := 0; k < conf.NumBuckets; k++ {
+		dtypes, err := config.ReadDtypes(k, sourcedir)
+		if err != nil {
+			return nil, err
+		}
+		dt, ok := dtypes[vname]
+		if !ok {
+			return nil, fmt.Errorf("Variable %s not found in bucket %d of %s", vname, k, sourcedir)
+		}
+
+		rdr, err := config.OpenReader(k, sourcedir, vname, dt)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			x, err := rdr.Uint()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			codes[x] = true
+		}
+		rdr.Close()
+	}
+
+	var labels map[int]string
+	if config.HasFactorCodes(vname, conf) {
+		fc, err := config.GetFactorCodes(vname, conf)
+		if err != nil {
+			return nil, err
+		}
+		labels = config.RevCodes(fc)
+	}
+
+	vals := make(map[string]bool)
+	for x := range codes {
+		lab, ok := labels[int(x)]
+		if !ok {
+			lab = fmt.Sprintf("%d", x)
+		}
+		vals[lab] = true
+	}
+
+	return vals, nil
+}
+
+// Run runs jaccard with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("jaccard", flag.ContinueOnError)
+	fs.StringVar(&sourcedir1, "sourcedir1", "", "directory of the first data set")
+	fs.StringVar(&vname1, "var1", "", "variable in the first data set")
+	fs.StringVar(&sourcedir2, "sourcedir2", "", "directory of the second data set")
+	fs.StringVar(&vname2, "var2", "", "variable in the second data set")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir1 == "" || vname1 == "" || sourcedir2 == "" || vname2 == "" {
+		return cli.Usage("usage:\njaccard -sourcedir1=... -var1=... -sourcedir2=... -var2=...\n\n")
+	}
+
+	a, err := distinct(sourcedir1, vname1)
+	if err != nil {
+		return err
+	}
+	b, err := distinct(sourcedir2, vname2)
+	if err != nil {
+		return err
+	}
+
+	var ni int
+	for v := range a {
+		if b[v] {
+			ni++
+		}
+	}
+	nu := len(a) + len(b) - ni
+
+	var jac float64
+	if nu > 0 {
+		jac = float64(ni) / float64(nu)
+	}
+
+	fmt.Printf("Distinct values: %d in %s, %d in %s\n", len(a), vname1, len(b), vname2)
+	fmt.Printf("Intersection: %d\n", ni)
+	fmt.Printf("Union: %d\n", nu)
+	fmt.Printf("Jaccard index: %.6f\n", jac)
+
+	return nil
+}
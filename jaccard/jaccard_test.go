@@ -0,0 +1,52 @@
+package jaccard_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kshedden/gocols/coltest"
+	"github.com/kshedden/gocols/jaccard"
+)
+
+func TestJaccard(t *testing.T) {
+
+	// The labels are coded in order of first appearance, so b and c
+	// have different codes in the two data sets.
+	dir1 := coltest.CSV(t, "s,x\na,1\nb,2\nc,2\na,3\n")
+	dir2 := coltest.CSV(t, "s,x\nc,3\nd,4\nb,4\n")
+
+	for _, tc := range []struct {
+		var1, var2 string
+		want       []string
+	}{
+		{
+			var1: "s",
+			var2: "s",
+			want: []string{
+				"Distinct values: 3 in s, 3 in s",
+				"Intersection: 2",
+				"Union: 4",
+				"Jaccard index: 0.500000",
+			},
+		},
+		{
+			var1: "x",
+			var2: "x",
+			want: []string{
+				"Distinct values: 3 in x, 2 in x",
+				"Intersection: 1",
+				"Union: 4",
+				"Jaccard index: 0.250000",
+			},
+		},
+	} {
+		out, err := coltest.Stdout(t, jaccard.Run, "-sourcedir1="+dir1, "-var1="+tc.var1,
+			"-sourcedir2="+dir2, "-var2="+tc.var2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, tc.want) {
+			t.Errorf("%s and %s: got %q, expected %q", tc.var1, tc.var2, out, tc.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// Package jobconfig sets the flags of a command from a job
+// configuration file and from environment variables, as well as from
+// the command line, so that a run can be reproduced from a file kept
+// under version control rather than from a long shell command.
+//
+// A command registers the -config flag with Flag, and calls Apply
+// after parsing its arguments (see cli.Parse).  Each flag that is not
+// given on the command line is then taken from the environment
+// variable named by EnvName, for example GOCOLS_SOURCEDIR for
+// -sourcedir or GOCOLS_APPEND_TARGET for -append-target, or otherwise
+// from the job file, or else keeps its default.  The job file is named
+// with -config, or by the environment variable GOCOLS_CONFIG.  It is a
+// JSON object, or a YAML mapping if its name ends in .yaml or .yml,
+// whose keys are flag names:
+//
+//	sourcedir: s3://data/cohort
+//	concurrency: 16
+//	select:
+//	  idvar: id
+//	  keepvars: [id, age, income]
+//
+// A key whose value is a mapping holds the flags of the command of
+// that name, which take precedence over the top-level keys.  Top-level
+// keys that are not flags of the command are ignored, so that one file
+// can serve several commands, but every key in the section of the
+// command must be one of its flags.  Lists are joined with commas.
+// Positional arguments are only taken from the command line.
+
+package jobconfig
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kshedden/gocols/config"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvName returns the name of the environment variable holding the
+// value of a flag.
+func EnvName(flagname string) string {
+	return "GOCOLS_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(flagname))
+}
+
+// Flag registers in fs the -config flag, naming the job file.
+func Flag(fs *flag.FlagSet) *string {
+	return fs.String("config", "", "job configuration file (JSON or YAML) holding flag values")
+}
+
+// Apply sets the flags of fs that were not given on the command line
+// from the environment and from the job file, which is named by file or
+// else by GOCOLS_CONFIG.  It is called after fs has parsed the command
+// line.  The section of the job file that is used is the one named by
+// fs.
+func Apply(fs *flag.FlagSet, file string) error {
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	if file == "" {
+		file = os.Getenv("GOCOLS_CONFIG")
+	}
+	var vals map[string]string
+	if file != "" {
+		var err error
+		vals, err = readfile(fs, file)
+		if err != nil {
+			return err
+		}
+	}
+
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if !given[f.Name] && f.Name != "config" {
+			names = append(names, f.Name)
+		}
+	})
+
+	for _, na := range names {
+		if v, ok := os.LookupEnv(EnvName(na)); ok {
+			if err := fs.Set(na, v); err != nil {
+				return fmt.Errorf("invalid value %q of %s: %v", v, EnvName(na), err)
+			}
+		} else if v, ok := vals[na]; ok {
+			if err := fs.Set(na, v); err != nil {
+				return fmt.Errorf("invalid value %q of %s in %s: %v", v, na, file, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// readfile returns the values of the flags in fs held in a job file.
+func readfile(fs *flag.FlagSet, file string) (map[string]string, error) {
+
+	command := fs.Name()
+
+	b, err := config.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]interface{}
+	switch filepath.Ext(file) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &m)
+	default:
+		err = json.Unmarshal(b, &m)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %v", file, err)
+	}
+
+	vals := make(map[string]string)
+	for k, v := range m {
+		if _, ok := v.(map[string]interface{}); ok || fs.Lookup(k) == nil || k == "config" {
+			continue
+		}
+		if vals[k], err = format(v); err != nil {
+			return nil, fmt.Errorf("%s in %s: %v", k, file, err)
+		}
+	}
+
+	if sec, ok := m[command].(map[string]interface{}); ok {
+		for k, v := range sec {
+			if fs.Lookup(k) == nil || k == "config" {
+				return nil, fmt.Errorf("%s in the %s section of %s is not a flag of %s", k, command, file, command)
+			}
+			if vals[k], err = format(v); err != nil {
+				return nil, fmt.Errorf("%s in %s: %v", k, file, err)
+			}
+		}
+	}
+
+	return vals, nil
+}
+
+// format returns the flag value written for a value in a job file.
+func format(v interface{}) (string, error) {
+
+	switch x := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return x, nil
+	case bool:
+		return strconv.FormatBool(x), nil
+	case int:
+		return strconv.Itoa(x), nil
+	case int64:
+		return strconv.FormatInt(x, 10), nil
+	case uint64:
+		return strconv.FormatUint(x, 10), nil
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64), nil
+	case []interface{}:
+		var a []string
+		for _, y := range x {
+			s, err := format(y)
+			if err != nil {
+				return "", err
+			}
+			a = append(a, s)
+		}
+		return strings.Join(a, ","), nil
+	}
+
+	return "", fmt.Errorf("unsupported value %v", v)
+}
@@ -0,0 +1,476 @@
+// Join adds variables from a right data set to the rows of a left data
+// set, matching the rows on an id variable.  Both data sets must have
+// the same number of buckets and be routed on the id, so that matching
+// rows are in buckets with the same number.  The id must be unique in
+// the right data set.
+//
+// With -how=inner (the default), only the left rows with a match are
+// kept.  With -how=left, all left rows are kept, and the right
+// variables of the unmatched rows are set to NaN (float variables) or
+// to the value of -fill (integer variables), and are marked as missing
+// in the validity bitmaps of the variables (see config.NullSuffix).
+// Right variables whose names are used in the left data set are renamed
+// by appending -suffix, and their factor codes and descriptions are
+// copied along with them.  Delta-uvarint right variables are stored as
+// uvarint, since the joined values need not be sorted.
+
+package join
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+	"github.com/kshedden/gocols/subset"
+)
+
+var (
+	// The data set whose rows are kept
+	leftdir string
+
+	// The data set providing the added variables
+	rightdir string
+
+	// The directory where the joined data will be stored
+	targetdir string
+
+	// The variable used to match rows
+	idvar string
+
+	// The right variables to add
+	vars []string
+
+	// The names of the added variables in the target
+	tnames []string
+
+	// The join type, inner or left
+	how string
+
+	// The value of integer variables in unmatched rows
+	fill uint64
+
+	// Appended to the names of right variables that are used in the
+	// left data set
+	suffix string
+
+	// Configuration information for the left and right data
+	lconf, rconf *config.Config
+
+	// Copies the matched rows of the left data set
+	copier *subset.Copier
+
+	// If true, overwrite existing files
+	replace bool
+
+	// The number of buckets processed in parallel
+	concurrency int
+
+	// The first error encountered while processing the buckets
+	firsterr error
+	errmut   sync.Mutex
+
+	sem chan bool
+)
+
+// storedtype returns the data type of an added variable in the target.
+func storedtype(dt string) string {
+	if dt == "delta-uvarint" {
+		return "uvarint"
+	}
+	return dt
+}
+
+// readids returns the id of each row in a bucket.
+func readids(bn int, dir string, dtypes map[string]string) ([]string, error) {
+
+	dt, ok := dtypes[idvar]
+	if !ok {
+		return nil, fmt.Errorf("variable %s not found in bucket %d of %s", idvar, bn, dir)
+	}
+	if strings.HasPrefix(dt, "float") {
+		return nil, fmt.Errorf("variable %s in %s has dtype %s, the id must be an integer", idvar, dir, dt)
+	}
+
+	rdr, err := config.OpenReader(bn, dir, idvar, dt)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+
+	var ids []string
+	for {
+		id, err := rdr.Text()
+		if err == io.EOF {
+			return ids, nil
+		} else if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+}
+
+// readright returns the position of each id in a bucket of the right
+// data set, and the values of the added variables.  The validity of
+// the values is also returned, nil for the variables with no missing
+// values.
+func readright(bn int, rdt map[string]string) (map[string]int, [][]uint64, [][]bool, error) {
+
+	ids, err := readids(bn, rightdir, rdt)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	pos := make(map[string]int)
+	for i, id := range ids {
+		if _, ok := pos[id]; ok {
+			return nil, nil, nil, fmt.Errorf("id %s appears more than once in bucket %d of %s", id, bn, rightdir)
+		}
+		pos[id] = i
+	}
+
+	vals := make([][]uint64, len(vars))
+	valid := make([][]bool, len(vars))
+	for j, vn := range vars {
+		dt, ok := rdt[vn]
+		if !ok {
+			return nil, nil, nil, fmt.Errorf("variable %s not found in bucket %d of %s", vn, bn, rightdir)
+		}
+		rdr, err := config.OpenReader(bn, rightdir, vn, dt)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for {
+			x, err := rdr.Bits()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				rdr.Close()
+				return nil, nil, nil, err
+			}
+			vals[j] = append(vals[j], x)
+		}
+		rdr.Close()
+		if len(vals[j]) != len(ids) {
+			return nil, nil, nil, fmt.Errorf("variable %s in bucket %d of %s has %d rows, expected %d",
+				vn, bn, rightdir, len(vals[j]), len(ids))
+		}
+
+		nr, err := config.OpenNulls(bn, rightdir, vn)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if nr.HasNulls() {
+			for range ids {
+				v, err := nr.Valid()
+				if err != nil {
+					nr.Close()
+					return nil, nil, nil, fmt.Errorf("validity of %s in bucket %d of %s: %v", vn, bn, rightdir, err)
+				}
+				valid[j] = append(valid[j], v)
+			}
+		}
+		nr.Close()
+	}
+
+	return pos, vals, valid, nil
+}
+
+// dobucket joins one bucket.
+func dobucket(bn int) {
+
+	defer func() { <-sem }()
+
+	err := joinbucket(bn)
+	if err != nil {
+		errmut.Lock()
+		if firsterr == nil {
+			firsterr = err
+		}
+		errmut.Unlock()
+	}
+}
+
+// joinbucket copies the kept left rows of one bucket, and writes the
+// matching values of the added variables.
+func joinbucket(bn int) error {
+
+	rdt, err := config.ReadDtypes(bn, rightdir)
+	if err != nil {
+		return err
+	}
+	pos, vals, valid, err := readright(bn, rdt)
+	if err != nil {
+		return err
+	}
+
+	ldt, err := config.ReadDtypes(bn, leftdir)
+	if err != nil {
+		return err
+	}
+	ids, err := readids(bn, leftdir, ldt)
+	if err != nil {
+		return err
+	}
+
+	// The right row of each kept left row, or -1 if there is no
+	// match
+	var rows []int
+	ix := make([]bool, len(ids))
+	for i, id := range ids {
+		r, ok := pos[id]
+		if !ok {
+			r = -1
+		}
+		ix[i] = ok || how == "left"
+		if ix[i] {
+			rows = append(rows, r)
+		}
+	}
+
+	if err := copier.CopyBucket(bn, ix); err != nil {
+		return err
+	}
+
+	tdt, err := config.ReadDtypes(bn, targetdir)
+	if err != nil {
+		return err
+	}
+
+	for j, vn := range vars {
+		dt := storedtype(rdt[vn])
+		wtr, fid, err := config.CreateColumn(bn, targetdir, tnames[j])
+		if err != nil {
+			return err
+		}
+		if dt == "bool" {
+			wtr = config.NewBoolWriter(wtr)
+		}
+
+		nw := config.CreateNulls(bn, targetdir, tnames[j])
+
+		missing := fill
+		if strings.HasPrefix(dt, "float") {
+			missing = math.Float64bits(math.NaN())
+		}
+		for _, r := range rows {
+			x, v := missing, false
+			if r >= 0 {
+				x, v = vals[j][r], valid[j] == nil || valid[j][r]
+			}
+			if err = config.WriteBits(wtr, dt, x); err != nil {
+				break
+			}
+			if err = nw.Put(v); err != nil {
+				break
+			}
+		}
+
+		err1 := wtr.Close()
+		err2 := fid.Close()
+		err3 := nw.Close()
+		for _, e := range []error{err, err1, err2, err3} {
+			if e != nil {
+				return fmt.Errorf("bucket %d, variable %s: %v", bn, vn, e)
+			}
+		}
+		tdt[tnames[j]] = dt
+	}
+
+	if err := config.WriteDtypes(bn, targetdir, tdt); err != nil {
+		return err
+	}
+
+	return config.RecordRows(bn, targetdir, len(rows))
+}
+
+// setup creates the target data set with the left configuration and
+// codes, and adds the codes of the factor-coded right variables and
+// the descriptions of the right variables.
+func setup() error {
+
+	if err := copier.Setup(lconf); err != nil {
+		return err
+	}
+
+	tconf, err := config.GetConfig(targetdir)
+	if err != nil {
+		return err
+	}
+
+	ldt, err := config.ReadDtypes(0, leftdir)
+	if err != nil {
+		return err
+	}
+	for _, vn := range vars {
+		tn := vn
+		if _, ok := ldt[tn]; ok {
+			tn = vn + suffix
+			if _, ok := ldt[tn]; ok {
+				return fmt.Errorf("variables %s and %s are both in %s", vn, tn, leftdir)
+			}
+		}
+		tnames = append(tnames, tn)
+	}
+
+	rcf, err := config.ReadCodeFiles(rconf)
+	if err != nil {
+		return err
+	}
+	tcf, err := config.ReadCodeFiles(tconf)
+	if err != nil {
+		return err
+	}
+
+	for j, vn := range vars {
+		if !config.HasFactorCodes(vn, rconf) {
+			continue
+		}
+		codes, err := config.GetFactorCodes(vn, rconf)
+		if err != nil {
+			return err
+		}
+		grp, ok := rcf[vn]
+		if !ok {
+			grp = vn
+		}
+
+		// Share a code group of the left data set only if it has
+		// the same codes.
+		if _, err := os.Stat(path.Join(tconf.CodesDir, grp+"Codes.json")); err == nil {
+			lcodes, err := config.GetFactorCodes(grp, tconf)
+			if err != nil {
+				return err
+			}
+			if !reflect.DeepEqual(lcodes, codes) {
+				grp = tnames[j]
+				if grp == vn {
+					grp += suffix
+				}
+			}
+		}
+
+		if err := config.WriteFactorCodes(grp, codes, tconf); err != nil {
+			return err
+		}
+		tcf[tnames[j]] = grp
+	}
+
+	if err := config.WriteCodeFiles(tconf, tcf); err != nil {
+		return err
+	}
+
+	// The left descriptions were copied with the left data set.
+	rinfo, err := config.ReadVarInfo(rightdir)
+	if err != nil {
+		return err
+	}
+	tinfo, err := config.ReadVarInfo(targetdir)
+	if err != nil {
+		return err
+	}
+	for j, vn := range vars {
+		if vi, ok := rinfo[vn]; ok {
+			tinfo[tnames[j]] = vi
+		}
+	}
+
+	return config.WriteVarInfo(targetdir, tinfo)
+}
+
+// Run runs join with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("join", flag.ContinueOnError)
+	var vlist string
+	fs.StringVar(&leftdir, "left", "", "data set whose rows are kept")
+	fs.StringVar(&rightdir, "right", "", "data set providing the added variables")
+	fs.StringVar(&targetdir, "targetdir", "", "destination directory")
+	fs.StringVar(&idvar, "idvar", "", "variable used to match rows")
+	fs.StringVar(&vlist, "vars", "", "comma-separated right variables to add")
+	fs.StringVar(&how, "how", "inner", "join type, inner or left")
+	fs.Uint64Var(&fill, "fill", 0, "value of integer variables in unmatched rows of a left join")
+	fs.StringVar(&suffix, "suffix", "_right", "appended to the names of right variables used in the left data set")
+	fs.BoolVar(&replace, "replace", false, "overwrite existing files")
+	fs.IntVar(&concurrency, "concurrency", runtime.NumCPU(), "number of buckets or variables processed in parallel")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	tnames = nil
+
+	if concurrency < 1 {
+		return errors.New("-concurrency must be positive")
+	}
+
+	if leftdir == "" || rightdir == "" || targetdir == "" || idvar == "" || vlist == "" ||
+		(how != "inner" && how != "left") || suffix == "" {
+		return cli.Usage("usage:\njoin -left=... -right=... -targetdir=... -idvar=... -vars=... [-how=inner|left] [-fill=...] [-suffix=...]\n\n")
+	}
+	vars = strings.Split(vlist, ",")
+
+	for _, dir := range []string{leftdir, rightdir} {
+		if err := config.CheckTarget(dir, targetdir); err != nil {
+			return err
+		}
+	}
+
+	if !replace {
+		_, err := os.Stat(targetdir)
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("Use -replace=true to overwrite existing contents of %s", targetdir)
+		}
+	}
+
+	var err error
+	lconf, err = config.GetConfig(leftdir)
+	if err != nil {
+		return err
+	}
+	rconf, err = config.GetConfig(rightdir)
+	if err != nil {
+		return err
+	}
+
+	if lconf.NumBuckets != rconf.NumBuckets {
+		return fmt.Errorf("%s has %d buckets but %s has %d buckets", leftdir, lconf.NumBuckets, rightdir, rconf.NumBuckets)
+	}
+	if lconf.Routing == nil || rconf.Routing == nil {
+		slog.Warn("cannot confirm that the data sets are routed on the id variable", "left", leftdir, "right", rightdir, "idvar", idvar)
+	} else if lconf.Routing.IdVar != idvar || !reflect.DeepEqual(lconf.Routing, rconf.Routing) {
+		return fmt.Errorf("%s and %s are not routed the same way on %s", leftdir, rightdir, idvar)
+	}
+
+	copier = &subset.Copier{SourceDir: leftdir, TargetDir: targetdir}
+	if err := setup(); err != nil {
+		return err
+	}
+
+	sem = make(chan bool, concurrency)
+	copier.Sem = sem
+
+	for k := 0; k < lconf.NumBuckets; k++ {
+		sem <- true
+		go dobucket(k)
+	}
+
+	for k := 0; k < concurrency; k++ {
+		sem <- true
+	}
+
+	if firsterr != nil {
+		return firsterr
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+// Package logging sets up the structured logs written by the commands.
+// The logging flags are registered with Flags, which cli.Parse does for
+// every command, and Setup makes the logger chosen by them the default
+// logger of log/slog once they are parsed.  The records are written as
+// key=value pairs or as JSON objects, so that fields such as the bucket
+// number can be extracted by other programs.  By default they go to
+// stderr, while the results of a command are written to stdout.
+//
+// The flags are:
+//
+//	-log         the log file, or - for stderr
+//	-log-format  text (key=value pairs) or json
+//	-v           also log debugging records
+//	-q           log only warnings and errors
+
+package logging
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Options holds the values of the logging flags.
+type Options struct {
+
+	// The log file, or "-" for stderr
+	File string
+
+	// The format of the records, "text" or "json"
+	Format string
+
+	// If true, debugging records are logged
+	Verbose bool
+
+	// If true, only warnings and errors are logged
+	Quiet bool
+}
+
+// Flags registers the logging flags in fs, with file as the default
+// log file ("-" for stderr).
+func Flags(fs *flag.FlagSet, file string) *Options {
+
+	o := new(Options)
+	fs.StringVar(&o.File, "log", file, "log file, - for stderr")
+	fs.StringVar(&o.Format, "log-format", "text", "format of the log records, text or json")
+	fs.BoolVar(&o.Verbose, "v", false, "also log debugging records")
+	fs.BoolVar(&o.Quiet, "q", false, "log only warnings and errors")
+
+	return o
+}
+
+// Setup creates the logger chosen by the logging flags of fs, which
+// were registered with Flags, and makes it the default logger of
+// log/slog.
+func Setup(fs *flag.FlagSet) (*slog.Logger, error) {
+
+	o := &Options{
+		File:    fs.Lookup("log").Value.String(),
+		Format:  fs.Lookup("log-format").Value.String(),
+		Verbose: fs.Lookup("v").Value.(flag.Getter).Get().(bool),
+		Quiet:   fs.Lookup("q").Value.(flag.Getter).Get().(bool),
+	}
+
+	logger, err := o.Logger()
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(logger)
+
+	return logger, nil
+}
+
+// Logger creates the log file if needed, and returns a logger writing
+// to it at the level chosen by the flags.
+func (o *Options) Logger() (*slog.Logger, error) {
+
+	if o.Verbose && o.Quiet {
+		return nil, fmt.Errorf("only one of -v and -q may be given")
+	}
+
+	level := slog.LevelInfo
+	if o.Verbose {
+		level = slog.LevelDebug
+	} else if o.Quiet {
+		level = slog.LevelWarn
+	}
+	hopts := &slog.HandlerOptions{Level: level}
+
+	var w io.Writer = os.Stderr
+	if o.File != "-" && o.File != "" {
+		fid, err := os.Create(o.File)
+		if err != nil {
+			return nil, err
+		}
+		w = fid
+	}
+
+	switch o.Format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, hopts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, hopts)), nil
+	}
+
+	return nil, fmt.Errorf("unknown log format %q, must be text or json", o.Format)
+}
@@ -0,0 +1,534 @@
+// Merge combines two or more data sets with the same variables and
+// data types into a single data set.  With -mode=append (the default),
+// the buckets of the sources are placed one after another in the
+// target, which has as many buckets as all the sources together.  With
+// -mode=buckets, the sources must have the same number of buckets, and
+// bucket k of the target holds the rows of bucket k of every source.
+// The routing of the sources is kept by -mode=buckets if all sources
+// use the same routing, and is otherwise dropped.
+//
+// The validity bitmaps of the sources (see config.NullSuffix) are
+// combined, so that missing values remain missing.
+//
+// The attributes, factor codes and variable descriptions of the first
+// source are kept.  Labels of the other sources are given the code
+// used by the first source, or a new code if the first source does not
+// have the label, and the columns of the other sources are re-encoded
+// where their codes differ.
+
+package merge
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The data sets being merged
+	sources []*source
+
+	// The directory where the merged data will be stored
+	targetdir string
+
+	// The merge mode, append or buckets
+	mode string
+
+	// Configuration information for the merged data
+	tconf *config.Config
+
+	// The names and data types of the variables
+	vnames []string
+	dtypes map[string]string
+
+	// If true, overwrite existing files
+	replace bool
+
+	// The number of buckets processed in parallel
+	concurrency int
+
+	// The first error encountered while processing the buckets
+	firsterr error
+	errmut   sync.Mutex
+
+	sem chan bool
+)
+
+// source is one of the data sets being merged.
+type source struct {
+	dir  string
+	conf *config.Config
+
+	// The map from the codes of the source to the codes of the
+	// target, for each factor-coded variable whose codes differ
+	recode map[string]map[uint64]uint64
+}
+
+// piece is a source bucket copied into a target bucket.
+type piece struct {
+	src *source
+	bn  int
+}
+
+// pieces returns the source buckets that make up a target bucket, in
+// order.
+func pieces(tb int) []piece {
+
+	if mode == "buckets" {
+		var pl []piece
+		for _, src := range sources {
+			pl = append(pl, piece{src, tb})
+		}
+		return pl
+	}
+
+	for _, src := range sources {
+		if tb < src.conf.NumBuckets {
+			return []piece{{src, tb}}
+		}
+		tb -= src.conf.NumBuckets
+	}
+
+	return nil
+}
+
+// getcodes determines the factor codes of the target, and how the
+// codes of each source are translated.
+func getcodes() (map[string]string, map[string]map[string]int, error) {
+
+	cf, err := config.ReadCodeFiles(sources[0].conf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tcf := make(map[string]string)
+	tcodes := make(map[string]map[string]int)
+	for _, vn := range vnames {
+		if !config.HasFactorCodes(vn, sources[0].conf) {
+			continue
+		}
+		grp, ok := cf[vn]
+		if !ok {
+			grp = vn
+		}
+		tcf[vn] = grp
+		if tcodes[grp] == nil {
+			tcodes[grp], err = config.GetFactorCodes(vn, sources[0].conf)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	for _, src := range sources[1:] {
+		src.recode = make(map[string]map[uint64]uint64)
+		for _, vn := range vnames {
+			has := config.HasFactorCodes(vn, src.conf)
+			if _, ok := tcf[vn]; has != ok {
+				return nil, nil, fmt.Errorf("variable %s is factor-coded in only one of %s and %s", vn, sources[0].dir, src.dir)
+			}
+			if !has {
+				continue
+			}
+
+			codes, err := config.GetFactorCodes(vn, src.conf)
+			if err != nil {
+				return nil, nil, err
+			}
+			rc, same := recodes(codes, tcodes[tcf[vn]])
+			if !same {
+				src.recode[vn] = rc
+			}
+		}
+	}
+
+	return tcf, tcodes, nil
+}
+
+// recodes returns the map from source codes to target codes, adding
+// the labels missing from the target codes.  The second return value
+// is true if no code changes.
+func recodes(codes, tcodes map[string]int) (map[uint64]uint64, bool) {
+
+	// Add new labels in the order of their source codes, so that
+	// the result does not depend on map iteration order.
+	var labs []string
+	for lab := range codes {
+		labs = append(labs, lab)
+	}
+	sort.Slice(labs, func(i, j int) bool { return codes[labs[i]] < codes[labs[j]] })
+
+	next := 0
+	for _, c := range tcodes {
+		if c >= next {
+			next = c + 1
+		}
+	}
+
+	rc := make(map[uint64]uint64)
+	same := true
+	for _, lab := range labs {
+		c := codes[lab]
+		t, ok := tcodes[lab]
+		if !ok {
+			t = next
+			next++
+			tcodes[lab] = t
+		}
+		rc[uint64(c)] = uint64(t)
+		same = same && t == c
+	}
+
+	return rc, same
+}
+
+// setup creates the target directory, and saves the configuration, the
+// factor codes and the descriptions of the variables there.
+func setup() error {
+
+	tconf = &config.Config{
+		Compression: sources[0].conf.Compression,
+		CodesDir:    path.Join(targetdir, "Codes"),
+		Attrs:       sources[0].conf.Attrs,
+	}
+
+	if mode == "buckets" {
+		tconf.NumBuckets = sources[0].conf.NumBuckets
+		tconf.Routing = sources[0].conf.Routing
+		for _, src := range sources[1:] {
+			if src.conf.NumBuckets != tconf.NumBuckets {
+				return fmt.Errorf("%s has %d buckets but %s has %d buckets", src.dir, src.conf.NumBuckets,
+					sources[0].dir, tconf.NumBuckets)
+			}
+			if !reflect.DeepEqual(src.conf.Routing, tconf.Routing) {
+				tconf.Routing = nil
+			}
+		}
+	} else {
+		for _, src := range sources {
+			tconf.NumBuckets += src.conf.NumBuckets
+		}
+	}
+
+	tcf, tcodes, err := getcodes()
+	if err != nil {
+		return err
+	}
+
+	for k := 0; k < tconf.NumBuckets; k++ {
+		err := os.MkdirAll(config.BucketPath(k, targetdir), 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = config.WriteConfig(targetdir, tconf)
+	if err != nil {
+		return err
+	}
+	err = config.CopyVarInfo(sources[0].dir, targetdir, nil)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(tconf.CodesDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	for grp, codes := range tcodes {
+		err = config.WriteFactorCodes(grp, codes, tconf)
+		if err != nil {
+			return err
+		}
+	}
+
+	return config.WriteCodeFiles(tconf, tcf)
+}
+
+// dobucket writes one target bucket.
+func dobucket(tb int) {
+
+	defer func() { <-sem }()
+
+	err := mergebucket(tb)
+	if err != nil {
+		errmut.Lock()
+		if firsterr == nil {
+			firsterr = err
+		}
+		errmut.Unlock()
+	}
+}
+
+// mergebucket writes the columns, dtypes and row count of one target
+// bucket.
+func mergebucket(tb int) error {
+
+	pl := pieces(tb)
+	for _, p := range pl {
+		if err := checkdtypes(p); err != nil {
+			return err
+		}
+	}
+
+	for _, vn := range vnames {
+		for _, fn := range config.ColumnFiles(vn, dtypes[vn]) {
+			wtr, fid, err := config.CreateColumn(tb, targetdir, fn)
+			if err != nil {
+				return err
+			}
+			if dtypes[vn] == "bool" {
+				wtr = config.NewBoolWriter(wtr)
+			}
+
+			var last uint64
+			for _, p := range pl {
+				err = copycolumn(wtr, p, fn, &last)
+				if err != nil {
+					break
+				}
+			}
+
+			err1 := wtr.Close()
+			err2 := fid.Close()
+			for _, e := range []error{err, err1, err2} {
+				if e != nil {
+					return fmt.Errorf("bucket %d, variable %s: %v", tb, vn, e)
+				}
+			}
+		}
+	}
+
+	for _, vn := range vnames {
+		if err := mergenulls(tb, vn, pl); err != nil {
+			return fmt.Errorf("bucket %d, variable %s: %v", tb, vn, err)
+		}
+	}
+
+	if err := config.WriteDtypes(tb, targetdir, dtypes); err != nil {
+		return err
+	}
+
+	// The columns were copied without counting the rows, so the
+	// number of rows is obtained from the sources.
+	var n int
+	for _, p := range pl {
+		m, err := config.NumRows(p.bn, p.src.dir)
+		if err != nil {
+			return err
+		}
+		n += m
+	}
+
+	return config.RecordRows(tb, targetdir, n)
+}
+
+// mergenulls writes the validity bitmap of a variable in a target
+// bucket, if any of the source buckets has missing values of the
+// variable.
+func mergenulls(tb int, vn string, pl []piece) error {
+
+	nw := config.CreateNulls(tb, targetdir, vn)
+
+	for _, p := range pl {
+		nr, err := config.OpenNulls(p.bn, p.src.dir, vn)
+		if err != nil {
+			nw.Close()
+			return err
+		}
+
+		if !nr.HasNulls() {
+			n, err := config.NumRows(p.bn, p.src.dir)
+			if err != nil {
+				nw.Close()
+				return err
+			}
+			for i := 0; i < n; i++ {
+				if err := nw.Put(true); err != nil {
+					nw.Close()
+					return err
+				}
+			}
+			continue
+		}
+
+		for {
+			x, err := nr.Valid()
+			if err == io.EOF {
+				break
+			}
+			if err == nil {
+				err = nw.Put(x)
+			}
+			if err != nil {
+				nr.Close()
+				nw.Close()
+				return err
+			}
+		}
+		nr.Close()
+	}
+
+	return nw.Close()
+}
+
+// checkdtypes confirms that a source bucket has the same variables and
+// data types as the first bucket of the first source.
+func checkdtypes(p piece) error {
+
+	dt, err := config.ReadDtypes(p.bn, p.src.dir)
+	if err != nil {
+		return err
+	}
+
+	if len(dt) != len(dtypes) {
+		return fmt.Errorf("bucket %d of %s has %d variables, expected %d", p.bn, p.src.dir, len(dt), len(dtypes))
+	}
+	for vn, d := range dtypes {
+		if dt[vn] != d {
+			return fmt.Errorf("variable %s has dtype %q in bucket %d of %s, expected %s", vn, dt[vn], p.bn, p.src.dir, d)
+		}
+	}
+
+	return nil
+}
+
+// copycolumn appends the values of a variable in a source bucket to a
+// target column.  vn is a file name given by config.ColumnFiles, so it
+// may be the file holding the bytes of a text variable.  last holds
+// the most recent value written to a delta-uvarint column.
+func copycolumn(w io.Writer, p piece, vn string, last *uint64) error {
+
+	dt := dtypes[vn]
+	rc := p.src.recode[vn]
+
+	if rc == nil && dt != "delta-uvarint" && dt != "bool" {
+		// The values can be copied unchanged, as can both files
+		// of a text variable.
+		rdr, fid, err := config.OpenColumn(p.bn, p.src.dir, vn)
+		if err != nil {
+			return err
+		}
+		defer fid.Close()
+		_, err = io.Copy(w, rdr)
+		return err
+	}
+
+	rdr, err := config.OpenReader(p.bn, p.src.dir, vn, dt)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+
+	for {
+		x, err := rdr.Uint()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if rc != nil {
+			y, ok := rc[x]
+			if !ok {
+				return fmt.Errorf("code %d in bucket %d of %s has no label", x, p.bn, p.src.dir)
+			}
+			x = y
+		}
+
+		if dt == "delta-uvarint" {
+			if x < *last {
+				return fmt.Errorf("values are not non-decreasing after merging bucket %d of %s", p.bn, p.src.dir)
+			}
+			err = config.WriteUint(w, "uvarint", x-*last)
+			*last = x
+		} else {
+			err = config.WriteUint(w, dt, x)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Run runs merge with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	fs.StringVar(&targetdir, "targetdir", "", "destination directory")
+	fs.StringVar(&mode, "mode", "append", "merge mode, append (renumber the buckets) or buckets (merge bucket-for-bucket)")
+	fs.BoolVar(&replace, "replace", false, "overwrite existing files")
+	fs.IntVar(&concurrency, "concurrency", runtime.NumCPU(), "number of buckets processed in parallel")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	sources, vnames = nil, nil
+
+	if concurrency < 1 {
+		return errors.New("-concurrency must be positive")
+	}
+
+	if targetdir == "" || fs.NArg() < 2 || (mode != "append" && mode != "buckets") {
+		return cli.Usage("usage:\nmerge -targetdir=... [-mode=append|buckets] sourcedir sourcedir...\n\n")
+	}
+
+	if !replace {
+		_, err := os.Stat(targetdir)
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("Use -replace=true to overwrite existing contents of %s", targetdir)
+		}
+	}
+
+	for _, dir := range fs.Args() {
+		if err := config.CheckTarget(dir, targetdir); err != nil {
+			return err
+		}
+		conf, err := config.GetConfig(dir)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, &source{dir: dir, conf: conf})
+	}
+
+	var err error
+	dtypes, err = config.ReadDtypes(0, sources[0].dir)
+	if err != nil {
+		return err
+	}
+	for vn := range dtypes {
+		vnames = append(vnames, vn)
+	}
+	sort.Strings(vnames)
+
+	if err := setup(); err != nil {
+		return err
+	}
+
+	sem = make(chan bool, concurrency)
+
+	for k := 0; k < tconf.NumBuckets; k++ {
+		sem <- true
+		go dobucket(k)
+	}
+
+	for k := 0; k < concurrency; k++ {
+		sem <- true
+	}
+
+	if firsterr != nil {
+		return firsterr
+	}
+
+	return nil
+}
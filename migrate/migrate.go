@@ -0,0 +1,61 @@
+// Migrate upgrades a data set to the current format version (see
+// config.FormatVersion), applying the registered migrations one
+// version at a time.  With -dryrun, the pending migrations are listed
+// and nothing is changed.
+
+package migrate
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+// Run runs migrate with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	var sourcedir string
+	var dryrun bool
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.BoolVar(&dryrun, "dryrun", false, "list the pending migrations without applying them")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" {
+		return cli.Usage("usage:\nmigrate -sourcedir=... [-dryrun]\n\n")
+	}
+
+	conf, err := config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	if conf.FormatVersion == config.FormatVersion {
+		slog.Info("the data set has the current format version", "sourcedir", sourcedir, "version", conf.FormatVersion)
+		return nil
+	}
+
+	if dryrun {
+		for _, mg := range config.Migrations(conf.FormatVersion) {
+			fmt.Printf("%d to %d: %s\n", mg.From, mg.From+1, mg.Description)
+		}
+		return nil
+	}
+
+	done, err := config.Migrate(sourcedir)
+	for _, mg := range done {
+		slog.Info("migrated", "from", mg.From, "to", mg.From+1, "change", mg.Description)
+	}
+	if err != nil {
+		return err
+	}
+
+	slog.Info("upgraded the data set", "sourcedir", sourcedir, "version", config.FormatVersion)
+
+	return nil
+}
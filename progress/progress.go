@@ -0,0 +1,96 @@
+// Package progress reports the progress of a command that processes
+// the buckets of a data set, periodically writing the number of
+// buckets completed, the numbers of rows scanned and selected, the
+// throughput and the estimated time remaining to stderr.
+
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reporter accumulates counts as buckets are completed and reports
+// them at regular intervals.
+type Reporter struct {
+
+	// The total number of buckets to process
+	NumBuckets int
+
+	// Where the reports are written, stderr if nil
+	Out io.Writer
+
+	start    time.Time
+	done     int
+	rows     int
+	selected int
+
+	mut  sync.Mutex
+	stop chan bool
+	wg   sync.WaitGroup
+}
+
+// Start begins writing a report every interval, until Stop is called.
+func (r *Reporter) Start(interval time.Duration) {
+
+	if r.Out == nil {
+		r.Out = os.Stderr
+	}
+	r.start = time.Now()
+	r.stop = make(chan bool)
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				r.report()
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// BucketDone records the completion of a bucket in which rows rows
+// were scanned and selected rows were selected.
+func (r *Reporter) BucketDone(rows, selected int) {
+	r.mut.Lock()
+	r.done++
+	r.rows += rows
+	r.selected += selected
+	r.mut.Unlock()
+}
+
+// Stop ends the periodic reports and writes a final report.
+func (r *Reporter) Stop() {
+	close(r.stop)
+	r.wg.Wait()
+	r.report()
+}
+
+// report writes one progress line.
+func (r *Reporter) report() {
+
+	r.mut.Lock()
+	done, rows, selected := r.done, r.rows, r.selected
+	r.mut.Unlock()
+
+	el := time.Since(r.start)
+	rate := float64(rows) / el.Seconds()
+
+	eta := "unknown"
+	if done > 0 {
+		rem := time.Duration(float64(el) * float64(r.NumBuckets-done) / float64(done))
+		eta = rem.Round(time.Second).String()
+	}
+
+	fmt.Fprintf(r.Out, "%d/%d buckets, %d rows scanned, %d selected, %.0f rows/s, elapsed %s, remaining %s\n",
+		done, r.NumBuckets, rows, selected, rate, el.Round(time.Second), eta)
+}
@@ -0,0 +1,225 @@
+// Project creates a copy of a columnized dataset retaining all of the
+// records, but only the variables named in -vars.  The column files
+// are copied without being decoded.  The factor codes of the retained
+// variables are copied, and the codes of the other variables are
+// omitted.
+
+package project
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+	"github.com/kshedden/gocols/subset"
+)
+
+var (
+	// The variables to retain
+	vars map[string]bool
+
+	// The directory where the projected data will be stored
+	targetdir string
+
+	// The directory where the full data are stored
+	sourcedir string
+
+	// Configuration information for the source data
+	conf *config.Config
+
+	// If true, overwrite existing files
+	replace bool
+
+	// The number of buckets processed in parallel
+	concurrency int
+
+	// The first error encountered while processing the buckets
+	firsterr error
+	errmut   sync.Mutex
+
+	sem chan bool
+)
+
+// setup creates the target directory, and saves the configuration and
+// the factor codes and descriptions of the retained variables there.
+func setup() error {
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		err := os.MkdirAll(config.BucketPath(k, targetdir), 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	tconf := *conf
+	tconf.CodesDir = path.Join(targetdir, "Codes")
+	err := config.WriteConfig(targetdir, &tconf)
+	if err != nil {
+		return err
+	}
+	err = config.CopyVarInfo(sourcedir, targetdir, func(vn string) bool { return vars[vn] })
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(tconf.CodesDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	cf, err := config.ReadCodeFiles(conf)
+	if err != nil {
+		return err
+	}
+
+	tcf := make(map[string]string)
+	for vn := range vars {
+		if !config.HasFactorCodes(vn, conf) {
+			continue
+		}
+		grp, ok := cf[vn]
+		if !ok {
+			grp = vn
+		}
+		tcf[vn] = grp
+		fn := grp + "Codes.json"
+		err = subset.CopyFile(path.Join(conf.CodesDir, fn), path.Join(tconf.CodesDir, fn))
+		if err != nil {
+			return err
+		}
+	}
+
+	return config.WriteCodeFiles(&tconf, tcf)
+}
+
+// dobucket copies the retained columns of one bucket.
+func dobucket(bn int) {
+
+	defer func() { <-sem }()
+
+	err := copybucket(bn)
+	if err != nil {
+		errmut.Lock()
+		if firsterr == nil {
+			firsterr = err
+		}
+		errmut.Unlock()
+	}
+}
+
+// copybucket copies the column files and writes the dtypes and row
+// count of one bucket.
+func copybucket(bn int) error {
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return err
+	}
+
+	tdtypes := make(map[string]string)
+	for vn := range vars {
+		dt, ok := dtypes[vn]
+		if !ok {
+			return fmt.Errorf("variable %s not found in bucket %d", vn, bn)
+		}
+		tdtypes[vn] = dt
+		for _, fn := range config.BucketFiles(bn, sourcedir, vn, dt) {
+			err = subset.CopyFile(config.ColumnPath(bn, sourcedir, fn), config.ColumnPath(bn, targetdir, fn))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := config.WriteDtypes(bn, targetdir, tdtypes); err != nil {
+		return err
+	}
+
+	n, err := config.NumRows(bn, sourcedir)
+	if err != nil {
+		return err
+	}
+	return config.RecordRows(bn, targetdir, n)
+}
+
+// Run runs project with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("project", flag.ContinueOnError)
+	var vlist string
+	fs.StringVar(&vlist, "vars", "", "comma-separated variables to retain")
+	fs.StringVar(&targetdir, "targetdir", "", "destination directory")
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.BoolVar(&replace, "replace", false, "overwrite existing files")
+	fs.IntVar(&concurrency, "concurrency", runtime.NumCPU(), "number of buckets processed in parallel")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if concurrency < 1 {
+		return errors.New("-concurrency must be positive")
+	}
+
+	if vlist == "" || targetdir == "" || sourcedir == "" {
+		return cli.Usage("usage:\nproject -vars=... -targetdir=... -sourcedir=...\n\n")
+	}
+
+	vars = make(map[string]bool)
+	for _, vn := range strings.Split(vlist, ",") {
+		vars[vn] = true
+	}
+
+	if err := config.CheckTarget(sourcedir, targetdir); err != nil {
+		return err
+	}
+
+	if !replace {
+		_, err := os.Stat(targetdir)
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("Use -replace=true to overwrite existing contents of %s", targetdir)
+		}
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	dtypes, err := config.ReadDtypes(0, sourcedir)
+	if err != nil {
+		return err
+	}
+	for vn := range vars {
+		if _, ok := dtypes[vn]; !ok {
+			return fmt.Errorf("Variable %s not found in %s", vn, sourcedir)
+		}
+	}
+
+	if err := setup(); err != nil {
+		return err
+	}
+
+	sem = make(chan bool, concurrency)
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		sem <- true
+		go dobucket(k)
+	}
+
+	for k := 0; k < concurrency; k++ {
+		sem <- true
+	}
+
+	if firsterr != nil {
+		return firsterr
+	}
+
+	return nil
+}
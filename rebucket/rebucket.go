@@ -0,0 +1,355 @@
+// Rebucket copies a data set into a new data set with a different
+// number of buckets.  If -idvar is given, each record is placed in the
+// bucket given by routing on its value (-routing hash or modulo), and
+// the routing is recorded in the configuration, so that data sets
+// rebucketed the same way can be joined.  Otherwise the records are
+// dealt to the buckets in round-robin order.
+//
+// The records of each target bucket keep the order of the source
+// buckets.  Delta-uvarint variables are stored as uvarint, since the
+// rebucketed values need not be sorted.  Missing values remain
+// missing.  The attributes of the data set, the factor codes and the
+// descriptions of the variables are copied.
+
+package rebucket
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+	"github.com/kshedden/gocols/subset"
+)
+
+var (
+	// The directory containing the source data
+	sourcedir string
+
+	// The directory where the rebucketed data will be stored
+	targetdir string
+
+	// The number of buckets in the target
+	nbuckets int
+
+	// The variable used to route records to buckets, if any
+	idvar string
+
+	// The routing method, modulo or hash
+	routing string
+
+	// The compression of the target column files
+	compression string
+
+	// If true, overwrite existing files
+	replace bool
+
+	// Configuration information for the source and target data
+	conf, tconf *config.Config
+
+	// The names and source data types of the variables
+	vnames []string
+	dtypes map[string]string
+
+	// The writers for each target bucket and variable
+	wtrs [][]io.WriteCloser
+	fids [][]io.Closer
+
+	// The writers of the validity bitmaps for each target bucket
+	// and variable
+	nwtrs [][]*config.NullWriter
+
+	// The number of records written so far, and the number written
+	// to each target bucket
+	nrec  int
+	nrows []int
+)
+
+// storedtype returns the data type of a variable in the target.
+func storedtype(dt string) string {
+	if dt == "delta-uvarint" {
+		return "uvarint"
+	}
+	return dt
+}
+
+// setup creates the target data set and opens the column files.
+func setup() error {
+
+	tconf = &config.Config{
+		NumBuckets:  nbuckets,
+		Compression: compression,
+		CodesDir:    path.Join(targetdir, "Codes"),
+		Attrs:       conf.Attrs,
+	}
+	if idvar != "" {
+		tconf.Routing = &config.Routing{IdVar: idvar, Method: routing}
+	}
+
+	err := os.MkdirAll(targetdir, 0755)
+	if err != nil {
+		return err
+	}
+	err = config.WriteConfig(targetdir, tconf)
+	if err != nil {
+		return err
+	}
+	err = subset.CopyCodes(conf.CodesDir, tconf.CodesDir)
+	if err != nil {
+		return err
+	}
+	err = config.CopyVarInfo(sourcedir, targetdir, nil)
+	if err != nil {
+		return err
+	}
+
+	tdt := make(map[string]string)
+	for vn, dt := range dtypes {
+		tdt[vn] = storedtype(dt)
+	}
+
+	wtrs = make([][]io.WriteCloser, nbuckets)
+	fids = make([][]io.Closer, nbuckets)
+	nwtrs = make([][]*config.NullWriter, nbuckets)
+	nrows = make([]int, nbuckets)
+	for k := 0; k < nbuckets; k++ {
+		err = os.MkdirAll(config.BucketPath(k, targetdir), 0755)
+		if err != nil {
+			return err
+		}
+		err = config.WriteDtypes(k, targetdir, tdt)
+		if err != nil {
+			return err
+		}
+		for _, vn := range vnames {
+			w, f, err := config.CreateColumn(k, targetdir, vn)
+			if err != nil {
+				return err
+			}
+			if tdt[vn] == "bool" {
+				w = config.NewBoolWriter(w)
+			}
+			wtrs[k] = append(wtrs[k], w)
+			fids[k] = append(fids[k], f)
+			nwtrs[k] = append(nwtrs[k], config.CreateNulls(k, targetdir, vn))
+		}
+	}
+
+	return nil
+}
+
+// targets returns the target bucket of each record in a source
+// bucket.
+func targets(bn int) ([]int, error) {
+
+	if idvar == "" {
+		n, err := config.NumRows(bn, sourcedir)
+		if err != nil {
+			return nil, err
+		}
+		tb := make([]int, n)
+		for i := range tb {
+			tb[i] = (nrec + i) % nbuckets
+		}
+		return tb, nil
+	}
+
+	rdr, err := config.OpenReader(bn, sourcedir, idvar, dtypes[idvar])
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+
+	var tb []int
+	for {
+		id, err := rdr.Uint()
+		if err == io.EOF {
+			return tb, nil
+		} else if err != nil {
+			return nil, err
+		}
+		k, err := config.Route(tconf, id)
+		if err != nil {
+			return nil, err
+		}
+		tb = append(tb, k)
+	}
+}
+
+// dobucket distributes the records of one source bucket to the target
+// buckets.
+func dobucket(bn int) error {
+
+	sdt, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return err
+	}
+	if len(sdt) != len(dtypes) {
+		return fmt.Errorf("bucket %d has %d variables, expected %d", bn, len(sdt), len(dtypes))
+	}
+	for vn, dt := range dtypes {
+		if sdt[vn] != dt {
+			return fmt.Errorf("variable %s has dtype %q in bucket %d, expected %s", vn, sdt[vn], bn, dt)
+		}
+	}
+
+	tb, err := targets(bn)
+	if err != nil {
+		return err
+	}
+
+	for j, vn := range vnames {
+		rdr, err := config.OpenReader(bn, sourcedir, vn, dtypes[vn])
+		if err != nil {
+			return err
+		}
+		nr, err := config.OpenNulls(bn, sourcedir, vn)
+		if err != nil {
+			rdr.Close()
+			return err
+		}
+		dt := storedtype(dtypes[vn])
+		for i := range tb {
+			x, err := rdr.Bits()
+			var valid bool
+			if err == nil {
+				valid, err = nr.Valid()
+			}
+			if err == io.EOF {
+				err = fmt.Errorf("has %d rows, expected %d", i, len(tb))
+			}
+			if err == nil {
+				err = config.WriteBits(wtrs[tb[i]][j], dt, x)
+			}
+			if err == nil {
+				err = nwtrs[tb[i]][j].Put(valid)
+			}
+			if err != nil {
+				rdr.Close()
+				nr.Close()
+				return fmt.Errorf("bucket %d, variable %s: %v", bn, vn, err)
+			}
+		}
+		rdr.Close()
+		nr.Close()
+	}
+	nrec += len(tb)
+	for _, k := range tb {
+		nrows[k]++
+	}
+
+	return nil
+}
+
+// finish closes the column files and saves the number of rows in each
+// bucket.
+func finish() error {
+
+	for k := range wtrs {
+		for j := range wtrs[k] {
+			if err := wtrs[k][j].Close(); err != nil {
+				return err
+			}
+			if err := fids[k][j].Close(); err != nil {
+				return err
+			}
+			if err := nwtrs[k][j].Close(); err != nil {
+				return err
+			}
+		}
+		if err := config.RecordRows(k, targetdir, nrows[k]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run runs rebucket with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("rebucket", flag.ContinueOnError)
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&targetdir, "targetdir", "", "destination directory")
+	fs.IntVar(&nbuckets, "buckets", 0, "number of buckets in the target")
+	fs.StringVar(&idvar, "idvar", "", "variable used to route records to buckets")
+	fs.StringVar(&routing, "routing", "hash", "routing method for -idvar, hash or modulo")
+	fs.StringVar(&compression, "compression", "", "compression of the column files (default is the source compression)")
+	fs.BoolVar(&replace, "replace", false, "overwrite existing files")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	nrec, vnames = 0, nil
+
+	if sourcedir == "" || targetdir == "" || nbuckets < 1 || (routing != "modulo" && routing != "hash") {
+		return cli.Usage("usage:\nrebucket -sourcedir=... -targetdir=... -buckets=... [-idvar=... [-routing=hash|modulo]]\n\n")
+	}
+
+	if err := config.CheckTarget(sourcedir, targetdir); err != nil {
+		return err
+	}
+
+	if !replace {
+		_, err := os.Stat(targetdir)
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("Use -replace=true to overwrite existing contents of %s", targetdir)
+		}
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+	if compression == "" {
+		compression = conf.Compression
+	}
+	if _, err := config.GetCodec(compression); err != nil {
+		return err
+	}
+
+	dtypes, err = config.ReadDtypes(0, sourcedir)
+	if err != nil {
+		return err
+	}
+	for vn := range dtypes {
+		vnames = append(vnames, vn)
+	}
+	sort.Strings(vnames)
+	if len(vnames) == 0 {
+		return errors.New("The data set has no variables")
+	}
+
+	if idvar != "" {
+		dt, ok := dtypes[idvar]
+		if !ok || dt == "varint" || config.IsTime(dt) || strings.HasPrefix(dt, "float") {
+			return fmt.Errorf("The id variable %s must be in the data with an unsigned integer dtype", idvar)
+		}
+	}
+
+	if err := setup(); err != nil {
+		return err
+	}
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		if err := dobucket(k); err != nil {
+			return err
+		}
+	}
+
+	if err := finish(); err != nil {
+		return err
+	}
+
+	slog.Info("wrote records", "records", nrec, "buckets", nbuckets)
+
+	return nil
+}
@@ -0,0 +1,245 @@
+// Recode changes the labels of a factor-coded variable according to a
+// mapping file, and rewrites the codes of the variable in every
+// bucket.  Each line of the mapping file is an old label and a new
+// label separated by a comma.  Several old labels may be mapped to the
+// same new label, collapsing them into one level.  Labels that are not
+// in the mapping file are unchanged.
+//
+// A new label that is already a label of the variable keeps its code.
+// Otherwise it is given the smallest code of the old labels mapped to
+// it.  As in collapserare, the variable is given its own code group,
+// so that other variables sharing its original group are unaffected.
+
+package recode
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The factor-coded variable
+	vname string
+
+	// The file mapping old labels to new labels
+	mapfile string
+
+	// Configuration information for the data set
+	conf *config.Config
+)
+
+// readmap returns the map from old labels to new labels.
+func readmap() (map[string]string, error) {
+
+	fid, err := os.Open(mapfile)
+	if err != nil {
+		return nil, err
+	}
+	defer fid.Close()
+
+	rdr := csv.NewReader(fid)
+	rdr.FieldsPerRecord = 2
+
+	mp := make(map[string]string)
+	for {
+		rec, err := rdr.Read()
+		if err == io.EOF {
+			return mp, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if nl, ok := mp[rec[0]]; ok && nl != rec[1] {
+			return nil, fmt.Errorf("label %q is mapped to both %q and %q", rec[0], nl, rec[1])
+		}
+		mp[rec[0]] = rec[1]
+	}
+}
+
+// newcodes returns the codes of the recoded variable, and the map from
+// old codes to new codes for the codes that change.
+func newcodes(codes map[string]int, mp map[string]string) (map[string]int, map[uint64]uint64) {
+
+	ncodes := make(map[string]int)
+	for lab, c := range codes {
+		if _, ok := mp[lab]; !ok {
+			ncodes[lab] = c
+		}
+	}
+
+	// Visit the old labels in code order, so that each new label
+	// gets the smallest code of the labels mapped to it.
+	var olds []string
+	for lab := range mp {
+		if _, ok := codes[lab]; ok {
+			olds = append(olds, lab)
+		}
+	}
+	sort.Slice(olds, func(i, j int) bool { return codes[olds[i]] < codes[olds[j]] })
+
+	for _, lab := range olds {
+		nl := mp[lab]
+		if _, ok := ncodes[nl]; !ok {
+			ncodes[nl] = codes[lab]
+		}
+	}
+
+	recode := make(map[uint64]uint64)
+	for _, lab := range olds {
+		c, nc := codes[lab], ncodes[mp[lab]]
+		if c != nc {
+			recode[uint64(c)] = uint64(nc)
+		}
+	}
+
+	return ncodes, recode
+}
+
+// getdtype returns the data type of the variable in a bucket.
+func getdtype(bn int) (string, error) {
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return "", err
+	}
+	dt, ok := dtypes[vname]
+	if !ok {
+		return "", fmt.Errorf("Variable %s not found in bucket %d", vname, bn)
+	}
+
+	return dt, nil
+}
+
+// rewrite recodes the variable in one bucket using the given map from
+// old codes to new codes.
+func rewrite(bn int, recode map[uint64]uint64) error {
+
+	dt, err := getdtype(bn)
+	if err != nil {
+		return err
+	}
+
+	rdr, err := config.OpenReader(bn, sourcedir, vname, dt)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+
+	tmpname := vname + ".tmp"
+	wtr, fid2, err := config.CreateColumn(bn, sourcedir, tmpname)
+	if err != nil {
+		return err
+	}
+
+	for {
+		x, err := rdr.Uint()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		y, ok := recode[x]
+		if !ok {
+			y = x
+		}
+		err = config.WriteUint(wtr, dt, y)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := wtr.Close(); err != nil {
+		return err
+	}
+	if err := fid2.Close(); err != nil {
+		return err
+	}
+
+	err = os.Rename(config.ColumnPath(bn, sourcedir, tmpname), config.ColumnPath(bn, sourcedir, vname))
+	if err != nil {
+		return err
+	}
+	if err := config.UpdateMeta(bn, sourcedir); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Run runs recode with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("recode", flag.ContinueOnError)
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&vname, "var", "", "factor-coded variable")
+	fs.StringVar(&mapfile, "map", "", "file of old,new label pairs")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" || vname == "" || mapfile == "" {
+		return cli.Usage("usage:\nrecode -sourcedir=... -var=... -map=...\n\n")
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	if !config.HasFactorCodes(vname, conf) {
+		return fmt.Errorf("Variable %s is not factor-coded", vname)
+	}
+
+	mp, err := readmap()
+	if err != nil {
+		return fmt.Errorf("Cannot read %s: %v", mapfile, err)
+	}
+
+	codes, err := config.GetFactorCodes(vname, conf)
+	if err != nil {
+		return err
+	}
+	for lab := range mp {
+		if _, ok := codes[lab]; !ok {
+			slog.Warn("label not found", "var", vname, "label", lab)
+		}
+	}
+
+	ncodes, recode := newcodes(codes, mp)
+
+	if len(recode) > 0 {
+		for bn := 0; bn < conf.NumBuckets; bn++ {
+			if err := rewrite(bn, recode); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := config.WriteFactorCodes(vname, ncodes, conf); err != nil {
+		return err
+	}
+	cf, err := config.ReadCodeFiles(conf)
+	if err != nil {
+		return err
+	}
+	cf[vname] = vname
+	if err := config.WriteCodeFiles(conf, cf); err != nil {
+		return err
+	}
+
+	slog.Info("recoded variable", "var", vname, "levels", len(codes), "newlevels", len(ncodes), "rewritten", len(recode))
+
+	return nil
+}
@@ -0,0 +1,204 @@
+// Recompress changes the compression of some variables of a data set,
+// in place.  The compression is recorded in the configuration for
+// each variable (see Config.ColumnCompression), overriding the
+// compression of the data set, so that for example large factors with
+// few levels can be stored with "zstd-19" while the other variables
+// keep snappy.  With -compression=default, the variables return to
+// the compression of the data set.
+//
+// The columns are written to temporary files in every bucket before
+// any column is replaced.
+
+package recompress
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The variables to recompress
+	vars []string
+
+	// The data types of the variables
+	dtypes map[string]string
+
+	// The new compression, and its codec
+	compression string
+	codec       *config.Codec
+
+	// Configuration information for the data set
+	conf *config.Config
+)
+
+// tmppath returns the path of the temporary file for a column.
+func tmppath(bn int, vn string) string {
+	return path.Join(config.BucketPath(bn, sourcedir), vn+codec.Ext+".tmp")
+}
+
+// bucketfiles returns the files of the variables in one bucket, as
+// named by config.BucketFiles.
+func bucketfiles(bn int) []string {
+
+	var files []string
+	for _, vn := range vars {
+		files = append(files, config.BucketFiles(bn, sourcedir, vn, dtypes[vn])...)
+	}
+
+	return files
+}
+
+// recompress writes a column of one bucket with the new codec to a
+// temporary file.
+func recompress(bn int, vn string) error {
+
+	rdr, rfid, err := config.OpenColumn(bn, sourcedir, vn)
+	if err != nil {
+		return err
+	}
+	defer rfid.Close()
+
+	fid, err := os.Create(tmppath(bn, vn))
+	if err != nil {
+		return err
+	}
+
+	wtr, err := codec.NewWriter(fid)
+	if err != nil {
+		fid.Close()
+		return err
+	}
+
+	_, err = io.Copy(wtr, rdr)
+	err1 := wtr.Close()
+	err2 := fid.Close()
+	for _, e := range []error{err, err1, err2} {
+		if e != nil {
+			return fmt.Errorf("variable %s, bucket %d: %v", vn, bn, e)
+		}
+	}
+
+	return nil
+}
+
+// cleanup removes the temporary files.
+func cleanup() {
+	for k := 0; k < conf.NumBuckets; k++ {
+		for _, vn := range bucketfiles(k) {
+			os.Remove(tmppath(k, vn))
+		}
+	}
+}
+
+// Run runs recompress with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("recompress", flag.ContinueOnError)
+	var vlist string
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&vlist, "vars", "", "comma-separated variables to recompress")
+	fs.StringVar(&compression, "compression", "", "new compression, e.g. zstd-19, or default for the compression of the data set")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" || vlist == "" || compression == "" {
+		return cli.Usage("usage:\nrecompress -sourcedir=... -vars=... -compression=...\n\n")
+	}
+	vars = strings.Split(vlist, ",")
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	name := compression
+	if compression == "default" {
+		name = conf.Compression
+	}
+	codec, err = config.GetCodec(name)
+	if err != nil {
+		return err
+	}
+
+	dtypes, err = config.ReadDtypes(0, sourcedir)
+	if err != nil {
+		return err
+	}
+	for _, vn := range vars {
+		if _, ok := dtypes[vn]; !ok {
+			return fmt.Errorf("Variable %s not found in bucket 0", vn)
+		}
+	}
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		for _, vn := range bucketfiles(k) {
+			if err := recompress(k, vn); err != nil {
+				cleanup()
+				return err
+			}
+		}
+	}
+
+	// The old files are removed before the configuration is
+	// updated, since their names depend on the old compression.
+	for k := 0; k < conf.NumBuckets; k++ {
+		for _, vn := range bucketfiles(k) {
+			oldpath := config.ColumnPath(k, sourcedir, vn)
+			newpath := path.Join(config.BucketPath(k, sourcedir), vn+codec.Ext)
+			if err := os.Rename(tmppath(k, vn), newpath); err != nil {
+				return err
+			}
+			if oldpath != newpath {
+				if err := os.Remove(oldpath); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, vn := range vars {
+		if compression == "default" {
+			delete(conf.ColumnCompression, vn)
+			continue
+		}
+		if conf.ColumnCompression == nil {
+			conf.ColumnCompression = make(map[string]string)
+		}
+		conf.ColumnCompression[vn] = compression
+	}
+	if len(conf.ColumnCompression) == 0 {
+		conf.ColumnCompression = nil
+	}
+	if err := config.WriteConfig(sourcedir, conf); err != nil {
+		return err
+	}
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		if meta, err := config.ReadMeta(k, sourcedir); err == nil {
+			meta, err = config.ComputeMeta(k, sourcedir, meta.IdVar)
+			if err != nil {
+				return err
+			}
+			if err := config.WriteMeta(k, sourcedir, meta); err != nil {
+				return err
+			}
+		}
+	}
+
+	slog.Info("recompressed variables", "vars", len(vars), "codec", codec.Name, "buckets", conf.NumBuckets)
+
+	return nil
+}
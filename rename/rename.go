@@ -0,0 +1,280 @@
+// Rename changes the names of variables in a data set, in place.  The
+// new names are given as old:new pairs, for example
+// -names=ht:height,wt:weight.  The column files and indexes are moved
+// in every bucket, and the names are changed in dtypes.json, in the
+// manifest.json and meta.json files of the buckets if there are any, in
+// the map of code groups, in the routing information, in the
+// compression settings and in the descriptions of the variables.
+// Factor-coded variables keep their code groups, so no codes files are
+// moved.  The new names must not be used by existing variables.
+
+package rename
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The new name of each renamed variable
+	newnames map[string]string
+
+	// Configuration information for the data set
+	conf *config.Config
+)
+
+// updatemanifest renames the variables in the manifest.json file of a
+// bucket, if there is one.
+func updatemanifest(bn int) error {
+
+	fn := path.Join(config.BucketPath(bn, sourcedir), "manifest.json")
+	b, err := ioutil.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var man map[string]interface{}
+	if err := json.Unmarshal(b, &man); err != nil {
+		return fmt.Errorf("cannot read %s: %v", fn, err)
+	}
+	cols, _ := man["Columns"].([]interface{})
+	for _, c := range cols {
+		cm, _ := c.(map[string]interface{})
+		vn, _ := cm["Name"].(string)
+		if nn, ok := newnames[vn]; ok {
+			cm["Name"] = nn
+			if pa, ok := cm["Path"].(string); ok {
+				cm["Path"] = path.Join(path.Dir(pa), nn+strings.TrimPrefix(path.Base(pa), vn))
+			}
+		}
+	}
+
+	b, err = json.Marshal(man)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(fn, append(b, '\n'), 0644)
+}
+
+// updatemeta renames the variables in the meta.json file of a bucket,
+// if there is one.
+func updatemeta(bn int) error {
+
+	meta, err := config.ReadMeta(bn, sourcedir)
+	if err != nil {
+		return nil
+	}
+
+	for vn, nn := range newnames {
+		for _, sfx := range []string{"", config.TextSuffix, config.NullSuffix} {
+			if n, ok := meta.Sizes[vn+sfx]; ok {
+				delete(meta.Sizes, vn+sfx)
+				meta.Sizes[nn+sfx] = n
+			}
+			if c, ok := meta.Checksums[vn+sfx]; ok {
+				delete(meta.Checksums, vn+sfx)
+				meta.Checksums[nn+sfx] = c
+			}
+		}
+		if r, ok := meta.Ranges[vn]; ok {
+			delete(meta.Ranges, vn)
+			meta.Ranges[nn] = r
+		}
+		if meta.IdVar == vn {
+			meta.IdVar = nn
+		}
+	}
+
+	return config.WriteMeta(bn, sourcedir, meta)
+}
+
+// dobucket renames the variables in one bucket.
+func dobucket(bn int) error {
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return err
+	}
+
+	for vn, nn := range newnames {
+		dt, ok := dtypes[vn]
+		if !ok {
+			return fmt.Errorf("variable %s not found in bucket %d", vn, bn)
+		}
+		// The new name keeps the compression of the old name,
+		// which is moved in the configuration after every
+		// bucket is done.
+		codec, err := config.ColumnCodec(sourcedir, vn)
+		if err != nil {
+			return err
+		}
+		for _, fn := range config.BucketFiles(bn, sourcedir, vn, dt) {
+			newpath := path.Join(config.BucketPath(bn, sourcedir), nn+strings.TrimPrefix(fn, vn)+codec.Ext)
+			if err := os.Rename(config.ColumnPath(bn, sourcedir, fn), newpath); err != nil {
+				return err
+			}
+		}
+		for _, ip := range []func(int, string, string) string{config.BloomPath, config.SortIndexPath} {
+			err = os.Rename(ip(bn, sourcedir, vn), ip(bn, sourcedir, nn))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		delete(dtypes, vn)
+		dtypes[nn] = dt
+	}
+
+	if err := config.WriteDtypes(bn, sourcedir, dtypes); err != nil {
+		return err
+	}
+
+	if err := updatemanifest(bn); err != nil {
+		return err
+	}
+
+	return updatemeta(bn)
+}
+
+// renamecodes points the new names at the code groups of the old
+// names.
+func renamecodes() error {
+
+	cf, err := config.ReadCodeFiles(conf)
+	if err != nil {
+		return err
+	}
+
+	var changed bool
+	for vn, nn := range newnames {
+		if !config.HasFactorCodes(vn, conf) {
+			continue
+		}
+		grp, ok := cf[vn]
+		if !ok {
+			grp = vn
+		}
+		delete(cf, vn)
+		cf[nn] = grp
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return config.WriteCodeFiles(conf, cf)
+}
+
+// Run runs rename with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("rename", flag.ContinueOnError)
+	var nlist string
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&nlist, "names", "", "comma-separated old:new pairs")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" || nlist == "" {
+		return cli.Usage("usage:\nrename -sourcedir=... -names=old:new,...\n\n")
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	dtypes, err := config.ReadDtypes(0, sourcedir)
+	if err != nil {
+		return err
+	}
+
+	newnames = make(map[string]string)
+	used := make(map[string]bool)
+	for _, f := range strings.Split(nlist, ",") {
+		v := strings.Split(f, ":")
+		if len(v) != 2 || v[0] == "" || v[1] == "" {
+			return fmt.Errorf("Invalid entry %s, expected old:new", f)
+		}
+		if _, ok := dtypes[v[0]]; !ok {
+			return fmt.Errorf("Variable %s not found in bucket 0", v[0])
+		}
+		if _, ok := dtypes[v[1]]; ok || used[v[1]] {
+			return fmt.Errorf("The name %s is already used", v[1])
+		}
+		if _, ok := newnames[v[0]]; ok {
+			return fmt.Errorf("Variable %s is renamed more than once", v[0])
+		}
+		newnames[v[0]] = v[1]
+		used[v[1]] = true
+	}
+
+	// Rename the code groups first, since HasFactorCodes uses the
+	// old names.
+	if err := renamecodes(); err != nil {
+		return err
+	}
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		if err := dobucket(k); err != nil {
+			return err
+		}
+	}
+
+	var changed bool
+	if conf.Routing != nil {
+		if nn, ok := newnames[conf.Routing.IdVar]; ok {
+			conf.Routing.IdVar = nn
+			changed = true
+		}
+	}
+	for vn, nn := range newnames {
+		if c, ok := conf.ColumnCompression[vn]; ok {
+			delete(conf.ColumnCompression, vn)
+			conf.ColumnCompression[nn] = c
+			changed = true
+		}
+	}
+	if changed {
+		if err := config.WriteConfig(sourcedir, conf); err != nil {
+			return err
+		}
+	}
+
+	info, err := config.ReadVarInfo(sourcedir)
+	if err != nil {
+		return err
+	}
+	if len(info) > 0 {
+		for vn, nn := range newnames {
+			if vi, ok := info[vn]; ok {
+				delete(info, vn)
+				info[nn] = vi
+			}
+		}
+		if err := config.WriteVarInfo(sourcedir, info); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("renamed variables", "vars", len(newnames), "buckets", conf.NumBuckets)
+
+	return nil
+}
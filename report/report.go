@@ -0,0 +1,168 @@
+// Package report writes a machine-readable account of a run of a
+// command into its target directory, as the file report.json, so that
+// the results can be checked by other programs without parsing logs.
+// The report holds the command line, the value of every flag, the
+// source and target directories, the start and end times, the numbers
+// of rows scanned and selected in each bucket and in total, any
+// warnings, and whether the run succeeded.
+//
+// A command creates a Report with New after parsing its flags,
+// records each bucket with Mask as it is completed, and calls Write
+// when it finishes.
+
+package report
+
+import (
+	"encoding/json"
+	"flag"
+	"sync"
+	"time"
+
+	"github.com/kshedden/gocols/config"
+)
+
+// FileName is the name of the report file in the target directory.
+const FileName = "report.json"
+
+// Bucket describes the processing of one bucket.
+type Bucket struct {
+
+	// The bucket number
+	Bucket int
+
+	// True if the bucket was completed
+	Done bool
+
+	// True if the bucket was completed by an earlier run, in which
+	// case Rows and Selected are not known
+	Resumed bool `json:",omitempty"`
+
+	// The number of rows in the source bucket
+	Rows int
+
+	// The number of rows written to the target bucket
+	Selected int
+}
+
+// Report is the content of report.json.
+type Report struct {
+
+	// The name of the command and its arguments
+	Command string
+	Args    []string
+
+	// The values of all the flags of the command, including those
+	// left at their defaults
+	Flags map[string]string
+
+	SourceDir string
+	TargetDir string
+
+	Start time.Time
+	End   time.Time
+
+	// The duration of the run in seconds
+	Seconds float64
+
+	// "ok" if the run succeeded, otherwise "failed" with the error
+	// in Error
+	Status string
+	Error  string `json:",omitempty"`
+
+	Buckets []Bucket
+
+	// The totals over the completed buckets
+	Rows     int
+	Selected int
+
+	Warnings []string
+
+	mut sync.Mutex
+}
+
+// New starts a report of a run of the command whose flag set is fs,
+// with the given arguments, that processes the given number of
+// buckets.  It must be called after the flags are parsed.
+func New(fs *flag.FlagSet, args []string, sourcedir, targetdir string, nbuckets int) *Report {
+
+	r := &Report{
+		Command:   fs.Name(),
+		Args:      args,
+		Flags:     make(map[string]string),
+		SourceDir: sourcedir,
+		TargetDir: targetdir,
+		Start:     time.Now(),
+		Buckets:   make([]Bucket, nbuckets),
+		Warnings:  []string{},
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		r.Flags[f.Name] = f.Value.String()
+	})
+
+	for k := range r.Buckets {
+		r.Buckets[k].Bucket = k
+	}
+
+	return r
+}
+
+// Mask records that a bucket was completed, where ix is its selection
+// mask.  It may be called from several goroutines at once.
+func (r *Report) Mask(bn int, ix []bool) {
+
+	var m int
+	for _, f := range ix {
+		if f {
+			m++
+		}
+	}
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.Buckets[bn] = Bucket{Bucket: bn, Done: true, Rows: len(ix), Selected: m}
+}
+
+// Resumed records that a bucket was completed by an earlier run.
+func (r *Report) Resumed(bn int) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.Buckets[bn] = Bucket{Bucket: bn, Done: true, Resumed: true}
+}
+
+// Warn records a warning.
+func (r *Report) Warn(msg string) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.Warnings = append(r.Warnings, msg)
+}
+
+// Write completes the report, where err is the error that ended the
+// run or nil if it succeeded, and saves it in the target directory.
+func (r *Report) Write(err error) error {
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.End = time.Now()
+	r.Seconds = r.End.Sub(r.Start).Seconds()
+
+	r.Status = "ok"
+	if err != nil {
+		r.Status = "failed"
+		r.Error = err.Error()
+	}
+
+	r.Rows, r.Selected = 0, 0
+	for _, b := range r.Buckets {
+		r.Rows += b.Rows
+		r.Selected += b.Selected
+	}
+
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return config.WriteFile(config.Join(r.TargetDir, FileName), append(b, '\n'))
+}
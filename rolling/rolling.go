@@ -0,0 +1,288 @@
+// Rolling computes a rolling aggregate of a variable over a window of
+// consecutive rows, and stores the result as a new float64 variable
+// in the data set.  The rows of each bucket must be sorted by a key
+// variable, and the window is restarted whenever the key changes, so
+// that each aggregate only involves rows with the same key.  The
+// window ending at a row includes that row and up to window-1
+// preceding rows.
+
+package rolling
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The variable that the rows are sorted by
+	byvar string
+
+	// The variable to aggregate
+	valvar string
+
+	// The name of the new variable
+	outvar string
+
+	// The number of rows in the window
+	window int
+
+	// The aggregate to compute, sum, mean or max
+	agg string
+
+	// If true, overwrite an existing variable named outvar
+	replace bool
+
+	// Configuration information for the data set
+	conf *config.Config
+)
+
+// key holds a value of the sort variable.  Only u is used for
+// unsigned integer types and only f is used for the other types.
+type key struct {
+	u uint64
+	f float64
+}
+
+func (a key) less(b key) bool {
+	return a.u < b.u || (a.u == b.u && a.f < b.f)
+}
+
+// readkey reads one value of the sort variable.
+func readkey(rdr *config.ColumnReader) (key, error) {
+
+	switch rdr.Dtype() {
+	case "float32", "float64", "varint", "date32", "timestamp64":
+		f, err := rdr.Float()
+		return key{f: f}, err
+	}
+
+	u, err := rdr.Uint()
+	return key{u: u}, err
+}
+
+// getdtypes returns the data types of the sort variable and the
+// aggregated variable in a bucket.
+func getdtypes(bn int) (string, string, error) {
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, vn := range []string{byvar, valvar} {
+		if _, ok := dtypes[vn]; !ok {
+			return "", "", fmt.Errorf("Variable %s not found in bucket %d", vn, bn)
+		}
+	}
+
+	if _, ok := dtypes[outvar]; ok && !replace {
+		return "", "", fmt.Errorf("Variable %s already exists, use -replace=true to overwrite it", outvar)
+	}
+
+	return dtypes[byvar], dtypes[valvar], nil
+}
+
+// checksorted returns an error if a bucket is not sorted by the sort
+// variable.
+func checksorted(bn int) error {
+
+	bdt, _, err := getdtypes(bn)
+	if err != nil {
+		return err
+	}
+
+	rdr, err := config.OpenReader(bn, sourcedir, byvar, bdt)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+
+	var last key
+	for i := 0; ; i++ {
+		x, err := readkey(rdr)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if i > 0 && x.less(last) {
+			return fmt.Errorf("Bucket %d is not sorted by %s (row %d)", bn, byvar, i)
+		}
+		last = x
+	}
+
+	return nil
+}
+
+// aggregate returns the aggregate of the values in the window.
+func aggregate(win []float64) float64 {
+
+	switch agg {
+	case "sum", "mean":
+		var s float64
+		for _, x := range win {
+			s += x
+		}
+		if agg == "mean" {
+			s /= float64(len(win))
+		}
+		return s
+	case "max":
+		m := win[0]
+		for _, x := range win[1:] {
+			if x > m {
+				m = x
+			}
+		}
+		return m
+	}
+
+	panic(fmt.Sprintf("unknown aggregate %s", agg))
+}
+
+// writecolumn writes the rolling aggregate for one bucket, where bdt
+// and vdt are the data types of the sort variable and the aggregated
+// variable.
+func writecolumn(bn int, bdt, vdt string) error {
+
+	krdr, err := config.OpenReader(bn, sourcedir, byvar, bdt)
+	if err != nil {
+		return err
+	}
+	defer krdr.Close()
+
+	vrdr, err := config.OpenReader(bn, sourcedir, valvar, vdt)
+	if err != nil {
+		return err
+	}
+	defer vrdr.Close()
+
+	wtr, fid3, err := config.CreateColumn(bn, sourcedir, outvar)
+	if err != nil {
+		return err
+	}
+	defer fid3.Close()
+	defer wtr.Close()
+
+	var win []float64
+	var last key
+	for i := 0; ; i++ {
+		k, err := readkey(krdr)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		x, err := vrdr.Float()
+		if err != nil {
+			return err
+		}
+
+		if i > 0 && k != last {
+			win = win[0:0]
+		}
+		last = k
+
+		win = append(win, x)
+		if len(win) > window {
+			win = win[1:]
+		}
+
+		err = binary.Write(wtr, binary.LittleEndian, aggregate(win))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dobucket computes the rolling aggregate for one bucket, and records
+// the new variable in the data types and the summary of the bucket.
+func dobucket(bn int) error {
+
+	bdt, vdt, err := getdtypes(bn)
+	if err != nil {
+		return err
+	}
+
+	if err := writecolumn(bn, bdt, vdt); err != nil {
+		return err
+	}
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return err
+	}
+	dtypes[outvar] = "float64"
+	if err := config.WriteDtypes(bn, sourcedir, dtypes); err != nil {
+		return err
+	}
+
+	return config.UpdateMeta(bn, sourcedir)
+}
+
+// Run runs rolling with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("rolling", flag.ContinueOnError)
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&byvar, "by", "", "variable that the rows are sorted by")
+	fs.StringVar(&valvar, "value", "", "variable to aggregate")
+	fs.StringVar(&outvar, "out", "", "name of the new variable (default value_aggN)")
+	fs.IntVar(&window, "window", 0, "number of rows in the window")
+	fs.StringVar(&agg, "agg", "mean", "aggregate to compute (sum, mean or max)")
+	fs.BoolVar(&replace, "replace", false, "overwrite an existing variable")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" || byvar == "" || valvar == "" || window < 1 {
+		return cli.Usage("usage:\nrolling -sourcedir=... -by=... -value=... -window=... [-agg=...] [-out=...]\n\n")
+	}
+
+	if agg != "sum" && agg != "mean" && agg != "max" {
+		return fmt.Errorf("Unknown aggregate %s, must be sum, mean or max", agg)
+	}
+
+	if outvar == "" {
+		outvar = fmt.Sprintf("%s_%s%d", valvar, agg, window)
+	}
+
+	// The new column is written while the sort variable and the
+	// aggregated variable are read, so it cannot replace either.
+	if outvar == byvar || outvar == valvar {
+		return fmt.Errorf("The new variable %s must differ from -by and -value", outvar)
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	// Check all buckets before writing anything.
+	for k := 0; k < conf.NumBuckets; k++ {
+		if err := checksorted(k); err != nil {
+			return err
+		}
+	}
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		if err := dobucket(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package rolling_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kshedden/gocols/coltest"
+	"github.com/kshedden/gocols/rolling"
+	"github.com/kshedden/gocols/verify"
+)
+
+func TestRolling(t *testing.T) {
+
+	dir := coltest.CSV(t, "k,v\n1,1\n1,2\n1,3\n2,4\n2,6\n2,8\n", "-buckets=1")
+
+	for _, tc := range []struct {
+		out   string
+		flags []string
+		want  []string
+	}{
+		{
+			out:   "mean2",
+			flags: []string{"-window=2"},
+			want:  []string{"1", "1.5", "2.5", "4", "5", "7"},
+		},
+		{
+			out:   "mean3",
+			flags: []string{"-window=3"},
+			want:  []string{"1", "1.5", "2", "4", "5", "6"},
+		},
+		{
+			out:   "sum2",
+			flags: []string{"-window=2", "-agg=sum"},
+			want:  []string{"1", "3", "5", "4", "10", "14"},
+		},
+		{
+			out:   "max2",
+			flags: []string{"-window=2", "-agg=max"},
+			want:  []string{"1", "2", "3", "4", "6", "8"},
+		},
+	} {
+		args := append([]string{"-sourcedir=" + dir, "-by=k", "-value=v", "-out=" + tc.out, "-q"}, tc.flags...)
+		if err := rolling.Run(args); err != nil {
+			t.Fatal(err)
+		}
+		if got := coltest.Records(t, dir, "-vars="+tc.out); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: got %q, expected %q", tc.flags, got, tc.want)
+		}
+	}
+
+	if _, err := coltest.Stdout(t, verify.Run, "-sourcedir="+dir); err != nil {
+		t.Errorf("verify: %v", err)
+	}
+}
+
+func TestOutInput(t *testing.T) {
+
+	dir := coltest.CSV(t, "k,v\n1,1\n1,2\n", "-buckets=1")
+	for _, out := range []string{"k", "v"} {
+		args := []string{"-sourcedir=" + dir, "-by=k", "-value=v", "-out=" + out, "-replace", "-q"}
+		if err := rolling.Run(args); err == nil {
+			t.Errorf("no error for -out=%s", out)
+		}
+	}
+	if got, want := coltest.Records(t, dir, "-vars=v"), []string{"1", "2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, expected %q", got, want)
+	}
+}
+
+func TestUnsorted(t *testing.T) {
+
+	dir := coltest.CSV(t, "k,v\n1,1\n2,2\n1,3\n", "-buckets=1")
+	if err := rolling.Run([]string{"-sourcedir=" + dir, "-by=k", "-value=v", "-window=2", "-q"}); err == nil {
+		t.Errorf("no error for rows that are not sorted by k")
+	}
+}
@@ -0,0 +1,285 @@
+// Package s3store gives access to data sets kept in Amazon S3, under
+// paths such as s3://bucket/prefix.  Importing the package registers
+// the storage with the config package, after which the functions of
+// config accept such paths wherever they accept a directory:
+//
+//	import _ "github.com/kshedden/gocols/s3store"
+//
+// The credentials and region are found as by the AWS command line
+// tools, from the environment, the shared configuration files or the
+// instance role.  A custom endpoint, for example of a MinIO server, can
+// be given in AWS_ENDPOINT_URL, in which case path-style addressing is
+// used.  Files are read as streams and written by multipart uploads,
+// so no file is held whole in memory or on local disk.
+package s3store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/kshedden/gocols/config"
+)
+
+// store implements config.Storage for S3.  The client is created when
+// first needed, so that programs that never use S3 need no AWS
+// configuration.
+type store struct {
+	once     sync.Once
+	client   *s3.Client
+	uploader *manager.Uploader
+	err      error
+}
+
+func init() {
+	config.RegisterStorage("s3", &store{})
+}
+
+// setup creates the client.
+func (s *store) setup() error {
+
+	s.once.Do(func() {
+		cfg, err := awsconfig.LoadDefaultConfig(context.Background())
+		if err != nil {
+			s.err = fmt.Errorf("cannot load the AWS configuration: %v", err)
+			return
+		}
+		s.client = s3.NewFromConfig(cfg, func(o *s3.Options) {
+			o.UsePathStyle = os.Getenv("AWS_ENDPOINT_URL") != ""
+		})
+		s.uploader = manager.NewUploader(s.client)
+	})
+
+	return s.err
+}
+
+// split returns the bucket and key of an s3:// URL.
+func split(name string) (string, string, error) {
+
+	u := strings.TrimPrefix(name, "s3://")
+	i := strings.Index(u, "/")
+	if i <= 0 {
+		return "", "", fmt.Errorf("invalid S3 path %s, expected s3://bucket/key", name)
+	}
+
+	return u[0:i], u[i+1:], nil
+}
+
+// notexist converts the error for a missing object into one for which
+// os.IsNotExist is true.
+func notexist(op, name string, err error) error {
+
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		switch ae.ErrorCode() {
+		case "NoSuchKey", "NotFound":
+			return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+		}
+	}
+
+	return err
+}
+
+func (s *store) Open(name string) (io.ReadCloser, error) {
+
+	if err := s.setup(); err != nil {
+		return nil, err
+	}
+	bucket, key, err := split(name)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := s.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, notexist("open", name, err)
+	}
+
+	return out.Body, nil
+}
+
+// upload streams the data written to it into a multipart upload.
+type upload struct {
+	pw   *io.PipeWriter
+	done chan error
+	once sync.Once
+	err  error
+}
+
+func (u *upload) Write(p []byte) (int, error) {
+	return u.pw.Write(p)
+}
+
+// Close finishes the upload, returning once the object is complete.
+// Subsequent calls return the same result.
+func (u *upload) Close() error {
+	u.once.Do(func() {
+		u.pw.Close()
+		u.err = <-u.done
+	})
+	return u.err
+}
+
+func (s *store) Create(name string) (io.WriteCloser, error) {
+
+	if err := s.setup(); err != nil {
+		return nil, err
+	}
+	bucket, key, err := split(name)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	u := &upload{pw: pw, done: make(chan error, 1)}
+	go func() {
+		_, err := s.uploader.Upload(context.Background(), &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   pr,
+		})
+		if err != nil {
+			err = fmt.Errorf("cannot write %s: %v", name, err)
+		}
+		// Unblock the writer if the upload failed.
+		pr.CloseWithError(err)
+		u.done <- err
+	}()
+
+	return u, nil
+}
+
+// fileinfo describes an object.
+type fileinfo struct {
+	name    string
+	size    int64
+	modtime time.Time
+}
+
+func (fi *fileinfo) Name() string       { return fi.name }
+func (fi *fileinfo) Size() int64        { return fi.size }
+func (fi *fileinfo) Mode() os.FileMode  { return 0644 }
+func (fi *fileinfo) ModTime() time.Time { return fi.modtime }
+func (fi *fileinfo) IsDir() bool        { return false }
+func (fi *fileinfo) Sys() interface{}   { return nil }
+
+func (s *store) Stat(name string) (os.FileInfo, error) {
+
+	if err := s.setup(); err != nil {
+		return nil, err
+	}
+	bucket, key, err := split(name)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := s.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, notexist("stat", name, err)
+	}
+
+	fi := &fileinfo{name: key[strings.LastIndex(key, "/")+1:]}
+	if out.ContentLength != nil {
+		fi.size = *out.ContentLength
+	}
+	if out.LastModified != nil {
+		fi.modtime = *out.LastModified
+	}
+
+	return fi, nil
+}
+
+func (s *store) Remove(name string) error {
+
+	if err := s.setup(); err != nil {
+		return err
+	}
+	bucket, key, err := split(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+
+	return notexist("remove", name, err)
+}
+
+// Rename copies the object to its new name and deletes the original,
+// since S3 cannot move objects.
+func (s *store) Rename(oldname, newname string) error {
+
+	if err := s.setup(); err != nil {
+		return err
+	}
+	obucket, okey, err := split(oldname)
+	if err != nil {
+		return err
+	}
+	nbucket, nkey, err := split(newname)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.CopyObject(context.Background(), &s3.CopyObjectInput{
+		Bucket:     aws.String(nbucket),
+		Key:        aws.String(nkey),
+		CopySource: aws.String(url.PathEscape(obucket + "/" + okey)),
+	})
+	if err != nil {
+		return notexist("rename", oldname, err)
+	}
+
+	return s.Remove(oldname)
+}
+
+func (s *store) ReadDir(name string) ([]string, error) {
+
+	if err := s.setup(); err != nil {
+		return nil, err
+	}
+	bucket, prefix, err := split(strings.TrimSuffix(name, "/") + "/")
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	pg := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	for pg.HasMorePages() {
+		out, err := pg.NextPage(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range out.Contents {
+			names = append(names, strings.TrimPrefix(aws.ToString(obj.Key), prefix))
+		}
+	}
+	if len(names) == 0 {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrNotExist}
+	}
+
+	return names, nil
+}
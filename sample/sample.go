@@ -0,0 +1,192 @@
+// Sample creates a copy of a columnized dataset, retaining a random
+// subset of the records in each bucket.  With -frac, each record is
+// retained independently with the given probability.  With -n, a
+// simple random sample of n records is drawn from each bucket (all
+// records are retained from buckets with n or fewer records).  The
+// sample is determined by -seed, so it can be reproduced.  A report of
+// the run, with the size of the sample from each bucket, is saved in
+// targetdir as report.json (see the report package).
+
+package sample
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+	"github.com/kshedden/gocols/report"
+	"github.com/kshedden/gocols/subset"
+)
+
+var (
+	// The probability of retaining each record
+	frac float64
+
+	// The number of records to retain from each bucket
+	nper int
+
+	// Seed for the random number generator
+	seed int64
+
+	// The directory where the sample will be stored
+	targetdir string
+
+	// The directory where the full data are stored
+	sourcedir string
+
+	// Configuration information for the source data
+	conf *config.Config
+
+	// If true, overwrite existing files
+	replace bool
+
+	// If true, read back each column after writing it to confirm
+	// that it round-trips
+	verifywrite bool
+
+	// Copies the selected rows to the target directory
+	copier *subset.Copier
+
+	// The report saved in the target directory
+	rpt *report.Report
+
+	// The number of buckets processed in parallel
+	concurrency int
+
+	sem chan bool
+
+	// The first error from any bucket
+	firsterr error
+	errmut   sync.Mutex
+)
+
+// seterr records an error from a bucket, if it is the first one.
+func seterr(err error) {
+	errmut.Lock()
+	if firsterr == nil {
+		firsterr = err
+	}
+	errmut.Unlock()
+}
+
+// numrows returns the number of rows in a bucket, which is recorded in
+// meta.json by the programs that write the bucket.
+func numrows(bn int) (int, error) {
+
+	n, err := config.NumRows(bn, sourcedir)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// dobucket draws the sample from one bucket.
+func dobucket(bn int) {
+
+	defer func() { <-sem }()
+
+	n, err := numrows(bn)
+	if err != nil {
+		seterr(err)
+		return
+	}
+	rng := rand.New(rand.NewSource(seed + int64(bn)))
+
+	ix := make([]bool, n)
+	if nper > 0 {
+		if nper >= n {
+			for i := range ix {
+				ix[i] = true
+			}
+		} else {
+			for _, i := range rng.Perm(n)[0:nper] {
+				ix[i] = true
+			}
+		}
+	} else {
+		for i := range ix {
+			ix[i] = rng.Float64() < frac
+		}
+	}
+
+	if err := copier.CopyBucket(bn, ix); err != nil {
+		seterr(err)
+		return
+	}
+	rpt.Mask(bn, ix)
+}
+
+// Run runs sample with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("sample", flag.ContinueOnError)
+	fs.Float64Var(&frac, "frac", 0, "probability of retaining each record")
+	fs.IntVar(&nper, "n", 0, "number of records to retain from each bucket")
+	fs.Int64Var(&seed, "seed", 1, "seed for the random number generator")
+	fs.StringVar(&targetdir, "targetdir", "", "destination directory")
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.BoolVar(&replace, "replace", false, "overwrite existing files")
+	fs.BoolVar(&verifywrite, "verify-write", false, "read back each column after writing to confirm it round-trips")
+	fs.IntVar(&concurrency, "concurrency", runtime.NumCPU(), "number of buckets or variables processed in parallel")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	firsterr = nil
+
+	if concurrency < 1 {
+		return errors.New("-concurrency must be positive")
+	}
+
+	if targetdir == "" || sourcedir == "" || (frac > 0) == (nper > 0) || frac > 1 {
+		return cli.Usage("usage:\nsample (-frac=... | -n=...) -targetdir=... -sourcedir=... [-seed=...]\n\n")
+	}
+
+	if !replace {
+		_, err := os.Stat(targetdir)
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("Use -replace=true to overwrite existing contents of %s", targetdir)
+		}
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	copier = &subset.Copier{SourceDir: sourcedir, TargetDir: targetdir, Verify: verifywrite}
+	if err := copier.Setup(conf); err != nil {
+		return err
+	}
+
+	rpt = report.New(fs, args, sourcedir, targetdir, conf.NumBuckets)
+
+	sem = make(chan bool, concurrency)
+	copier.Sem = sem
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		sem <- true
+		go dobucket(k)
+	}
+
+	for k := 0; k < concurrency; k++ {
+		sem <- true
+	}
+
+	if firsterr != nil {
+		return firsterr
+	}
+
+	if err := rpt.Write(nil); err != nil {
+		return err
+	}
+
+	return nil
+}
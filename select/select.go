@@ -1,38 +1,114 @@
 // Select creates a copy of a columnized dataset, retaining only those
 // records where the value of the index variable belongs to a given
-// set.
-
-package main
+// set.  The source and target directories may be in Amazon S3, given
+// as s3://bucket/prefix (see s3store), or in Google Cloud Storage,
+// given as gs://bucket/prefix (see gcsstore), except when appending to
+// the target or verifying the written columns.  The source directory
+// may also be the base URL of a data set published on a web server
+// (see httpstore).
+//
+// When selecting on a single variable, a bucket is skipped without
+// reading its columns if the range of the variable recorded in its
+// meta.json file (see bucketmanifest) holds none of the requested ids,
+// or if the Bloom filter of the variable built by index shows that the
+// bucket holds none of them.  The Bloom filters are consulted when at
+// most 65536 ids are requested.  In a bucket sorted by the variable and
+// indexed by index -sorted, only the rows that may hold the requested
+// ids are read to find the selected rows.
+//
+// If a selection variable has an id map (see csv2cols -idmap), the
+// idfile holds its string identifiers, such as UUIDs, which are
+// translated to the stored integer ids.  Identifiers that are not in
+// the map select no records and are reported as warnings.
+//
+// With -mmap, the selection variables are read from memory-mapped
+// files when they are fixed-width and stored without compression
+// (see config.UseMmap).
+//
+// If a bucket fails, the buckets that have not started are not
+// processed, the partial output of the buckets that were not completed
+// is removed, and select exits with status 1.  The completed buckets
+// are kept, so the selection can be finished with -resume.  The same
+// happens on an interrupt (Ctrl-C or SIGTERM), or when the time given
+// by -timeout has passed, except that the variables being copied are
+// finished first.
+//
+// With -limit and -offset, only a window of the selected rows is
+// copied, as when building a small subset of a large data set for
+// development: the first -offset selected rows are skipped and at
+// most -limit rows are copied after them, taking the rows in bucket
+// order and in their order within each bucket.  Buckets are still
+// processed in parallel, each waiting only for the counts of the
+// buckets before it before trimming its selection, and the buckets
+// after the window are not read once the window is known to be full.
+// -exclude and -maskdir apply before the window.
+//
+// Unless -dryrun is given, a report of the run (see the report
+// package) is saved in targetdir as report.json, whether or not it
+// succeeds.
+//
+// With -metrics, the wall time, the numbers of rows scanned and
+// selected, and the numbers of bytes read from the source columns and
+// written to the target of each bucket are printed at the end, with
+// their totals and the slowest bucket compared to the median.
+//
+// The log is written to select.log, or to the file given by -log (-
+// for stderr), as key=value pairs or with -log-format=json as JSON
+// objects (see the logging package).  The records about a bucket have
+// a bucket field.  With -v the time taken by each bucket is also
+// logged, and with -q only warnings and errors are logged.
+
+package selectcmd
 
 import (
 	"bufio"
+	"context"
 	"encoding/binary"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
-	"path"
+	"os/signal"
+	"runtime"
 	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unicode"
 
-	"github.com/golang/snappy"
+	"github.com/kshedden/gocols/cli"
 	"github.com/kshedden/gocols/config"
-)
-
-const (
-	concurrency = 20
+	_ "github.com/kshedden/gocols/gcsstore"
+	_ "github.com/kshedden/gocols/httpstore"
+	"github.com/kshedden/gocols/idrange"
+	"github.com/kshedden/gocols/logging"
+	"github.com/kshedden/gocols/progress"
+	"github.com/kshedden/gocols/report"
+	_ "github.com/kshedden/gocols/s3store"
+	"github.com/kshedden/gocols/subset"
+	"golang.org/x/sync/errgroup"
 )
 
 var (
 	// The name of the variable whose values will determine which
-	// records are selected.
+	// records are selected.  A comma-separated list of names selects
+	// on the joint values of several variables.
 	idvar string
 
-	// The specific values of the selection variable to retain.
-	ids []uint64
+	// The names in idvar
+	idvars []string
+
+	// The values of the selection variable to retain
+	ids idrange.Set
+
+	// The joint values of the selection variables to retain, when
+	// there is more than one selection variable
+	keys map[string]bool
 
 	// File name containing ids
 	idfile string
@@ -49,359 +125,1249 @@ var (
 	// If true, overwrite existing files
 	replace bool
 
+	// If true, retain the records whose idvar value is not in ids
+	exclude bool
+
+	// If true, idfile contains only string labels that are
+	// translated to integer codes using the factor codes of idvar.
+	// Labels of factor-coded variables are recognized even when
+	// this is false.
+	stringids bool
+
+	// If true and the target directory already holds a data set
+	// with the same schema as the source, append the selected rows
+	// to the existing buckets
+	appendtarget bool
+
+	// Set when rows are being appended to an existing target
+	appending bool
+
+	// If true, read back each column after writing it to confirm
+	// that it round-trips
+	verifywrite bool
+
+	// If true, save the selection mask of each bucket in the target
+	savemask bool
+
+	// If not empty, the directory of an earlier target whose saved
+	// masks are applied instead of selecting on idvar
+	maskdir string
+
+	// If true, skip the buckets completed by an earlier run into
+	// the same target directory
+	resume bool
+
+	// If true, report the number of rows that would be selected
+	// without writing anything
+	dryrun bool
+
+	// If positive, the interval between progress reports
+	progressint time.Duration
+
+	// If positive, the time after which the buckets are stopped
+	timeout time.Duration
+
+	// If true, print the metrics of each bucket at the end
+	showmetrics bool
+
+	// If positive, the first offset selected rows are skipped and at
+	// most limit rows are selected after them
+	limit, offset int
+
+	// With -limit or -offset, before[k] is the number of rows
+	// matching the selection in the buckets before bucket k, which
+	// is set before ready[k] is closed
+	before []int
+	ready  []chan struct{}
+
+	// Reports progress, if requested
+	prog *progress.Reporter
+
+	// The per-bucket results of a dry run
+	dryrows, drysel []int
+	drybytes        []int64
+
+	// The per-bucket metrics, if they are reported
+	metrics []bucketmetrics
+
+	// The report saved in the target directory, except in a dry run
+	rpt *report.Report
+
+	// Copies the selected rows to the target directory
+	copier *subset.Copier
+
+	// If not empty, only these variables are copied
+	keepvars map[string]bool
+
+	// These variables are not copied
+	dropvars map[string]bool
+
 	// Logging
-	logger *log.Logger
+	logger  *slog.Logger
+	logopts *logging.Options
+
+	// The buckets whose selected rows have all been written
+	completed []bool
+
+	// The number of buckets processed in parallel
+	concurrency int
 
 	sem chan bool
 )
 
-func setupLogger() {
-	fn := "select.log"
-	fid, err := os.Create(fn)
-	if err != nil {
-		panic(err)
+// openids opens the file containing the ids, which is stdin if the
+// file name is "-".
+func openids(idfile string) (io.ReadCloser, error) {
+	if idfile == "-" {
+		return ioutil.NopCloser(os.Stdin), nil
 	}
-
-	logger = log.New(fid, "", log.Ltime)
+	return os.Open(idfile)
 }
 
-type Sl64 []uint64
-
-func (a Sl64) Len() int           { return len(a) }
-func (a Sl64) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a Sl64) Less(i, j int) bool { return a[i] < a[j] }
-
 // getids reads the id values that will be included in the target data
-// set.
-func getids(idfile string) {
-
-	fid, err := os.Open(idfile)
+// set.  Each line holds a single id or an inclusive range lo-hi.
+// Ranges are not expanded; overlapping and adjacent ranges are merged
+// so that the membership test can use a binary search.
+//
+// If idvar is factor-coded, lines may also hold labels, which are
+// translated to their integer codes.  A line matching a label is
+// always treated as a label.  With -strings, every line must be a
+// label.  If idvar has an id map, every line holds an identifier.
+func getids(idfile string) error {
+
+	fid, err := openids(idfile)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer fid.Close()
 
+	var idm *config.IdMap
+	if config.HasIdMap(idvar, conf) {
+		idm, err = config.ReadIdMap(idvar, conf)
+		if err != nil {
+			return err
+		}
+	}
+
+	var codes map[string]int
+	if stringids || config.HasFactorCodes(idvar, conf) {
+		codes, err = config.GetFactorCodes(idvar, conf)
+		if err != nil {
+			return err
+		}
+	}
+
 	scanner := bufio.NewScanner(fid)
 
 	for scanner.Scan() {
-		id, err := strconv.Atoi(scanner.Text())
-		if err != nil {
-			panic(err)
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
 		}
-		ids = append(ids, uint64(id))
+
+		if idm != nil {
+			if id, ok := idm.Lookup(strings.TrimSpace(line)); ok {
+				ids = append(ids, idrange.Interval{Lo: id, Hi: id})
+			} else {
+				warn(fmt.Sprintf("identifier %q not found in the id map of %s", line, idvar))
+			}
+			continue
+		}
+
+		if c, ok := codes[line]; ok {
+			ids = append(ids, idrange.Interval{Lo: uint64(c), Hi: uint64(c)})
+			continue
+		}
+
+		var r idrange.Interval
+		if !stringids {
+			r, err = idrange.ParseInterval(line)
+		}
+		if stringids || (err != nil && codes != nil) {
+			warn(fmt.Sprintf("label %q not found in the codes for %s", line, idvar))
+			continue
+		} else if err != nil {
+			return fmt.Errorf("invalid line %q in %s: %v", line, idfile, err)
+		}
+		ids = append(ids, r)
 	}
 
 	if err := scanner.Err(); err != nil {
-		panic(err)
+		return err
 	}
 
-	sort.Sort(Sl64(ids))
+	ids = idrange.NewSet(ids)
+
+	return nil
 }
 
-// setupTargetDir creates the directory layout where the selected
-// cases will be written.
-func setupTargetDir() {
+// makekey combines the values of several selection variables into a
+// map key.
+func makekey(x []uint64) string {
+	b := make([]byte, 8*len(x))
+	for j, v := range x {
+		binary.LittleEndian.PutUint64(b[8*j:], v)
+	}
+	return string(b)
+}
 
-	p := path.Join(targetdir, "Buckets")
-	err := os.MkdirAll(p, 0755)
+// getkeys reads the joint id values that will be included in the target
+// data set when selecting on several variables.  Each line holds one
+// value per selection variable, separated by commas or white space.
+// Values of factor-coded variables may be given as labels, and values
+// of variables with an id map as identifiers, as in getids.
+func getkeys(idfile string) error {
+
+	fid, err := openids(idfile)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	defer fid.Close()
 
-	for k := 0; k < conf.NumBuckets; k++ {
-		q := config.BucketPath(k, targetdir)
-		err = os.MkdirAll(q, 0755)
-		if err != nil {
-			panic(err)
+	codes := make([]map[string]int, len(idvars))
+	idms := make([]*config.IdMap, len(idvars))
+	for j, vn := range idvars {
+		if config.HasIdMap(vn, conf) {
+			idms[j], err = config.ReadIdMap(vn, conf)
+			if err != nil {
+				return err
+			}
+		} else if config.HasFactorCodes(vn, conf) {
+			codes[j], err = config.GetFactorCodes(vn, conf)
+			if err != nil {
+				return err
+			}
 		}
 	}
+
+	sep := func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	}
+
+	keys = make(map[string]bool)
+	scanner := bufio.NewScanner(fid)
+	x := make([]uint64, len(idvars))
+lines:
+	for scanner.Scan() {
+		fields := strings.FieldsFunc(scanner.Text(), sep)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != len(idvars) {
+			return fmt.Errorf("line %q of %s has %d values, expected %d",
+				scanner.Text(), idfile, len(fields), len(idvars))
+		}
+		for j, f := range fields {
+			if idms[j] != nil {
+				var ok bool
+				if x[j], ok = idms[j].Lookup(f); !ok {
+					warn(fmt.Sprintf("identifier %q not found in the id map of %s", f, idvars[j]))
+					continue lines
+				}
+				continue
+			}
+			if c, ok := codes[j][f]; ok {
+				x[j] = uint64(c)
+				continue
+			}
+			x[j], err = strconv.ParseUint(f, 10, 64)
+			if err != nil && (stringids || codes[j] != nil) {
+				warn(fmt.Sprintf("label %q not found in the codes for %s", f, idvars[j]))
+				continue lines
+			} else if err != nil {
+				return fmt.Errorf("invalid line %q in %s: %v", scanner.Text(), idfile, err)
+			}
+		}
+		keys[makekey(x)] = true
+	}
+
+	return scanner.Err()
 }
 
-// contains returns true if and only if v is an element of a, where a
-// is a sorted array.
-func contains(a []uint64, v uint64) bool {
+// maxbloomids is the largest number of requested ids that are looked
+// up in the Bloom filter of a bucket.
+const maxbloomids = 1 << 16
+
+// skippable returns true if the range recorded in meta.json or the
+// Bloom filter built by index shows that a bucket holds none of the
+// ids, so that its selection variable need not be read.
+func skippable(bn int) (bool, error) {
+
+	if meta, err := config.ReadMeta(bn, sourcedir); err == nil {
+		if r, ok := meta.Range(idvar); ok && !ids.Overlaps(r.Min, r.Max) {
+			return true, nil
+		}
+	}
 
-	f := func(i int) bool {
-		return a[i] >= v
+	var nids uint64
+	for _, r := range ids {
+		if r.Hi-r.Lo >= maxbloomids {
+			return false, nil
+		}
+		nids += r.Hi - r.Lo + 1
+	}
+	if nids > maxbloomids {
+		return false, nil
 	}
 
-	k := sort.Search(len(a), f)
-	if k >= len(a) {
-		return false
+	b, err := config.ReadBloom(bn, sourcedir, idvar)
+	if err != nil || b == nil {
+		return false, err
 	}
-	return a[k] == v
+	for _, r := range ids {
+		for x := r.Lo; ; x++ {
+			if b.Test(x) {
+				return false, nil
+			}
+			if x == r.Hi {
+				break
+			}
+		}
+	}
+
+	return true, nil
 }
 
-// getix returns a boolean vector indicating which values should be selected
-func getix(bn int) []bool {
+// maskname is the name of the column holding a saved selection mask.
+// It is not listed in dtypes.json, so it is not copied as a variable.
+const maskname = "_mask"
 
-	fn := config.BucketPath(bn, sourcedir)
-	fn = path.Join(fn, fmt.Sprintf("%s.bin.sz", idvar))
-	fid, err := os.Open(fn)
+// selection returns the selection mask of a bucket, either by
+// selecting on idvar or from a saved mask.
+func selection(bn int) ([]bool, error) {
+	if maskdir != "" {
+		return getmask(bn)
+	}
+	return getix(bn)
+}
+
+// getmask reads a selection mask saved by an earlier run.
+func getmask(bn int) ([]bool, error) {
+
+	rdr, err := config.OpenReader(bn, maskdir, maskname, "uint8")
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	rdr := snappy.NewReader(fid)
+	defer rdr.Close()
 
-	var ix []bool
-	var m, n int
+	ix := make([]bool, 0, config.RowsHint(bn, sourcedir))
+	var m int
 	for {
-		var x uint64
-		err := binary.Read(rdr, binary.LittleEndian, &x)
+		x, err := rdr.Uint()
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			panic(err)
+			return nil, fmt.Errorf("reading mask in bucket %d: %v", bn, err)
 		}
-		f := contains(ids, x)
+		f := (x != 0) != exclude
 		ix = append(ix, f)
 		if f {
 			m++
 		}
-		n++
 	}
 
-	logger.Printf("Selected %d out of %d rows from bucket %d\n", m, n, bn)
+	logger.Info("selected rows using the saved mask", "bucket", bn, "selected", m, "rows", len(ix))
 
-	return ix
+	return ix, nil
 }
 
-// dofixedwith selects the values of interest from the given variable
-// in the source directory, and writes only those values to the target
-// directory.  This function operates on any slice of fixed width
-// values.
-func dofixedwidth(bn int, vname string, w int, ix []bool) {
-
-	// Input
-	rdr, fid1 := getreader(bn, vname)
-	defer fid1.Close()
+// writemask saves the selection mask of a bucket in the target, with
+// one uint8 value (0 or 1) per row of the source.
+func writemask(bn int, ix []bool) error {
 
-	// Output
-	wtr, fid2 := getwriter(bn, vname)
-	defer fid2.Close()
+	wtr, fid, err := config.CreateColumn(bn, targetdir, maskname)
+	if err != nil {
+		return err
+	}
+	defer fid.Close()
 	defer wtr.Close()
 
-	b := make([]byte, w)
+	for _, f := range ix {
+		var x uint64
+		if f {
+			x = 1
+		}
+		if err := config.WriteUint(wtr, "uint8", x); err != nil {
+			return err
+		}
+	}
 
-	for _, ii := range ix {
-		_, err := io.ReadFull(rdr, b)
+	if err := wtr.Close(); err != nil {
+		return err
+	}
+	return fid.Close()
+}
+
+// idblock is the number of ids that getix reads at a time.
+const idblock = 1 << 13
+
+// getix returns a boolean vector indicating which values should be selected
+func getix(bn int) ([]bool, error) {
+
+	if len(idvars) == 1 {
+		skip, err := skippable(bn)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-
-		if !ii {
-			continue
+		if skip {
+			n, err := config.NumRows(bn, sourcedir)
+			if err != nil {
+				return nil, err
+			}
+			ix := make([]bool, n)
+			for i := range ix {
+				ix[i] = exclude
+			}
+			logger.Info("bucket holds none of the ids, skipping", "bucket", bn, "rows", n)
+			return ix, nil
 		}
 
-		err = binary.Write(wtr, binary.LittleEndian, b)
+		si, err := config.ReadSortIndex(bn, sourcedir, idvar)
 		if err != nil {
-			panic(err)
+			return nil, err
+		}
+		if si != nil {
+			return sortedix(bn, si)
 		}
 	}
-}
 
-// getreader returns a reader, closer pair for the source directory.
-func getreader(bn int, vname string) (io.Reader, io.Closer) {
-	fn := config.BucketPath(bn, sourcedir)
-	fn = path.Join(fn, fmt.Sprintf("%s.bin.sz", vname))
-	fid, err := os.Open(fn)
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	rdrs := make([]*config.ColumnReader, len(idvars))
+	for j, vn := range idvars {
+		rdrs[j], err = config.OpenReader(bn, sourcedir, vn, dtypes[vn])
+		if err != nil {
+			return nil, err
+		}
+		defer rdrs[j].Close()
+	}
+
+	// The ids are read in blocks, blk[j] holding a block of values
+	// of idvars[j].
+	blk := make([][]uint64, len(idvars))
+	for j := range blk {
+		blk[j] = make([]uint64, idblock)
+	}
+
+	ix := make([]bool, 0, config.RowsHint(bn, sourcedir))
+	var m, n int
+	x := make([]uint64, len(idvars))
+	for {
+		var k int
+		var err error
+		for j := range rdrs {
+			var kj int
+			kj, err = rdrs[j].Uints(blk[j])
+			if err != nil {
+				break
+			}
+			if j > 0 && kj != k {
+				err = fmt.Errorf("%s and %s have different lengths", idvars[0], idvars[j])
+				break
+			}
+			k = kj
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("reading ids in bucket %d: %v", bn, err)
+		}
+
+		for i := 0; i < k; i++ {
+			var f bool
+			if len(idvars) == 1 {
+				f = ids.Contains(blk[0][i])
+			} else {
+				for j := range blk {
+					x[j] = blk[j][i]
+				}
+				f = keys[makekey(x)]
+			}
+			f = f != exclude
+			ix = append(ix, f)
+			if f {
+				m++
+			}
+		}
+		n += k
 	}
-	rdr := snappy.NewReader(fid)
-	return rdr, fid
+
+	if err := config.CheckRows(bn, sourcedir, n); err != nil {
+		return nil, err
+	}
+
+	logger.Info("selected rows", "bucket", bn, "selected", m, "rows", n)
+
+	return ix, nil
 }
 
-// getwriter returns a writer, closer pair for the target directory.
-func getwriter(bn int, vname string) (io.WriteCloser, io.Closer) {
-	fn := config.BucketPath(bn, targetdir)
-	fn = path.Join(fn, fmt.Sprintf("%s.bin.sz", vname))
-	fid, err := os.Create(fn)
+// sortedix returns the selection mask of a bucket sorted by the
+// selection variable, reading only the rows that its sparse index shows
+// may hold the ids.
+func sortedix(bn int, si *config.SortIndex) ([]bool, error) {
+
+	if err := config.CheckRows(bn, sourcedir, si.NumRows); err != nil {
+		return nil, err
+	}
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return nil, err
+	}
+	rdr, err := config.OpenReader(bn, sourcedir, idvar, dtypes[idvar])
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	wtr := snappy.NewBufferedWriter(fid)
-	return wtr, fid
+	defer rdr.Close()
+
+	ix := make([]bool, si.NumRows)
+	for i := range ix {
+		ix[i] = exclude
+	}
+
+	// The ids are sorted, so the rows to read come in order, and pos
+	// is the number of rows read so far.
+	var pos, nread, m int
+	for _, r := range ids {
+		start, end := si.Rows(r.Lo, r.Hi)
+		if start < pos {
+			start = pos
+		}
+		if start >= end {
+			continue
+		}
+		if err := rdr.Skip(start - pos); err != nil {
+			return nil, fmt.Errorf("reading ids in bucket %d: %v", bn, err)
+		}
+		for i := start; i < end; i++ {
+			x, err := rdr.Uint()
+			if err != nil {
+				return nil, fmt.Errorf("reading ids in bucket %d: %v", bn, err)
+			}
+			ix[i] = ids.Contains(x) != exclude
+		}
+		nread += end - start
+		pos = end
+	}
+
+	for _, f := range ix {
+		if f {
+			m++
+		}
+	}
+
+	logger.Info("selected rows using the sorted index", "bucket", bn, "selected", m, "rows", len(ix), "read", nread)
+
+	return ix, nil
 }
 
-// douvarint selects the values of interest for a variable of type
-// uvarint from the source directory, and writes them to the target
-// directory.
-func douvarint(bn int, vname string, ix []bool) {
+// windowed returns true if the selection is limited by -limit or
+// -offset.
+func windowed() bool {
+	return limit > 0 || offset > 0
+}
 
-	// Input
-	rdr, fid1 := getreader(bn, vname)
-	defer fid1.Close()
-	br := bufio.NewReader(rdr)
+// pastlimit returns true if the buckets before bn are already known to
+// hold all the rows allowed by -limit, so that bn need not be read.
+// Saved masks must cover every row, so buckets are always read with
+// -savemask.
+func pastlimit(bn int) bool {
 
-	// Output
-	wtr, fid2 := getwriter(bn, vname)
-	defer fid2.Close()
-	defer wtr.Close()
+	if limit == 0 || savemask {
+		return false
+	}
+
+	select {
+	case <-ready[bn]:
+		return before[bn] >= offset+limit
+	default:
+		return false
+	}
+}
 
-	b := make([]byte, 8)
+// window trims the selection mask of a bucket to the rows within
+// -offset and -limit, once the buckets before it have been counted,
+// and records the count for the next bucket.  The mask of a bucket
+// past the limit is nil.
+func window(ctx context.Context, bn int, ix []bool) error {
 
-	for _, ii := range ix {
-		x, err := binary.ReadUvarint(br)
-		if err != nil {
-			panic(err)
-		}
+	if !windowed() {
+		return nil
+	}
 
-		if !ii {
+	select {
+	case <-ready[bn]:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	n := before[bn]
+	for i, f := range ix {
+		if !f {
 			continue
 		}
+		if n < offset || (limit > 0 && n >= offset+limit) {
+			ix[i] = false
+		}
+		n++
+	}
 
-		m := binary.PutUvarint(b, x)
-		_, err = wtr.Write(b[0:m])
+	before[bn+1] = n
+	close(ready[bn+1])
+
+	return nil
+}
+
+// windowselection returns the selection mask of a bucket, trimmed by
+// window.  The mask is nil if the bucket is past the limit.
+func windowselection(ctx context.Context, bn int) ([]bool, error) {
+
+	if pastlimit(bn) {
+		logger.Info("the limit is reached before the bucket, skipping", "bucket", bn)
+		return nil, window(ctx, bn, nil)
+	}
+
+	ix, err := selection(bn)
+	if err != nil {
+		return nil, err
+	}
+
+	return ix, window(ctx, bn, ix)
+}
+
+// dobucket does the selection on one bucket, stopping early when ctx
+// is done.
+func dobucket(ctx context.Context, bn int) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	start := time.Now()
+
+	if resume && copier.Done(bn) {
+		logger.Info("bucket was completed by an earlier run, skipping", "bucket", bn)
+		var err error
+		if _, err = config.ReadMeta(bn, targetdir); err != nil {
+			err = writemeta(bn)
+		}
+		// The later buckets need the number of rows matching
+		// the selection, which is not kept.
+		if err == nil && windowed() {
+			_, err = windowselection(ctx, bn)
+		}
 		if err != nil {
-			panic(err)
+			return err
+		}
+		completed[bn] = true
+		rpt.Resumed(bn)
+		if prog != nil {
+			prog.BucketDone(0, 0)
 		}
+		return nil
+	}
+
+	ix, err := windowselection(ctx, bn)
+	if err == nil && savemask {
+		err = writemask(bn, ix)
+	}
+	if err == nil {
+		err = copier.CopyBucketContext(ctx, bn, ix)
+	}
+	if err == nil {
+		err = writemeta(bn)
+	}
+	if err != nil {
+		return err
 	}
+	completed[bn] = true
+	rpt.Mask(bn, ix)
+
+	var m int
+	for _, f := range ix {
+		if f {
+			m++
+		}
+	}
+	logger.Debug("bucket done", "bucket", bn, "selected", m, "rows", len(ix), "elapsed", time.Since(start))
+	if metrics != nil {
+		metrics[bn].rows = len(ix)
+		metrics[bn].selected = m
+	}
+
+	if prog != nil {
+		prog.BucketDone(len(ix), m)
+	}
+
+	return nil
 }
 
-func writedtypes(dtypes map[string]string, bn int) {
+// writemeta saves the row count, column sizes and id range of a
+// bucket of the target data set.  The range is recorded only when
+// selecting on a single variable that is copied.
+func writemeta(bn int) error {
 
-	fn := config.BucketPath(bn, targetdir)
-	fn = path.Join(fn, "dtypes.json")
-	fid, err := os.Create(fn)
-	if err != nil {
-		panic(err)
+	var iv string
+	if len(idvars) == 1 && keepvar(idvar) {
+		iv = idvar
 	}
-	defer fid.Close()
-	enc := json.NewEncoder(fid)
-	err = enc.Encode(dtypes)
+
+	meta, err := config.ComputeMeta(bn, targetdir, iv)
 	if err != nil {
-		panic(err)
+		return err
 	}
+
+	return config.WriteMeta(bn, targetdir, meta)
 }
 
-// dobucket does the selection on one bucket
-func dobucket(bn int) {
+// drybucket counts the rows that would be selected from one bucket,
+// and estimates the compressed size of the selected data by prorating
+// the size of each column that would be copied.
+func drybucket(ctx context.Context, bn int) error {
+
+	ix, err := windowselection(ctx, bn)
+	var dtypes map[string]string
+	if err == nil {
+		dtypes, err = copier.Dtypes(bn)
+	}
+	if err != nil {
+		return err
+	}
+
+	var m int
+	for _, f := range ix {
+		if f {
+			m++
+		}
+	}
+
+	var sz int64
+	for vn := range dtypes {
+		fi, err := config.StatFile(config.ColumnPath(bn, sourcedir, vn))
+		if err == nil {
+			sz += fi.Size()
+		}
+	}
 
-	defer func() { <-sem }()
+	if prog != nil {
+		prog.BucketDone(len(ix), m)
+	}
+	if metrics != nil {
+		metrics[bn].rows = len(ix)
+		metrics[bn].selected = m
+	}
 
-	dtypes := config.ReadDtypes(bn, sourcedir)
+	dryrows[bn] = len(ix)
+	drysel[bn] = m
+	if len(ix) > 0 {
+		drybytes[bn] = sz * int64(m) / int64(len(ix))
+	}
 
-	writedtypes(dtypes, bn)
+	return nil
+}
 
-	ix := getix(bn)
+// runbuckets processes the buckets with at most concurrency of them
+// (or of their variables, see subset.Copier) at a time.  The first
+// bucket to fail, or to panic, cancels the buckets that have not yet
+// started, and its error is returned once the running buckets have
+// finished.  When ctx is done, the buckets stop in the same way and
+// the error of ctx is returned.
+func runbuckets(ctx context.Context) error {
 
-	for vn, dt := range dtypes {
+	g, gctx := errgroup.WithContext(ctx)
 
-		if dt == "uvarint" {
-			douvarint(bn, vn, ix)
-		} else if dt == "varint" {
-			panic("varint not implemented\n")
-		} else {
-			w := config.DTsize[dt]
-			dofixedwidth(bn, vn, w, ix)
+	for k := 0; k < conf.NumBuckets; k++ {
+		select {
+		case sem <- true:
+		case <-gctx.Done():
+		}
+		if gctx.Err() != nil {
+			break
 		}
+
+		bn := k
+		g.Go(func() (err error) {
+			defer func() { <-sem }()
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("%v", r)
+				}
+				if err != nil {
+					logger.Error("bucket failed", "bucket", bn, "err", err)
+					err = fmt.Errorf("bucket %d: %v", bn, err)
+				}
+			}()
+			if metrics != nil {
+				start := time.Now()
+				var before int64
+				if !dryrun {
+					before = bucketbytes(bn)
+				}
+				defer func() {
+					metrics[bn].elapsed = time.Since(start)
+					if !dryrun {
+						metrics[bn].written = bucketbytes(bn) - before
+					}
+				}()
+			}
+			if dryrun {
+				return drybucket(gctx, bn)
+			}
+			return dobucket(gctx, bn)
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return err
 	}
+
+	return ctx.Err()
 }
 
-// copycodes makes a copy in the target directory of all the files in
-// the Codes directory of the source data (labels for factor-coded
-// variables and related meta-data).
-func copycodes() {
+// bucketmetrics holds the measurements of one bucket.
+type bucketmetrics struct {
+
+	// The time taken to process the bucket
+	elapsed time.Duration
 
-	sp := conf.CodesDir
+	// The numbers of rows scanned and selected
+	rows, selected int
 
-	dp := path.Join(targetdir, "Codes")
-	os.MkdirAll(dp, 0755)
+	// The numbers of bytes read from column files (see
+	// config.CountRead), and added to the files of the target bucket
+	read, written int64
+}
 
-	fl, err := ioutil.ReadDir(sp)
+// bucketbytes returns the total size of the files in a bucket of the
+// target data set.
+func bucketbytes(bn int) int64 {
+
+	p := config.BucketPath(bn, targetdir)
+	names, err := config.ReadDir(p)
 	if err != nil {
-		panic(err)
+		return 0
 	}
 
-	for _, fi := range fl {
+	var n int64
+	for _, na := range names {
+		if fi, err := config.StatFile(config.Join(p, na)); err == nil {
+			n += fi.Size()
+		}
+	}
 
-		fn := fi.Name()
+	return n
+}
 
-		fid, err := os.Open(path.Join(sp, fn))
-		if err != nil {
-			panic(err)
-		}
-		defer fid.Close()
+// metricsreport prints the metrics of each bucket and their totals,
+// where elapsed is the time taken by all the buckets.  The slowest
+// bucket is compared to the median, to show whether the work is
+// evenly spread over the buckets.
+func metricsreport(elapsed time.Duration) {
 
-		gid, err := os.Create(path.Join(dp, fn))
-		if err != nil {
-			panic(err)
+	rate := func(n int, d time.Duration) float64 {
+		if d <= 0 {
+			return 0
 		}
-		defer gid.Close()
+		return float64(n) / d.Seconds()
+	}
 
-		_, err = io.Copy(gid, fid)
-		if err != nil {
-			panic(err)
+	fmt.Printf("%8s %12s %12s %14s %14s %10s %12s\n", "Bucket", "Rows", "Selected",
+		"Bytes read", "Bytes written", "Seconds", "Rows/s")
+	var tot bucketmetrics
+	var times []time.Duration
+	slowest := 0
+	for k, bm := range metrics {
+		fmt.Printf("%8d %12d %12d %14d %14d %10.3f %12.0f\n", k, bm.rows, bm.selected,
+			bm.read, bm.written, bm.elapsed.Seconds(), rate(bm.rows, bm.elapsed))
+		tot.rows += bm.rows
+		tot.selected += bm.selected
+		tot.read += bm.read
+		tot.written += bm.written
+		times = append(times, bm.elapsed)
+		if bm.elapsed > metrics[slowest].elapsed {
+			slowest = k
 		}
 	}
+	fmt.Printf("%8s %12d %12d %14d %14d %10.3f %12.0f\n", "Total", tot.rows, tot.selected,
+		tot.read, tot.written, elapsed.Seconds(), rate(tot.rows, elapsed))
+
+	if len(times) == 0 {
+		return
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	if med := times[len(times)/2]; med > 0 {
+		fmt.Printf("\nSlowest bucket: %d (%.3fs, %.1f times the median of %.3fs)\n", slowest,
+			metrics[slowest].elapsed.Seconds(), float64(metrics[slowest].elapsed)/float64(med), med.Seconds())
+	}
 }
 
-func check() {
+// cleanup removes the partial output of the buckets that were not
+// completed, after a failure.  The completed buckets are kept, so the
+// selection can be finished with -resume.
+func cleanup() {
 
-	if !replace {
-		_, err := os.Stat(targetdir)
-		if !os.IsNotExist(err) {
-			fmt.Printf("Use -replace=true to overwrite existing contents of %s\n\n", targetdir)
-			os.Exit(1)
+	if dryrun || appending {
+		return
+	}
+
+	for k, done := range completed {
+		if done {
+			continue
 		}
+		if err := copier.Discard(k); err != nil {
+			logger.Error("cannot remove the partial output", "bucket", k, "err", err)
+		}
+	}
+}
+
+// dryreport prints the results of a dry run.
+func dryreport() {
+
+	fmt.Printf("%8s %12s %12s %14s\n", "Bucket", "Rows", "Selected", "Est. bytes")
+	var n, m int
+	var b int64
+	for k := range dryrows {
+		fmt.Printf("%8d %12d %12d %14d\n", k, dryrows[k], drysel[k], drybytes[k])
+		n += dryrows[k]
+		m += drysel[k]
+		b += drybytes[k]
 	}
+	fmt.Printf("%8s %12d %12d %14d\n", "Total", n, m, b)
+}
+
+// checkschema confirms that the existing target data set has the same
+// buckets and variables as the source, so that selected rows can be
+// appended to it.
+func checkschema() error {
 
-	ts, err := os.Stat(targetdir)
+	tconf, err := config.GetConfig(targetdir)
 	if err != nil {
-		ss, err := os.Stat(sourcedir)
+		return err
+	}
+	if tconf.NumBuckets != conf.NumBuckets {
+		return fmt.Errorf("cannot append: %s has %d buckets but %s has %d",
+			targetdir, tconf.NumBuckets, sourcedir, conf.NumBuckets)
+	}
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		sdt, err := copier.Dtypes(k)
+		if err != nil {
+			return err
+		}
+		tdt, err := config.ReadDtypes(k, targetdir)
 		if err != nil {
-			if os.SameFile(ts, ss) {
-				os.Stderr.WriteString("Cannot have targetdir equal to sourcedir\n")
-				os.Exit(1)
+			return err
+		}
+		if len(sdt) != len(tdt) {
+			return fmt.Errorf("cannot append: bucket %d has different variables in source and target", k)
+		}
+		for vn, dt := range sdt {
+			if tdt[vn] != dt {
+				return fmt.Errorf("cannot append: variable %s in bucket %d has dtype %s in source and %s in target",
+					vn, k, dt, tdt[vn])
 			}
 		}
 	}
+
+	return nil
+}
+
+// keepvar returns true if variable vn is to be copied.
+func keepvar(vn string) bool {
+	if len(keepvars) > 0 && !keepvars[vn] {
+		return false
+	}
+	return !dropvars[vn]
+}
+
+// varset returns the set of names in a comma-separated list.
+func varset(vlist string) map[string]bool {
+	m := make(map[string]bool)
+	if vlist == "" {
+		return m
+	}
+	for _, vn := range strings.Split(vlist, ",") {
+		m[vn] = true
+	}
+	return m
 }
 
-func main() {
+// checkvars confirms that the variables named in -idvar, -keepvars and
+// -dropvars exist in the source data set.
+func checkvars() error {
+
+	dtypes, err := config.ReadDtypes(0, sourcedir)
+	if err != nil {
+		return err
+	}
+	for _, m := range []map[string]bool{varset(idvar), keepvars, dropvars} {
+		for vn := range m {
+			if _, ok := dtypes[vn]; !ok {
+				return fmt.Errorf("variable %s not found in %s", vn, sourcedir)
+			}
+		}
+	}
+
+	return nil
+}
+
+// check confirms that the target directory can be written.
+func check() error {
+
+	if appendtarget {
+		_, err := config.StatFile(config.ConfigPath(targetdir))
+		appending = err == nil
+	}
+
+	if !replace && !appending && !resume {
+		// Object stores have no directories, so a remote target
+		// exists if it holds a data set.
+		fn := targetdir
+		if config.IsRemote(targetdir) {
+			fn = config.ConfigPath(targetdir)
+		}
+		_, err := config.StatFile(fn)
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("use -replace=true to overwrite existing contents of %s", targetdir)
+		}
+	}
+
+	return config.CheckTarget(sourcedir, targetdir)
+}
+
+// warn logs a warning, and records it in the report.
+func warn(msg string) {
+	logger.Warn(msg)
+	if rpt != nil {
+		rpt.Warn(msg)
+	}
+}
+
+// abort logs an error that ends the run, and returns the error to be
+// reported by the caller, unless the log is already written to stderr.
+func abort(err error) error {
+	logger.Error(err.Error())
+	if logopts.File == "-" {
+		return cli.Status(1)
+	}
+	return err
+}
+
+// Run runs select with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("select", flag.ContinueOnError)
+	fs.StringVar(&idvar, "idvar", "", "variable, or comma-separated variables, to select on")
+	fs.StringVar(&idfile, "idfile", "", "file path to values or lo-hi ranges to select, - for stdin")
+	fs.StringVar(&targetdir, "targetdir", "", "destination directory")
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.BoolVar(&replace, "replace", false, "overwrite existing files")
+	fs.BoolVar(&exclude, "exclude", false, "retain the records whose ids are not in idfile")
+	fs.BoolVar(&stringids, "strings", false, "idfile contains only labels of the factor-coded idvar")
+	fs.BoolVar(&appendtarget, "append-target", false, "append to an existing target with the same schema")
+	fs.BoolVar(&verifywrite, "verify-write", false, "read back each column after writing to confirm it round-trips")
+	keeplist := fs.String("keepvars", "", "comma-separated variables to copy (default all)")
+	droplist := fs.String("dropvars", "", "comma-separated variables not to copy")
+	fs.IntVar(&concurrency, "concurrency", runtime.NumCPU(), "number of buckets or variables processed in parallel")
+	fs.BoolVar(&savemask, "savemask", false, "save the selection mask of each bucket in targetdir")
+	fs.StringVar(&maskdir, "maskdir", "", "apply the masks saved in this earlier targetdir instead of selecting on idvar")
+	fs.BoolVar(&resume, "resume", false, "skip the buckets completed by an earlier run into targetdir")
+	fs.BoolVar(&dryrun, "dryrun", false, "report the selected rows and estimated size without writing anything")
+	fs.DurationVar(&progressint, "progress", 0, "interval between progress reports on stderr, e.g. 10s (default none)")
+	fs.BoolVar(&showmetrics, "metrics", false, "print the time, rows and bytes of each bucket at the end")
+	fs.DurationVar(&timeout, "timeout", 0, "stop after this long, leaving a target that -resume can finish (default none)")
+	fs.IntVar(&limit, "limit", 0, "copy at most this many of the selected rows (default all)")
+	fs.IntVar(&offset, "offset", 0, "skip this many of the selected rows before copying")
+	mmap := fs.Bool("mmap", false, "memory-map uncompressed fixed-width columns")
+	logopts = logging.Flags(fs, "select.log")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+	logger = slog.Default()
+
+	// -mmap applies to this run only.
+	defer func(old bool) { config.UseMmap = old }(config.UseMmap)
+	config.UseMmap = *mmap
+
+	// The state of an earlier run in the same process is discarded.
+	ids, keys, appending = nil, nil, false
+	rpt, prog, metrics = nil, nil, nil
+	before, ready = nil, nil
+
+	if concurrency < 1 {
+		return errors.New("-concurrency must be positive")
+	}
+
+	if limit < 0 || offset < 0 {
+		return errors.New("-limit and -offset must not be negative")
+	}
+
+	if ((idvar == "" || idfile == "") && maskdir == "") || (targetdir == "" && !dryrun) || sourcedir == "" {
+		return cli.Usage("usage:\nselect idvar idfile targetdir sourcedir\n\n")
+	}
 
-	flag.StringVar(&idvar, "idvar", "", "variable to select on")
-	flag.StringVar(&idfile, "idfile", "", "file path to values to select")
-	flag.StringVar(&targetdir, "targetdir", "", "destination directory")
-	flag.StringVar(&sourcedir, "sourcedir", "", "source directory")
-	flag.BoolVar(&replace, "replace", false, "overwrite existing files")
-	flag.Parse()
+	if maskdir != "" && idvar != "" {
+		return errors.New("-maskdir cannot be used with -idvar")
+	}
 
-	if idvar == "" || idfile == "" || targetdir == "" || sourcedir == "" {
-		msg := fmt.Sprintf("usage:\nselect idvar idfile targetdir sourcedir\n\n")
-		os.Stderr.WriteString(msg)
-		os.Exit(1)
+	if resume && appendtarget {
+		return errors.New("-resume cannot be used with -append-target")
 	}
 
-	check()
+	if *keeplist != "" && *droplist != "" {
+		return errors.New("only one of -keepvars and -dropvars may be given")
+	}
+	keepvars = varset(*keeplist)
+	dropvars = varset(*droplist)
+	idvars = strings.Split(idvar, ",")
 
-	setupLogger()
-	os.MkdirAll(targetdir, 0755)
+	if !dryrun {
+		if err := check(); err != nil {
+			return abort(err)
+		}
+	}
 
-	conf = config.GetConfig(sourcedir)
+	if !dryrun {
+		if err := config.MkdirAll(targetdir); err != nil {
+			return abort(err)
+		}
+	}
 
-	// Modify the conf for the target directory and save it there.
-	var tconf config.Config
-	tconf = *conf
-	tconf.CodesDir = path.Join(targetdir, "Codes")
-	config.WriteConfig(targetdir, &tconf)
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return abort(err)
+	}
+	if err := checkvars(); err != nil {
+		return abort(err)
+	}
+
+	copier = &subset.Copier{
+		SourceDir: sourcedir,
+		TargetDir: targetdir,
+		Append:    appending,
+		Verify:    verifywrite,
+		Keep:      keepvar,
+		Markers:   !appending,
+	}
+	if dryrun {
+		dryrows = make([]int, conf.NumBuckets)
+		drysel = make([]int, conf.NumBuckets)
+		drybytes = make([]int64, conf.NumBuckets)
+	} else if appending {
+		err = checkschema()
+	} else {
+		err = copier.Setup(conf)
+	}
+	if err != nil {
+		return abort(err)
+	}
 
 	sem = make(chan bool, concurrency)
+	copier.Sem = sem
 
-	copycodes()
-	getids(idfile)
+	if !dryrun {
+		rpt = report.New(fs, args, sourcedir, targetdir, conf.NumBuckets)
+	}
 
-	setupTargetDir()
+	switch {
+	case maskdir != "":
+		// The saved masks are applied, so no ids are needed.
+	case len(idvars) == 1:
+		err = getids(idfile)
+	default:
+		err = getkeys(idfile)
+	}
+	if err != nil {
+		return abort(err)
+	}
 
-	for k := 0; k < conf.NumBuckets; k++ {
-		sem <- true
-		go dobucket(k)
+	if progressint > 0 {
+		prog = &progress.Reporter{NumBuckets: conf.NumBuckets}
+		prog.Start(progressint)
 	}
 
-	for k := 0; k < concurrency; k++ {
-		sem <- true
+	// An interrupt stops the buckets, leaving a target that -resume
+	// can finish.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if showmetrics {
+		metrics = make([]bucketmetrics, conf.NumBuckets)
+		config.CountRead = func(bn, n int) {
+			atomic.AddInt64(&metrics[bn].read, int64(n))
+		}
+		defer func() { config.CountRead = nil }()
 	}
 
-	logger.Printf("Done, exiting")
+	if windowed() {
+		before = make([]int, conf.NumBuckets+1)
+		ready = make([]chan struct{}, conf.NumBuckets+1)
+		for k := range ready {
+			ready[k] = make(chan struct{})
+		}
+		close(ready[0])
+	}
+
+	completed = make([]bool, conf.NumBuckets)
+	start := time.Now()
+	err = runbuckets(ctx)
+	elapsed := time.Since(start)
+
+	if prog != nil {
+		prog.Stop()
+	}
+
+	if err != nil {
+		cleanup()
+		if ctx.Err() != nil && !dryrun {
+			var n int
+			for _, done := range completed {
+				if done {
+					n++
+				}
+			}
+			logger.Warn("stopped", "completed", n, "buckets", conf.NumBuckets, "err", ctx.Err())
+			if !appending {
+				reason := "interrupted"
+				if ctx.Err() == context.DeadlineExceeded {
+					reason = "timed out"
+				}
+				err = fmt.Errorf("%s: %d of %d buckets completed, rerun with -resume to finish",
+					reason, n, conf.NumBuckets)
+			}
+		}
+		if rpt != nil {
+			if werr := rpt.Write(err); werr != nil {
+				logger.Error("cannot write the report", "err", werr)
+			}
+		}
+		return abort(err)
+	}
+
+	if rpt != nil {
+		if err := rpt.Write(nil); err != nil {
+			return abort(err)
+		}
+	}
+
+	if dryrun {
+		dryreport()
+	}
+	if showmetrics {
+		if dryrun {
+			fmt.Println()
+		}
+		metricsreport(elapsed)
+	}
+
+	logger.Info("done, exiting")
+
+	return nil
 }
@@ -0,0 +1,118 @@
+package selectcmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/kshedden/gocols/coltest"
+	"github.com/kshedden/gocols/config"
+	"github.com/kshedden/gocols/gen"
+	"github.com/kshedden/gocols/idrange"
+)
+
+// writeids writes ids, one per line, to a file and returns its name.
+func writeids(t testing.TB, ids string) string {
+	fn := filepath.Join(t.TempDir(), "ids.txt")
+	if err := ioutil.WriteFile(fn, []byte(ids), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+func TestAppendTarget(t *testing.T) {
+
+	src := coltest.CSV(t, "id,x\n0,a\n1,b\n2,c\n3,d\n4,e\n5,f\n6,g\n7,h\n", "-idvar=id")
+	target := coltest.Dir(t, "target")
+
+	for _, tc := range []struct {
+		ids   string
+		flags []string
+		want  []string
+	}{
+		{
+			ids:  "1-3\n",
+			want: []string{"1,b", "2,c", "3,d"},
+		},
+		{
+			ids:   "6\n0\n",
+			flags: []string{"-append-target"},
+			want:  []string{"0,a", "1,b", "2,c", "3,d", "6,g"},
+		},
+	} {
+		args := append([]string{"-sourcedir=" + src, "-targetdir=" + target, "-idvar=id",
+			"-idfile=" + writeids(t, tc.ids), "-log=-", "-q"}, tc.flags...)
+		if err := Run(args); err != nil {
+			t.Fatal(err)
+		}
+
+		got := coltest.Records(t, target)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("after selecting %q: got %q, expected %q", tc.ids, got, tc.want)
+		}
+	}
+}
+
+// BenchmarkSelect finds the records of a generated data set holding a
+// tenth of its ids, reading the ids in blocks as getix does, and one
+// value at a time for comparison.
+func BenchmarkSelect(b *testing.B) {
+
+	const nrows, nbuckets = 400000, 4
+
+	idvar, idvars = "id", []string{"id"}
+	logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	ids = nil
+	for lo := uint64(0); lo < nrows; lo += 1000 {
+		ids = append(ids, idrange.Interval{Lo: lo, Hi: lo + 99})
+	}
+
+	for _, dt := range []string{"uvarint", "uint64"} {
+		sourcedir = filepath.Join(b.TempDir(), "data")
+		if err := gen.Run([]string{"-schema=id:" + dt + ",x:float64", "-idvar=id",
+			fmt.Sprintf("-rows=%d", nrows), fmt.Sprintf("-buckets=%d", nbuckets),
+			"-targetdir=" + sourcedir, "-q"}); err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(dt+"/block", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for bn := 0; bn < nbuckets; bn++ {
+					if _, err := getix(bn); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+
+		b.Run(dt+"/value", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for bn := 0; bn < nbuckets; bn++ {
+					r, err := config.OpenReader(bn, sourcedir, "id", dt)
+					if err != nil {
+						b.Fatal(err)
+					}
+					var ix []bool
+					for {
+						x, err := r.Uint()
+						if err == io.EOF {
+							break
+						} else if err != nil {
+							b.Fatal(err)
+						}
+						ix = append(ix, ids.Contains(x))
+					}
+					r.Close()
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,743 @@
+// Serve makes a data set available over HTTP, so that its schema,
+// summary statistics and selected rows can be obtained without access
+// to the host holding the data.  The endpoints are:
+//
+//	GET /schema
+//		The configuration of the data set and, for each variable, its
+//		data type, whether it is factor-coded and its description
+//		(see varinfo), as JSON.
+//
+//	GET /stats?vars=a,b
+//		The number of values, the number of missing values, and the
+//		minimum, maximum, mean and standard deviation of the
+//		non-missing values of the variables (default all except
+//		text variables), as JSON.
+//		The statistics are computed when first requested and kept
+//		for later requests.
+//
+//	GET or POST /select?idvar=id&ids=1,5,100-200&where=...&vars=a,b&format=csv
+//		Streams the rows whose value of idvar is among the ids
+//		(single values or inclusive ranges) and for which the where
+//		expression (see the expr package) is true.  The ids may also
+//		be posted as the request body, one value or range per line,
+//		of at most -maxbody bytes.
+//		Without idvar or where, all rows are returned.  The variables
+//		(default all) are written as CSV with a header line, or with
+//		format=json as one JSON object per line.  Factor-coded
+//		variables are written using their labels, and missing values
+//		are empty in CSV and null in JSON.
+//
+// As in select, a bucket is skipped without reading its columns if the
+// range of idvar recorded in its meta.json file, or its Bloom filter
+// built by index, shows that it holds none of the ids.  The source
+// directory may be remote (see s3store, gcsstore and httpstore).
+//
+// A client must send the headers of a request within -readheader, and
+// the whole request within -read, and an idle connection is closed
+// after -idle.  No write timeout is set, since a response to /select
+// may take as long as reading the whole data set.
+//
+// Errors are logged to stderr, or to the file given by -log, and with
+// -v every request is logged too (see the logging package).
+
+package serve
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+	"github.com/kshedden/gocols/expr"
+	_ "github.com/kshedden/gocols/gcsstore"
+	_ "github.com/kshedden/gocols/httpstore"
+	"github.com/kshedden/gocols/idrange"
+	_ "github.com/kshedden/gocols/s3store"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// Configuration information for the data set
+	conf *config.Config
+
+	// The data types of the variables, from the first bucket
+	dtypes map[string]string
+
+	// The names of the variables, in alphabetical order
+	varnames []string
+
+	// The reverse factor codes of the factor-coded variables
+	labels map[string]map[int]string
+
+	// The statistics computed so far, by variable name
+	stats    = make(map[string]*Summary)
+	statsmut sync.Mutex
+
+	// The largest request body accepted, in bytes
+	maxbody int64
+
+	// Logging
+	logger *slog.Logger
+)
+
+// Summary holds the statistics of one variable.
+type Summary struct {
+	Name  string
+	Dtype string
+
+	// The number of values, including missing values
+	Count int
+
+	// The number of missing values
+	Missing int
+
+	// The statistics of the non-missing values, nil if undefined
+	Min, Max, Mean, SD *float64
+
+	// Running quantities for the mean and variance
+	min, max, mean, m2 float64
+}
+
+// add updates the statistics with a value.
+func (s *Summary) add(x float64, valid bool) {
+
+	s.Count++
+	if !valid || math.IsNaN(x) {
+		s.Missing++
+		return
+	}
+
+	n := float64(s.Count - s.Missing)
+	if n == 1 || x < s.min {
+		s.min = x
+	}
+	if n == 1 || x > s.max {
+		s.max = x
+	}
+	d := x - s.mean
+	s.mean += d / n
+	s.m2 += d * (x - s.mean)
+}
+
+// finish sets the final statistics.
+func (s *Summary) finish() {
+
+	f := func(x float64) *float64 { return &x }
+
+	n := s.Count - s.Missing
+	if n > 0 {
+		s.Min, s.Max, s.Mean = f(s.min), f(s.max), f(s.mean)
+	}
+	if n > 1 {
+		s.SD = f(math.Sqrt(s.m2 / float64(n-1)))
+	}
+}
+
+// summarize computes the statistics of a variable over all buckets.
+func summarize(vn string) (*Summary, error) {
+
+	s := &Summary{Name: vn, Dtype: dtypes[vn]}
+	if s.Dtype == "text" {
+		return nil, fmt.Errorf("variable %s is text, statistics are only computed for numbers", vn)
+	}
+
+	for bn := 0; bn < conf.NumBuckets; bn++ {
+		rdr, err := config.OpenReader(bn, sourcedir, vn, s.Dtype)
+		if err != nil {
+			return nil, err
+		}
+		nr, err := config.OpenNulls(bn, sourcedir, vn)
+		if err != nil {
+			rdr.Close()
+			return nil, err
+		}
+		for {
+			x, err := rdr.Float()
+			if err == io.EOF {
+				break
+			}
+			var valid bool
+			if err == nil {
+				valid, err = nr.Valid()
+			}
+			if err != nil {
+				rdr.Close()
+				nr.Close()
+				return nil, err
+			}
+			s.add(x, valid)
+		}
+		rdr.Close()
+		nr.Close()
+	}
+	s.finish()
+
+	return s, nil
+}
+
+// getstats returns the statistics of a variable, computing them if
+// they have not been requested before.  Concurrent requests for new
+// variables are computed one at a time.
+func getstats(vn string) (*Summary, error) {
+
+	statsmut.Lock()
+	defer statsmut.Unlock()
+
+	if s, ok := stats[vn]; ok {
+		return s, nil
+	}
+
+	s, err := summarize(vn)
+	if err != nil {
+		return nil, err
+	}
+	stats[vn] = s
+
+	return s, nil
+}
+
+// getvars returns the variables in a comma-separated list, or all
+// variables if the list is empty.
+func getvars(vl string) ([]string, error) {
+
+	if vl == "" {
+		return varnames, nil
+	}
+
+	vars := strings.Split(vl, ",")
+	for _, vn := range vars {
+		if _, ok := dtypes[vn]; !ok {
+			return nil, fmt.Errorf("variable %s not found", vn)
+		}
+	}
+
+	return vars, nil
+}
+
+// writejson writes a JSON response.
+func writejson(w http.ResponseWriter, v interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		logger.Error("cannot write response", "err", err)
+	}
+}
+
+// Variable describes a variable in the schema.
+type Variable struct {
+	Name  string
+	Dtype string
+	Coded bool
+	*config.VarInfo
+}
+
+func handleschema(w http.ResponseWriter, r *http.Request) {
+
+	info, err := config.ReadVarInfo(sourcedir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var vars []Variable
+	for _, vn := range varnames {
+		_, coded := labels[vn]
+		vars = append(vars, Variable{Name: vn, Dtype: dtypes[vn], Coded: coded, VarInfo: info[vn]})
+	}
+
+	writejson(w, struct {
+		NumBuckets  int
+		Compression string
+		Routing     *config.Routing   `json:",omitempty"`
+		Attrs       map[string]string `json:",omitempty"`
+		Variables   []Variable
+	}{conf.NumBuckets, conf.Compression, conf.Routing, conf.Attrs, vars})
+}
+
+func handlestats(w http.ResponseWriter, r *http.Request) {
+
+	vl := r.URL.Query().Get("vars")
+	vars, err := getvars(vl)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var sums []*Summary
+	for _, vn := range vars {
+		if vl == "" && dtypes[vn] == "text" {
+			continue
+		}
+		s, err := getstats(vn)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sums = append(sums, s)
+	}
+
+	writejson(w, sums)
+}
+
+// query describes the rows requested from /select.
+type query struct {
+
+	// The id variable and the ids to select, if selecting on ids
+	idvar string
+	ids   idrange.Set
+
+	// The selection expression, if any
+	where *expr.Expr
+
+	// The variables to write
+	vars []string
+
+	// If true, write JSON rather than CSV
+	json bool
+}
+
+// parsequery reads the parameters of a request to /select.
+func parsequery(r *http.Request) (*query, error) {
+
+	par := r.URL.Query()
+	q := &query{idvar: par.Get("idvar")}
+
+	idtext := par.Get("ids")
+	if r.Method == "POST" {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		idtext += "\n" + string(b)
+	}
+	if q.idvar != "" {
+		dt, ok := dtypes[q.idvar]
+		if !ok {
+			return nil, fmt.Errorf("variable %s not found", q.idvar)
+		}
+		if !strings.HasPrefix(dt, "uint") && dt != "uvarint" && dt != "delta-uvarint" {
+			return nil, fmt.Errorf("variable %s has dtype %s, not an unsigned integer type", q.idvar, dt)
+		}
+		var err error
+		q.ids, err = idrange.Parse(idtext)
+		if err != nil {
+			return nil, err
+		}
+	} else if strings.TrimSpace(idtext) != "" {
+		return nil, fmt.Errorf("ids were given without idvar")
+	}
+
+	if wh := par.Get("where"); wh != "" {
+		var err error
+		q.where, err = expr.Parse(wh)
+		if err != nil {
+			return nil, err
+		}
+		for _, vn := range q.where.Vars {
+			if _, ok := dtypes[vn]; !ok {
+				return nil, fmt.Errorf("variable %s not found", vn)
+			}
+		}
+	}
+
+	var err error
+	if q.vars, err = getvars(par.Get("vars")); err != nil {
+		return nil, err
+	}
+
+	switch par.Get("format") {
+	case "", "csv":
+	case "json":
+		q.json = true
+	default:
+		return nil, fmt.Errorf("unknown format %s, expected csv or json", par.Get("format"))
+	}
+
+	return q, nil
+}
+
+// skippable returns true if the range recorded in meta.json or the
+// Bloom filter built by index shows that a bucket holds none of the
+// requested ids.
+func (q *query) skippable(bn int) (bool, error) {
+
+	if len(q.ids) == 0 {
+		return q.idvar != "", nil
+	}
+
+	if meta, err := config.ReadMeta(bn, sourcedir); err == nil {
+		if r, ok := meta.Range(q.idvar); ok {
+			if !q.ids.Overlaps(r.Min, r.Max) {
+				return true, nil
+			}
+		}
+	}
+
+	var nids uint64
+	for _, r := range q.ids {
+		nids += r.Hi - r.Lo + 1
+		if r.Hi-r.Lo >= 1<<16 || nids > 1<<16 {
+			return false, nil
+		}
+	}
+
+	b, err := config.ReadBloom(bn, sourcedir, q.idvar)
+	if err != nil || b == nil {
+		return false, err
+	}
+	for _, r := range q.ids {
+		for x := r.Lo; ; x++ {
+			if b.Test(x) {
+				return false, nil
+			}
+			if x == r.Hi {
+				break
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// mask returns the rows of a bucket that are selected, or nil if no row
+// is selected.
+func (q *query) mask(bn int) ([]bool, error) {
+
+	skip, err := q.skippable(bn)
+	if err != nil || skip {
+		return nil, err
+	}
+
+	var idr *config.ColumnReader
+	if q.idvar != "" {
+		idr, err = config.OpenReader(bn, sourcedir, q.idvar, dtypes[q.idvar])
+		if err != nil {
+			return nil, err
+		}
+		defer idr.Close()
+	}
+
+	var wrdrs []*config.ColumnReader
+	if q.where != nil {
+		for _, vn := range q.where.Vars {
+			rdr, err := config.OpenReader(bn, sourcedir, vn, dtypes[vn])
+			if err != nil {
+				return nil, err
+			}
+			defer rdr.Close()
+			wrdrs = append(wrdrs, rdr)
+		}
+	}
+
+	n, err := config.NumRows(bn, sourcedir)
+	if err != nil {
+		return nil, err
+	}
+
+	ix := make([]bool, n)
+	vals := make([]float64, len(wrdrs))
+	var m int
+	for i := range ix {
+		ix[i] = true
+		if idr != nil {
+			x, err := idr.Uint()
+			if err != nil {
+				return nil, err
+			}
+			ix[i] = q.ids.Contains(x)
+		}
+		if q.where != nil {
+			for j, rdr := range wrdrs {
+				if vals[j], err = rdr.Float(); err != nil {
+					return nil, err
+				}
+			}
+			ix[i] = ix[i] && q.where.Test(vals)
+		}
+		if ix[i] {
+			m++
+		}
+	}
+	if m == 0 {
+		return nil, nil
+	}
+
+	return ix, nil
+}
+
+// rowwriter writes selected rows in the requested format.
+type rowwriter struct {
+	q    *query
+	bw   *bufio.Writer
+	csv  *csv.Writer
+	jrow map[string]interface{}
+}
+
+// write writes one row, where rec holds the values as text and valid
+// indicates which values are present.
+func (rw *rowwriter) write(rec []string, valid []bool) error {
+
+	if !rw.q.json {
+		for j := range rec {
+			if !valid[j] {
+				rec[j] = ""
+			}
+		}
+		return rw.csv.Write(rec)
+	}
+
+	for j, vn := range rw.q.vars {
+		rw.jrow[vn] = jsonvalue(vn, rec[j], valid[j])
+	}
+	b, err := json.Marshal(rw.jrow)
+	if err != nil {
+		return err
+	}
+	rw.bw.Write(b)
+	return rw.bw.WriteByte('\n')
+}
+
+// jsonvalue returns the JSON value of a variable given as text:
+// numbers for numeric data types, booleans for bool, and strings for
+// text, dates, times and factor labels.
+func jsonvalue(vn, x string, valid bool) interface{} {
+
+	if !valid {
+		return nil
+	}
+	if _, ok := labels[vn]; ok {
+		return x
+	}
+
+	switch dtypes[vn] {
+	case "text", "date32", "timestamp64":
+		return x
+	case "bool":
+		return x == "true"
+	case "float32", "float64":
+		f, err := strconv.ParseFloat(x, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil
+		}
+	}
+
+	return json.Number(x)
+}
+
+// writebucket writes the selected rows of one bucket.
+func (rw *rowwriter) writebucket(bn int, ix []bool) error {
+
+	vars := rw.q.vars
+	rdrs := make([]*config.ColumnReader, len(vars))
+	nrdrs := make([]*config.NullReader, len(vars))
+	for j, vn := range vars {
+		var err error
+		rdrs[j], err = config.OpenReader(bn, sourcedir, vn, dtypes[vn])
+		if err != nil {
+			return err
+		}
+		defer rdrs[j].Close()
+		nrdrs[j], err = config.OpenNulls(bn, sourcedir, vn)
+		if err != nil {
+			return err
+		}
+		defer nrdrs[j].Close()
+	}
+
+	rec := make([]string, len(vars))
+	valid := make([]bool, len(vars))
+	for i := 0; i < len(ix); {
+
+		// Skip the run of rows that are not selected.
+		k := i
+		for k < len(ix) && !ix[k] {
+			k++
+		}
+		if k > i {
+			for j := range rdrs {
+				if err := rdrs[j].Skip(k - i); err != nil {
+					return err
+				}
+				for r := i; r < k; r++ {
+					if _, err := nrdrs[j].Valid(); err != nil {
+						return err
+					}
+				}
+			}
+			i = k
+			continue
+		}
+
+		for j, vn := range vars {
+			var err error
+			if lab := labels[vn]; lab != nil {
+				var x uint64
+				x, err = rdrs[j].Uint()
+				if s, ok := lab[int(x)]; ok {
+					rec[j] = s
+				} else {
+					rec[j] = strconv.FormatUint(x, 10)
+				}
+			} else {
+				rec[j], err = rdrs[j].Text()
+			}
+			if err == nil {
+				valid[j], err = nrdrs[j].Valid()
+			}
+			if err != nil {
+				return fmt.Errorf("cannot read variable %s in bucket %d: %v", vn, bn, err)
+			}
+		}
+		if err := rw.write(rec, valid); err != nil {
+			return err
+		}
+		i++
+	}
+
+	return nil
+}
+
+func handleselect(w http.ResponseWriter, r *http.Request) {
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxbody)
+	q, err := parsequery(r)
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if q.json {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "text/csv")
+	}
+
+	rw := &rowwriter{q: q, bw: bufio.NewWriter(w), jrow: make(map[string]interface{})}
+	if !q.json {
+		rw.csv = csv.NewWriter(rw.bw)
+		rw.csv.Write(q.vars)
+	}
+
+	for bn := 0; bn < conf.NumBuckets; bn++ {
+		ix, err := q.mask(bn)
+		if err == nil && ix != nil {
+			err = rw.writebucket(bn, ix)
+		}
+		if err != nil {
+			// The status has already been sent, so the response
+			// is cut short to show the client that it is
+			// incomplete.
+			logger.Error("response cut short", "url", r.URL.String(), "bucket", bn, "err", err)
+			panic(http.ErrAbortHandler)
+		}
+		if rw.csv != nil {
+			rw.csv.Flush()
+		}
+		if err := rw.bw.Flush(); err != nil {
+			// The client went away.
+			return
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+}
+
+// Run runs serve with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	var addr string
+	var readheader, read, idle time.Duration
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&addr, "addr", "localhost:8080", "address to listen on")
+	fs.Int64Var(&maxbody, "maxbody", 16<<20, "largest request body accepted, in bytes")
+	fs.DurationVar(&readheader, "readheader", 10*time.Second, "time allowed to read the headers of a request")
+	fs.DurationVar(&read, "read", time.Minute, "time allowed to read a whole request")
+	fs.DurationVar(&idle, "idle", 2*time.Minute, "time an idle connection is kept open")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	varnames = nil
+	stats = make(map[string]*Summary)
+
+	if sourcedir == "" {
+		return cli.Usage("usage:\nserve -sourcedir=... [-addr=host:port] [-maxbody=...] [-readheader=...] [-read=...] [-idle=...]\n\n")
+	}
+
+	logger = slog.Default()
+
+	var err error
+
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	dtypes, err = config.ReadDtypes(0, sourcedir)
+	if err != nil {
+		return err
+	}
+	for vn := range dtypes {
+		varnames = append(varnames, vn)
+	}
+	sort.Strings(varnames)
+
+	labels = make(map[string]map[int]string)
+	for _, vn := range varnames {
+		if config.HasFactorCodes(vn, conf) {
+			codes, err := config.GetFactorCodes(vn, conf)
+			if err != nil {
+				return err
+			}
+			labels[vn] = config.RevCodes(codes)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/schema", handleschema)
+	mux.HandleFunc("/stats", handlestats)
+	mux.HandleFunc("/select", handleselect)
+
+	// Each request is logged at the debugging level.
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.Debug("request", "method", r.Method, "url", r.URL.String(), "remote", r.RemoteAddr)
+		mux.ServeHTTP(w, r)
+	})
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: readheader,
+		ReadTimeout:       read,
+		IdleTimeout:       idle,
+	}
+
+	logger.Info("serving", "sourcedir", sourcedir, "addr", addr)
+	err = srv.ListenAndServe()
+	logger.Error("server stopped", "err", err)
+	return cli.Status(1)
+}
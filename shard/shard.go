@@ -0,0 +1,270 @@
+// Shard splits a columnized dataset into one dataset for each level of
+// a factor-coded variable.  The dataset for a level holds the records
+// with that level, and is written to a directory under -targetdir
+// named by the level's label (with characters other than letters,
+// digits, '-', '_' and '.' replaced by '_').  Every dataset has the
+// buckets of the source, and the full factor codes.
+//
+// Each bucket is read once to find the records of every level, and
+// the records are then copied as in select.  Records whose code has no
+// label are not copied.
+
+package shard
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+	"github.com/kshedden/gocols/subset"
+)
+
+var (
+	// The factor-coded variable defining the shards
+	vname string
+
+	// The directory where the shards will be stored
+	targetdir string
+
+	// The directory where the full data are stored
+	sourcedir string
+
+	// Configuration information for the source data
+	conf *config.Config
+
+	// If true, overwrite existing files
+	replace bool
+
+	// If true, read back each column after writing it to confirm
+	// that it round-trips
+	verifywrite bool
+
+	// The copier for the shard of each code
+	copiers map[uint64]*subset.Copier
+
+	// The numbers of records copied to each shard, and of records
+	// without a label
+	counts    map[uint64]int
+	unlabeled int
+
+	// The number of buckets processed in parallel
+	concurrency int
+
+	// The first error encountered while processing the buckets
+	firsterr error
+	errmut   sync.Mutex
+
+	sem chan bool
+)
+
+// dirname returns the name of the directory holding the shard for a
+// label.
+func dirname(lab string) string {
+
+	f := func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}
+	d := strings.Map(f, lab)
+	if d == "" || d == "." || d == ".." {
+		d = "_" + d
+	}
+
+	return d
+}
+
+// setup creates a shard for each label.
+func setup(codes map[string]int) error {
+
+	copiers = make(map[uint64]*subset.Copier)
+	counts = make(map[uint64]int)
+	dirs := make(map[string]string)
+
+	for lab, c := range codes {
+		d := dirname(lab)
+		if other, ok := dirs[d]; ok {
+			return fmt.Errorf("labels %q and %q both have directory name %s", lab, other, d)
+		}
+		dirs[d] = lab
+
+		cp := &subset.Copier{SourceDir: sourcedir, TargetDir: path.Join(targetdir, d), Verify: verifywrite}
+		if err := cp.Setup(conf); err != nil {
+			return err
+		}
+		copiers[uint64(c)] = cp
+	}
+
+	return nil
+}
+
+// masks returns the records of each shard in a bucket.
+func masks(bn int) (map[uint64][]bool, int, error) {
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return nil, 0, err
+	}
+	dt, ok := dtypes[vname]
+	if !ok {
+		return nil, 0, fmt.Errorf("variable %s not found in bucket %d", vname, bn)
+	}
+
+	rdr, err := config.OpenReader(bn, sourcedir, vname, dt)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rdr.Close()
+
+	var xs []uint64
+	for {
+		x, err := rdr.Uint()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, 0, err
+		}
+		xs = append(xs, x)
+	}
+
+	mk := make(map[uint64][]bool)
+	for c := range copiers {
+		mk[c] = make([]bool, len(xs))
+	}
+	var nu int
+	for i, x := range xs {
+		if m, ok := mk[x]; ok {
+			m[i] = true
+		} else {
+			nu++
+		}
+	}
+
+	return mk, nu, nil
+}
+
+// dobucket copies the records of one bucket to the shards.
+func dobucket(bn int) {
+
+	defer func() { <-sem }()
+
+	mk, nu, err := masks(bn)
+	if err == nil {
+		for c, m := range mk {
+			if err = copiers[c].CopyBucket(bn, m); err != nil {
+				break
+			}
+		}
+	}
+
+	errmut.Lock()
+	defer errmut.Unlock()
+	if err != nil {
+		if firsterr == nil {
+			firsterr = err
+		}
+		return
+	}
+	unlabeled += nu
+	for c, m := range mk {
+		for _, b := range m {
+			if b {
+				counts[c]++
+			}
+		}
+	}
+}
+
+// Run runs shard with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("shard", flag.ContinueOnError)
+	fs.StringVar(&vname, "var", "", "factor-coded variable defining the shards")
+	fs.StringVar(&targetdir, "targetdir", "", "directory where the shards are written")
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.BoolVar(&replace, "replace", false, "overwrite existing files")
+	fs.BoolVar(&verifywrite, "verify-write", false, "read back each column after writing to confirm it round-trips")
+	fs.IntVar(&concurrency, "concurrency", runtime.NumCPU(), "number of buckets or variables processed in parallel")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	unlabeled = 0
+
+	if concurrency < 1 {
+		return errors.New("-concurrency must be positive")
+	}
+
+	if vname == "" || targetdir == "" || sourcedir == "" {
+		return cli.Usage("usage:\nshard -var=... -targetdir=... -sourcedir=...\n\n")
+	}
+
+	if !replace {
+		_, err := os.Stat(targetdir)
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("Use -replace=true to overwrite existing contents of %s", targetdir)
+		}
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	if !config.HasFactorCodes(vname, conf) {
+		return fmt.Errorf("Variable %s is not factor-coded", vname)
+	}
+	codes, err := config.GetFactorCodes(vname, conf)
+	if err != nil {
+		return err
+	}
+
+	if err := setup(codes); err != nil {
+		return err
+	}
+
+	sem = make(chan bool, concurrency)
+	for _, cp := range copiers {
+		cp.Sem = sem
+	}
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		sem <- true
+		go dobucket(k)
+	}
+
+	for k := 0; k < concurrency; k++ {
+		sem <- true
+	}
+
+	if firsterr != nil {
+		return firsterr
+	}
+
+	var labs []string
+	for lab := range codes {
+		labs = append(labs, lab)
+	}
+	sort.Strings(labs)
+	for _, lab := range labs {
+		slog.Info("wrote shard", "label", lab, "records", counts[uint64(codes[lab])], "dir", path.Join(targetdir, dirname(lab)))
+	}
+	if unlabeled > 0 {
+		slog.Warn("records have codes without labels and were not copied", "records", unlabeled)
+	}
+
+	return nil
+}
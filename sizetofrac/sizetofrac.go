@@ -0,0 +1,267 @@
+// Sizetofrac estimates the fraction of records that should be sampled
+// from a data set so that the sample occupies approximately a given
+// number of bytes on disk.  The number of bytes per record and the
+// number of records are estimated from a few evenly spaced buckets.
+// Optionally the sample is drawn, retaining each record independently
+// with the estimated probability, and its actual size is reported.
+// When the sample is drawn, a report of the run is saved in targetdir
+// as report.json (see the report package).
+
+package sizetofrac
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+	"github.com/kshedden/gocols/report"
+	"github.com/kshedden/gocols/subset"
+)
+
+var (
+	// The desired size of the sample in bytes
+	targetsize int64
+
+	// The number of buckets used to estimate the size per record
+	nprobe int
+
+	// If true, draw the sample
+	run bool
+
+	// Seed for the random number generator
+	seed int64
+
+	// The directory where the sample will be stored
+	targetdir string
+
+	// The directory where the full data are stored
+	sourcedir string
+
+	// Configuration information for the source data
+	conf *config.Config
+
+	// If true, overwrite existing files
+	replace bool
+
+	// Copies the selected rows to the target directory
+	copier *subset.Copier
+
+	// The report saved in the target directory
+	rpt *report.Report
+
+	// The number of buckets processed in parallel
+	concurrency int
+
+	sem chan bool
+
+	// The first error from any bucket
+	firsterr error
+	errmut   sync.Mutex
+)
+
+// seterr records an error from a bucket, if it is the first one.
+func seterr(err error) {
+	errmut.Lock()
+	if firsterr == nil {
+		firsterr = err
+	}
+	errmut.Unlock()
+}
+
+// numrows returns the number of rows in a bucket, which is recorded in
+// meta.json by the programs that write the bucket.
+func numrows(bn int) (int, error) {
+
+	n, err := config.NumRows(bn, sourcedir)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// bucketsize returns the total size in bytes of the column files in a
+// bucket of the data set in directory pa.
+func bucketsize(bn int, pa string) (int64, error) {
+
+	dtypes, err := config.ReadDtypes(bn, pa)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for vn := range dtypes {
+		fi, err := os.Stat(config.ColumnPath(bn, pa, vn))
+		if err != nil {
+			return 0, err
+		}
+		n += fi.Size()
+	}
+
+	return n, nil
+}
+
+// estimate returns the estimated number of bytes per record and the
+// estimated number of records in the data set.
+func estimate() (float64, float64, error) {
+
+	nb := conf.NumBuckets
+	if nprobe > nb {
+		nprobe = nb
+	}
+
+	var size int64
+	var rows int
+	for j := 0; j < nprobe; j++ {
+		bn := j * nb / nprobe
+		m, err := bucketsize(bn, sourcedir)
+		if err != nil {
+			return 0, 0, err
+		}
+		n, err := numrows(bn)
+		if err != nil {
+			return 0, 0, err
+		}
+		size += m
+		rows += n
+	}
+
+	if rows == 0 {
+		return 0, 0, nil
+	}
+
+	return float64(size) / float64(rows), float64(rows) * float64(nb) / float64(nprobe), nil
+}
+
+// dobucket draws the sample from one bucket.
+func dobucket(bn int, frac float64) {
+
+	defer func() { <-sem }()
+
+	// Seed each bucket separately so that the sample does not
+	// depend on the order in which buckets are processed.
+	rng := rand.New(rand.NewSource(seed + int64(bn)))
+
+	n, err := numrows(bn)
+	if err != nil {
+		seterr(err)
+		return
+	}
+
+	ix := make([]bool, n)
+	for i := range ix {
+		ix[i] = rng.Float64() < frac
+	}
+
+	if err := copier.CopyBucket(bn, ix); err != nil {
+		seterr(err)
+		return
+	}
+	rpt.Mask(bn, ix)
+}
+
+// Run runs sizetofrac with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("sizetofrac", flag.ContinueOnError)
+	fs.Int64Var(&targetsize, "size", 0, "desired size of the sample in bytes")
+	fs.IntVar(&nprobe, "probe", 5, "number of buckets used for the estimate")
+	fs.BoolVar(&run, "run", false, "draw the sample")
+	fs.Int64Var(&seed, "seed", 1, "seed for the random number generator")
+	fs.StringVar(&targetdir, "targetdir", "", "destination directory")
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.BoolVar(&replace, "replace", false, "overwrite existing files")
+	fs.IntVar(&concurrency, "concurrency", runtime.NumCPU(), "number of buckets or variables processed in parallel")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	firsterr = nil
+
+	if concurrency < 1 {
+		return errors.New("-concurrency must be positive")
+	}
+
+	if targetsize <= 0 || sourcedir == "" || nprobe < 1 || (run && targetdir == "") {
+		return cli.Usage("usage:\nsizetofrac -size=... -sourcedir=... [-probe=...] [-run -targetdir=... -seed=...]\n\n")
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	bpr, nrows, err := estimate()
+	if err != nil {
+		return err
+	}
+	if nrows == 0 {
+		return errors.New("The probed buckets contain no records")
+	}
+
+	frac := float64(targetsize) / (bpr * nrows)
+	if frac > 1 {
+		frac = 1
+	}
+
+	fmt.Printf("Estimated %.1f bytes per record and %.0f records\n", bpr, nrows)
+	fmt.Printf("Estimated sampling fraction: %.6g\n", frac)
+
+	if !run {
+		return nil
+	}
+
+	if !replace {
+		_, err := os.Stat(targetdir)
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("Use -replace=true to overwrite existing contents of %s", targetdir)
+		}
+	}
+
+	copier = &subset.Copier{SourceDir: sourcedir, TargetDir: targetdir}
+	if err := copier.Setup(conf); err != nil {
+		return err
+	}
+
+	rpt = report.New(fs, args, sourcedir, targetdir, conf.NumBuckets)
+
+	sem = make(chan bool, concurrency)
+	copier.Sem = sem
+
+	for bn := 0; bn < conf.NumBuckets; bn++ {
+		sem <- true
+		go dobucket(bn, frac)
+	}
+
+	for j := 0; j < concurrency; j++ {
+		sem <- true
+	}
+
+	if firsterr != nil {
+		return firsterr
+	}
+
+	if err := rpt.Write(nil); err != nil {
+		return err
+	}
+
+	var size int64
+	for bn := 0; bn < conf.NumBuckets; bn++ {
+		m, err := bucketsize(bn, targetdir)
+		if err != nil {
+			return err
+		}
+		size += m
+	}
+
+	slog.Info("wrote the sample", "targetdir", targetdir, "bytes", size, "target", targetsize)
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package sizetofrac_test
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kshedden/gocols/coltest"
+	"github.com/kshedden/gocols/config"
+	"github.com/kshedden/gocols/sizetofrac"
+)
+
+// size returns the total size of the column files of a data set.
+func size(t *testing.T, dir string) int64 {
+	conf, err := config.GetConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int64
+	for k := 0; k < conf.NumBuckets; k++ {
+		dtypes, err := config.ReadDtypes(k, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for vn := range dtypes {
+			fi, err := config.StatFile(config.ColumnPath(k, dir, vn))
+			if err != nil {
+				t.Fatal(err)
+			}
+			n += fi.Size()
+		}
+	}
+	return n
+}
+
+func TestSizeToFrac(t *testing.T) {
+
+	var b strings.Builder
+	b.WriteString("x,y\n")
+	for i := 0; i < 4000; i++ {
+		fmt.Fprintf(&b, "%d,%d\n", 1000+i, (i*7919)%100000)
+	}
+
+	// Without compression, the size of a sample is nearly proportional
+	// to its number of records.
+	src := coltest.CSV(t, b.String(), "-buckets=4", "-compression=none")
+	total := float64(size(t, src))
+
+	for _, tc := range []struct {
+		target float64
+		frac   float64
+	}{
+		{total / 10, 0.1},
+		{total / 2, 0.5},
+		{2 * total, 1},
+	} {
+		target := int64(tc.target)
+		dst := coltest.Dir(t, "sample")
+		out, err := coltest.Stdout(t, sizetofrac.Run, "-sourcedir="+src, "-targetdir="+dst,
+			fmt.Sprintf("-size=%d", target), "-probe=4", "-run")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out) != 2 || !strings.HasPrefix(out[1], "Estimated sampling fraction: ") {
+			t.Fatalf("size %d: got %q", target, out)
+		}
+
+		// All buckets are probed, so the estimate is exact.
+		frac, err := strconv.ParseFloat(strings.TrimPrefix(out[1], "Estimated sampling fraction: "), 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(frac-tc.frac) > 1e-3 {
+			t.Errorf("size %d: estimated fraction %v, expected %v", target, frac, tc.frac)
+		}
+
+		want := math.Min(tc.target, total)
+		if got := float64(size(t, dst)); math.Abs(got-want) > 0.1*want {
+			t.Errorf("size %d: the sample has %.0f bytes, expected about %.0f", target, got, want)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+// Sortrows sorts the rows of each bucket of a data set in place, by
+// one or more variables given with -by.  Rows are ordered by the
+// first variable, ties are broken by the second variable, and so on,
+// and rows that tie on all variables keep their order.  Values are
+// compared numerically, so factor-coded variables are ordered by their
+// codes.  With -desc, the order is reversed.
+//
+// Each column of a bucket is rewritten in the new order, to a
+// temporary file that replaces the column once all columns have been
+// written.  A delta-uvarint variable that is no longer non-decreasing
+// is stored as uvarint.  If a bucket has a meta.json file, it is
+// updated.
+
+package sortrows
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The variables to sort by
+	by []string
+
+	// If true, sort in decreasing order
+	desc bool
+
+	// Configuration information for the data set
+	conf *config.Config
+
+	// The number of buckets processed in parallel
+	concurrency int
+
+	// The first error encountered while processing the buckets
+	firsterr error
+	errmut   sync.Mutex
+
+	sem chan bool
+)
+
+// column holds the values of a variable in a bucket.
+type column struct {
+	dtype string
+	vals  []uint64
+}
+
+// readcolumn reads all values of a variable in a bucket.
+func readcolumn(bn int, vn, dt string) (*column, error) {
+
+	rdr, err := config.OpenReader(bn, sourcedir, vn, dt)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+
+	col := &column{dtype: dt}
+	for {
+		x, err := rdr.Bits()
+		if err == io.EOF {
+			return col, nil
+		} else if err != nil {
+			return nil, err
+		}
+		col.vals = append(col.vals, x)
+	}
+}
+
+// compare returns -1, 0 or 1 as the value in row i of a column is less
+// than, equal to or greater than the value in row j.  NaN is greater
+// than all other values.
+func (col *column) compare(i, j int) int {
+
+	x, y := col.vals[i], col.vals[j]
+
+	switch col.dtype {
+	case "float32", "float64":
+		a, b := math.Float64frombits(x), math.Float64frombits(y)
+		switch {
+		case a < b || (!math.IsNaN(a) && math.IsNaN(b)):
+			return -1
+		case a > b || (math.IsNaN(a) && !math.IsNaN(b)):
+			return 1
+		}
+		return 0
+	case "varint", "date32", "timestamp64":
+		a, b := int64(x), int64(y)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	}
+
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// permutation returns the order of the rows of a bucket.
+func permutation(bn int, dtypes map[string]string) ([]int, error) {
+
+	var keys []*column
+	for _, vn := range by {
+		dt, ok := dtypes[vn]
+		if !ok {
+			return nil, fmt.Errorf("variable %s not found in bucket %d", vn, bn)
+		}
+		col, err := readcolumn(bn, vn, dt)
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) > 0 && len(col.vals) != len(keys[0].vals) {
+			return nil, fmt.Errorf("variable %s in bucket %d has %d rows, expected %d",
+				vn, bn, len(col.vals), len(keys[0].vals))
+		}
+		keys = append(keys, col)
+	}
+
+	perm := make([]int, len(keys[0].vals))
+	for i := range perm {
+		perm[i] = i
+	}
+
+	sort.SliceStable(perm, func(i, j int) bool {
+		for _, col := range keys {
+			c := col.compare(perm[i], perm[j])
+			if desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	return perm, nil
+}
+
+// writecolumn writes the values of a column in the given order to a
+// temporary file, returning the data type of the stored values.
+func writecolumn(bn int, vn string, col *column, perm []int) (string, error) {
+
+	dt := col.dtype
+	if dt == "delta-uvarint" {
+		for i := 1; i < len(perm); i++ {
+			if col.vals[perm[i]] < col.vals[perm[i-1]] {
+				dt = "uvarint"
+				break
+			}
+		}
+	}
+
+	wtr, fid, err := config.CreateColumn(bn, sourcedir, vn+".tmp")
+	if err != nil {
+		return "", err
+	}
+
+	var last uint64
+	for _, i := range perm {
+		x := col.vals[i]
+		if dt == "delta-uvarint" {
+			err = config.WriteUint(wtr, "uvarint", x-last)
+			last = x
+		} else {
+			err = config.WriteBits(wtr, dt, x)
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	err1 := wtr.Close()
+	err2 := fid.Close()
+	for _, e := range []error{err, err1, err2} {
+		if e != nil {
+			return "", e
+		}
+	}
+
+	return dt, nil
+}
+
+// dobucket sorts one bucket.
+func dobucket(bn int) {
+
+	defer func() { <-sem }()
+
+	err := sortbucket(bn)
+	if err != nil {
+		errmut.Lock()
+		if firsterr == nil {
+			firsterr = err
+		}
+		errmut.Unlock()
+	}
+}
+
+// sortbucket rewrites the columns of one bucket in sorted order.
+func sortbucket(bn int) error {
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return err
+	}
+
+	perm, err := permutation(bn, dtypes)
+	if err != nil {
+		return err
+	}
+
+	newdt := make(map[string]string)
+	for vn, dt := range dtypes {
+		col, err := readcolumn(bn, vn, dt)
+		if err != nil {
+			return err
+		}
+		if len(col.vals) != len(perm) {
+			return fmt.Errorf("variable %s in bucket %d has %d rows, expected %d", vn, bn, len(col.vals), len(perm))
+		}
+		newdt[vn], err = writecolumn(bn, vn, col, perm)
+		if err != nil {
+			return fmt.Errorf("bucket %d, variable %s: %v", bn, vn, err)
+		}
+	}
+
+	for vn := range dtypes {
+		err := os.Rename(config.ColumnPath(bn, sourcedir, vn+".tmp"), config.ColumnPath(bn, sourcedir, vn))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = config.WriteDtypes(bn, sourcedir, newdt)
+	if err != nil {
+		return err
+	}
+
+	// The column sizes have changed.
+	if meta, err := config.ReadMeta(bn, sourcedir); err == nil {
+		meta, err = config.ComputeMeta(bn, sourcedir, meta.IdVar)
+		if err != nil {
+			return err
+		}
+		return config.WriteMeta(bn, sourcedir, meta)
+	}
+
+	return nil
+}
+
+// Run runs sortrows with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("sortrows", flag.ContinueOnError)
+	var blist string
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&blist, "by", "", "comma-separated variables to sort by")
+	fs.BoolVar(&desc, "desc", false, "sort in decreasing order")
+	fs.IntVar(&concurrency, "concurrency", runtime.NumCPU(), "number of buckets processed in parallel")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	firsterr = nil
+
+	if concurrency < 1 {
+		return errors.New("-concurrency must be positive")
+	}
+
+	if sourcedir == "" || blist == "" {
+		return cli.Usage("usage:\nsortrows -sourcedir=... -by=... [-desc]\n\n")
+	}
+	by = strings.Split(blist, ",")
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	sem = make(chan bool, concurrency)
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		sem <- true
+		go dobucket(k)
+	}
+
+	for k := 0; k < concurrency; k++ {
+		sem <- true
+	}
+
+	if firsterr != nil {
+		return firsterr
+	}
+
+	return nil
+}
@@ -0,0 +1,350 @@
+// Stats computes summary statistics for the variables of a data set:
+// the number of values, the number of missing values (NaN, or marked as
+// missing in the validity bitmap of the variable, see
+// config.NullSuffix), and the minimum, maximum, mean, standard
+// deviation and number of distinct values of the non-missing values.
+// The buckets are read one at a time, and the report is written as JSON
+// or CSV.
+//
+// The number of distinct values is exact when it is at most 1024, and
+// is otherwise estimated from the smallest hashes of the values (a
+// k-minimum-values sketch), with a relative error of about 3%.
+//
+// With -mmap, fixed-width variables stored without compression are
+// read from memory-mapped files (see config.UseMmap).
+
+package stats
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The variables to summarize
+	vars []string
+
+	// The report format, json or csv
+	format string
+
+	// Configuration information for the data set
+	conf *config.Config
+)
+
+const (
+	// The number of hashes kept to estimate the number of distinct
+	// values
+	sketchsize = 1024
+)
+
+// Summary holds the statistics of one variable.
+type Summary struct {
+	Name  string
+	Dtype string
+
+	// The number of values, including missing values
+	Count int
+
+	// The number of missing values
+	Missing int
+
+	Min, Max, Mean, SD float64
+
+	// The number of distinct non-missing values, and whether it is
+	// exact or estimated
+	Distinct      float64
+	DistinctExact bool
+
+	// Running quantities for the mean and variance
+	m2 float64
+
+	sketch *kmv
+}
+
+// kmv keeps the smallest distinct hashes seen, in a max-heap.
+type kmv struct {
+	h    []uint64
+	seen map[uint64]bool
+}
+
+func (s *kmv) Len() int           { return len(s.h) }
+func (s *kmv) Less(i, j int) bool { return s.h[i] > s.h[j] }
+func (s *kmv) Swap(i, j int)      { s.h[i], s.h[j] = s.h[j], s.h[i] }
+func (s *kmv) Push(x interface{}) { s.h = append(s.h, x.(uint64)) }
+func (s *kmv) Pop() interface{}   { x := s.h[len(s.h)-1]; s.h = s.h[:len(s.h)-1]; return x }
+
+// add adds the hash of a value to the sketch.
+func (s *kmv) add(x uint64) {
+
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], x)
+	hf := fnv.New64a()
+	hf.Write(b[:])
+	h := hf.Sum64()
+
+	if s.seen[h] || (len(s.h) == sketchsize && h >= s.h[0]) {
+		return
+	}
+
+	heap.Push(s, h)
+	s.seen[h] = true
+	if len(s.h) > sketchsize {
+		delete(s.seen, heap.Pop(s).(uint64))
+	}
+}
+
+// estimate returns the estimated number of distinct values, and true if
+// it is exact.
+func (s *kmv) estimate() (float64, bool) {
+	if len(s.h) < sketchsize {
+		return float64(len(s.h)), true
+	}
+	return float64(sketchsize-1) / (float64(s.h[0]) / math.MaxUint64), false
+}
+
+// add updates the statistics with a value, given as a float and as the
+// 64-bit value returned by config.ColumnReader.Bits.
+func (s *Summary) add(x float64, bits uint64) {
+
+	s.Count++
+	if math.IsNaN(x) {
+		s.Missing++
+		return
+	}
+
+	n := float64(s.Count - s.Missing)
+	if n == 1 || x < s.Min {
+		s.Min = x
+	}
+	if n == 1 || x > s.Max {
+		s.Max = x
+	}
+	d := x - s.Mean
+	s.Mean += d / n
+	s.m2 += d * (x - s.Mean)
+
+	// Floats are hashed by value so that 0 and -0 agree.
+	if x == 0 {
+		bits = 0
+	}
+	s.sketch.add(bits)
+}
+
+// finish computes the final statistics.
+func (s *Summary) finish() {
+
+	n := s.Count - s.Missing
+	if n == 0 {
+		s.Min, s.Max, s.Mean = math.NaN(), math.NaN(), math.NaN()
+	}
+	s.SD = math.NaN()
+	if n > 1 {
+		s.SD = math.Sqrt(s.m2 / float64(n-1))
+	}
+	s.Distinct, s.DistinctExact = s.sketch.estimate()
+}
+
+// dobucket adds the values in one bucket to the statistics.
+func dobucket(bn int, sums []*Summary) error {
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return err
+	}
+
+	for j, vn := range vars {
+		dt, ok := dtypes[vn]
+		if !ok {
+			return fmt.Errorf("variable %s not found in bucket %d", vn, bn)
+		}
+		if sums[j].Dtype == "" {
+			sums[j].Dtype = dt
+		} else if dt != sums[j].Dtype {
+			return fmt.Errorf("variable %s has dtype %s in bucket %d, but %s in an earlier bucket", vn, dt, bn, sums[j].Dtype)
+		}
+
+		rdr, err := config.OpenReader(bn, sourcedir, vn, dt)
+		if err != nil {
+			return err
+		}
+		nr, err := config.OpenNulls(bn, sourcedir, vn)
+		if err != nil {
+			rdr.Close()
+			return err
+		}
+		for {
+			bits, err := rdr.Bits()
+			if err == io.EOF {
+				break
+			}
+			var valid bool
+			if err == nil {
+				valid, err = nr.Valid()
+			}
+			if err != nil {
+				rdr.Close()
+				nr.Close()
+				return err
+			}
+			if !valid {
+				sums[j].add(math.NaN(), 0)
+				continue
+			}
+
+			var x float64
+			switch dt {
+			case "float32", "float64":
+				x = math.Float64frombits(bits)
+			case "varint", "date32", "timestamp64":
+				x = float64(int64(bits))
+			default:
+				x = float64(bits)
+			}
+			sums[j].add(x, bits)
+		}
+		rdr.Close()
+		nr.Close()
+	}
+
+	return nil
+}
+
+// writecsv writes the statistics as CSV.
+func writecsv(w io.Writer, sums []*Summary) error {
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"Name", "Dtype", "Count", "Missing", "Min", "Max", "Mean", "SD", "Distinct", "DistinctExact"})
+
+	f := func(x float64) string { return strconv.FormatFloat(x, 'g', -1, 64) }
+	for _, s := range sums {
+		out.Write([]string{s.Name, s.Dtype, strconv.Itoa(s.Count), strconv.Itoa(s.Missing), f(s.Min), f(s.Max),
+			f(s.Mean), f(s.SD), f(math.Round(s.Distinct)), strconv.FormatBool(s.DistinctExact)})
+	}
+	out.Flush()
+
+	return out.Error()
+}
+
+// writejson writes the statistics as JSON.  Undefined statistics are
+// written as null.
+func writejson(w io.Writer, sums []*Summary) error {
+
+	num := func(x float64) interface{} {
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return nil
+		}
+		return x
+	}
+
+	var recs []map[string]interface{}
+	for _, s := range sums {
+		recs = append(recs, map[string]interface{}{
+			"Name":          s.Name,
+			"Dtype":         s.Dtype,
+			"Count":         s.Count,
+			"Missing":       s.Missing,
+			"Min":           num(s.Min),
+			"Max":           num(s.Max),
+			"Mean":          num(s.Mean),
+			"SD":            num(s.SD),
+			"Distinct":      math.Round(s.Distinct),
+			"DistinctExact": s.DistinctExact,
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(recs)
+}
+
+// Run runs stats with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	var vlist, outname string
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&vlist, "vars", "", "comma-separated variables to summarize (default all)")
+	fs.StringVar(&format, "format", "json", "report format, json or csv")
+	fs.StringVar(&outname, "out", "", "output file (default stdout)")
+	fs.BoolVar(&config.UseMmap, "mmap", false, "memory-map uncompressed fixed-width columns")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" || (format != "json" && format != "csv") {
+		return cli.Usage("usage:\nstats -sourcedir=... [-vars=...] [-format=json|csv] [-out=...] [-mmap]\n\n")
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	if vlist != "" {
+		vars = strings.Split(vlist, ",")
+	} else {
+		dtypes, err := config.ReadDtypes(0, sourcedir)
+		if err != nil {
+			return err
+		}
+		for vn := range dtypes {
+			vars = append(vars, vn)
+		}
+		sort.Strings(vars)
+	}
+
+	sums := make([]*Summary, len(vars))
+	for j, vn := range vars {
+		sums[j] = &Summary{Name: vn, sketch: &kmv{seen: make(map[uint64]bool)}}
+	}
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		if err := dobucket(k, sums); err != nil {
+			return err
+		}
+	}
+	for _, s := range sums {
+		s.finish()
+	}
+
+	w := os.Stdout
+	if outname != "" {
+		w, err = os.Create(outname)
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+	}
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	if format == "csv" {
+		err = writecsv(bw, sums)
+	} else {
+		err = writejson(bw, sums)
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
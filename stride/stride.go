@@ -0,0 +1,177 @@
+// Stride creates a copy of a columnized dataset, retaining every k'th
+// record.  By default the stride is global, so the retained records
+// are those at positions 0, k, 2k, ... in the concatenation of all
+// buckets (taken in bucket order).  With -perbucket, the stride
+// restarts at the first record of each bucket.  A report of the run is
+// saved in targetdir as report.json (see the report package).
+
+package stride
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+	"github.com/kshedden/gocols/report"
+	"github.com/kshedden/gocols/subset"
+)
+
+var (
+	// Retain every k'th record
+	k int
+
+	// If true, the stride restarts in each bucket
+	perbucket bool
+
+	// The directory where the selected data will be stored
+	targetdir string
+
+	// The directory where the full data are stored
+	sourcedir string
+
+	// Configuration information for the source data
+	conf *config.Config
+
+	// If true, overwrite existing files
+	replace bool
+
+	// If true, read back each column after writing it to confirm
+	// that it round-trips
+	verifywrite bool
+
+	// Copies the selected rows to the target directory
+	copier *subset.Copier
+
+	// The report saved in the target directory
+	rpt *report.Report
+
+	// The number of buckets processed in parallel
+	concurrency int
+
+	sem chan bool
+
+	// The first error from any bucket
+	firsterr error
+	errmut   sync.Mutex
+)
+
+// seterr records an error from a bucket, if it is the first one.
+func seterr(err error) {
+	errmut.Lock()
+	if firsterr == nil {
+		firsterr = err
+	}
+	errmut.Unlock()
+}
+
+// numrows returns the number of rows in a bucket, which is recorded in
+// meta.json by the programs that write the bucket.
+func numrows(bn int) (int, error) {
+
+	n, err := config.NumRows(bn, sourcedir)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// dobucket does the selection on one bucket, where pos is the global
+// position of the first row of the bucket.
+func dobucket(bn, pos, n int) {
+
+	defer func() { <-sem }()
+
+	if perbucket {
+		pos = 0
+	}
+
+	ix := make([]bool, n)
+	for i := range ix {
+		ix[i] = (pos+i)%k == 0
+	}
+
+	if err := copier.CopyBucket(bn, ix); err != nil {
+		seterr(err)
+		return
+	}
+	rpt.Mask(bn, ix)
+}
+
+// Run runs stride with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("stride", flag.ContinueOnError)
+	fs.IntVar(&k, "k", 0, "retain every k'th record")
+	fs.BoolVar(&perbucket, "perbucket", false, "restart the stride in each bucket")
+	fs.StringVar(&targetdir, "targetdir", "", "destination directory")
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.BoolVar(&replace, "replace", false, "overwrite existing files")
+	fs.BoolVar(&verifywrite, "verify-write", false, "read back each column after writing to confirm it round-trips")
+	fs.IntVar(&concurrency, "concurrency", runtime.NumCPU(), "number of buckets or variables processed in parallel")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	firsterr = nil
+
+	if concurrency < 1 {
+		return errors.New("-concurrency must be positive")
+	}
+
+	if k < 1 || targetdir == "" || sourcedir == "" {
+		return cli.Usage("usage:\nstride -k=... -targetdir=... -sourcedir=... [-perbucket]\n\n")
+	}
+
+	if !replace {
+		_, err := os.Stat(targetdir)
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("Use -replace=true to overwrite existing contents of %s", targetdir)
+		}
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	copier = &subset.Copier{SourceDir: sourcedir, TargetDir: targetdir, Verify: verifywrite}
+	if err := copier.Setup(conf); err != nil {
+		return err
+	}
+
+	rpt = report.New(fs, args, sourcedir, targetdir, conf.NumBuckets)
+
+	sem = make(chan bool, concurrency)
+	copier.Sem = sem
+
+	var pos int
+	for bn := 0; bn < conf.NumBuckets; bn++ {
+		n, err := numrows(bn)
+		if err != nil {
+			return err
+		}
+		sem <- true
+		go dobucket(bn, pos, n)
+		pos += n
+	}
+
+	for j := 0; j < concurrency; j++ {
+		sem <- true
+	}
+
+	if firsterr != nil {
+		return firsterr
+	}
+
+	if err := rpt.Write(nil); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package stride_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kshedden/gocols/coltest"
+	"github.com/kshedden/gocols/stride"
+)
+
+func TestStride(t *testing.T) {
+
+	// Bucket 0 holds the even values and bucket 1 the odd values, so
+	// the global positions 0, 1, ..., 9 hold 0, 2, 4, 6, 8, 1, 3, 5,
+	// 7, 9.
+	src := coltest.CSV(t, "x\n0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n")
+
+	for _, tc := range []struct {
+		flags []string
+		want  []string
+	}{
+		{
+			flags: []string{"-k=1"},
+			want:  []string{"0", "2", "4", "6", "8", "1", "3", "5", "7", "9"},
+		},
+		{
+			// Global positions 0, 3, 6 and 9
+			flags: []string{"-k=3"},
+			want:  []string{"0", "6", "3", "9"},
+		},
+		{
+			// Global positions 0, 4 and 8
+			flags: []string{"-k=4"},
+			want:  []string{"0", "8", "7"},
+		},
+		{
+			// Positions 0 and 3 of each bucket
+			flags: []string{"-k=3", "-perbucket"},
+			want:  []string{"0", "6", "1", "7"},
+		},
+		{
+			flags: []string{"-k=20"},
+			want:  []string{"0"},
+		},
+	} {
+		target := coltest.Dir(t, "target")
+		args := append([]string{"-sourcedir=" + src, "-targetdir=" + target, "-q"}, tc.flags...)
+		if err := stride.Run(args); err != nil {
+			t.Fatal(err)
+		}
+		if got := coltest.Records(t, target); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: got %q, expected %q", tc.flags, got, tc.want)
+		}
+	}
+}
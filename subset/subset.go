@@ -0,0 +1,1103 @@
+// Package subset copies a columnized data set into a new location,
+// retaining only some of the rows in each bucket.  The rows to retain
+// in a bucket are described by a boolean mask with one element per
+// row.
+
+package subset
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/kshedden/gocols/config"
+)
+
+// Copier copies masked rows of each bucket from a source data set to
+// a target data set.
+type Copier struct {
+
+	// The directory where the full data are stored
+	SourceDir string
+
+	// The directory where the selected data will be stored
+	TargetDir string
+
+	// If true, the selected rows are appended to the existing
+	// columns of the target data set
+	Append bool
+
+	// If true, each column is written to a temporary file and read
+	// back to confirm that it decompresses to the values that were
+	// written, before it is moved into place
+	Verify bool
+
+	// If not nil, only the variables for which Keep returns true
+	// are copied
+	Keep func(vname string) bool
+
+	// If true, a marker file recording the data types and file
+	// sizes is written in each target bucket once it has been
+	// copied, so that an interrupted copy can be resumed
+	Markers bool
+
+	// If not nil, the variables of a bucket are copied concurrently,
+	// sharing the tokens of Sem with the buckets.  The goroutine
+	// calling CopyBucket is assumed to hold a token, and copies a
+	// variable itself when no other token is free, so that no more
+	// copies run at once than Sem has room for.
+	Sem chan bool
+}
+
+// marker is the content of the file written when a bucket has been
+// copied.
+type marker struct {
+
+	// The data types of the variables
+	Dtypes map[string]string
+
+	// The sizes in bytes of the column files
+	Sizes map[string]int64
+}
+
+// markerpath returns the path of the marker file for a bucket.
+func (c *Copier) markerpath(bn int) string {
+	return config.Join(config.BucketPath(bn, c.TargetDir), "done.json")
+}
+
+// writemarker records that a bucket has been copied.  The files of the
+// bucket are synced first, so that the marker is not written before
+// the data it describes are on disk.
+func (c *Copier) writemarker(bn int, dtypes map[string]string) error {
+
+	p := config.BucketPath(bn, c.TargetDir)
+	if !config.IsRemote(p) {
+		names, err := config.ReadDir(p)
+		if err != nil {
+			return err
+		}
+		for _, na := range names {
+			if err := config.SyncFile(config.Join(p, na)); err != nil {
+				return err
+			}
+		}
+	}
+
+	mk := marker{Dtypes: dtypes, Sizes: make(map[string]int64)}
+	for vn := range dtypes {
+		fi, err := config.StatFile(config.ColumnPath(bn, c.TargetDir, vn))
+		if err != nil {
+			return err
+		}
+		mk.Sizes[vn] = fi.Size()
+	}
+
+	b, err := json.Marshal(mk)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that a marker is never
+	// partially written.
+	fn := c.markerpath(bn)
+	err = config.WriteFile(fn+".tmp", b)
+	if err == nil {
+		err = config.SyncFile(fn + ".tmp")
+	}
+	if err == nil {
+		err = config.RenameFile(fn+".tmp", fn)
+	}
+	if err != nil {
+		return err
+	}
+	return config.SyncFile(p)
+}
+
+// Done returns true if a bucket was completely copied by an earlier
+// run with Markers set.  The marker must exist, and the data types and
+// column file sizes in the target bucket must agree with it.
+func (c *Copier) Done(bn int) bool {
+
+	b, err := config.ReadFile(c.markerpath(bn))
+	if err != nil {
+		return false
+	}
+	var mk marker
+	if json.Unmarshal(b, &mk) != nil {
+		return false
+	}
+
+	sdt, err := c.Dtypes(bn)
+	if err != nil || len(sdt) != len(mk.Dtypes) {
+		return false
+	}
+	tdt, err := config.ReadDtypes(bn, c.TargetDir)
+	if err != nil || len(tdt) != len(mk.Dtypes) {
+		return false
+	}
+
+	for vn, dt := range mk.Dtypes {
+		if sdt[vn] != dt || tdt[vn] != dt {
+			return false
+		}
+		fi, err := config.StatFile(config.ColumnPath(bn, c.TargetDir, vn))
+		if err != nil || fi.Size() != mk.Sizes[vn] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Discard removes the files written to a bucket of the target data
+// set by a copy that did not complete, so that no partially copied
+// columns are left behind.  The bucket directory itself is kept, and a
+// later run with Markers set copies the bucket again.  Rows appended to
+// existing columns cannot be removed, so Discard fails when appending.
+func (c *Copier) Discard(bn int) error {
+
+	if c.Append {
+		return fmt.Errorf("cannot discard the rows appended to bucket %d", bn)
+	}
+
+	p := config.BucketPath(bn, c.TargetDir)
+	names, err := config.ReadDir(p)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, na := range names {
+		if err := config.RemoveFile(config.Join(p, na)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Setup creates the directory layout where the selected cases will be
+// written, and saves a configuration file, a copy of the factor codes
+// and the descriptions of the copied variables in the target
+// directory.  It fails without writing anything if the target
+// directory is the source directory, or if either contains the other.
+func (c *Copier) Setup(conf *config.Config) error {
+
+	if err := config.CheckTarget(c.SourceDir, c.TargetDir); err != nil {
+		return err
+	}
+
+	p := config.Join(c.TargetDir, "Buckets")
+	err := config.MkdirAll(p)
+	if err != nil {
+		return err
+	}
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		q := config.BucketPath(k, c.TargetDir)
+		err = config.MkdirAll(q)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Modify the conf for the target directory and save it there.
+	var tconf config.Config
+	tconf = *conf
+	tconf.CodesDir = config.Join(c.TargetDir, "Codes")
+	err = config.WriteConfig(c.TargetDir, &tconf)
+	if err != nil {
+		return err
+	}
+	err = config.CopyVarInfo(c.SourceDir, c.TargetDir, c.Keep)
+	if err != nil {
+		return err
+	}
+
+	return CopyCodes(conf.CodesDir, tconf.CodesDir)
+}
+
+// Dtypes returns the data types of the variables in a bucket of the
+// source data set that are copied.
+func (c *Copier) Dtypes(bn int) (map[string]string, error) {
+
+	dtypes, err := config.ReadDtypes(bn, c.SourceDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Keep != nil {
+		for vn := range dtypes {
+			if !c.Keep(vn) {
+				delete(dtypes, vn)
+			}
+		}
+	}
+
+	return dtypes, nil
+}
+
+// CopyBucket copies the rows of one bucket flagged in ix, for every
+// variable in the bucket that is kept.
+func (c *Copier) CopyBucket(bn int, ix []bool) error {
+	return c.CopyBucketContext(context.Background(), bn, ix)
+}
+
+// CopyBucketContext is like CopyBucket, but stops when ctx is done and
+// returns its error.  The variables being copied at that time are
+// finished, but no others are started, and the bucket gets no marker,
+// so a later run with Markers set copies it again.
+func (c *Copier) CopyBucketContext(ctx context.Context, bn int, ix []bool) error {
+
+	dtypes, err := c.Dtypes(bn)
+	if err != nil {
+		return err
+	}
+
+	if c.Markers {
+		err = config.RemoveFile(c.markerpath(bn))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if !c.Append {
+		err = config.WriteDtypes(bn, c.TargetDir, dtypes)
+		if err != nil {
+			return err
+		}
+	}
+
+	var n int
+	for _, f := range ix {
+		if f {
+			n++
+		}
+	}
+
+	// When no row is selected, the source columns are not read: the
+	// target columns are created empty, or left alone when appending.
+	sel := ix
+	if n == 0 {
+		sel = nil
+	}
+
+	if n > 0 || !c.Append {
+		if err := c.copyvars(ctx, bn, dtypes, sel); err != nil {
+			return err
+		}
+	}
+
+	// Record the number of rows, so that readers need not count
+	// them.  When appending to a bucket without a summary, the
+	// number of rows is not known.
+	if c.Append {
+		meta, err := config.ReadMeta(bn, c.TargetDir)
+		if err == nil {
+			n += meta.NumRows
+		} else {
+			n = -1
+		}
+	}
+	if n >= 0 {
+		if err := config.RecordRows(bn, c.TargetDir, n); err != nil {
+			return err
+		}
+	}
+
+	if c.Markers {
+		return c.writemarker(bn, dtypes)
+	}
+
+	return nil
+}
+
+// copyvars copies the selected rows of the variables of a bucket,
+// concurrently if c.Sem is set, until ctx is done.  The first error is
+// returned.
+func (c *Copier) copyvars(ctx context.Context, bn int, dtypes map[string]string, ix []bool) error {
+
+	var wg sync.WaitGroup
+	var mut sync.Mutex
+	var firsterr error
+
+	for vn, dt := range dtypes {
+		if ctx.Err() != nil {
+			break
+		}
+		vn, dt := vn, dt
+		f := func() {
+			if err := c.copyvar(bn, vn, dt, ix); err != nil {
+				mut.Lock()
+				if firsterr == nil {
+					firsterr = err
+				}
+				mut.Unlock()
+			}
+		}
+
+		if c.Sem == nil {
+			f()
+			continue
+		}
+
+		select {
+		case c.Sem <- true:
+			wg.Add(1)
+			go func() {
+				defer func() { <-c.Sem; wg.Done() }()
+				f()
+			}()
+		default:
+			f()
+		}
+	}
+	wg.Wait()
+
+	if firsterr == nil {
+		firsterr = ctx.Err()
+	}
+
+	return firsterr
+}
+
+// copyvar copies the selected rows of one variable of a bucket, with
+// its validity bitmap.
+func (c *Copier) copyvar(bn int, vn, dt string, ix []bool) error {
+
+	var err error
+	if dt == "uvarint" {
+		err = c.CopyUvarint(bn, vn, ix)
+	} else if dt == "delta-uvarint" {
+		err = c.CopyDeltaUvarint(bn, vn, ix)
+	} else if dt == "varint" {
+		err = c.CopyVarint(bn, vn, ix)
+	} else if dt == "text" {
+		err = c.CopyText(bn, vn, ix)
+	} else if dt == "bool" {
+		err = c.CopyBool(bn, vn, ix)
+	} else {
+		w, ok := config.DTsize[dt]
+		if !ok {
+			return fmt.Errorf("variable %s in bucket %d has unsupported dtype %s", vn, bn, dt)
+		}
+		err = c.CopyFixedWidth(bn, vn, w, ix)
+	}
+	if err == nil {
+		err = c.CopyNulls(bn, vn, ix)
+	}
+	if err != nil {
+		return fmt.Errorf("copying %s in bucket %d: %v", vn, bn, err)
+	}
+
+	return nil
+}
+
+// bufpool holds the buffers used to copy blocks of column data, so
+// that they are not allocated for every column or value copied.
+var bufpool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 1<<16)
+		return &b
+	},
+}
+
+// copybytes copies n bytes from r to w through buf, which must not be
+// empty.
+func copybytes(w io.Writer, r io.Reader, n int64, buf []byte) error {
+	for n > 0 {
+		b := buf
+		if int64(len(b)) > n {
+			b = b[0:n]
+		}
+		if _, err := io.ReadFull(r, b); err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		n -= int64(len(b))
+	}
+	return nil
+}
+
+// getreader returns a reader, closer pair for the source directory.
+func (c *Copier) getreader(bn int, vname string) (io.Reader, io.Closer, error) {
+	return config.OpenColumn(bn, c.SourceDir, vname)
+}
+
+// getwriter returns a writer, closer pair for the target directory,
+// compressing with the codec of the variable in the target data set.
+// When appending, a new compressed stream is written after the existing
+// contents of the file.
+func (c *Copier) getwriter(bn int, vname string) (io.WriteCloser, io.Closer, error) {
+	return c.openwriter(bn, vname, c.Append)
+}
+
+// openwriter is like getwriter, but appends to the existing contents
+// of the file only if appending is true.
+func (c *Copier) openwriter(bn int, vname string, appending bool) (io.WriteCloser, io.Closer, error) {
+	codec, err := config.ColumnCodec(c.TargetDir, vname)
+	if err != nil {
+		return nil, nil, err
+	}
+	fn := config.ColumnPath(bn, c.TargetDir, vname)
+	if c.Verify {
+		if config.IsRemote(fn) {
+			return nil, nil, fmt.Errorf("cannot verify %s, only local columns can be verified", fn)
+		}
+		v, err := newVerifier(fn, codec, appending)
+		if err != nil {
+			return nil, nil, err
+		}
+		return v, v, nil
+	}
+	var fid io.WriteCloser
+	if appending {
+		fid, err = config.AppendFile(fn)
+	} else {
+		fid, err = config.CreateFile(fn)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	wtr, err := codec.NewWriter(fid)
+	if err != nil {
+		fid.Close()
+		return nil, nil, err
+	}
+	return wtr, fid, nil
+}
+
+// closewriter closes a writer, closer pair obtained from getwriter,
+// reporting the first error.
+func closewriter(wtr io.WriteCloser, fid io.Closer) error {
+	err := wtr.Close()
+	if err2 := fid.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// verifier writes a column to a temporary file.  When closed, it reads
+// back the data and compares a hash of the decompressed bytes to a
+// hash of the bytes that were written, then renames the file into
+// place.  A mismatch is reported as an error, leaving the target
+// column untouched.
+type verifier struct {
+	fn     string
+	fid    *os.File
+	codec  *config.Codec
+	wtr    io.WriteCloser
+	offset int64
+	sum    hash.Hash64
+	closed bool
+}
+
+// newVerifier creates a verifier for the column file fn.  When
+// appending, the existing contents of fn are first copied into the
+// temporary file.
+func newVerifier(fn string, codec *config.Codec, appending bool) (*verifier, error) {
+
+	fid, err := os.OpenFile(fn+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	var offset int64
+	if appending {
+		old, err := os.Open(fn)
+		if err == nil {
+			offset, err = io.Copy(fid, old)
+			old.Close()
+			if err != nil {
+				fid.Close()
+				return nil, err
+			}
+		} else if !os.IsNotExist(err) {
+			fid.Close()
+			return nil, err
+		}
+	}
+
+	wtr, err := codec.NewWriter(fid)
+	if err != nil {
+		fid.Close()
+		return nil, err
+	}
+
+	return &verifier{
+		fn:     fn,
+		fid:    fid,
+		codec:  codec,
+		wtr:    wtr,
+		offset: offset,
+		sum:    fnv.New64a(),
+	}, nil
+}
+
+func (v *verifier) Write(p []byte) (int, error) {
+	v.sum.Write(p)
+	return v.wtr.Write(p)
+}
+
+// Close flushes and verifies the column, then moves it into place.
+// Subsequent calls do nothing.
+func (v *verifier) Close() error {
+
+	if v.closed {
+		return nil
+	}
+	v.closed = true
+
+	err := v.wtr.Close()
+	if err != nil {
+		v.fid.Close()
+		return err
+	}
+
+	_, err = v.fid.Seek(v.offset, io.SeekStart)
+	if err != nil {
+		v.fid.Close()
+		return err
+	}
+	h := fnv.New64a()
+	rdr, err := v.codec.NewReader(v.fid)
+	if err == nil {
+		_, err = io.Copy(h, rdr)
+		if c, ok := rdr.(io.Closer); ok {
+			c.Close()
+		}
+	}
+	v.fid.Close()
+	if err != nil || h.Sum64() != v.sum.Sum64() {
+		os.Remove(v.fid.Name())
+		return fmt.Errorf("verification of %s failed: data read back differ from data written (%v)", v.fn, err)
+	}
+
+	return os.Rename(v.fid.Name(), v.fn)
+}
+
+// CopyFixedWidth selects the values of interest from the given
+// variable in the source directory, and writes only those values to
+// the target directory.  This function operates on any slice of fixed
+// width values.
+func (c *Copier) CopyFixedWidth(bn int, vname string, w int, ix []bool) error {
+
+	// Input
+	rdr, fid1, err := c.getreader(bn, vname)
+	if err != nil {
+		return err
+	}
+	defer fid1.Close()
+
+	// Output
+	wtr, fid2, err := c.getwriter(bn, vname)
+	if err != nil {
+		return err
+	}
+	defer fid2.Close()
+	defer wtr.Close()
+
+	buf := bufpool.Get().(*[]byte)
+	defer bufpool.Put(buf)
+
+	// The mask is processed in runs of selected or unselected rows,
+	// so that dense selections are copied in large blocks.
+	for i := 0; i < len(ix); {
+		n := runlen(ix, i)
+		m := int64(n * w)
+		if ix[i] {
+			err = copybytes(wtr, rdr, m, *buf)
+		} else {
+			err = skipbytes(rdr, m)
+		}
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		} else if err != nil {
+			return err
+		}
+		i += n
+	}
+
+	return closewriter(wtr, fid2)
+}
+
+// runlen returns the length of the run of equal mask values starting
+// at position i.
+func runlen(ix []bool, i int) int {
+	j := i + 1
+	for j < len(ix) && ix[j] == ix[i] {
+		j++
+	}
+	return j - i
+}
+
+// skipbytes advances rdr by n bytes.  The buffered readers of
+// config.OpenColumn discard the bytes without copying them.
+func skipbytes(rdr io.Reader, n int64) error {
+	if br, ok := rdr.(*bufio.Reader); ok {
+		_, err := br.Discard(int(n))
+		return err
+	}
+	_, err := io.CopyN(io.Discard, rdr, n)
+	return err
+}
+
+// CopyUvarint selects the values of interest for a variable of type
+// uvarint from the source directory, and writes them to the target
+// directory.
+func (c *Copier) CopyUvarint(bn int, vname string, ix []bool) error {
+
+	// Input
+	rdr, fid1, err := c.getreader(bn, vname)
+	if err != nil {
+		return err
+	}
+	defer fid1.Close()
+	br := bufio.NewReader(rdr)
+
+	// Output
+	wtr, fid2, err := c.getwriter(bn, vname)
+	if err != nil {
+		return err
+	}
+	defer fid2.Close()
+	defer wtr.Close()
+
+	var b [binary.MaxVarintLen64]byte
+
+	for _, ii := range ix {
+		x, err := binary.ReadUvarint(br)
+		if err != nil {
+			return err
+		}
+
+		if !ii {
+			continue
+		}
+
+		m := binary.PutUvarint(b[:], x)
+		_, err = wtr.Write(b[0:m])
+		if err != nil {
+			return err
+		}
+	}
+
+	return closewriter(wtr, fid2)
+}
+
+// CopyVarint selects the values of interest for a variable of type
+// varint (signed) from the source directory, and writes them to the
+// target directory.
+func (c *Copier) CopyVarint(bn int, vname string, ix []bool) error {
+
+	// Input
+	rdr, fid1, err := c.getreader(bn, vname)
+	if err != nil {
+		return err
+	}
+	defer fid1.Close()
+	br := bufio.NewReader(rdr)
+
+	// Output
+	wtr, fid2, err := c.getwriter(bn, vname)
+	if err != nil {
+		return err
+	}
+	defer fid2.Close()
+	defer wtr.Close()
+
+	var b [binary.MaxVarintLen64]byte
+
+	for _, ii := range ix {
+		x, err := binary.ReadVarint(br)
+		if err != nil {
+			return err
+		}
+
+		if !ii {
+			continue
+		}
+
+		m := binary.PutVarint(b[:], x)
+		_, err = wtr.Write(b[0:m])
+		if err != nil {
+			return err
+		}
+	}
+
+	return closewriter(wtr, fid2)
+}
+
+// CopyText selects the values of interest for a variable of type text
+// from the source directory, and writes their lengths and bytes to the
+// target directory.
+func (c *Copier) CopyText(bn int, vname string, ix []bool) error {
+
+	// Input
+	rdr, fid1, err := c.getreader(bn, vname)
+	if err != nil {
+		return err
+	}
+	defer fid1.Close()
+	br := bufio.NewReader(rdr)
+
+	trdr, fid2, err := c.getreader(bn, vname+config.TextSuffix)
+	if err != nil {
+		return err
+	}
+	defer fid2.Close()
+	tbr := bufio.NewReader(trdr)
+
+	// Output
+	wtr, fid3, err := c.getwriter(bn, vname)
+	if err != nil {
+		return err
+	}
+	defer fid3.Close()
+	defer wtr.Close()
+
+	twtr, fid4, err := c.getwriter(bn, vname+config.TextSuffix)
+	if err != nil {
+		return err
+	}
+	defer fid4.Close()
+	defer twtr.Close()
+
+	var b [binary.MaxVarintLen64]byte
+	buf := bufpool.Get().(*[]byte)
+	defer bufpool.Put(buf)
+
+	for _, ii := range ix {
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return err
+		}
+
+		if !ii {
+			if _, err := tbr.Discard(int(n)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		m := binary.PutUvarint(b[:], n)
+		if _, err := wtr.Write(b[0:m]); err != nil {
+			return err
+		}
+		if err := copybytes(twtr, tbr, int64(n), *buf); err != nil {
+			return err
+		}
+	}
+
+	if err := closewriter(wtr, fid3); err != nil {
+		return err
+	}
+	return closewriter(twtr, fid4)
+}
+
+// CopyBool selects the values of interest for a variable of type bool
+// from the source directory, and writes them to the target directory.
+// Since the values are packed into bytes, a bool column cannot be
+// extended, so when appending the existing values of the target are
+// read and written again ahead of the selected values.
+func (c *Copier) CopyBool(bn int, vname string, ix []bool) error {
+
+	var old []bool
+	if c.Append {
+		var err error
+		old, err = c.readbools(bn, vname)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Input
+	rdr, err := config.OpenReader(bn, c.SourceDir, vname, "bool")
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+
+	// Output
+	wtr, fid, err := c.openwriter(bn, vname, false)
+	if err != nil {
+		return err
+	}
+	defer fid.Close()
+	bw := config.NewBoolWriter(wtr)
+
+	for _, x := range old {
+		if err := bw.Put(x); err != nil {
+			bw.Close()
+			return err
+		}
+	}
+
+	for _, ii := range ix {
+		x, err := rdr.Bool()
+		if err == nil && ii {
+			err = bw.Put(x)
+		}
+		if err != nil {
+			bw.Close()
+			return err
+		}
+	}
+
+	return closewriter(bw, fid)
+}
+
+// CopyNulls selects the values of interest from the validity bitmap of
+// a variable (see config.NullSuffix) in the source directory, and
+// writes them to the target directory.  The target has a bitmap if the
+// source has one, or when appending, if the target already has one.
+// It must be called after the values of the variable are copied, so
+// that when appending to a target without a bitmap, the number of rows
+// already in the target can be found.
+func (c *Copier) CopyNulls(bn int, vname string, ix []bool) error {
+
+	nr, err := config.OpenNulls(bn, c.SourceDir, vname)
+	if err != nil {
+		return err
+	}
+	defer nr.Close()
+
+	var old []bool
+	if c.Append {
+		old, err = c.readbools(bn, vname+config.NullSuffix)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case !nr.HasNulls() && !c.Append:
+		// A bitmap left by an earlier copy is removed.
+		err := config.RemoveFile(config.ColumnPath(bn, c.TargetDir, vname+config.NullSuffix))
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return err
+	case !nr.HasNulls() && old == nil:
+		return nil
+	}
+
+	var nsel int
+	for _, ii := range ix {
+		if ii {
+			nsel++
+		}
+	}
+
+	// Rows already in the target that have no bitmap have values.
+	if c.Append && old == nil {
+		dtypes, err := config.ReadDtypes(bn, c.TargetDir)
+		if err != nil {
+			return err
+		}
+		n, err := config.CountRows(bn, c.TargetDir, vname, dtypes[vname])
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n-nsel; i++ {
+			old = append(old, true)
+		}
+	}
+
+	wtr, fid, err := c.openwriter(bn, vname+config.NullSuffix, false)
+	if err != nil {
+		return err
+	}
+	defer fid.Close()
+	bw := config.NewBoolWriter(wtr)
+
+	for _, x := range old {
+		if err := bw.Put(x); err != nil {
+			bw.Close()
+			return err
+		}
+	}
+
+	for _, ii := range ix {
+		x, err := nr.Valid()
+		if err == nil && ii {
+			err = bw.Put(x)
+		}
+		if err != nil {
+			bw.Close()
+			return err
+		}
+	}
+
+	return closewriter(bw, fid)
+}
+
+// readbools returns the values of a bool variable, or of a validity
+// bitmap, in the target directory, which are nil if the column does not
+// exist.
+func (c *Copier) readbools(bn int, vname string) ([]bool, error) {
+
+	rdr, err := config.OpenReader(bn, c.TargetDir, vname, "bool")
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+
+	var x []bool
+	for {
+		v, err := rdr.Bool()
+		if err == io.EOF {
+			return x, nil
+		} else if err != nil {
+			return nil, err
+		}
+		x = append(x, v)
+	}
+}
+
+// CopyDeltaUvarint selects the values of interest for a variable of
+// type delta-uvarint.  The values are reconstructed from the stored
+// differences, and the differences between the selected values are
+// written to the target directory.
+func (c *Copier) CopyDeltaUvarint(bn int, vname string, ix []bool) error {
+
+	// When appending, the differences continue from the last value
+	// already in the target.
+	var x, last uint64
+	if c.Append {
+		var err error
+		last, err = c.lastvalue(bn, vname)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Input
+	rdr, fid1, err := c.getreader(bn, vname)
+	if err != nil {
+		return err
+	}
+	defer fid1.Close()
+	br := bufio.NewReader(rdr)
+
+	// Output
+	wtr, fid2, err := c.getwriter(bn, vname)
+	if err != nil {
+		return err
+	}
+	defer fid2.Close()
+	defer wtr.Close()
+
+	var b [binary.MaxVarintLen64]byte
+
+	for _, ii := range ix {
+		d, err := binary.ReadUvarint(br)
+		if err != nil {
+			return err
+		}
+		x += d
+
+		if !ii {
+			continue
+		}
+
+		if x < last {
+			return fmt.Errorf("cannot append to %s in bucket %d: values are not sorted", vname, bn)
+		}
+		m := binary.PutUvarint(b[:], x-last)
+		_, err = wtr.Write(b[0:m])
+		if err != nil {
+			return err
+		}
+		last = x
+	}
+
+	return closewriter(wtr, fid2)
+}
+
+// lastvalue returns the last value of a delta-uvarint variable in the
+// target directory, or zero if the variable has not been written.
+func (c *Copier) lastvalue(bn int, vname string) (uint64, error) {
+
+	_, err := config.StatFile(config.ColumnPath(bn, c.TargetDir, vname))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	rdr, err := config.OpenReader(bn, c.TargetDir, vname, "delta-uvarint")
+	if err != nil {
+		return 0, err
+	}
+	defer rdr.Close()
+
+	var last uint64
+	for {
+		x, err := rdr.Uint()
+		if err == io.EOF {
+			return last, nil
+		} else if err != nil {
+			return 0, err
+		}
+		last = x
+	}
+}
+
+// CopyCodes makes a copy in directory dp of all the files in the
+// codes directory sp (labels for factor-coded variables and related
+// meta-data).
+func CopyCodes(sp, dp string) error {
+
+	err := config.MkdirAll(dp)
+	if err != nil {
+		return err
+	}
+
+	names, err := config.ReadDir(sp)
+	if err != nil {
+		return err
+	}
+
+	for _, fn := range names {
+		err = CopyFile(config.Join(sp, fn), config.Join(dp, fn))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CopyFile copies the contents of file src to file dst, either of which
+// may be remote (see config.Storage).
+func CopyFile(src, dst string) error {
+
+	fid, err := config.OpenFile(src)
+	if err != nil {
+		return err
+	}
+	defer fid.Close()
+
+	gid, err := config.CreateFile(dst)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(gid, fid)
+	if err != nil {
+		gid.Close()
+		return err
+	}
+	return gid.Close()
+}
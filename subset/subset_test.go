@@ -0,0 +1,197 @@
+package subset_test
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kshedden/gocols/coltest"
+	"github.com/kshedden/gocols/config"
+	"github.com/kshedden/gocols/subset"
+)
+
+// data holds a record of each data type, with missing values, placed
+// in two buckets in turn.
+const data = `u,v,f,t,b,s
+1,-5,1.5,one,true,a
+2,6,,two,false,b
+3,-7,3.5,,true,c
+4,8,4.5,four,,a
+5,-9,5.5,five,false,b
+6,10,NA,six,true,c
+7,-11,7.5,seven,false,a
+`
+
+const schema = "-schema=u:uvarint,v:varint,f:float64,t:text,b:bool,s:string"
+
+// copyall copies the rows of each bucket of a data set selected by
+// mask, which is given the bucket and the row in the bucket.
+func copyall(c *subset.Copier, mask func(bn, i int) bool) error {
+
+	conf, err := config.GetConfig(c.SourceDir)
+	if err != nil {
+		return err
+	}
+	if err := c.Setup(conf); err != nil {
+		return err
+	}
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		n, err := config.NumRows(k, c.SourceDir)
+		if err != nil {
+			return err
+		}
+		ix := make([]bool, n)
+		for i := range ix {
+			ix[i] = mask(k, i)
+		}
+		if err := c.CopyBucket(k, ix); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func TestCopyBucket(t *testing.T) {
+
+	src := coltest.CSV(t, data, schema)
+
+	for _, tc := range []struct {
+		name string
+		mask func(bn, i int) bool
+	}{
+		{"all", func(bn, i int) bool { return true }},
+		{"none", func(bn, i int) bool { return false }},
+		{"even", func(bn, i int) bool { return i%2 == 0 }},
+		{"bucket 1", func(bn, i int) bool { return bn == 1 }},
+		{"runs", func(bn, i int) bool { return i != 1 }},
+	} {
+		for _, verify := range []bool{false, true} {
+			c := &subset.Copier{SourceDir: src, TargetDir: coltest.Dir(t, "target"), Verify: verify}
+			if err := copyall(c, tc.mask); err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+
+			for k := 0; k < 2; k++ {
+				var want []string
+				for i, rec := range coltest.Records(t, src, fmt.Sprintf("-bucket=%d", k)) {
+					if tc.mask(k, i) {
+						want = append(want, rec)
+					}
+				}
+				got := coltest.Records(t, c.TargetDir, fmt.Sprintf("-bucket=%d", k))
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("%s, bucket %d: got %q, expected %q", tc.name, k, got, want)
+				}
+			}
+		}
+	}
+}
+
+// broken makes the writers of the broken codec corrupt the data.
+var broken bool
+
+// brokenWriter changes the first byte of every write when broken is
+// set.
+type brokenWriter struct {
+	io.Writer
+}
+
+func (w brokenWriter) Write(p []byte) (int, error) {
+	if broken && len(p) > 0 {
+		q := append([]byte(nil), p...)
+		q[0]++
+		p = q
+	}
+	return w.Writer.Write(p)
+}
+
+func (w brokenWriter) Close() error {
+	return nil
+}
+
+func init() {
+	config.RegisterCodec(&config.Codec{
+		Name:      "broken",
+		Ext:       ".bin.broken",
+		NewReader: func(r io.Reader) (io.Reader, error) { return r, nil },
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) { return brokenWriter{w}, nil },
+	})
+}
+
+func TestVerifyBrokenCodec(t *testing.T) {
+
+	src := coltest.CSV(t, data, schema, "-compression=broken")
+
+	broken = true
+	defer func() { broken = false }()
+
+	for _, tc := range []struct {
+		verify bool
+		err    string
+	}{
+		{false, ""},
+		{true, "verification of "},
+	} {
+		c := &subset.Copier{SourceDir: src, TargetDir: coltest.Dir(t, "target"), Verify: tc.verify}
+		err := copyall(c, func(bn, i int) bool { return true })
+
+		if tc.err == "" {
+			// The corruption goes unnoticed.
+			if err != nil {
+				t.Errorf("verify=%v: %v", tc.verify, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("verify=%v: got error %v, expected %q", tc.verify, err, tc.err)
+		}
+
+		// The column that failed is not moved into place.
+		names, err := filepath.Glob(filepath.Join(config.BucketPath(0, c.TargetDir), "*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, na := range names {
+			if strings.HasSuffix(na, ".bin.broken") || strings.HasSuffix(na, ".tmp") {
+				t.Errorf("verify=%v: %s was left in the target", tc.verify, na)
+			}
+		}
+	}
+}
+
+func TestSetupNested(t *testing.T) {
+
+	src := coltest.CSV(t, data)
+	conf, err := config.GetConfig(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		target string
+		err    string
+	}{
+		{src, "equal to sourcedir"},
+		{filepath.Join(src, "sub"), "inside sourcedir"},
+		{filepath.Dir(src), "inside targetdir"},
+		{src + "2", ""},
+	} {
+		c := &subset.Copier{SourceDir: src, TargetDir: tc.target}
+		err := c.Setup(conf)
+		if tc.err == "" && err != nil {
+			t.Errorf("%s: %v", tc.target, err)
+		} else if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%s: got error %v, expected %q", tc.target, err, tc.err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(src, "sub")); !os.IsNotExist(err) {
+		t.Errorf("Setup wrote into the source directory")
+	}
+}
@@ -0,0 +1,154 @@
+// Tolong writes a data set to stdout as CSV in long format, with one
+// row for each combination of a record and a measurement variable.
+// The columns are the id, the name of the measurement variable and
+// its value.  Optionally, factor-coded variables are written using
+// their labels.
+
+package tolong
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The variable identifying the records
+	idvar string
+
+	// The measurement variables
+	vars []string
+
+	// If true, write labels in place of factor codes
+	decode bool
+
+	// Configuration information for the data set
+	conf *config.Config
+
+	// The reverse factor codes of the measurement variables, nil
+	// for variables that are not decoded
+	labels []map[int]string
+
+	out *bufio.Writer
+)
+
+// dobucket writes the rows for one bucket.
+func dobucket(bn int) error {
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		return err
+	}
+
+	for _, vn := range append([]string{idvar}, vars...) {
+		if _, ok := dtypes[vn]; !ok {
+			return fmt.Errorf("Variable %s not found in bucket %d", vn, bn)
+		}
+	}
+
+	idr, err := config.OpenReader(bn, sourcedir, idvar, dtypes[idvar])
+	if err != nil {
+		return err
+	}
+	defer idr.Close()
+
+	rdrs := make([]*config.ColumnReader, len(vars))
+	for j, vn := range vars {
+		rdrs[j], err = config.OpenReader(bn, sourcedir, vn, dtypes[vn])
+		if err != nil {
+			return err
+		}
+		defer rdrs[j].Close()
+	}
+
+	for {
+		id, err := idr.Text()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		for j, vn := range vars {
+			var v string
+			if labels[j] != nil {
+				var x uint64
+				x, err = rdrs[j].Uint()
+				lab, ok := labels[j][int(x)]
+				if ok {
+					v = lab
+				} else {
+					v = fmt.Sprintf("%d", x)
+				}
+			} else {
+				v, err = rdrs[j].Text()
+			}
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(out, "%s,%s,%s\n", id, vn, v)
+		}
+	}
+
+	return nil
+}
+
+// Run runs tolong with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("tolong", flag.ContinueOnError)
+	var vlist string
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&idvar, "idvar", "", "variable identifying the records")
+	fs.StringVar(&vlist, "vars", "", "comma-separated measurement variables")
+	fs.BoolVar(&decode, "decode", false, "write labels for factor-coded variables")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" || idvar == "" || vlist == "" {
+		return cli.Usage("usage:\ntolong -sourcedir=... -idvar=... -vars=... [-decode]\n\n")
+	}
+
+	vars = strings.Split(vlist, ",")
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	labels = make([]map[int]string, len(vars))
+	if decode {
+		for j, vn := range vars {
+			if config.HasFactorCodes(vn, conf) {
+				codes, err := config.GetFactorCodes(vn, conf)
+				if err != nil {
+					return err
+				}
+				labels[j] = config.RevCodes(codes)
+			}
+		}
+	}
+
+	out = bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	fmt.Fprintf(out, "%s,variable,value\n", idvar)
+	for k := 0; k < conf.NumBuckets; k++ {
+		if err := dobucket(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
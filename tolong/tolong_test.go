@@ -0,0 +1,55 @@
+package tolong_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kshedden/gocols/coltest"
+	"github.com/kshedden/gocols/tolong"
+)
+
+func TestToLong(t *testing.T) {
+
+	dir := coltest.CSV(t, "id,a,b,s\n1,10,0.5,x\n2,20,1.5,y\n3,30,2.5,x\n", "-buckets=1")
+
+	for _, tc := range []struct {
+		flags []string
+		want  []string
+	}{
+		{
+			flags: []string{"-vars=a,b"},
+			want: []string{
+				"id,variable,value",
+				"1,a,10", "1,b,0.5",
+				"2,a,20", "2,b,1.5",
+				"3,a,30", "3,b,2.5",
+			},
+		},
+		{
+			flags: []string{"-vars=s,a"},
+			want: []string{
+				"id,variable,value",
+				"1,s,0", "1,a,10",
+				"2,s,1", "2,a,20",
+				"3,s,0", "3,a,30",
+			},
+		},
+		{
+			flags: []string{"-vars=s", "-decode"},
+			want: []string{
+				"id,variable,value",
+				"1,s,x",
+				"2,s,y",
+				"3,s,x",
+			},
+		},
+	} {
+		out, err := coltest.Stdout(t, tolong.Run, append([]string{"-sourcedir=" + dir, "-idvar=id"}, tc.flags...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, tc.want) {
+			t.Errorf("%v: got %q, expected %q", tc.flags, out, tc.want)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+// Validate checks the structure of a data set.  For each bucket, it
+// confirms that every variable listed in dtypes.json has a column file,
+// that each column file decompresses cleanly and holds a whole number
+// of values of its data type (for text variables, that the lengths of
+// the values agree with the file holding their bytes), and that all
+// columns have the same number of rows (matching meta.json if it
+// exists), including the validity bitmaps of variables with missing
+// values.  It also confirms that every variable has the same data type
+// in all buckets, and that the codes file of every code group named in
+// CodeFiles.json exists and can be read.
+//
+// Each problem is reported with its bucket and variable, and the
+// program exits with a non-zero status if any problem is found.  Use
+// checkintegrity to confirm that the stored factor codes have labels.
+
+package validate
+
+import (
+	"bufio"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// Configuration information for the data set
+	conf *config.Config
+
+	// The problems found
+	problems []string
+)
+
+// report records a problem.
+func report(format string, args ...interface{}) {
+	problems = append(problems, fmt.Sprintf(format, args...))
+}
+
+// scancolumn reads a column file to the end, returning the number of
+// values it holds.
+func scancolumn(bn int, vn, dt string) (int, error) {
+
+	rdr, fid, err := config.OpenColumn(bn, sourcedir, vn)
+	if err != nil {
+		return 0, err
+	}
+	defer fid.Close()
+
+	switch dt {
+	case "text":
+		return scantext(bn, vn, rdr)
+	case "bool":
+		return config.CountRows(bn, sourcedir, vn, dt)
+	case "uvarint", "varint", "delta-uvarint":
+		// Each varint ends with the only byte having its high
+		// bit cleared, so the file must end with such a byte.
+		var n int
+		var last byte
+		b := make([]byte, 64*1024)
+		for {
+			m, err := rdr.Read(b)
+			for _, c := range b[0:m] {
+				if c < 0x80 {
+					n++
+				}
+				last = c
+			}
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return n, err
+			}
+		}
+		if last >= 0x80 {
+			return n, fmt.Errorf("the last value is truncated")
+		}
+		return n, nil
+	}
+
+	w, ok := config.DTsize[dt]
+	if !ok {
+		return 0, fmt.Errorf("unsupported dtype %s", dt)
+	}
+	nb, err := io.Copy(io.Discard, rdr)
+	if err != nil {
+		return 0, err
+	}
+	if int(nb)%w != 0 {
+		return int(nb) / w, fmt.Errorf("%d bytes is not a whole number of %s values", nb, dt)
+	}
+
+	return int(nb) / w, nil
+}
+
+// scantext reads the lengths of the values of a text column from rdr,
+// and confirms that the file holding the bytes of the values has the
+// total length.  The number of values is returned.
+func scantext(bn int, vn string, rdr *bufio.Reader) (int, error) {
+
+	var n int
+	var total uint64
+	for {
+		m, err := binary.ReadUvarint(rdr)
+		if err == io.EOF {
+			break
+		} else if err == io.ErrUnexpectedEOF {
+			return n, fmt.Errorf("the last value is truncated")
+		} else if err != nil {
+			return n, err
+		}
+		total += m
+		n++
+	}
+
+	trdr, fid, err := config.OpenColumn(bn, sourcedir, vn+config.TextSuffix)
+	if err != nil {
+		return n, err
+	}
+	defer fid.Close()
+	nb, err := io.Copy(io.Discard, trdr)
+	if err != nil {
+		return n, err
+	}
+	if uint64(nb) != total {
+		return n, fmt.Errorf("the values have %d bytes, but their lengths add to %d", nb, total)
+	}
+
+	return n, nil
+}
+
+// checkbucket checks the columns of one bucket, returning its data
+// types.
+func checkbucket(bn int) map[string]string {
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		report("bucket %d: cannot read dtypes: %v", bn, err)
+		return nil
+	}
+
+	var names []string
+	for vn := range dtypes {
+		names = append(names, vn)
+	}
+	sort.Strings(names)
+
+	nrows, first := -1, ""
+	for _, vn := range names {
+		if _, err := os.Stat(config.ColumnPath(bn, sourcedir, vn)); err != nil {
+			report("bucket %d, variable %s: column file is missing", bn, vn)
+			continue
+		}
+
+		n, err := scancolumn(bn, vn, dtypes[vn])
+		if err != nil {
+			report("bucket %d, variable %s: %v", bn, vn, err)
+			continue
+		}
+
+		if nrows == -1 {
+			nrows, first = n, vn
+		} else if n != nrows {
+			report("bucket %d, variable %s: %d rows, but %s has %d rows", bn, vn, n, first, nrows)
+		}
+
+		if config.HasNulls(bn, sourcedir, vn) {
+			m, err := config.CountRows(bn, sourcedir, vn+config.NullSuffix, "bool")
+			if err != nil {
+				report("bucket %d, variable %s: validity bitmap: %v", bn, vn, err)
+			} else if m != n {
+				report("bucket %d, variable %s: the validity bitmap has %d rows, but the column has %d rows", bn, vn, m, n)
+			}
+		}
+	}
+
+	if meta, err := config.ReadMeta(bn, sourcedir); err == nil && nrows != -1 && meta.NumRows != nrows {
+		report("bucket %d: meta.json records %d rows, but the columns have %d rows", bn, meta.NumRows, nrows)
+	}
+
+	return dtypes
+}
+
+// checkcodes confirms that the codes file of every code group can be
+// read.
+func checkcodes() {
+
+	cf, err := config.ReadCodeFiles(conf)
+	if err != nil {
+		report("codes: %v", err)
+		return
+	}
+
+	var names []string
+	for vn := range cf {
+		names = append(names, vn)
+	}
+	sort.Strings(names)
+
+	for _, vn := range names {
+		grp := cf[vn]
+		fn := path.Join(conf.CodesDir, grp+"Codes.json")
+		if _, err := os.Stat(fn); err != nil {
+			report("variable %s: codes file %s for group %s is missing", vn, fn, grp)
+			continue
+		}
+		if _, err := config.GetFactorCodes(vn, conf); err != nil {
+			report("variable %s: %v", vn, err)
+		}
+	}
+}
+
+// Run runs validate with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	problems = nil
+
+	if sourcedir == "" {
+		return cli.Usage("usage:\nvalidate -sourcedir=...\n\n")
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	// The data type of each variable, and the first bucket where it
+	// was seen
+	vdt := make(map[string]string)
+	vbn := make(map[string]int)
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		dtypes := checkbucket(k)
+		for vn, dt := range dtypes {
+			if _, ok := vdt[vn]; !ok {
+				vdt[vn] = dt
+				vbn[vn] = k
+			} else if dt != vdt[vn] {
+				report("bucket %d, variable %s: dtype %s, but %s in bucket %d", k, vn, dt, vdt[vn], vbn[vn])
+			}
+		}
+	}
+
+	checkcodes()
+
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("Found %d problems", len(problems))
+	}
+	fmt.Printf("%d buckets and %d variables are valid\n", conf.NumBuckets, len(vdt))
+
+	return nil
+}
@@ -0,0 +1,192 @@
+// Varinfo shows or changes the descriptions of the variables of a data
+// set: a human-readable label, the units of measurement and notes on
+// the provenance of each variable.  The descriptions are stored in
+// variables.json (see config.VarInfo), and are kept by the commands
+// that copy, rename or drop variables.
+//
+// With -var, the fields given by -label, -units and -notes are set for
+// one variable; an empty value clears the field.  With -import, the
+// descriptions are read from a CSV file with a header row, which must
+// have a Variable column and may have Label, Units and Notes columns.
+// The fields of the listed variables are replaced by the values in the
+// file.  Otherwise, the descriptions are printed.
+
+package varinfo
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// The variable to describe
+	vname string
+
+	// A CSV file of descriptions to import
+	importfile string
+
+	// The names of the variables in the data set
+	dtypes map[string]string
+
+	// The descriptions of the variables
+	info map[string]*config.VarInfo
+)
+
+// setfields sets the fields of the description of vname that were
+// given on the command line, whose flag set is fs.
+func setfields(fs *flag.FlagSet) {
+
+	vi := info[vname]
+	if vi == nil {
+		vi = new(config.VarInfo)
+		info[vname] = vi
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "label":
+			vi.Label = f.Value.String()
+		case "units":
+			vi.Units = f.Value.String()
+		case "notes":
+			vi.Notes = f.Value.String()
+		}
+	})
+}
+
+// doimport sets the descriptions of the variables listed in a CSV
+// file.
+func doimport() error {
+
+	fid, err := os.Open(importfile)
+	if err != nil {
+		return err
+	}
+	defer fid.Close()
+
+	rdr := csv.NewReader(fid)
+	head, err := rdr.Read()
+	if err != nil {
+		return fmt.Errorf("cannot read the header of %s: %v", importfile, err)
+	}
+	pos := make(map[string]int)
+	for j, h := range head {
+		pos[strings.ToLower(strings.TrimSpace(h))] = j
+	}
+	vpos, ok := pos["variable"]
+	if !ok {
+		return fmt.Errorf("%s has no Variable column", importfile)
+	}
+
+	field := func(rec []string, name string) string {
+		if j, ok := pos[name]; ok {
+			return strings.TrimSpace(rec[j])
+		}
+		return ""
+	}
+
+	for line := 2; ; line++ {
+		rec, err := rdr.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		vn := strings.TrimSpace(rec[vpos])
+		if _, ok := dtypes[vn]; !ok {
+			return fmt.Errorf("line %d of %s: variable %s is not in the data set", line, importfile, vn)
+		}
+		info[vn] = &config.VarInfo{
+			Label: field(rec, "label"),
+			Units: field(rec, "units"),
+			Notes: field(rec, "notes"),
+		}
+	}
+}
+
+// show prints the descriptions of the variables.
+func show() {
+
+	var names []string
+	for vn := range dtypes {
+		names = append(names, vn)
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Variable\tLabel\tUnits\tNotes\n")
+	for _, vn := range names {
+		vi := info[vn]
+		if vi == nil {
+			vi = new(config.VarInfo)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", vn, vi.Label, vi.Units, vi.Notes)
+	}
+	tw.Flush()
+}
+
+// Run runs varinfo with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("varinfo", flag.ContinueOnError)
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.StringVar(&vname, "var", "", "variable to describe")
+	fs.String("label", "", "label of the variable")
+	fs.String("units", "", "units of the variable")
+	fs.String("notes", "", "notes on the provenance of the variable")
+	fs.StringVar(&importfile, "import", "", "CSV file of descriptions to import")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if sourcedir == "" || (vname != "" && importfile != "") {
+		return cli.Usage("usage:\nvarinfo -sourcedir=... [-var=... [-label=...] [-units=...] [-notes=...] | -import=...]\n\n")
+	}
+
+	if _, err := config.GetConfig(sourcedir); err != nil {
+		return err
+	}
+
+	var err error
+	dtypes, err = config.ReadDtypes(0, sourcedir)
+	if err != nil {
+		return err
+	}
+	info, err = config.ReadVarInfo(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case vname != "":
+		if _, ok := dtypes[vname]; !ok {
+			return fmt.Errorf("Variable %s is not in the data set", vname)
+		}
+		setfields(fs)
+	case importfile != "":
+		if err := doimport(); err != nil {
+			return err
+		}
+	default:
+		show()
+		return nil
+	}
+
+	if err := config.WriteVarInfo(sourcedir, info); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+// Verify detects corrupted column files by recomputing the checksum of
+// every column file and comparing it to the checksum recorded in the
+// meta.json file of its bucket (see config.FileChecksum).  The file
+// sizes are also compared.  The checksums are recorded whenever
+// meta.json is written, for example by bucketmanifest -meta, so verify
+// reports buckets without meta.json or without checksums.
+//
+// Each problem is reported with its bucket and variable, and the
+// program exits with a non-zero status if any problem is found.  A
+// checksum mismatch means that the file changed after meta.json was
+// written, either through corruption or through a program that does
+// not update meta.json.  Use validate to check the contents of the
+// files.
+
+package verify
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/kshedden/gocols/cli"
+	"github.com/kshedden/gocols/config"
+)
+
+var (
+	// The directory containing the data set
+	sourcedir string
+
+	// Configuration information for the data set
+	conf *config.Config
+
+	// The problems found in each bucket
+	problems [][]string
+
+	// The number of files checked
+	nfiles int
+	mut    sync.Mutex
+
+	// The number of buckets processed in parallel
+	concurrency int
+
+	sem chan bool
+)
+
+// checkbucket compares the column files of one bucket to its
+// meta.json file, returning the problems found.
+func checkbucket(bn int) []string {
+
+	var probs []string
+	report := func(format string, args ...interface{}) {
+		probs = append(probs, fmt.Sprintf("bucket %d, ", bn)+fmt.Sprintf(format, args...))
+	}
+
+	dtypes, err := config.ReadDtypes(bn, sourcedir)
+	if err != nil {
+		report("cannot read dtypes: %v", err)
+		return probs
+	}
+
+	meta, err := config.ReadMeta(bn, sourcedir)
+	if os.IsNotExist(err) {
+		report("no meta.json (run bucketmanifest -meta)")
+		return probs
+	} else if err != nil {
+		report("cannot read meta.json: %v", err)
+		return probs
+	}
+	if meta.Checksums == nil {
+		report("meta.json has no checksums (run bucketmanifest -meta)")
+		return probs
+	}
+
+	files := make(map[string]bool)
+	var names []string
+	for vn, dt := range dtypes {
+		fl := config.BucketFiles(bn, sourcedir, vn, dt)
+		if _, ok := meta.Checksums[vn+config.NullSuffix]; ok && !config.HasNulls(bn, sourcedir, vn) {
+			// Report a missing validity bitmap.
+			fl = append(fl, vn+config.NullSuffix)
+		}
+		for _, fn := range fl {
+			names = append(names, fn)
+			files[fn] = true
+		}
+	}
+	sort.Strings(names)
+
+	var n int
+	for _, vn := range names {
+		want, ok := meta.Checksums[vn]
+		if !ok {
+			report("variable %s: no checksum in meta.json", vn)
+			continue
+		}
+		fn := config.ColumnPath(bn, sourcedir, vn)
+		fi, err := os.Stat(fn)
+		if err != nil {
+			report("variable %s: %v", vn, err)
+			continue
+		}
+		if size, ok := meta.Sizes[vn]; ok && size != fi.Size() {
+			report("variable %s: file has %d bytes, but meta.json records %d", vn, fi.Size(), size)
+		}
+		got, err := config.FileChecksum(fn)
+		if err != nil {
+			report("variable %s: %v", vn, err)
+			continue
+		}
+		if got != want {
+			report("variable %s: checksum %s, but meta.json records %s", vn, got, want)
+		}
+		n++
+	}
+
+	for vn := range meta.Checksums {
+		if !files[vn] {
+			report("variable %s: in meta.json, but not in dtypes.json", vn)
+		}
+	}
+
+	mut.Lock()
+	nfiles += n
+	mut.Unlock()
+
+	return probs
+}
+
+// dobucket checks one bucket.
+func dobucket(bn int) {
+
+	defer func() { <-sem }()
+
+	problems[bn] = checkbucket(bn)
+}
+
+// Run runs verify with the given command-line arguments.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.StringVar(&sourcedir, "sourcedir", "", "source directory")
+	fs.IntVar(&concurrency, "concurrency", runtime.NumCPU(), "number of buckets processed in parallel")
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	nfiles = 0
+
+	if concurrency < 1 {
+		return errors.New("-concurrency must be positive")
+	}
+
+	if sourcedir == "" {
+		return cli.Usage("usage:\nverify -sourcedir=...\n\n")
+	}
+
+	var err error
+	conf, err = config.GetConfig(sourcedir)
+	if err != nil {
+		return err
+	}
+
+	problems = make([][]string, conf.NumBuckets)
+	sem = make(chan bool, concurrency)
+
+	for k := 0; k < conf.NumBuckets; k++ {
+		sem <- true
+		go dobucket(k)
+	}
+
+	for k := 0; k < concurrency; k++ {
+		sem <- true
+	}
+
+	var np int
+	for _, probs := range problems {
+		for _, p := range probs {
+			fmt.Println(p)
+		}
+		np += len(probs)
+	}
+	if np > 0 {
+		return fmt.Errorf("Found %d problems", np)
+	}
+	fmt.Printf("%d column files in %d buckets match their checksums\n", nfiles, conf.NumBuckets)
+
+	return nil
+}